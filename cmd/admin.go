@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Borislavv/advanced-cache/pkg/config"
+	"github.com/Borislavv/advanced-cache/pkg/server/auth"
+	"github.com/valyala/fasthttp"
+)
+
+// adminTokenEnv is read when the -token flag is not set.
+const adminTokenEnv = "ADV_CACHE_ADMIN_TOKEN"
+
+// adminFlags are the flags of commands calling the admin API of a running cache.
+type adminFlags struct {
+	addr    *string
+	token   *string
+	timeout *time.Duration
+}
+
+func newAdminFlags(fs *flag.FlagSet) *adminFlags {
+	return &adminFlags{
+		addr:    fs.String("addr", "", "Admin address of the running cache (default: admin.addr of the config)"),
+		token:   fs.String("token", "", "Bearer token (default: $"+adminTokenEnv+")"),
+		timeout: fs.Duration("timeout", 10*time.Second, "Request timeout"),
+	}
+}
+
+// call sends a request to the admin API, the request is signed if admin.auth.hmac is configured.
+// Returns the response body, non-2xx statuses are errors.
+func (f *adminFlags) call(cfg *config.Cache, method, path string, body []byte) ([]byte, error) {
+	addr := *f.addr
+	if addr == "" {
+		addr = cfg.Cache.Admin.Addr
+	}
+	if strings.HasPrefix(addr, ":") {
+		addr = "127.0.0.1" + addr
+	}
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.Header.SetMethod(method)
+	req.SetRequestURI(addr + path)
+	req.SetBody(body)
+
+	token := *f.token
+	if token == "" {
+		token = os.Getenv(adminTokenEnv)
+	}
+	if token != "" {
+		req.Header.Set(fasthttp.HeaderAuthorization, "Bearer "+token)
+	}
+	if hmacCfg := cfg.Cache.Admin.Auth.HMAC; hmacCfg != nil {
+		h, err := auth.LoadHMAC(*hmacCfg)
+		if err != nil {
+			return nil, fmt.Errorf("hmac: %w", err)
+		}
+		h.SignRequest(req)
+	}
+
+	if err := fasthttp.DoTimeout(req, resp, *f.timeout); err != nil {
+		return nil, err
+	}
+	if code := resp.StatusCode(); code < 200 || code > 299 {
+		return nil, fmt.Errorf("%s %s: status %d: %s", method, path, code, strings.TrimSpace(string(resp.Body())))
+	}
+	return append([]byte(nil), resp.Body()...), nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Failed requests are retried by the worker after a backoff doubling from the min to the max one.
+const (
+	benchMinBackoff = 10 * time.Millisecond
+	benchMaxBackoff = time.Second
+)
+
+// bench sends GET requests to a running cache with a fixed concurrency and reports RPS, statuses and latencies.
+func bench(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	url := fs.String("url", "", "URL to request (required)")
+	concurrency := fs.Int("c", 64, "Number of concurrent workers")
+	duration := fs.Duration("d", 10*time.Second, "Duration of the load")
+	timeout := fs.Duration("timeout", time.Second, "Request timeout")
+	_ = fs.Parse(args)
+	if *url == "" {
+		return errors.New("-url is required")
+	}
+	if *concurrency <= 0 {
+		return errors.New("-c must be positive")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, *duration)
+	defer cancel()
+
+	client := &fasthttp.Client{MaxConnsPerHost: *concurrency}
+	type result struct {
+		latencies []time.Duration
+		statuses  map[int]int
+		errors    int
+	}
+	results := make([]result, *concurrency)
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(res *result) {
+			defer wg.Done()
+			res.statuses = make(map[int]int)
+
+			req := fasthttp.AcquireRequest()
+			resp := fasthttp.AcquireResponse()
+			defer fasthttp.ReleaseRequest(req)
+			defer fasthttp.ReleaseResponse(resp)
+			req.SetRequestURI(*url)
+
+			var backoff time.Duration // doubled by consecutive errors, a refused target doesn't spin the worker
+			for ctx.Err() == nil {
+				from := time.Now()
+				if err := client.DoTimeout(req, resp, *timeout); err != nil {
+					res.errors++
+					backoff = min(max(backoff*2, benchMinBackoff), benchMaxBackoff)
+					select {
+					case <-ctx.Done():
+					case <-time.After(backoff):
+					}
+					continue
+				}
+				backoff = 0
+				res.latencies = append(res.latencies, time.Since(from))
+				res.statuses[resp.StatusCode()]++
+			}
+		}(&results[i])
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	var latencies []time.Duration
+	statuses := make(map[int]int)
+	var errs int
+	for _, res := range results {
+		latencies = append(latencies, res.latencies...)
+		for code, n := range res.statuses {
+			statuses[code] += n
+		}
+		errs += res.errors
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "requests\t%d\n", len(latencies)+errs)
+	fmt.Fprintf(tw, "errors\t%d\n", errs)
+	fmt.Fprintf(tw, "rps\t%.0f\n", float64(len(latencies))/elapsed.Seconds())
+	codes := make([]int, 0, len(statuses))
+	for code := range statuses {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		fmt.Fprintf(tw, "status %d\t%d\n", code, statuses[code])
+	}
+	for _, p := range []float64{0.5, 0.9, 0.99} {
+		fmt.Fprintf(tw, "p%.0f\t%s\n", p*100, percentile(latencies, p))
+	}
+	return tw.Flush()
+}
+
+// percentile returns the p-th latency of the sorted slice, 0 if it's empty.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[int(float64(len(sorted)-1)*p)]
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configValidate checks a config with all layers applied, the path may be given as an argument.
+func configValidate(_ context.Context, args []string) error {
+	fs := flag.NewFlagSet("config validate", flag.ExitOnError)
+	cf := newConfigFlags(fs)
+	_ = fs.Parse(args)
+	if fs.NArg() > 0 {
+		*cf.path = fs.Arg(0)
+	}
+
+	cfg, err := cf.load()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("config %s is valid\n", cfg.Path)
+	return nil
+}
+
+// configPrint prints the effective config: the file, includes, templates, env variables and flags merged.
+func configPrint(_ context.Context, args []string) error {
+	fs := flag.NewFlagSet("config print", flag.ExitOnError)
+	cf := newConfigFlags(fs)
+	format := fs.String("format", "yaml", "Output format: yaml or json")
+	_ = fs.Parse(args)
+
+	cfg, err := cf.load()
+	if err != nil {
+		return err
+	}
+
+	switch *format {
+	case "yaml":
+		enc := yaml.NewEncoder(os.Stdout)
+		enc.SetIndent(2)
+		if err = enc.Encode(cfg); err != nil {
+			return err
+		}
+		return enc.Close()
+	case "json":
+		// go through yaml to keep yaml field names
+		var tree any
+		b, err := yaml.Marshal(cfg)
+		if err != nil {
+			return err
+		}
+		if err = yaml.Unmarshal(b, &tree); err != nil {
+			return err
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(tree)
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Borislavv/advanced-cache/pkg/config"
+	"github.com/Borislavv/advanced-cache/pkg/storage"
+	"github.com/Borislavv/advanced-cache/pkg/storage/inspect"
+	"github.com/Borislavv/advanced-cache/pkg/storage/snapshot"
+	"github.com/Borislavv/advanced-cache/pkg/utils"
+)
+
+// dumpList prints the dump versions of the configured dump dir.
+func dumpList(_ context.Context, args []string) error {
+	fs := flag.NewFlagSet("dump ls", flag.ExitOnError)
+	cf := newConfigFlags(fs)
+	asJson := fs.Bool("json", false, "Print JSON")
+	_ = fs.Parse(args)
+
+	cfg, err := cf.load()
+	if err != nil {
+		return err
+	}
+	dump := cfg.Cache.Persistence.Dump
+	versions, err := storage.ListDumpVersions(dump.Dir, dump.Name)
+	if err != nil {
+		return err
+	}
+
+	if *asJson {
+		return printJson(versions)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tSTATUS\tENTRIES\tFILES\tSIZE\tMODIFIED")
+	for _, v := range versions {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\t%s\n", v.Name, v.Status, v.Entries, len(v.Files), utils.FmtMem(v.Size), v.ModTime.Format(time.RFC3339))
+	}
+	return tw.Flush()
+}
+
+// dumpInspect decodes every record of a dump version and prints statistics and (with -entries) matching entries.
+func dumpInspect(_ context.Context, args []string) error {
+	fs := flag.NewFlagSet("dump inspect", flag.ExitOnError)
+	cf := newConfigFlags(fs)
+	asJson := fs.Bool("json", false, "Print JSON")
+	entries := fs.Bool("entries", false, "Print matching entries")
+	limit := fs.Int("limit", 100, "Max number of printed entries (0 means no limit)")
+	bodyLimit := fs.Int("body", 0, "Number of body bytes to print with each entry")
+	var filter inspect.Filter
+	fs.StringVar(&filter.Rule, "rule", "", "Only entries of the rule")
+	fs.StringVar(&filter.Path, "path", "", "Only entries with the path prefix")
+	fs.StringVar(&filter.Query, "query", "", "Only entries with the query substring")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("version is required, see `dump ls`")
+	}
+
+	cfg, err := cf.load()
+	if err != nil {
+		return err
+	}
+	parts, err := versionParts(cfg, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	var records []*inspect.Record
+	keys, err := storage.LoadDumpKeys(cfg)
+	if err != nil {
+		return err
+	}
+	opts := inspect.Options{Crc32Control: cfg.Cache.Persistence.Dump.Crc32Control, Keys: keys, Filter: filter, BodyLimit: *bodyLimit}
+	stats, err := inspect.Inspect(cfg, parts, opts, func(r *inspect.Record) error {
+		if !*entries || (*limit > 0 && len(records) >= *limit) {
+			return nil
+		}
+		if *asJson {
+			records = append(records, r)
+		} else {
+			printRecord(r)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if *asJson {
+		return printJson(struct {
+			Version string            `json:"version"`
+			Stats   *inspect.Stats    `json:"stats"`
+			Entries []*inspect.Record `json:"entries,omitempty"`
+		}{Version: fs.Arg(0), Stats: stats, Entries: records})
+	}
+	return printStats(fs.Arg(0), stats)
+}
+
+// dumpVerify checks files of the version against its manifest (sizes and checksums),
+// CRC of each record (if the config enables it) and that it decodes into an entry of a known rule.
+func dumpVerify(_ context.Context, args []string) error {
+	fs := flag.NewFlagSet("dump verify", flag.ExitOnError)
+	cf := newConfigFlags(fs)
+	_ = fs.Parse(args)
+
+	cfg, err := cf.load()
+	if err != nil {
+		return err
+	}
+	version := fs.Arg(0)
+	if version == "" {
+		dump := cfg.Cache.Persistence.Dump
+		versions, err := storage.ListDumpVersions(dump.Dir, dump.Name)
+		if err != nil {
+			return err
+		}
+		if len(versions) == 0 {
+			return fmt.Errorf("no dump versions in %s", dump.Dir)
+		}
+		version = versions[0].Name
+	}
+	parts, err := versionParts(cfg, version)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(cfg.Cache.Persistence.Dump.Dir, version)
+	if m, err := storage.ReadManifest(dir); err == nil {
+		if err = m.Check(dir, true); err != nil {
+			return fmt.Errorf("dump %s: %w", version, err)
+		}
+	} else if errors.Is(err, os.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "%s has no manifest, files are not checked against it\n", version)
+	} else {
+		return err
+	}
+
+	keys, err := storage.LoadDumpKeys(cfg)
+	if err != nil {
+		return err
+	}
+	stats, err := inspect.Inspect(cfg, parts, inspect.Options{Crc32Control: cfg.Cache.Persistence.Dump.Crc32Control, Keys: keys}, nil)
+	if err != nil {
+		return err
+	}
+	for _, problem := range stats.Problems {
+		fmt.Fprintln(os.Stderr, problem)
+	}
+	fmt.Printf("%s: %d files, %d records, %d crc mismatches, %d decode errors, %d broken files, %d entries of unknown rules\n",
+		version, stats.Files, stats.Records, stats.CRCErrors, stats.DecodeErrors, stats.BrokenFiles, stats.NoRule)
+	if stats.Corrupted() {
+		return fmt.Errorf("dump %s is corrupted", version)
+	}
+	return nil
+}
+
+// dumpConvert rewrites a dump version (e.g. a legacy one) as a new snapshot version,
+// the codec, segments and block size of the config may be overridden by flags.
+func dumpConvert(_ context.Context, args []string) error {
+	fs := flag.NewFlagSet("dump convert", flag.ExitOnError)
+	cf := newConfigFlags(fs)
+	codec := fs.String("codec", "", "Block compression: none, gzip or zstd (default: persistence.dump.codec)")
+	level := fs.Int("level", 0, "zstd level, 1 (fastest) to 22 (best) (default: persistence.dump.level)")
+	segments := fs.Int("segments", 0, "Number of snapshot files (default: persistence.dump.segments)")
+	blockSize := fs.Int("block-size", 0, "Raw size of blocks in bytes (default: persistence.dump.block_size)")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("version is required, see `dump ls`")
+	}
+
+	cfg, err := cf.load()
+	if err != nil {
+		return err
+	}
+	opts := storage.NewSnapshotOptions(cfg)
+	if opts.Keys, err = storage.LoadDumpKeys(cfg); err != nil {
+		return err
+	}
+	if *codec == "" {
+		*codec = opts.Codec.Name()
+	}
+	if *level == 0 {
+		*level = cfg.Cache.Persistence.Dump.Level
+	}
+	switch *codec {
+	case config.DumpCodecZstd:
+		if opts.Codec, err = snapshot.NewZstdCodec(*level); err != nil {
+			return err
+		}
+	default:
+		if opts.Codec, err = snapshot.CodecByName(*codec); err != nil {
+			return err
+		}
+	}
+	if *segments > 0 {
+		opts.Segments = *segments
+	}
+	if *blockSize > 0 {
+		opts.BlockSize = *blockSize
+	}
+
+	dump := cfg.Cache.Persistence.Dump
+	dir, records, err := storage.ConvertDumpVersion(filepath.Join(dump.Dir, fs.Arg(0)), dump.Dir, dump.Name, opts)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s converted into %s: %d records\n", fs.Arg(0), dir, records)
+	return nil
+}
+
+// versionParts returns the files of the dump version.
+func versionParts(cfg *config.Cache, version string) ([]storage.DumpPart, error) {
+	dump := cfg.Cache.Persistence.Dump
+	parts := storage.DumpParts(filepath.Join(dump.Dir, version), dump.Name)
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("no dump files of version %s in %s", version, dump.Dir)
+	}
+	return parts, nil
+}
+
+func printJson(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func printRecord(r *inspect.Record) {
+	fmt.Printf("%s?%s\n", r.Path, r.Query)
+	fmt.Printf("  rule: %s", r.Rule)
+	if r.NoRule {
+		fmt.Print(" (not configured)")
+	}
+	fmt.Printf("\n  key: %d, shard: %d, file: %s\n", r.Key, r.Shard, r.File)
+	fmt.Printf("  status: %d, body: %s, record: %s\n", r.Status, utils.FmtMem(int64(r.BodySize)), utils.FmtMem(int64(r.Size)))
+	fmt.Printf("  updated: %s (%s ago)\n", r.UpdatedAt.Format(time.RFC3339), r.Age)
+	if !r.AccessedAt.IsZero() {
+		fmt.Printf("  accessed: %s, frequency: %d\n", r.AccessedAt.Format(time.RFC3339), r.Frequency)
+	}
+	for _, kv := range r.QueryHeaders {
+		fmt.Printf("  > %s: %s\n", kv[0], kv[1])
+	}
+	for _, kv := range r.Headers {
+		fmt.Printf("  < %s: %s\n", kv[0], kv[1])
+	}
+	if r.Body != "" {
+		fmt.Printf("  body: %q\n", r.Body)
+	}
+	fmt.Println()
+}
+
+func printStats(version string, stats *inspect.Stats) error {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "version\t%s\n", version)
+	fmt.Fprintf(tw, "files\t%d (broken: %d)\n", stats.Files, stats.BrokenFiles)
+	if len(stats.Snapshots) > 0 {
+		h := stats.Snapshots[0]
+		fmt.Fprintf(tw, "format\tsnapshot v%d, entries v%d, %d segments\n", h.Version, h.EntryVersion, h.Segments)
+		fmt.Fprintf(tw, "created\t%s\n", h.Created.Format(time.RFC3339))
+		fmt.Fprintf(tw, "rules hash\t%016x\n", h.RulesHash)
+	} else {
+		fmt.Fprintln(tw, "format\tlegacy")
+	}
+	fmt.Fprintf(tw, "records\t%d (%s)\n", stats.Records, utils.FmtMem(stats.Bytes))
+	fmt.Fprintf(tw, "crc mismatches\t%d\n", stats.CRCErrors)
+	fmt.Fprintf(tw, "decode errors\t%d\n", stats.DecodeErrors)
+	fmt.Fprintf(tw, "unknown rules\t%d\n", stats.NoRule)
+	fmt.Fprintf(tw, "matched\t%d\n", stats.Matched)
+	if stats.Matched > 0 {
+		fmt.Fprintf(tw, "oldest\t%s\n", stats.Oldest.Format(time.RFC3339))
+		fmt.Fprintf(tw, "newest\t%s\n", stats.Newest.Format(time.RFC3339))
+	}
+
+	fmt.Fprintln(tw, "\nRULE\tENTRIES\tBYTES\tMIN\tAVG\tMAX")
+	rules := make([]string, 0, len(stats.Rules))
+	for rule := range stats.Rules {
+		rules = append(rules, rule)
+	}
+	sort.Strings(rules)
+	for _, name := range rules {
+		rule := stats.Rules[name]
+		if rule.NoRule {
+			name += " (not configured)"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\n", name, rule.Entries, utils.FmtMem(rule.Bytes),
+			utils.FmtMem(int64(rule.MinSize)), utils.FmtMem(rule.Bytes/int64(rule.Entries)), utils.FmtMem(int64(rule.MaxSize)))
+	}
+
+	fmt.Fprintln(tw, "\nAGE\tENTRIES")
+	for _, bucket := range stats.Ages {
+		le := "older"
+		if bucket.Le > 0 {
+			le = "<= " + bucket.Le.String()
+		}
+		fmt.Fprintf(tw, "%s\t%d\n", le, bucket.Entries)
+	}
+
+	if len(stats.Problems) > 0 {
+		fmt.Fprintln(tw, "\nPROBLEMS")
+		for _, problem := range stats.Problems {
+			fmt.Fprintln(tw, problem)
+		}
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+
+	"github.com/Borislavv/advanced-cache/internal/cache/api"
+	"github.com/Borislavv/advanced-cache/pkg/storage/exchange"
+	"github.com/valyala/fasthttp"
+)
+
+// formatStatic is the format of the export command writing a static site (see exchange.StaticSite) to -o.
+const formatStatic = "static"
+
+// export writes the entries of a running cache as JSONL or HAR via GET /cache/export of the admin listener,
+// or a static site built from the JSONL export.
+func export(_ context.Context, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	cf := newConfigFlags(fs)
+	af := newAdminFlags(fs)
+	format := fs.String("format", exchange.FormatJSONL, "Format: jsonl, har or static (a directory of pages, see exchange.StaticSite)")
+	rule := fs.String("rule", "", "Export entries of the rule path only")
+	prefix := fs.String("prefix", "", "Export entries of request paths with the prefix only")
+	minAge := fs.Duration("min-age", 0, "Export entries updated at least this long ago only")
+	maxAge := fs.Duration("max-age", 0, "Export entries updated within this period only")
+	out := fs.String("o", "", "Output file (default: stdout), the directory of a static export")
+	_ = fs.Parse(args)
+
+	cfg, err := cf.load()
+	if err != nil {
+		return err
+	}
+	if *format == formatStatic && *out == "" {
+		return errors.New("-o <dir> is required by a static export")
+	}
+	query := url.Values{"format": {*format}}
+	if *format == formatStatic {
+		query.Set("format", exchange.FormatJSONL)
+	}
+	if *rule != "" {
+		query.Set("rule", *rule)
+	}
+	if *prefix != "" {
+		query.Set("prefix", *prefix)
+	}
+	if *minAge > 0 {
+		query.Set("min_age", minAge.String())
+	}
+	if *maxAge > 0 {
+		query.Set("max_age", maxAge.String())
+	}
+	body, err := af.call(cfg, fasthttp.MethodGet, api.ExportPath+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	if *format == formatStatic {
+		return exportStatic(body, *out)
+	}
+	if *out == "" {
+		_, err = os.Stdout.Write(body)
+		return err
+	}
+	return os.WriteFile(*out, body, 0o644)
+}
+
+// exportStatic writes the pages of the JSONL export to dir.
+func exportStatic(jsonl []byte, dir string) error {
+	site, err := exchange.NewStaticSite(dir)
+	if err != nil {
+		return err
+	}
+	if err = exchange.Decode(bytes.NewReader(jsonl), exchange.FormatJSONL, site.Encode); err != nil {
+		return err
+	}
+	if err = site.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("%d pages written to %s, see %s\n", site.Pages(), dir, exchange.StaticIndexName)
+	return nil
+}
+
+// importEntries loads an exported file into a running cache via POST /cache/import of the admin listener.
+func importEntries(_ context.Context, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	cf := newConfigFlags(fs)
+	af := newAdminFlags(fs)
+	format := fs.String("format", "", "Format: jsonl or har (default: by the file extension)")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("an exported file is required")
+	}
+
+	cfg, err := cf.load()
+	if err != nil {
+		return err
+	}
+	file := fs.Arg(0)
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if *format == "" {
+		*format = exchange.FormatOf(file)
+	}
+	body, err := af.call(cfg, fasthttp.MethodPost, api.ImportPath+"?"+url.Values{"format": {*format}}.Encode(), data)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(body))
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"strings"
+
+	"github.com/Borislavv/advanced-cache/internal/cache"
+	"github.com/Borislavv/advanced-cache/pkg/config"
+	"gopkg.in/yaml.v3"
+)
+
+// configFlags are flags of a subcommand which loads the config: -config, repeatable -set path=value
+// and named shortcuts bound to config paths. All of them are applied through config overrides
+// on top of the config file layers (includes, ADVCACHE_* env variables).
+type configFlags struct {
+	fs    *flag.FlagSet
+	path  *string
+	sets  setFlag
+	paths map[string][]string // flag name -> config path (relative to `cache`)
+}
+
+func newConfigFlags(fs *flag.FlagSet) *configFlags {
+	f := &configFlags{
+		fs:    fs,
+		path:  fs.String("config", "", fmt.Sprintf("Config file (default %s or %s)", cache.ConfigPathLocal, cache.ConfigPath)),
+		paths: make(map[string][]string),
+	}
+	fs.Var(&f.sets, "set", "Override a config value: -set proxy.rate=100 (repeatable, path is relative to `cache`)")
+	return f
+}
+
+// bind maps an already defined flag to a config path.
+func (f *configFlags) bind(name string, path ...string) {
+	f.paths[name] = path
+}
+
+// overrides returns the overrides of -set (in the command line order) followed by those of the set bound flags,
+// so a bound flag (e.g. -upstreamrate) wins over -set of the same path wherever they are on the command line.
+func (f *configFlags) overrides() []config.Override {
+	overrides := append([]config.Override{}, f.sets...)
+	f.fs.Visit(func(fl *flag.Flag) {
+		if path, ok := f.paths[fl.Name]; ok {
+			overrides = append(overrides, config.Override{
+				Path:   path,
+				Value:  fl.Value.(flag.Getter).Get(),
+				Source: "flag -" + fl.Name,
+			})
+		}
+	})
+	return overrides
+}
+
+// load loads the config with the overrides.
+func (f *configFlags) load() (*config.Cache, error) {
+	if *f.path != "" {
+		return config.LoadConfig(*f.path, f.overrides()...)
+	}
+	cfg, err := config.LoadConfig(cache.ConfigPathLocal, f.overrides()...)
+	if err != nil && errors.Is(err, fs.ErrNotExist) {
+		return config.LoadConfig(cache.ConfigPath, f.overrides()...)
+	}
+	return cfg, err
+}
+
+// setFlag collects -set path=value flags, the value is parsed as YAML.
+type setFlag []config.Override
+
+func (s *setFlag) String() string {
+	parts := make([]string, 0, len(*s))
+	for _, o := range *s {
+		parts = append(parts, strings.Join(o.Path, "."))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (s *setFlag) Set(value string) error {
+	path, raw, ok := strings.Cut(value, "=")
+	if !ok || path == "" {
+		return errors.New("expected path=value")
+	}
+
+	node := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: raw}
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(raw), &doc); err == nil && len(doc.Content) == 1 {
+		node = doc.Content[0]
+	}
+
+	*s = append(*s, config.Override{Path: strings.Split(path, "."), Value: node, Source: "flag -set " + path})
+	return nil
+}
@@ -2,195 +2,83 @@ package main
 
 import (
 	"context"
-	"flag"
-	"github.com/Borislavv/advanced-cache/internal/cache"
-	"github.com/Borislavv/advanced-cache/pkg/config"
-	"github.com/Borislavv/advanced-cache/pkg/gc"
-	"github.com/Borislavv/advanced-cache/pkg/k8s/probe/liveness"
-	"github.com/Borislavv/advanced-cache/pkg/shutdown"
-	//	"github.com/Borislavv/advanced-cache/pkg/tui"
-	"github.com/rs/zerolog/log"
-	"go.uber.org/automaxprocs/maxprocs"
-	"runtime"
-	"time"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
 )
 
-var (
-	from          = flag.String("from", "http://localhost:8080", "Origin server address to proxy requests from")
-	to            = flag.String("to", ":8020", "Port or address to serve the cache application on")
-	mocks         = flag.Bool("mocks", false, "Enable mocks mode (for dev/testing)")
-	mocksLen      = flag.Int("mockslen", 10000, "Length of mock data to generate if mocks mode is enabled")
-	dump          = flag.Bool("dump", false, "Enable dump loading at startup and writing at shutdown")
-	refresh       = flag.Bool("refresh", false, "Enable background data refresh")
-	eviction      = flag.Bool("eviction", false, "Enable data eviction on overflow")
-	upstreamRate  = flag.Int("upstreamrate", 1000, "Maximum rate of upstream requests per second")
-	memoryLimit   = flag.Int("memorylimit", 34359738368, "Maximum amount of bytes that can be used to cache evictions")
-	gomaxprocs    = flag.Int("procs", 3, "Maximum number of CPU cores to use")
-	isInteractive = flag.Bool("inter", false, "Enable interactive mode of data loading")
-
-	fromDefined         = false
-	toDefined           = false
-	mocksDefined        = false
-	mockslenDefined     = false
-	dumpDefined         = false
-	refreshDefined      = false
-	evictionDefined     = false
-	upstreamRateDefined = false
-	memoryLimitDefined  = false
-	gomaxprocsDefined   = false
-)
-
-func init() {
-	flag.Parse()
-
-	logEvent := log.Info()
-	flag.Visit(func(f *flag.Flag) {
-		if f.Name == "from" {
-			fromDefined = true
-			logEvent.Str("from", *from)
-		} else if f.Name == "to" {
-			toDefined = true
-			logEvent.Str("to", *to)
-		} else if f.Name == "mocks" {
-			mocksDefined = true
-			logEvent.Bool("mocks", *mocks)
-		} else if f.Name == "mockslen" {
-			mockslenDefined = true
-			logEvent.Int("mockslen", *mocksLen)
-		} else if f.Name == "dump" {
-			dumpDefined = true
-			logEvent.Bool("dump", *dump)
-		} else if f.Name == "refresh" {
-			refreshDefined = true
-			logEvent.Bool("refresh", *refresh)
-		} else if f.Name == "eviction" {
-			evictionDefined = true
-			logEvent.Bool("eviction", *eviction)
-		} else if f.Name == "upstreamrate" {
-			upstreamRateDefined = true
-			logEvent.Int("upstreamrate", *upstreamRate)
-		} else if f.Name == "memorylimit" {
-			memoryLimitDefined = true
-			logEvent.Int("memorylimit", *memoryLimit)
-		} else if f.Name == "procs" {
-			gomaxprocsDefined = true
-			logEvent.Int("procs", *gomaxprocs)
-		}
-	})
-
-	logEvent.Msg("[main] startup flags")
+// command is a subcommand of the binary, args are the arguments after its name.
+type command struct {
+	usage string
+	run   func(ctx context.Context, args []string) error
 }
 
-// setMaxProcs automatically sets the optimal GOMAXPROCS value (CPU parallelism)
-// based on the available CPUs and cgroup/docker CPU quotas (uses automaxprocs).
-func setMaxProcs(cfg *config.Cache) {
-	if cfg.Cache.Runtime.Gomaxprocs == 0 {
-		if _, err := maxprocs.Set(); err != nil {
-			log.Err(err).Msg("[main] setting up GOMAXPROCS value failed")
-		}
-	} else {
-		runtime.GOMAXPROCS(cfg.Cache.Runtime.Gomaxprocs)
-	}
-	log.Info().Msgf("[main] GOMAXPROCS=%d was set up", runtime.GOMAXPROCS(0))
+var commands = map[string]command{
+	"serve":           {usage: "serve [flags]                      run the cache (default command)", run: serve},
+	"dump ls":         {usage: "dump ls [flags]                    list dump versions", run: dumpList},
+	"dump inspect":    {usage: "dump inspect [flags] <version>     decode a dump version: statistics, entries filtered by -rule/-path/-query", run: dumpInspect},
+	"dump verify":     {usage: "dump verify [flags] [version]      check CRC and decoding of a dump version (latest by default)", run: dumpVerify},
+	"dump convert":    {usage: "dump convert [flags] <version>     rewrite a dump version (of any format) as a new snapshot version", run: dumpConvert},
+	"config validate": {usage: "config validate [flags] [path]     validate a config (exits non-zero if invalid)", run: configValidate},
+	"config print":    {usage: "config print [flags]               print the effective config (all layers applied)", run: configPrint},
+	"purge":           {usage: "purge [flags]                      remove all entries (or those of -rule/-prefix, -soft marks them stale) of a running cache via the admin API", run: purge},
+	"export":          {usage: "export [flags]                     write entries of a running cache as JSONL, HAR or a static site (filtered by -rule/-prefix/-min-age/-max-age)", run: export},
+	"import":          {usage: "import [flags] <file>              load an exported JSONL or HAR file into a running cache", run: importEntries},
+	"bench":           {usage: "bench [flags] -url <url>           load a running cache and report RPS and latencies", run: bench},
 }
 
-// loadCfg loads the configuration struct from environment variables
-// and computes any derived configuration values.
-func loadCfg() (*config.Cache, error) {
-	cfg, err := config.LoadConfig(cache.ConfigPathLocal)
-	if err != nil {
-		cfg, err = config.LoadConfig(cache.ConfigPath)
-		if err != nil {
-			log.Err(err).Msg("[config] failed to load")
-			return nil, err
-		} else {
-			log.Info().Msgf("[config] config loaded from '%v'", cache.ConfigPath)
-		}
-	} else {
-		log.Info().Msgf("[config] config loaded from '%v'", cache.ConfigPathLocal)
-	}
-
-	if fromDefined {
-		cfg.Cache.Proxy.From = *from
-	}
-	if toDefined {
-		cfg.Cache.Proxy.To = *to
-	}
-	if mocksDefined {
-		cfg.Cache.Persistence.Mock.Enabled = *mocks
-	}
-	if mockslenDefined {
-		cfg.Cache.Persistence.Mock.Length = *mocksLen
-	}
-	if dumpDefined {
-		cfg.Cache.Persistence.Dump.IsEnabled = *dump
-	}
-	if refreshDefined {
-		cfg.Cache.Refresh.Enabled = *dump
-	}
-	if evictionDefined {
-		cfg.Cache.Eviction.Enabled = *eviction
-	}
-	if upstreamRateDefined {
-		cfg.Cache.Proxy.Rate = *upstreamRate
-	}
-	if memoryLimitDefined {
-		cfg.Cache.Storage.Size = uint(*memoryLimit)
-	}
-	if gomaxprocsDefined {
-		cfg.Cache.Runtime.Gomaxprocs = *gomaxprocs
-	}
-
-	return cfg, nil
-}
-
-// Main entrypoint: configures and starts the cache application.
+// Main entrypoint: dispatches the subcommand, `serve` is used when there is none (flags only).
 func main() {
-	// Create a root context for gracefulShutdown shutdown and cancellation.
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
-	// Load the application configuration from env vars.
-	cfg, err := loadCfg()
-	if err != nil {
-		log.Err(err).Msg("[main] failed to load cache config")
-		return
+	name, args := resolveCommand(os.Args[1:])
+	cmd, ok := commands[name]
+	if !ok {
+		printUsage(name)
+		os.Exit(2)
 	}
 
-	// Optimize GOMAXPROCS for the current environment.
-	setMaxProcs(cfg)
-
-	// Setup gracefulShutdown shutdown handler (SIGTERM, SIGINT, etc).
-	gracefulShutdown := shutdown.NewGraceful(ctx, cancel)
-	gracefulShutdown.SetGracefulTimeout(time.Minute * 5)
-
-	// Initialize liveness probe for Kubernetes/Cloud health checks.
-	probe := liveness.NewProbe(cfg.Cache.K8S.Probe.Timeout)
-
-	// Initialize and start the cache application.
-	app, err := cache.NewApp(ctx, cfg, probe)
-	if err != nil {
-		log.Err(err).Msg("[main] failed to init cache app")
+	if name == "serve" {
+		cancel() // serve handles signals itself (graceful shutdown)
+		ctx = context.Background()
 	}
 
-	// Load data manually or interactive, as you wish
-	if err = app.LoadData(ctx, *isInteractive); err != nil {
-		log.Err(err).Msg("[main] failed to load data")
-		return
+	if err := cmd.run(ctx, args); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+		os.Exit(1)
 	}
+}
 
-	// Register app for gracefulShutdown shutdown.
-	gracefulShutdown.Add(1)
-	go app.Start(gracefulShutdown)
-
-	gcCtx, gcCancel := context.WithCancel(context.Background())
-	defer gcCancel()
+// resolveCommand finds the longest known command name at the beginning of args.
+func resolveCommand(args []string) (name string, rest []string) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return "serve", args
+	}
+	if len(args) > 1 {
+		if _, ok := commands[args[0]+" "+args[1]]; ok {
+			return args[0] + " " + args[1], args[2:]
+		}
+	}
+	return args[0], args[1:]
+}
 
-	// Run forced GC.
-	go gc.Run(gcCtx, cfg)
+func printUsage(name string) {
+	if name != "" && name != "help" && name != "-h" {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+	}
+	fmt.Fprintln(os.Stderr, "Usage: advanced-cache <command> [flags]\n\nCommands:")
 
-	// Listen for OS signals or context cancellation and wait for gracefulShutdown shutdown.
-	if err = gracefulShutdown.ListenCancelAndAwait(); err != nil {
-		log.Err(err).Msg("failed to gracefully shut down service")
+	names := make([]string, 0, len(commands))
+	for n := range commands {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		fmt.Fprintln(os.Stderr, "  "+commands[n].usage)
 	}
+	fmt.Fprintln(os.Stderr, "\nRun `advanced-cache <command> -h` for the flags of a command.")
 }
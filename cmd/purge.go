@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+
+	"github.com/Borislavv/advanced-cache/internal/cache/api"
+	"github.com/valyala/fasthttp"
+)
+
+// purge removes entries of a running cache via the admin listener: all of them (POST /cache/clear)
+// or those of -rule/-prefix (POST /cache/purge or, with -soft, /cache/soft-purge). In the cluster mode
+// the operation is broadcast to all instances unless -local is set.
+func purge(_ context.Context, args []string) error {
+	fs := flag.NewFlagSet("purge", flag.ExitOnError)
+	cf := newConfigFlags(fs)
+	af := newAdminFlags(fs)
+	rule := fs.String("rule", "", "purge the entries of the rule (path)")
+	prefix := fs.String("prefix", "", "purge the entries of paths with the prefix")
+	soft := fs.Bool("soft", false, "mark the entries stale instead of removing them, they are served until revalidated")
+	id := fs.String("id", "", "ID of the cluster operation, a repeated purge with the same ID is not applied again")
+	local := fs.Bool("local", false, "purge this instance only (cluster mode)")
+	_ = fs.Parse(args)
+
+	cfg, err := cf.load()
+	if err != nil {
+		return err
+	}
+	query := url.Values{}
+	if *rule != "" {
+		query.Set("rule", *rule)
+	}
+	if *prefix != "" {
+		query.Set("prefix", *prefix)
+	}
+	path := api.ClearPath
+	switch {
+	case len(query) > 0 && *soft:
+		path = api.SoftPurgePath
+	case len(query) > 0:
+		path = api.PurgePath
+	case *soft:
+		return errors.New("-soft requires -rule or -prefix")
+	}
+	if *id != "" {
+		query.Set("id", *id)
+	}
+	if *local {
+		query.Set("local", "true")
+	}
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	body, err := af.call(cfg, fasthttp.MethodPost, path, nil)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(body))
+	return nil
+}
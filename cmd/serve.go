@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"runtime"
+	"time"
+
+	"github.com/Borislavv/advanced-cache/internal/cache"
+	"github.com/Borislavv/advanced-cache/pkg/config"
+	"github.com/Borislavv/advanced-cache/pkg/gc"
+	"github.com/Borislavv/advanced-cache/pkg/k8s/probe/liveness"
+	"github.com/Borislavv/advanced-cache/pkg/shutdown"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"go.uber.org/automaxprocs/maxprocs"
+)
+
+// serve configures and runs the cache application until a termination signal.
+func serve(_ context.Context, args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	cf := newConfigFlags(fs)
+	fs.String("from", "http://localhost:8080", "Origin server address to proxy requests from")
+	cf.bind("from", "proxy", "from")
+	fs.String("to", ":8020", "Port or address to serve the cache application on")
+	cf.bind("to", "proxy", "to")
+	fs.Bool("mocks", false, "Enable mocks mode (for dev/testing)")
+	cf.bind("mocks", "persistence", "mock", "enabled")
+	fs.Int("mockslen", 10000, "Length of mock data to generate if mocks mode is enabled")
+	cf.bind("mockslen", "persistence", "mock", "length")
+	fs.Bool("dump", false, "Enable dump loading at startup and writing at shutdown")
+	cf.bind("dump", "persistence", "dump", "enabled")
+	fs.String("peer", "", "Admin address of a running instance to warm up from before serving (e.g. http://cache-0:8021)")
+	cf.bind("peer", "persistence", "peer", "url")
+	fs.Bool("refresh", false, "Enable background data refresh")
+	cf.bind("refresh", "refresh", "enabled")
+	fs.Bool("eviction", false, "Enable data eviction on overflow")
+	cf.bind("eviction", "eviction", "enabled")
+	fs.Int("upstreamrate", 1000, "Maximum rate of upstream requests per second")
+	cf.bind("upstreamrate", "proxy", "rate")
+	fs.Uint("memorylimit", 34359738368, "Maximum amount of bytes that can be used to cache evictions")
+	cf.bind("memorylimit", "storage", "size")
+	fs.Int("procs", 3, "Maximum number of CPU cores to use")
+	cf.bind("procs", "runtime", "gomaxprocs")
+	isInteractive := fs.Bool("inter", false, "Enable interactive mode of data loading")
+	_ = fs.Parse(args)
+
+	logEvent := log.Info()
+	for _, o := range cf.overrides() {
+		logEvent.Interface(o.Source, o.Value)
+	}
+	logEvent.Msg("[main] startup flags")
+
+	// Create a root context for gracefulShutdown shutdown and cancellation.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Load the application configuration: file layers, env variables and flags.
+	cfg, err := cf.load()
+	if err != nil {
+		log.Err(err).Msg("[main] failed to load cache config")
+		return err
+	}
+	log.Info().Msgf("[config] config loaded from '%v'", cfg.Path)
+
+	// Apply the configured log level (it may be changed later by config reload).
+	zerolog.SetGlobalLevel(cfg.LogLevel())
+
+	// Optimize GOMAXPROCS for the current environment.
+	setMaxProcs(cfg)
+
+	// Setup gracefulShutdown shutdown handler (SIGTERM, SIGINT, etc).
+	gracefulShutdown := shutdown.NewGraceful(ctx, cancel)
+	gracefulShutdown.SetGracefulTimeout(time.Minute * 5)
+
+	// Initialize liveness probe for Kubernetes/Cloud health checks.
+	probe := liveness.NewProbe(cfg.Cache.K8S.Probe.Timeout)
+
+	// Initialize and start the cache application.
+	app, err := cache.NewApp(ctx, cfg, probe)
+	if err != nil {
+		log.Err(err).Msg("[main] failed to init cache app")
+		return err
+	}
+
+	// Load data from the dump and mocks or ask the user what to load.
+	if *isInteractive {
+		err = app.LoadDataInteractive(ctx)
+	} else {
+		err = app.LoadData(ctx)
+	}
+	if err != nil {
+		log.Err(err).Msg("[main] failed to load data")
+		return err
+	}
+
+	// Register app for gracefulShutdown shutdown.
+	gracefulShutdown.Add(1)
+	go app.Start(gracefulShutdown)
+
+	gcCtx, gcCancel := context.WithCancel(context.Background())
+	defer gcCancel()
+
+	// Run forced GC.
+	go gc.Run(gcCtx, cfg)
+
+	// Listen for OS signals or context cancellation and wait for gracefulShutdown shutdown.
+	if err = gracefulShutdown.ListenCancelAndAwait(); err != nil {
+		log.Err(err).Msg("failed to gracefully shut down service")
+	}
+	return nil
+}
+
+// setMaxProcs automatically sets the optimal GOMAXPROCS value (CPU parallelism)
+// based on the available CPUs and cgroup/docker CPU quotas (uses automaxprocs).
+func setMaxProcs(cfg *config.Cache) {
+	if cfg.Cache.Runtime.Gomaxprocs == 0 {
+		if _, err := maxprocs.Set(); err != nil {
+			log.Err(err).Msg("[main] setting up GOMAXPROCS value failed")
+		}
+	} else {
+		runtime.GOMAXPROCS(cfg.Cache.Runtime.Gomaxprocs)
+	}
+	log.Info().Msgf("[main] GOMAXPROCS=%d was set up", runtime.GOMAXPROCS(0))
+}
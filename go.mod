@@ -8,6 +8,7 @@ require (
 	github.com/VictoriaMetrics/metrics v1.39.1
 	github.com/andybalholm/brotli v1.2.0
 	github.com/fasthttp/router v1.5.4
+	github.com/klauspost/compress v1.18.0
 	github.com/manifoldco/promptui v0.9.0
 	github.com/rs/zerolog v1.34.0
 	github.com/valyala/fasthttp v1.64.0
@@ -19,7 +20,6 @@ require (
 
 require (
 	github.com/chzyer/readline v1.5.1 // indirect
-	github.com/klauspost/compress v1.18.0 // indirect
 	github.com/klauspost/cpuid/v2 v2.3.0 // indirect
 	github.com/kr/text v0.2.0 // indirect
 	github.com/mattn/go-colorable v0.1.14 // indirect
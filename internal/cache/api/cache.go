@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"github.com/Borislavv/advanced-cache/pkg/cluster"
 	"github.com/Borislavv/advanced-cache/pkg/config"
 	"github.com/Borislavv/advanced-cache/pkg/header"
 	"github.com/Borislavv/advanced-cache/pkg/model"
@@ -42,6 +43,7 @@ var (
 var (
 	total         = &atomic.Int64{}
 	hits          = &atomic.Int64{}
+	peerHits      = &atomic.Int64{} // served by the owner instance of the key (cluster), not by the local storage
 	misses        = &atomic.Int64{}
 	proxies       = &atomic.Int64{}
 	errors        = &atomic.Int64{}
@@ -55,10 +57,11 @@ type CacheController struct {
 	cache    storage.Storage
 	metrics  metrics.Meter
 	backend  upstream.Gateway
+	cluster  *cluster.Node // nil if the cluster mode is disabled
 	errorsCh chan error
 }
 
-// NewCacheController builds a cache API controller with all dependencies.
+// NewCacheController builds a cache API controller with all dependencies, node is nil out of the cluster mode.
 // If debug is enabled, launches internal stats logger goroutine.
 func NewCacheController(
 	ctx context.Context,
@@ -66,6 +69,7 @@ func NewCacheController(
 	cache storage.Storage,
 	metrics metrics.Meter,
 	backend upstream.Gateway,
+	node *cluster.Node,
 ) *CacheController {
 	c := &CacheController{
 		cfg:      cfg,
@@ -73,6 +77,7 @@ func NewCacheController(
 		cache:    cache,
 		metrics:  metrics,
 		backend:  backend,
+		cluster:  node,
 		errorsCh: make(chan error, 8196),
 	}
 	enabled.Store(cfg.Cache.Enabled)
@@ -114,6 +119,14 @@ func (c *CacheController) handleTroughCache(r *fasthttp.RequestCtx) {
 	)
 
 	foundEntry, found := c.cache.Get(newEntry)
+	fromPeer := false
+	if !found && c.cluster != nil {
+		// a key of another instance is requested from its owner before the upstream
+		queryHeaders, queryReleaser := c.queryHeaders(r)
+		foundEntry, found = c.cluster.Fetch(newEntry, r.Path(), r.QueryArgs().QueryString(), queryHeaders)
+		queryReleaser(queryHeaders)
+		fromPeer = found
+	}
 	if !found {
 		misses.Add(1)
 
@@ -146,7 +159,23 @@ func (c *CacheController) handleTroughCache(r *fasthttp.RequestCtx) {
 			payloadLastModified = newEntry.UpdateAt()
 		}
 	} else {
-		hits.Add(1)
+		if fromPeer {
+			peerHits.Add(1)
+		} else {
+			hits.Add(1)
+		}
+
+		// An expired entry restored from a dump is revalidated on the first hit,
+		// the stale payload is served if the upstream fails (and to concurrent requests meanwhile).
+		// Entries of other instances are revalidated by their owners.
+		if !fromPeer && foundEntry.ClaimStale() {
+			if err = foundEntry.Revalidate(); err == nil {
+				c.cache.Refreshed(foundEntry)
+			} else {
+				foundEntry.MarkStale()
+				log.Warn().Err(err).Msg("[cache] stale entry revalidation failed, serving stale")
+			}
+		}
 
 		payloadLastModified = foundEntry.UpdateAt()
 
@@ -288,6 +317,7 @@ func (c *CacheController) runLoggerMetricsWriter() {
 			// 5s логика
 			totalNum         int64
 			hitsNum          int64
+			peerHitsNum      int64
 			missesNum        int64
 			errorsNum        int64
 			proxiedNum       int64
@@ -319,6 +349,9 @@ func (c *CacheController) runLoggerMetricsWriter() {
 				hitsNumLoc := hits.Load()
 				hits.Store(0)
 
+				peerHitsNumLoc := peerHits.Load()
+				peerHits.Store(0)
+
 				missesNumLoc := misses.Load()
 				misses.Store(0)
 
@@ -348,14 +381,15 @@ func (c *CacheController) runLoggerMetricsWriter() {
 
 				totalNum += totalNumLoc
 				hitsNum += hitsNumLoc
+				peerHitsNum += peerHitsNumLoc
 				missesNum += missesNumLoc
 				errorsNum += errorsNumLoc
 				proxiedNum += proxiedNumLoc
 				totalDurationNum += totalDurationNumLoc
 
-				accHourly.add(totalNumLoc, hitsNumLoc, missesNumLoc, errorsNumLoc, proxiedNumLoc, totalDurationNumLoc)
-				acc12Hourly.add(totalNumLoc, hitsNumLoc, missesNumLoc, errorsNumLoc, proxiedNumLoc, totalDurationNumLoc)
-				acc24Hourly.add(totalNumLoc, hitsNumLoc, missesNumLoc, errorsNumLoc, proxiedNumLoc, totalDurationNumLoc)
+				accHourly.add(totalNumLoc, hitsNumLoc, peerHitsNumLoc, missesNumLoc, errorsNumLoc, proxiedNumLoc, totalDurationNumLoc)
+				acc12Hourly.add(totalNumLoc, hitsNumLoc, peerHitsNumLoc, missesNumLoc, errorsNumLoc, proxiedNumLoc, totalDurationNumLoc)
+				acc24Hourly.add(totalNumLoc, hitsNumLoc, peerHitsNumLoc, missesNumLoc, errorsNumLoc, proxiedNumLoc, totalDurationNumLoc)
 
 				if i == logIntervalSecs {
 					elapsed := time.Since(prev)
@@ -386,8 +420,8 @@ func (c *CacheController) runLoggerMetricsWriter() {
 
 					if enabled.Load() {
 						logEvent.Msgf(
-							"[%s][%s] served %d requests (rps: %.f, avg.dur.: %s hits: %d, misses: %d, errors: %d)",
-							target, elapsed.String(), totalNum, rps, duration.String(), hitsNum, missesNum, errorsNum,
+							"[%s][%s] served %d requests (rps: %.f, avg.dur.: %s hits: %d, peer hits: %d, misses: %d, errors: %d)",
+							target, elapsed.String(), totalNum, rps, duration.String(), hitsNum, peerHitsNum, missesNum, errorsNum,
 						)
 					} else {
 						logEvent.Msgf(
@@ -398,6 +432,7 @@ func (c *CacheController) runLoggerMetricsWriter() {
 
 					totalNum = 0
 					hitsNum = 0
+					peerHitsNum = 0
 					missesNum = 0
 					errorsNum = 0
 					proxiedNum = 0
@@ -426,15 +461,17 @@ func (c *CacheController) runLoggerMetricsWriter() {
 type counters struct {
 	total    int64
 	hits     int64
+	peerHits int64
 	misses   int64
 	errors   int64
 	proxied  int64
 	duration int64
 }
 
-func (c *counters) add(total, hits, misses, errors, proxied, dur int64) {
+func (c *counters) add(total, hits, peerHits, misses, errors, proxied, dur int64) {
 	c.total += total
 	c.hits += hits
+	c.peerHits += peerHits
 	c.misses += misses
 	c.errors += errors
 	c.proxied += proxied
@@ -442,7 +479,7 @@ func (c *counters) add(total, hits, misses, errors, proxied, dur int64) {
 }
 
 func (c *counters) reset() {
-	c.total, c.hits, c.misses, c.errors, c.proxied, c.duration = 0, 0, 0, 0, 0, 0
+	c.total, c.hits, c.peerHits, c.misses, c.errors, c.proxied, c.duration = 0, 0, 0, 0, 0, 0, 0
 }
 
 func logLong(label string, c counters, cfg *config.Cache) {
@@ -475,6 +512,7 @@ func logLong(label string, c counters, cfg *config.Cache) {
 			Str("period", label).
 			Int64("total", c.total).
 			Int64("hits", c.hits).
+			Int64("peerHits", c.peerHits).
 			Int64("misses", c.misses).
 			Int64("errors", c.errors).
 			Int64("proxied", c.proxied).
@@ -482,6 +520,6 @@ func logLong(label string, c counters, cfg *config.Cache) {
 			Str("avgDuration", avgDur.String())
 	}
 
-	logEvent.Msgf("[cache][%s] total=%d hits=%d misses=%d errors=%d proxied=%d avgRPS=%.2f avgDur=%s",
-		label, c.total, c.hits, c.misses, c.errors, c.proxied, avgRPS, avgDur.String())
+	logEvent.Msgf("[cache][%s] total=%d hits=%d peerHits=%d misses=%d errors=%d proxied=%d avgRPS=%.2f avgDur=%s",
+		label, c.total, c.hits, c.peerHits, c.misses, c.errors, c.proxied, avgRPS, avgDur.String())
 }
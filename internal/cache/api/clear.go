@@ -1,12 +1,9 @@
 package api
 
 import (
-	"crypto/rand"
-	"encoding/hex"
-	"encoding/json"
+	"github.com/Borislavv/advanced-cache/pkg/cluster"
 	"github.com/Borislavv/advanced-cache/pkg/config"
 	"github.com/Borislavv/advanced-cache/pkg/storage"
-	"sync"
 	"time"
 
 	"github.com/fasthttp/router"
@@ -14,21 +11,20 @@ import (
 	"github.com/valyala/fasthttp"
 )
 
-type ClearController struct {
-	db      storage.Storage
-	cfg     *config.Cache
-	mu      sync.Mutex
-	token   string
-	expires time.Time
-}
+// ClearPath is the admin route which removes all entries from the storage.
+const ClearPath = "/cache/clear"
 
-func NewClearController(cfg *config.Cache, db storage.Storage) *ClearController {
-	return &ClearController{cfg: cfg, db: db}
+// ClearController provides an endpoint to clear the whole storage.
+// It's served by the admin server, so authentication is done by the admin middleware.
+// In the cluster mode the storages of all instances are cleared (see Operations).
+type ClearController struct {
+	db  storage.Storage
+	cfg *config.Cache
+	ops *Operations
 }
 
-type tokenResponse struct {
-	Token     string `json:"token"`
-	ExpiresAt int64  `json:"expiresAt"`
+func NewClearController(cfg *config.Cache, db storage.Storage, ops *Operations) *ClearController {
+	return &ClearController{cfg: cfg, db: db, ops: ops}
 }
 
 type clearStatusResponse struct {
@@ -36,68 +32,18 @@ type clearStatusResponse struct {
 	Error   string `json:"error,omitempty"`
 }
 
-// HandleClear is mounted at GET /cache/clear.
-// Without ?token, returns a valid token (5min TTL).
-// With ?token, validates, clears storage, logs, and returns status.
+// HandleClear is mounted at POST /cache/clear and DELETE /cache.
+// Clears storage, logs the caller and returns status.
 func (c *ClearController) HandleClear(ctx *fasthttp.RequestCtx) {
-	now := time.Now()
-	raw := string(ctx.QueryArgs().Peek("token"))
-
-	if raw == "" {
-		// return or reuse token
-		c.mu.Lock()
-		if c.token != "" && now.Before(c.expires) {
-			tok, exp := c.token, c.expires
-			c.mu.Unlock()
-			ctx.SetStatusCode(fasthttp.StatusOK)
-			ctx.SetContentType("application/json")
-			_ = json.NewEncoder(ctx).Encode(tokenResponse{tok, exp.UnixMilli()})
-			return
-		}
-		c.mu.Unlock()
-
-		// generate new token
-		b := make([]byte, 16)
-		if _, err := rand.Read(b); err != nil {
-			log.Error().Err(err).Msg("token generation failed")
-			ctx.Error("internal error", fasthttp.StatusInternalServerError)
-			return
-		}
-		tok := hex.EncodeToString(b)
-		exp := now.Add(5 * time.Minute)
-
-		c.mu.Lock()
-		c.token = tok
-		c.expires = exp
-		c.mu.Unlock()
-
-		ctx.SetStatusCode(fasthttp.StatusOK)
-		ctx.SetContentType("application/json")
-		_ = json.NewEncoder(ctx).Encode(tokenResponse{tok, exp.UnixMilli()})
-		return
-	}
-
-	// validate provided token
-	c.mu.Lock()
-	valid := raw == c.token && now.Before(c.expires)
-	c.token = ""
-	c.expires = time.Time{}
-	c.mu.Unlock()
-
-	if !valid {
-		ctx.SetStatusCode(fasthttp.StatusForbidden)
-		ctx.SetContentType("application/json")
-		_ = json.NewEncoder(ctx).Encode(clearStatusResponse{Error: "invalid or expired token"})
+	status, _, err := c.ops.run(ctx, cluster.Operation{Kind: cluster.OpClear})
+	if err != nil {
+		c.ops.respond(ctx, status, nil, err)
 		return
 	}
 
-	// clear and log
-	c.db.Clear()
-
 	logEvent := log.Info()
 	if c.cfg.IsProd() {
 		logEvent.
-			Str("token", raw).
 			Str("ip", ctx.RemoteAddr().String()).
 			Str("method", string(ctx.Method())).
 			Str("path", string(ctx.Path())).
@@ -106,11 +52,10 @@ func (c *ClearController) HandleClear(ctx *fasthttp.RequestCtx) {
 	}
 	logEvent.Msg("storage cleared")
 
-	ctx.SetStatusCode(fasthttp.StatusOK)
-	ctx.SetContentType("application/json")
-	_ = json.NewEncoder(ctx).Encode(clearStatusResponse{Cleared: true})
+	c.ops.respond(ctx, status, clearStatusResponse{Cleared: true}, nil)
 }
 
 func (c *ClearController) AddRoute(r *router.Router) {
-	r.GET("/cache/clear", c.HandleClear)
+	r.POST(ClearPath, c.HandleClear)
+	r.DELETE("/cache", c.HandleClear)
 }
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"encoding/json"
+
+	"github.com/Borislavv/advanced-cache/pkg/cluster"
+	"github.com/fasthttp/router"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	// ClusterPeersPath is the admin route with the ring and the counters of the instance.
+	ClusterPeersPath = "/cluster/peers"
+	// ClusterOpsPath is the admin route with the statuses of the operations broadcast by the instance.
+	ClusterOpsPath = "/cluster/ops"
+)
+
+// ClusterController serves entries (see cluster.Node.ServeEntry), applies operations
+// (see cluster.Broadcaster.ServeApply) and refreshed entries (see cluster.Node.ServeRefreshed)
+// of other instances of the cluster.
+type ClusterController struct {
+	node        *cluster.Node
+	broadcaster *cluster.Broadcaster
+}
+
+func NewClusterController(node *cluster.Node, broadcaster *cluster.Broadcaster) *ClusterController {
+	return &ClusterController{node: node, broadcaster: broadcaster}
+}
+
+type clusterPeersResponse struct {
+	Self  string        `json:"self"`
+	Peers []string      `json:"peers"`
+	Stats cluster.Stats `json:"stats"`
+}
+
+// Peers handles GET /cluster/peers.
+func (c *ClusterController) Peers(ctx *fasthttp.RequestCtx) {
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType("application/json")
+	_ = json.NewEncoder(ctx).Encode(clusterPeersResponse{
+		Self:  c.node.Self(),
+		Peers: c.node.Ring().Peers(),
+		Stats: c.node.Stats(),
+	})
+}
+
+// Ops handles GET /cluster/ops[?id=...]: the recent operations (the newest first) or the one of the ID
+// with the acks of the peers.
+func (c *ClusterController) Ops(ctx *fasthttp.RequestCtx) {
+	ctx.SetContentType("application/json")
+	if id := ctx.QueryArgs().Peek("id"); len(id) > 0 {
+		status, err := c.broadcaster.Status(string(id))
+		if err != nil {
+			ctx.SetStatusCode(fasthttp.StatusNotFound)
+			_ = json.NewEncoder(ctx).Encode(operationErrorResponse{Error: err.Error()})
+			return
+		}
+		ctx.SetStatusCode(fasthttp.StatusOK)
+		_ = json.NewEncoder(ctx).Encode(status)
+		return
+	}
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	_ = json.NewEncoder(ctx).Encode(c.broadcaster.Recent())
+}
+
+func (c *ClusterController) AddRoute(r *router.Router) {
+	r.POST(cluster.EntryPath, c.node.ServeEntry)
+	r.POST(cluster.ApplyPath, c.broadcaster.ServeApply)
+	r.POST(cluster.RefreshedPath, c.node.ServeRefreshed)
+	r.GET(ClusterPeersPath, c.Peers)
+	r.GET(ClusterOpsPath, c.Ops)
+}
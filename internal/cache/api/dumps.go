@@ -0,0 +1,122 @@
+package api
+
+import (
+	"encoding/json"
+	goerrors "errors"
+
+	"github.com/Borislavv/advanced-cache/pkg/storage"
+	"github.com/fasthttp/router"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	DumpsPath    = "/cache/dumps"
+	DumpLoadPath = "/cache/dumps/load"
+	DumpJobsPath = "/cache/dumps/jobs"
+)
+
+// DumpsController exposes dump management of the running instance (admin only):
+// dumps on demand, versions with manifests, loading and deleting versions. Dumps and loads are jobs,
+// their progress is polled by id.
+type DumpsController struct {
+	manager *storage.DumpManager
+}
+
+func NewDumpsController(manager *storage.DumpManager) *DumpsController {
+	return &DumpsController{manager: manager}
+}
+
+type dumpsErrorResponse struct {
+	Error string           `json:"error"`
+	Job   *storage.JobInfo `json:"job,omitempty"` // the running one if the error is a busy one
+}
+
+// List handles GET /cache/dumps.
+func (c *DumpsController) List(ctx *fasthttp.RequestCtx) {
+	versions, err := c.manager.Versions()
+	if err != nil {
+		c.respondError(ctx, err, storage.JobInfo{})
+		return
+	}
+	c.respond(ctx, fasthttp.StatusOK, versions)
+}
+
+// Dump handles POST /cache/dumps: starts a dump job.
+func (c *DumpsController) Dump(ctx *fasthttp.RequestCtx) {
+	job, err := c.manager.Dump()
+	if err != nil {
+		c.respondError(ctx, err, job)
+		return
+	}
+	c.respond(ctx, fasthttp.StatusAccepted, job)
+}
+
+// Load handles POST /cache/dumps/load?version=v3&mode=merge|replace: starts a job loading the version.
+func (c *DumpsController) Load(ctx *fasthttp.RequestCtx) {
+	mode := string(ctx.QueryArgs().Peek("mode"))
+	if mode == "" {
+		mode = storage.LoadMerge
+	}
+	job, err := c.manager.Load(string(ctx.QueryArgs().Peek("version")), mode)
+	if err != nil {
+		c.respondError(ctx, err, job)
+		return
+	}
+	c.respond(ctx, fasthttp.StatusAccepted, job)
+}
+
+// Delete handles DELETE /cache/dumps?version=v3.
+func (c *DumpsController) Delete(ctx *fasthttp.RequestCtx) {
+	version := string(ctx.QueryArgs().Peek("version"))
+	if err := c.manager.Delete(version); err != nil {
+		c.respondError(ctx, err, storage.JobInfo{})
+		return
+	}
+	c.respond(ctx, fasthttp.StatusOK, map[string]string{"deleted": version})
+}
+
+// Jobs handles GET /cache/dumps/jobs[?id=N]: a job by id or the running and recent ones.
+func (c *DumpsController) Jobs(ctx *fasthttp.RequestCtx) {
+	id := string(ctx.QueryArgs().Peek("id"))
+	if id == "" {
+		c.respond(ctx, fasthttp.StatusOK, c.manager.Jobs().List())
+		return
+	}
+	job, err := c.manager.Jobs().Get(id)
+	if err != nil {
+		c.respondError(ctx, err, storage.JobInfo{})
+		return
+	}
+	c.respond(ctx, fasthttp.StatusOK, job)
+}
+
+func (c *DumpsController) respondError(ctx *fasthttp.RequestCtx, err error, job storage.JobInfo) {
+	status := fasthttp.StatusBadRequest
+	resp := dumpsErrorResponse{Error: err.Error()}
+	switch {
+	case goerrors.Is(err, storage.VersionNotFoundError), goerrors.Is(err, storage.JobNotFoundError):
+		status = fasthttp.StatusNotFound
+	case goerrors.Is(err, storage.JobBusyError), goerrors.Is(err, storage.VersionBusyError):
+		status = fasthttp.StatusConflict
+		if job.ID != "" {
+			resp.Job = &job
+		}
+	case goerrors.Is(err, storage.IncompleteDumpError):
+		status = fasthttp.StatusUnprocessableEntity
+	}
+	c.respond(ctx, status, resp)
+}
+
+func (c *DumpsController) respond(ctx *fasthttp.RequestCtx, status int, v any) {
+	ctx.SetStatusCode(status)
+	ctx.SetContentType("application/json")
+	_ = json.NewEncoder(ctx).Encode(v)
+}
+
+func (c *DumpsController) AddRoute(r *router.Router) {
+	r.GET(DumpsPath, c.List)
+	r.POST(DumpsPath, c.Dump)
+	r.DELETE(DumpsPath, c.Delete)
+	r.POST(DumpLoadPath, c.Load)
+	r.GET(DumpJobsPath, c.Jobs)
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/Borislavv/advanced-cache/pkg/config"
+	"github.com/Borislavv/advanced-cache/pkg/storage"
+	"github.com/Borislavv/advanced-cache/pkg/storage/exchange"
+	"github.com/Borislavv/advanced-cache/pkg/upstream"
+	"github.com/fasthttp/router"
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	ExportPath = "/cache/export"
+	ImportPath = "/cache/import"
+)
+
+// ExchangeController exports entries of the storage as JSONL or HAR and imports them (admin only),
+// e.g. to seed a staging instance with a subset of the production cache.
+type ExchangeController struct {
+	ctx     context.Context
+	cfg     *config.Cache
+	db      storage.Storage
+	backend upstream.Gateway
+}
+
+func NewExchangeController(ctx context.Context, cfg *config.Cache, db storage.Storage, backend upstream.Gateway) *ExchangeController {
+	return &ExchangeController{ctx: ctx, cfg: cfg, db: db, backend: backend}
+}
+
+type exchangeErrorResponse struct {
+	Error string                `json:"error"`
+	Stats *exchange.ImportStats `json:"stats,omitempty"` // records imported before a broken one
+}
+
+// Export handles GET /cache/export?format=jsonl|har[&rule=/api/v2/pagedata][&prefix=/api][&min_age=1h][&max_age=24h].
+func (c *ExchangeController) Export(ctx *fasthttp.RequestCtx) {
+	args := ctx.QueryArgs()
+	filter := exchange.Filter{Rule: string(args.Peek("rule")), Prefix: string(args.Peek("prefix"))}
+	for name, age := range map[string]*time.Duration{"min_age": &filter.MinAge, "max_age": &filter.MaxAge} {
+		if v := args.Peek(name); len(v) > 0 {
+			d, err := time.ParseDuration(string(v))
+			if err != nil {
+				c.respond(ctx, fasthttp.StatusBadRequest, exchangeErrorResponse{Error: name + ": " + err.Error()})
+				return
+			}
+			*age = d
+		}
+	}
+	format := string(args.Peek("format"))
+	if _, err := exchange.NewEncoder(nil, format, ""); err != nil {
+		c.respond(ctx, fasthttp.StatusBadRequest, exchangeErrorResponse{Error: err.Error()})
+		return
+	}
+
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	if format == exchange.FormatHAR {
+		ctx.SetContentType("application/json")
+	} else {
+		ctx.SetContentType("application/x-ndjson")
+	}
+	baseURL := string(c.cfg.Cache.Proxy.FromUrl)
+	ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+		enc, _ := exchange.NewEncoder(w, format, baseURL)
+		n, err := exchange.Export(c.ctx, c.db, filter, enc)
+		if err == nil {
+			err = enc.Close()
+		}
+		if err == nil {
+			err = w.Flush()
+		}
+		if err != nil {
+			log.Warn().Err(err).Msgf("[export] broken after %d entries", n)
+			return
+		}
+		log.Info().Msgf("[export] %d entries exported", n)
+	})
+}
+
+// Import handles POST /cache/import?format=jsonl|har with the exported document as the body.
+func (c *ExchangeController) Import(ctx *fasthttp.RequestCtx) {
+	format := string(ctx.QueryArgs().Peek("format"))
+	stats, err := exchange.Import(c.cfg, c.db, c.backend.RevalidatorMaker(), bytes.NewReader(ctx.PostBody()), format)
+	if err != nil {
+		c.respond(ctx, fasthttp.StatusBadRequest, exchangeErrorResponse{Error: err.Error(), Stats: &stats})
+		return
+	}
+	log.Info().Msgf("[import] %d of %d entries imported, no rule: %d, rejected: %d, invalid: %d",
+		stats.Imported, stats.Records, stats.NoRule, stats.Rejected, stats.Invalid)
+	c.respond(ctx, fasthttp.StatusOK, stats)
+}
+
+func (c *ExchangeController) respond(ctx *fasthttp.RequestCtx, status int, v any) {
+	ctx.SetStatusCode(status)
+	ctx.SetContentType("application/json")
+	_ = json.NewEncoder(ctx).Encode(v)
+}
+
+func (c *ExchangeController) AddRoute(r *router.Router) {
+	r.GET(ExportPath, c.Export)
+	r.POST(ImportPath, c.Import)
+}
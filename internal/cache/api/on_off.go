@@ -1,17 +1,19 @@
 package api
 
 import (
-	"encoding/json"
+	"github.com/Borislavv/advanced-cache/pkg/cluster"
 	"github.com/fasthttp/router"
 	"github.com/valyala/fasthttp"
 )
 
-// OnOffController provides endpoints to switch the advanced cache on and off.
-type OnOffController struct{}
+// OnOffController provides endpoints to switch the advanced cache on and off (of all instances in the cluster mode).
+type OnOffController struct {
+	ops *Operations
+}
 
 // NewOnOffController creates a new OnOffController instance.
-func NewOnOffController() *OnOffController {
-	return &OnOffController{}
+func NewOnOffController(ops *Operations) *OnOffController {
+	return &OnOffController{ops: ops}
 }
 
 // clearStatusResponse is the JSON payload returned by On and Off handlers.
@@ -20,26 +22,20 @@ type onOffStatusResponse struct {
 	Message string `json:"message,omitempty"`
 }
 
-// On handles POST /adv-cache/on and enables the advanced cache, returning JSON.
+// On handles POST /cache/on and enables the advanced cache, returning JSON.
 func (c *OnOffController) On(ctx *fasthttp.RequestCtx) {
-	enabled.Store(true)
-	resp := onOffStatusResponse{Enabled: true, Message: "cache enabled"}
-	ctx.SetStatusCode(fasthttp.StatusOK)
-	ctx.SetContentType("application/json; charset=utf-8")
-	_ = json.NewEncoder(ctx).Encode(resp)
+	status, _, err := c.ops.run(ctx, cluster.Operation{Kind: cluster.OpOn})
+	c.ops.respond(ctx, status, onOffStatusResponse{Enabled: true, Message: "cache enabled"}, err)
 }
 
-// Off handles POST /adv-cache/off and disables the advanced cache, returning JSON.
+// Off handles POST /cache/off and disables the advanced cache, returning JSON.
 func (c *OnOffController) Off(ctx *fasthttp.RequestCtx) {
-	enabled.Store(false)
-	resp := onOffStatusResponse{Enabled: false, Message: "cache disabled"}
-	ctx.SetStatusCode(fasthttp.StatusOK)
-	ctx.SetContentType("application/json; charset=utf-8")
-	_ = json.NewEncoder(ctx).Encode(resp)
+	status, _, err := c.ops.run(ctx, cluster.Operation{Kind: cluster.OpOff})
+	c.ops.respond(ctx, status, onOffStatusResponse{Enabled: false, Message: "cache disabled"}, err)
 }
 
 // AddRoute attaches the on/off routes to the given router.
 func (c *OnOffController) AddRoute(r *router.Router) {
-	r.GET("/cache/on", c.On)
-	r.GET("/cache/off", c.Off)
+	r.POST("/cache/on", c.On)
+	r.POST("/cache/off", c.Off)
 }
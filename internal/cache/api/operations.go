@@ -0,0 +1,108 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	goerrors "errors"
+
+	"github.com/Borislavv/advanced-cache/pkg/cluster"
+	"github.com/Borislavv/advanced-cache/pkg/storage"
+	"github.com/valyala/fasthttp"
+)
+
+// Operations applies the admin operations which change the state of the whole cache (purge, clear, on/off).
+// In the cluster mode they are broadcast to all instances unless the request has local=true.
+type Operations struct {
+	ctx         context.Context
+	db          storage.Storage
+	broadcaster *cluster.Broadcaster
+}
+
+// NewOperations creates the broadcaster of the node, the node is nil outside the cluster mode.
+func NewOperations(ctx context.Context, db storage.Storage, node *cluster.Node) *Operations {
+	ops := &Operations{ctx: ctx, db: db}
+	if node != nil {
+		ops.broadcaster = cluster.NewBroadcaster(ctx, node, ops.Apply)
+	}
+	return ops
+}
+
+// Broadcaster returns nil outside the cluster mode.
+func (o *Operations) Broadcaster() *cluster.Broadcaster {
+	return o.broadcaster
+}
+
+// Apply applies the operation on this instance, returns the number of affected entries.
+func (o *Operations) Apply(op cluster.Operation) (int, error) {
+	switch op.Kind {
+	case cluster.OpPurge, cluster.OpSoftPurge:
+		return storage.Purge(o.ctx, o.db, op.Filter, op.Kind == cluster.OpSoftPurge)
+	case cluster.OpClear:
+		n := o.db.RealLen()
+		o.db.Clear()
+		return int(n), nil
+	case cluster.OpOn:
+		enabled.Store(true)
+		return 0, nil
+	case cluster.OpOff:
+		enabled.Store(false)
+		return 0, nil
+	}
+	return 0, cluster.UnknownOperationError
+}
+
+// run applies the operation of the request. The status is nil unless the operation was broadcast,
+// the ID of a broadcast operation may be given as the id argument to make retries of the request idempotent.
+func (o *Operations) run(ctx *fasthttp.RequestCtx, op cluster.Operation) (*cluster.OperationStatus, int, error) {
+	if o.broadcaster == nil {
+		if err := op.Validate(); err != nil {
+			return nil, 0, err
+		}
+		n, err := o.Apply(op)
+		return nil, n, err
+	}
+
+	args := ctx.QueryArgs()
+	op.ID = string(args.Peek("id"))
+	if args.GetBool("local") {
+		ack, err := o.broadcaster.ApplyLocal(op)
+		if err != nil {
+			return nil, 0, err
+		}
+		if ack.State != cluster.AckDone {
+			return nil, 0, goerrors.New(ack.Error)
+		}
+		return nil, ack.Affected, nil
+	}
+	status, err := o.broadcaster.Submit(op)
+	if err != nil {
+		return nil, 0, err
+	}
+	if status.Local.State == cluster.AckFailed {
+		return &status, 0, goerrors.New(status.Local.Error)
+	}
+	return &status, status.Local.Affected, nil
+}
+
+type operationErrorResponse struct {
+	Error  string                   `json:"error"`
+	Status *cluster.OperationStatus `json:"status,omitempty"`
+}
+
+// respond writes the status of a broadcast operation or, if it was applied locally, the local response.
+func (o *Operations) respond(ctx *fasthttp.RequestCtx, status *cluster.OperationStatus, local any, err error) {
+	code, body := fasthttp.StatusOK, local
+	switch {
+	case goerrors.Is(err, cluster.OperationIDConflictedError):
+		code, body = fasthttp.StatusConflict, operationErrorResponse{Error: err.Error()}
+	case goerrors.Is(err, cluster.UnknownOperationError), goerrors.Is(err, storage.PurgeFilterIsEmptyError):
+		code, body = fasthttp.StatusBadRequest, operationErrorResponse{Error: err.Error()}
+	case err != nil:
+		code, body = fasthttp.StatusInternalServerError, operationErrorResponse{Error: err.Error(), Status: status}
+	case status != nil:
+		body = status
+	}
+	ctx.SetStatusCode(code)
+	ctx.SetContentType("application/json")
+	_ = json.NewEncoder(ctx).Encode(body)
+}
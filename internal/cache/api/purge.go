@@ -0,0 +1,54 @@
+package api
+
+import (
+	"github.com/Borislavv/advanced-cache/pkg/cluster"
+	"github.com/Borislavv/advanced-cache/pkg/storage"
+	"github.com/fasthttp/router"
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	PurgePath     = "/cache/purge"
+	SoftPurgePath = "/cache/soft-purge"
+)
+
+// PurgeController removes the entries of a rule or a path prefix (purge) or marks them stale,
+// so they are served until revalidated (soft purge). In the cluster mode all instances are purged (see Operations).
+type PurgeController struct {
+	ops *Operations
+}
+
+func NewPurgeController(ops *Operations) *PurgeController {
+	return &PurgeController{ops: ops}
+}
+
+type purgeStatusResponse struct {
+	Affected int  `json:"affected"`
+	Soft     bool `json:"soft,omitempty"`
+}
+
+// Purge handles POST /cache/purge?rule=/api/v2/pagedata|prefix=/api[&id=...][&local=true].
+func (c *PurgeController) Purge(ctx *fasthttp.RequestCtx) {
+	c.purge(ctx, cluster.OpPurge)
+}
+
+// SoftPurge handles POST /cache/soft-purge with the arguments of Purge.
+func (c *PurgeController) SoftPurge(ctx *fasthttp.RequestCtx) {
+	c.purge(ctx, cluster.OpSoftPurge)
+}
+
+func (c *PurgeController) purge(ctx *fasthttp.RequestCtx, kind string) {
+	args := ctx.QueryArgs()
+	filter := storage.PurgeFilter{Rule: string(args.Peek("rule")), Prefix: string(args.Peek("prefix"))}
+	status, n, err := c.ops.run(ctx, cluster.Operation{Kind: kind, Filter: filter})
+	if err == nil {
+		log.Info().Str("rule", filter.Rule).Str("prefix", filter.Prefix).Msgf("[admin] %s: %d entries", kind, n)
+	}
+	c.ops.respond(ctx, status, purgeStatusResponse{Affected: n, Soft: kind == cluster.OpSoftPurge}, err)
+}
+
+func (c *PurgeController) AddRoute(r *router.Router) {
+	r.POST(PurgePath, c.Purge)
+	r.POST(SoftPurgePath, c.SoftPurge)
+}
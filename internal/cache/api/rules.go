@@ -0,0 +1,180 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	goerrors "errors"
+	"strconv"
+
+	"github.com/Borislavv/advanced-cache/pkg/config"
+	"github.com/Borislavv/advanced-cache/pkg/rules"
+	"github.com/fasthttp/router"
+	"github.com/valyala/fasthttp"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	RulesPath         = "/cache/rules"
+	RulePath          = "/cache/rule"
+	RuleVersionsPath  = "/cache/rules/versions"
+	RulesRollbackPath = "/cache/rules/rollback"
+)
+
+// RulesController exposes runtime management of config.Rule entries (admin only).
+// Rule bodies are accepted both as JSON and YAML, durations are strings like "12h".
+type RulesController struct {
+	manager *rules.Manager
+}
+
+func NewRulesController(manager *rules.Manager) *RulesController {
+	return &RulesController{manager: manager}
+}
+
+type rulesResponse struct {
+	Version int `json:"version"`
+	Rules   any `json:"rules,omitempty"`
+	Rule    any `json:"rule,omitempty"`
+}
+
+type rulesErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// List handles GET /cache/rules.
+func (c *RulesController) List(ctx *fasthttp.RequestCtx) {
+	view, err := toView(c.manager.List())
+	if err != nil {
+		c.respondError(ctx, err)
+		return
+	}
+	c.respond(ctx, fasthttp.StatusOK, rulesResponse{Version: c.manager.Version(), Rules: view})
+}
+
+// Get handles GET /cache/rule?path=/api/v2/pagedata.
+func (c *RulesController) Get(ctx *fasthttp.RequestCtx) {
+	rule, ok := c.manager.Get(string(ctx.QueryArgs().Peek("path")))
+	if !ok {
+		c.respondError(ctx, rules.RuleNotFoundError)
+		return
+	}
+	view, err := toView(rule)
+	if err != nil {
+		c.respondError(ctx, err)
+		return
+	}
+	c.respond(ctx, fasthttp.StatusOK, rulesResponse{Version: c.manager.Version(), Rule: view})
+}
+
+// Create handles POST /cache/rule?path=/api/v2/pagedata.
+func (c *RulesController) Create(ctx *fasthttp.RequestCtx) {
+	c.change(ctx, fasthttp.StatusCreated, c.manager.Create)
+}
+
+// Update handles PUT /cache/rule?path=/api/v2/pagedata.
+func (c *RulesController) Update(ctx *fasthttp.RequestCtx) {
+	c.change(ctx, fasthttp.StatusOK, c.manager.Update)
+}
+
+// Delete handles DELETE /cache/rule?path=/api/v2/pagedata.
+func (c *RulesController) Delete(ctx *fasthttp.RequestCtx) {
+	version, err := c.manager.Delete(string(ctx.QueryArgs().Peek("path")))
+	if err != nil {
+		c.respondError(ctx, err)
+		return
+	}
+	c.respond(ctx, fasthttp.StatusOK, rulesResponse{Version: version})
+}
+
+// Versions handles GET /cache/rules/versions.
+func (c *RulesController) Versions(ctx *fasthttp.RequestCtx) {
+	versions, err := c.manager.Versions()
+	if err != nil {
+		c.respondError(ctx, err)
+		return
+	}
+	c.respond(ctx, fasthttp.StatusOK, versions)
+}
+
+// Rollback handles POST /cache/rules/rollback?version=N.
+func (c *RulesController) Rollback(ctx *fasthttp.RequestCtx) {
+	target, err := strconv.Atoi(string(ctx.QueryArgs().Peek("version")))
+	if err != nil {
+		c.respond(ctx, fasthttp.StatusBadRequest, rulesErrorResponse{Error: "query param 'version' must be an integer"})
+		return
+	}
+	version, err := c.manager.Rollback(target)
+	if err != nil {
+		c.respondError(ctx, err)
+		return
+	}
+	c.respond(ctx, fasthttp.StatusOK, rulesResponse{Version: version})
+}
+
+func (c *RulesController) change(ctx *fasthttp.RequestCtx, status int, fn func(string, *config.Rule) (int, error)) {
+	path := string(ctx.QueryArgs().Peek("path"))
+	if path == "" {
+		c.respond(ctx, fasthttp.StatusBadRequest, rulesErrorResponse{Error: "query param 'path' is required"})
+		return
+	}
+
+	rule := &config.Rule{}
+	dec := yaml.NewDecoder(bytes.NewReader(ctx.PostBody()))
+	dec.KnownFields(true) // misspelled fields are errors, not silently dropped
+	if err := dec.Decode(rule); err != nil {
+		c.respond(ctx, fasthttp.StatusBadRequest, rulesErrorResponse{Error: "invalid rule body: " + err.Error()})
+		return
+	}
+	if rule.Extends != "" {
+		// templates are merged by the config load only, the rule would differ after a restart
+		c.respond(ctx, fasthttp.StatusBadRequest, rulesErrorResponse{Error: "invalid rule body: extends is not supported, set the values of the template"})
+		return
+	}
+
+	version, err := fn(path, rule)
+	if err != nil {
+		c.respondError(ctx, err)
+		return
+	}
+	c.respond(ctx, status, rulesResponse{Version: version})
+}
+
+func (c *RulesController) respondError(ctx *fasthttp.RequestCtx, err error) {
+	status := fasthttp.StatusBadRequest
+	switch {
+	case goerrors.Is(err, rules.RuleNotFoundError), goerrors.Is(err, rules.VersionNotFoundError):
+		status = fasthttp.StatusNotFound
+	case goerrors.Is(err, rules.RuleExistsError):
+		status = fasthttp.StatusConflict
+	}
+	c.respond(ctx, status, rulesErrorResponse{Error: err.Error()})
+}
+
+func (c *RulesController) respond(ctx *fasthttp.RequestCtx, status int, v any) {
+	ctx.SetStatusCode(status)
+	ctx.SetContentType("application/json")
+	_ = json.NewEncoder(ctx).Encode(v)
+}
+
+// toView converts config structs into a generic JSON-friendly form through YAML
+// (so field names and durations look exactly like in the config file).
+func toView(v any) (any, error) {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var view any
+	if err = yaml.Unmarshal(data, &view); err != nil {
+		return nil, err
+	}
+	return view, nil
+}
+
+func (c *RulesController) AddRoute(r *router.Router) {
+	r.GET(RulesPath, c.List)
+	r.GET(RuleVersionsPath, c.Versions)
+	r.POST(RulesRollbackPath, c.Rollback)
+	r.GET(RulePath, c.Get)
+	r.POST(RulePath, c.Create)
+	r.PUT(RulePath, c.Update)
+	r.DELETE(RulePath, c.Delete)
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"bufio"
+	"context"
+
+	"github.com/Borislavv/advanced-cache/pkg/config"
+	"github.com/Borislavv/advanced-cache/pkg/storage"
+	"github.com/fasthttp/router"
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
+)
+
+// SnapshotPath is the admin route streaming a snapshot of the storage, new instances warm up from it
+// (see persistence.peer).
+const SnapshotPath = "/cache/snapshot"
+
+// SnapshotController streams the live storage in the snapshot format (see storage.WriteSnapshot).
+type SnapshotController struct {
+	ctx context.Context
+	cfg *config.Cache
+	db  storage.Storage
+}
+
+func NewSnapshotController(ctx context.Context, cfg *config.Cache, db storage.Storage) *SnapshotController {
+	return &SnapshotController{ctx: ctx, cfg: cfg, db: db}
+}
+
+// HandleSnapshot is mounted at GET /cache/snapshot. An error breaks the stream, so the reader gets a truncated snapshot.
+func (c *SnapshotController) HandleSnapshot(ctx *fasthttp.RequestCtx) {
+	remote := ctx.RemoteAddr().String()
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType("application/octet-stream")
+	ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+		n, err := storage.WriteSnapshot(c.ctx, c.cfg, c.db, w)
+		if err == nil {
+			err = w.Flush()
+		}
+		if err != nil {
+			log.Warn().Err(err).Str("peer", remote).Msgf("[peer] snapshot stream broken after %d entries", n)
+			return
+		}
+		log.Info().Str("peer", remote).Msgf("[peer] snapshot streamed: %d entries", n)
+	})
+}
+
+func (c *SnapshotController) AddRoute(r *router.Router) {
+	r.GET(SnapshotPath, c.HandleSnapshot)
+}
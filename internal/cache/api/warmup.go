@@ -0,0 +1,95 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	goerrors "errors"
+
+	"github.com/Borislavv/advanced-cache/pkg/storage"
+	"github.com/Borislavv/advanced-cache/pkg/warmup"
+	"github.com/fasthttp/router"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	WarmupPath     = "/cache/warmup"
+	WarmupJobsPath = "/cache/warmup/jobs"
+)
+
+// WarmupController starts warm-up jobs (admin only): from a URL list of the request body
+// or from the configured sources if the body is empty.
+type WarmupController struct {
+	warmer *warmup.Warmer
+}
+
+func NewWarmupController(warmer *warmup.Warmer) *WarmupController {
+	return &WarmupController{warmer: warmer}
+}
+
+type warmupErrorResponse struct {
+	Error string           `json:"error"`
+	Job   *storage.JobInfo `json:"job,omitempty"` // the running one if the error is a busy one
+}
+
+// Start handles POST /cache/warmup[?format=text|jsonl|sitemap].
+func (c *WarmupController) Start(ctx *fasthttp.RequestCtx) {
+	var (
+		requests []warmup.Request
+		invalid  int
+		target   = "sources"
+		err      error
+	)
+	if body := ctx.PostBody(); len(body) > 0 {
+		target = "request"
+		err = warmup.Parse(bytes.NewReader(body), string(ctx.QueryArgs().Peek("format")), nil, func(req warmup.Request, err error) error {
+			if err != nil {
+				invalid++
+				return nil
+			}
+			requests = append(requests, req)
+			return nil
+		})
+	} else {
+		requests, invalid, err = c.warmer.ReadSources()
+	}
+	if err != nil {
+		c.respond(ctx, fasthttp.StatusBadRequest, warmupErrorResponse{Error: err.Error()})
+		return
+	}
+
+	job, err := c.warmer.Start(target, requests, invalid)
+	if goerrors.Is(err, storage.JobBusyError) {
+		c.respond(ctx, fasthttp.StatusConflict, warmupErrorResponse{Error: err.Error(), Job: &job})
+		return
+	} else if err != nil {
+		c.respond(ctx, fasthttp.StatusBadRequest, warmupErrorResponse{Error: err.Error()})
+		return
+	}
+	c.respond(ctx, fasthttp.StatusAccepted, job)
+}
+
+// Jobs handles GET /cache/warmup/jobs[?id=N]: a job by id or the running and recent ones.
+func (c *WarmupController) Jobs(ctx *fasthttp.RequestCtx) {
+	id := string(ctx.QueryArgs().Peek("id"))
+	if id == "" {
+		c.respond(ctx, fasthttp.StatusOK, c.warmer.Jobs().List())
+		return
+	}
+	job, err := c.warmer.Jobs().Get(id)
+	if err != nil {
+		c.respond(ctx, fasthttp.StatusNotFound, warmupErrorResponse{Error: err.Error()})
+		return
+	}
+	c.respond(ctx, fasthttp.StatusOK, job)
+}
+
+func (c *WarmupController) respond(ctx *fasthttp.RequestCtx, status int, v any) {
+	ctx.SetStatusCode(status)
+	ctx.SetContentType("application/json")
+	_ = json.NewEncoder(ctx).Encode(v)
+}
+
+func (c *WarmupController) AddRoute(r *router.Router) {
+	r.POST(WarmupPath, c.Start)
+	r.GET(WarmupJobsPath, c.Jobs)
+}
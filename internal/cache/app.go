@@ -3,25 +3,24 @@ package cache
 import (
 	"context"
 	"errors"
-	"fmt"
-	"github.com/Borislavv/advanced-cache/pkg/upstream"
-	"io/fs"
-	"os"
-	"os/exec"
-	"path/filepath"
-	"sort"
-	"strconv"
 	"strings"
 	"time"
 
+	"github.com/Borislavv/advanced-cache/internal/cache/api"
 	"github.com/Borislavv/advanced-cache/internal/cache/server"
+	"github.com/Borislavv/advanced-cache/pkg/cluster"
 	"github.com/Borislavv/advanced-cache/pkg/config"
 	"github.com/Borislavv/advanced-cache/pkg/k8s/probe/liveness"
 	"github.com/Borislavv/advanced-cache/pkg/prometheus/metrics"
+	"github.com/Borislavv/advanced-cache/pkg/reload"
+	"github.com/Borislavv/advanced-cache/pkg/rules"
 	"github.com/Borislavv/advanced-cache/pkg/shutdown"
 	"github.com/Borislavv/advanced-cache/pkg/storage"
 	"github.com/Borislavv/advanced-cache/pkg/storage/lru"
-	"github.com/manifoldco/promptui"
+	"github.com/Borislavv/advanced-cache/pkg/storage/snapshot"
+	"github.com/Borislavv/advanced-cache/pkg/storage/wal"
+	"github.com/Borislavv/advanced-cache/pkg/upstream"
+	"github.com/Borislavv/advanced-cache/pkg/warmup"
 	"github.com/rs/zerolog/log"
 )
 
@@ -30,28 +29,32 @@ const (
 	ConfigPathLocal = "advancedCache.cfg.local.yaml"
 )
 
-type Version struct {
-	Name    string
-	Size    int64
-	ModTime time.Time
-}
-
 // App defines the cache application lifecycle interface.
 type App interface {
 	Start(gc shutdown.Gracefuller)
-	loadDataInteractive(ctx context.Context) error
+	LoadData(ctx context.Context) error
+	LoadDataInteractive(ctx context.Context) error
 }
 
 // Cache encapsulates the entire cache application state.
 type Cache struct {
-	cfg     *config.Cache
-	ctx     context.Context
-	cancel  context.CancelFunc
-	probe   liveness.Prober
-	dumper  storage.Dumper
-	server  server.Http
-	backend upstream.Gateway
-	db      storage.Storage
+	cfg       *config.Cache
+	ctx       context.Context
+	cancel    context.CancelFunc
+	probe     liveness.Prober
+	dumper    storage.Dumper
+	server    server.Http
+	admin     server.Http
+	reloader  *reload.Reloader
+	snapshots *storage.DumpScheduler
+	dumps     *storage.DumpManager
+	warmer    *warmup.Warmer
+	cluster   *cluster.Node
+	ops       *api.Operations
+	opsCancel context.CancelFunc // stops deliveries of operations to peers, after stop awaited them
+	wal       *wal.Log
+	backend   upstream.Gateway
+	db        storage.Storage
 }
 
 // NewApp builds a new Cache app.
@@ -62,6 +65,7 @@ func NewApp(ctx context.Context, cfg *config.Cache, probe liveness.Prober) (*Cac
 	backend := upstream.NewBackend(ctx, cfg)
 	db := lru.NewStorage(ctx, cfg, backend)
 	dumper := storage.NewDumper(cfg, db, backend)
+	dumper.SetMeter(meter)
 
 	cacheObj := &Cache{
 		ctx:     ctx,
@@ -73,13 +77,80 @@ func NewApp(ctx context.Context, cfg *config.Cache, probe liveness.Prober) (*Cac
 		dumper:  dumper,
 	}
 
-	srv, err := server.New(ctx, cfg, db, backend, probe, meter)
+	if cfg.Cache.Enabled && cfg.Cache.Cluster.Enabled {
+		node, err := cluster.New(ctx, cfg, db, backend)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		cacheObj.cluster = node
+		db.SetRefreshOwner(node)
+	}
+
+	srv, err := server.New(ctx, cfg, db, backend, probe, meter, cacheObj.cluster)
 	if err != nil {
 		cancel()
 		return nil, err
 	}
 	cacheObj.server = srv
 
+	rulesManager, err := rules.NewManager(cfg)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	if dump := cfg.Cache.Persistence.Dump; cfg.Cache.Enabled && dump.IsEnabled {
+		cacheObj.dumps = storage.NewDumpManager(ctx, cfg, dumper, db)
+	}
+
+	if cfg.Cache.Enabled {
+		cacheObj.warmer = warmup.NewWarmer(ctx, cfg, backend, db)
+	}
+
+	// Deliveries to peers outlive the app context, stop awaits them for a while.
+	opsCtx, opsCancel := context.WithCancel(context.WithoutCancel(ctx))
+	cacheObj.ops, cacheObj.opsCancel = api.NewOperations(opsCtx, db, cacheObj.cluster), opsCancel
+
+	admin, err := server.NewAdmin(ctx, cfg, db, backend, probe, rulesManager, cacheObj.dumps, cacheObj.warmer, cacheObj.cluster, cacheObj.ops)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	cacheObj.admin = admin
+
+	if cfg.Cache.Persistence.Dump.IsEnabled {
+		// Keys are read by each dump and load, an unreadable one fails the start instead of the shutdown dump.
+		if _, err = storage.LoadDumpKeys(cfg); err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+
+	if dump := cfg.Cache.Persistence.Dump; cfg.Cache.Enabled && dump.IsEnabled && dump.Schedule.Enabled {
+		cacheObj.snapshots = storage.NewDumpScheduler(ctx, cfg, dumper, db, meter)
+	}
+
+	if cfg.Cache.Enabled && cfg.Cache.Persistence.WAL.Enabled {
+		// The context of the app is cancelled before stop, the wal is rotated by the final dump and closed after it.
+		walLog, err := wal.Open(context.WithoutCancel(ctx), *cfg.Cache.Persistence.WAL)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		cacheObj.wal = walLog
+		dumper.SetWAL(walLog)
+	}
+
+	if cfg.Path != "" {
+		reloader, err := reload.NewReloader(ctx, cfg, rulesManager, backend, db)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		cacheObj.reloader = reloader
+	}
+
 	return cacheObj, nil
 }
 
@@ -97,7 +168,27 @@ func (c *Cache) Start(gc shutdown.Gracefuller) {
 		defer close(waitCh)
 		c.db.Run()
 		c.probe.Watch(c)
+		if c.reloader != nil {
+			c.reloader.Run()
+		}
+		if c.cluster != nil {
+			c.cluster.Run()
+		}
+		if c.snapshots != nil {
+			c.snapshots.Run()
+		}
+		if c.dumps != nil {
+			c.dumps.Run()
+		}
+
+		adminWaitCh := make(chan struct{})
+		go func() {
+			defer close(adminWaitCh)
+			c.admin.Start()
+		}()
+
 		c.server.Start()
+		<-adminWaitCh
 	}()
 
 	log.Info().Msg("[app] cache has been started")
@@ -109,6 +200,10 @@ func (c *Cache) stop() {
 	log.Info().Msg("[app] stopping cache")
 	defer c.cancel()
 
+	if c.snapshots != nil {
+		c.snapshots.Stop() // a scheduled snapshot in progress is replaced by the final one
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
 	defer cancel()
 
@@ -118,278 +213,98 @@ func (c *Cache) stop() {
 		}
 	}
 
-	log.Info().Msg("[app] cache has been stopped")
-}
-
-func (c *Cache) LoadData(ctx context.Context, interactive bool) error {
-	if interactive {
-		if err := c.loadDataInteractive(ctx); err != nil {
-			if !errors.Is(err, useYamlCfgErr) {
-				return err
-			}
-			return c.LoadData(ctx, false)
-		}
-
-		savePrompt := promptui.Prompt{Label: "Save cache state to new dump version on termination?", IsConfirm: true}
-		// if user confirms, comment: yes -> new dump will be stored
-		if _, spErr := savePrompt.Run(); spErr == nil {
-			c.cfg.Cache.Persistence.Dump.IsEnabled = true
+	if c.wal != nil {
+		c.db.SetJournal(nil)
+		if err := c.wal.Close(); err != nil {
+			log.Err(err).Msg("[wal] failed to close")
 		}
-	} else {
-		if c.cfg.Cache.Enabled {
-			if c.cfg.Cache.Persistence.Dump.IsEnabled {
-				if err := c.dumper.Load(ctx); err != nil {
-					log.Warn().Err(err).Msg("[dump] failed to load dump")
-				}
-			} else {
-				log.Info().Msg("[app] dump loading is disabled")
-			}
-			if c.cfg.Cache.Persistence.Mock.Enabled {
-				storage.LoadMocks(ctx, c.cfg, c.backend, c.db, c.cfg.Cache.Persistence.Mock.Length)
-			} else {
-				log.Info().Msg("[app] mock loading is disabled")
-			}
-		} else {
-			log.Info().Msg("[app] cache is disabled")
-		}
-	}
-	return nil
-}
-
-var useYamlCfgErr = errors.New("user choose a yaml config file instead")
-
-// loadDataInteractive asks user to select dump by date desc, mocks, yaml config, edit or exit.
-func (c *Cache) loadDataInteractive(ctx context.Context) error {
-	entries, err := os.ReadDir(c.cfg.Cache.Persistence.Dump.Dir)
-	if err != nil {
-		log.Fatal().Err(err).Msgf("[dump] failed to read dumps dir %q: %v", c.cfg.Cache.Persistence.Dump.Dir, err)
-		return err
 	}
 
-	var versions []Version
-	for _, e := range entries {
-		if !e.IsDir() {
-			continue
-		}
-		full := filepath.Join(c.cfg.Cache.Persistence.Dump.Dir, e.Name())
-		info, err := os.Stat(full)
-		if err != nil {
-			log.Err(err).Msgf("[dump] warning: cannot stat %q: %v", full, err)
-			continue
-		}
-		sz, err := c.dirSize(full)
-		if err != nil {
-			log.Err(err).Msgf("[dump] warning: cannot size %q: %v", full, err)
-			continue
+	if broadcaster := c.ops.Broadcaster(); broadcaster != nil {
+		delivered := make(chan struct{})
+		go func() {
+			broadcaster.Wait()
+			close(delivered)
+		}()
+		select {
+		case <-delivered:
+		case <-ctx.Done():
+			log.Warn().Msg("[cluster] operations still being delivered to peers are dropped")
 		}
-		versions = append(versions, Version{e.Name(), sz, info.ModTime()})
-	}
-	if len(versions) == 0 {
-		log.Err(err).Msgf("[dump] no versions in '%s'", c.cfg.Cache.Persistence.Dump.Dir)
-	} else {
-		// sort by ModTime descending
-		sort.Slice(versions, func(i, j int) bool { return versions[i].ModTime.After(versions[j].ModTime) })
-	}
-
-	// build menu
-	items := make([]string, 0, len(versions)+5)
-	for _, v := range versions {
-		items = append(items, fmt.Sprintf("%s\t%s\t%s", v.Name, c.byteCount(v.Size), v.ModTime.Format(time.RFC3339)))
-	}
-	items = append(items,
-		"Continue without dump loading",
-		"Run mocks loading",
-		"Run yaml file config",
-		"Edit and run yaml config",
-		"Exit",
-	)
-
-	prompt := promptui.Select{Label: "Select version or action", Items: items, Size: len(items)}
-	idx, _, err := prompt.Run()
-	if err != nil {
-		log.Err(err).Msgf("[dump] selection aborted: %v", err)
-		return err
 	}
+	c.opsCancel()
 
-	n := len(versions)
-	switch {
-	case idx < n:
-		// apply version
-		ver := versions[idx]
-		applyVersionPrompt := promptui.Prompt{Label: fmt.Sprintf("Apply version %s?", ver.Name), IsConfirm: true}
-		if _, err := applyVersionPrompt.Run(); err != nil {
-			fmt.Println("Aborted.")
-			return err
-		}
-		fmt.Printf("Applying dump %q…\n", ver.Name)
-		if err := c.applyDump(ctx, ver); err != nil {
-			log.Err(err).Msgf("[dump] failed apply %s: %v", ver.Name, err)
-			return err
-		}
+	log.Info().Msg("[app] cache has been stopped")
+}
 
-	case idx == n:
-		fmt.Println("Continuing without dump.")
+// LoadData fills the storage at startup: a snapshot of the peer (if configured) or the latest dump
+// (if persistence is enabled) with the wal on top of it (if enabled), URL lists (if warm-up is enabled)
+// and mocks (if enabled).
+func (c *Cache) LoadData(ctx context.Context) error {
+	if !c.cfg.Cache.Enabled {
+		log.Info().Msg("[app] cache is disabled")
+		return nil
+	}
 
-	case idx == n+1:
-		numberOfMocksPrompt := promptui.Prompt{Label: "Number of mocks to load", Validate: func(in string) error {
-			if _, err := strconv.Atoi(in); err != nil {
-				return fmt.Errorf("invalid: %v", err)
-			}
-			return nil
-		}}
-		// mocks
-		res, _ := numberOfMocksPrompt.Run()
-		nMocks, _ := strconv.Atoi(res)
-		storage.LoadMocks(ctx, c.cfg, c.backend, c.db, nMocks)
-
-	case idx == n+2:
-		// YAML config selection menu
-		for {
-			actions := []string{
-				fmt.Sprintf("Local config (%s)", ConfigPathLocal),
-				fmt.Sprintf("Prod config (%s)", ConfigPath),
-				"Back",
-				"Exit",
-			}
-			cfgPrompt := promptui.Select{Label: "Select config action", Items: actions}
-			choice, _, err := cfgPrompt.Run()
-			if err != nil {
-				log.Err(err).Msgf("[dump] config selection aborted: %v", err)
+	fromPeer := c.loadFromPeer(ctx)
+	if !fromPeer {
+		if c.cfg.Cache.Persistence.Dump.IsEnabled {
+			if err := c.dumper.Load(ctx); errors.Is(err, snapshot.KeyError) {
 				return err
+			} else if err != nil {
+				log.Warn().Err(err).Msg("[dump] failed to load dump")
 			}
-
-			var path string
-			switch choice {
-			case 0:
-				path = ConfigPathLocal
-			case 1:
-				path = ConfigPath
-			case 2:
-				return c.loadDataInteractive(ctx)
-			case 3:
-				fmt.Println("Exiting.")
-				os.Exit(0)
-			}
-
-			fmt.Printf("Using config: %s\n", path)
-			info, statErr := os.Stat(path)
-			if statErr != nil {
-				if os.IsNotExist(statErr) {
-					fmt.Printf("File %q not found\n", path)
-					continue
-				}
-				return statErr
-			}
-			if info.IsDir() {
-				fmt.Printf("%q is a directory\n", path)
-				continue
-			}
-			cfg, loadErr := config.LoadConfig(path)
-			if loadErr != nil {
-				fmt.Printf("Failed to load config: %v\n", loadErr)
-				continue
-			}
-			c.cfg = cfg
-			return useYamlCfgErr
-		}
-
-	case idx == n+3:
-		// Edit YAML config with fallback editors
-		path, err := c.getCfgPath()
-		if err != nil {
-			return err
-		}
-		fmt.Println() // reset prompt
-		editor := os.Getenv("EDITOR")
-		if editor == "" {
-			editor = "nano"
+		} else {
+			log.Info().Msg("[app] dump loading is disabled")
 		}
-		// Try user editor, then fallback to nano and vi
-		for _, ed := range uniqueStrings([]string{editor, "nano", "vi"}) {
-			fmt.Printf("Opening %s in %s...\n", path, ed)
-			cmd := exec.Command(ed, path)
-			cmd.Stdin = os.Stdin
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stderr
-			if runErr := cmd.Run(); runErr != nil {
-				fmt.Printf("Editor %s error: %v\n", ed, runErr)
-				continue
-			}
-			// success
-			newCfg, loadErr := config.LoadConfig(path)
-			if loadErr != nil {
-				fmt.Printf("Failed to reload config: %v\n", loadErr)
-				return loadErr
+	}
+	if c.wal != nil {
+		// The wal continues the local dump, the peer state supersedes both.
+		if !fromPeer {
+			if err := storage.ReplayWAL(ctx, c.cfg, c.db, c.backend); err != nil {
+				log.Warn().Err(err).Msg("[wal] failed to replay")
 			}
-			c.cfg = newCfg
-			fmt.Printf("Config reloaded from %s\n", path)
-			return useYamlCfgErr
 		}
-		// all editors failed
-		return c.loadDataInteractive(ctx)
-
-	case idx == n+4:
-		fmt.Println("Exiting.")
-		os.Exit(0)
+		c.db.SetJournal(c.wal)
+	}
+	if c.cfg.Cache.Persistence.Warmup.Enabled {
+		c.warmUp(ctx)
+	}
+	if c.cfg.Cache.Persistence.Mock.Enabled {
+		storage.LoadMocks(ctx, c.cfg, c.backend, c.db, c.cfg.Cache.Persistence.Mock.Length)
+	} else {
+		log.Info().Msg("[app] mock loading is disabled")
 	}
 	return nil
 }
 
-func (c *Cache) getCfgPath() (string, error) {
-	path := ConfigPathLocal
-	_, statErr := os.Stat(path)
-	if statErr != nil {
-		path = ConfigPath
-		_, statErr = os.Stat(path)
-		if statErr != nil {
-			return "", statErr
-		}
+// warmUp fetches the URLs of persistence.warmup.sources before serving, within persistence.warmup.timeout.
+func (c *Cache) warmUp(ctx context.Context) {
+	requests, invalid, err := c.warmer.ReadSources()
+	if err != nil {
+		log.Warn().Err(err).Msg("[warmup] failed to read sources")
+		return
 	}
-	return path, nil
-}
-
-func (c *Cache) dirSize(path string) (int64, error) {
-	var total int64
-	err := filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-		if !d.IsDir() {
-			info, err := d.Info()
-			if err != nil {
-				return err
-			}
-			total += info.Size()
-		}
-		return nil
-	})
-	return total, err
-}
-
-func (c *Cache) parseVer(name string) int {
-	if strings.HasPrefix(name, "v") {
-		if n, err := strconv.Atoi(name[1:]); err == nil {
-			return n
-		}
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.Cache.Persistence.Warmup.Timeout)
+	defer cancel()
+	if _, err = c.warmer.Run(ctx, requests, invalid, nil); err != nil {
+		log.Warn().Err(err).Msg("[warmup] startup warm-up is not finished")
 	}
-	return int(^uint(0) >> 1)
 }
 
-func (c *Cache) byteCount(b int64) string {
-	const unit = 1000
-	if b < unit {
-		return fmt.Sprintf("%d B", b)
+// loadFromPeer warms the storage up from persistence.peer, a partially loaded storage is cleared on failure.
+func (c *Cache) loadFromPeer(ctx context.Context) bool {
+	peer := c.cfg.Cache.Persistence.Peer
+	if peer.URL == "" {
+		return false
 	}
-	div, exp := int64(unit), 0
-	for n := b / unit; n >= unit; n /= unit {
-		div *= unit
-		exp++
+	url := strings.TrimSuffix(peer.URL, "/") + api.SnapshotPath
+	if _, err := storage.LoadFromPeer(ctx, c.cfg, c.db, c.backend, url); err != nil {
+		log.Warn().Err(err).Str("peer", peer.URL).Msg("[peer] warm-up failed, falling back to the local dump")
+		c.db.Clear()
+		return false
 	}
-	return fmt.Sprintf("%.1f %cB", float64(b)/float64(div), "kMGTPE"[exp])
-}
-
-func (c *Cache) applyDump(ctx context.Context, v Version) error {
-	return c.dumper.LoadVersion(ctx, v.Name)
+	return true
 }
 
 func (c *Cache) IsAlive(_ context.Context) bool {
@@ -397,17 +312,9 @@ func (c *Cache) IsAlive(_ context.Context) bool {
 		log.Info().Msg("[app] http server has gone away")
 		return false
 	}
-	return true
-}
-
-func uniqueStrings(input []string) []string {
-	seen := make(map[string]struct{}, len(input))
-	result := make([]string, 0, len(input))
-	for _, s := range input {
-		if _, ok := seen[s]; !ok {
-			seen[s] = struct{}{}
-			result = append(result, s)
-		}
+	if !c.admin.IsAlive() {
+		log.Info().Msg("[app] admin http server has gone away")
+		return false
 	}
-	return result
+	return true
 }
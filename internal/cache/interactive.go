@@ -0,0 +1,239 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/Borislavv/advanced-cache/pkg/config"
+	"github.com/Borislavv/advanced-cache/pkg/storage"
+	"github.com/manifoldco/promptui"
+	"github.com/rs/zerolog/log"
+)
+
+// LoadDataInteractive asks the user in the terminal what to load: a dump version, mocks,
+// another yaml config or nothing, and whether to store a dump on termination.
+func (c *Cache) LoadDataInteractive(ctx context.Context) error {
+	if err := c.selectData(ctx); err != nil {
+		if !errors.Is(err, useYamlCfgErr) {
+			return err
+		}
+		return c.LoadData(ctx)
+	}
+	if c.wal != nil {
+		// The wal continues the latest dump, it's not replayed over a selected one. Changes are still
+		// recorded: the wal is compacted by the dump on termination (if confirmed below).
+		log.Info().Msg("[wal] not replayed over interactively selected data")
+		c.db.SetJournal(c.wal)
+	}
+
+	savePrompt := promptui.Prompt{Label: "Save cache state to new dump version on termination?", IsConfirm: true}
+	// if user confirms, comment: yes -> new dump will be stored
+	if _, spErr := savePrompt.Run(); spErr == nil {
+		c.cfg.Cache.Persistence.Dump.IsEnabled = true
+	}
+	return nil
+}
+
+var useYamlCfgErr = errors.New("user choose a yaml config file instead")
+
+// selectData asks user to select dump by date desc, mocks, yaml config, edit or exit.
+func (c *Cache) selectData(ctx context.Context) error {
+	versions, err := storage.ListDumpVersions(c.cfg.Cache.Persistence.Dump.Dir, c.cfg.Cache.Persistence.Dump.Name)
+	if err != nil {
+		log.Err(err).Msgf("[dump] failed to read dumps dir %q", c.cfg.Cache.Persistence.Dump.Dir)
+		return err
+	}
+	if len(versions) == 0 {
+		log.Warn().Msgf("[dump] no versions in '%s'", c.cfg.Cache.Persistence.Dump.Dir)
+	}
+
+	// build menu
+	items := make([]string, 0, len(versions)+5)
+	for _, v := range versions {
+		items = append(items, fmt.Sprintf("%s\t%s\t%s\t%s", v.Name, v.Status, c.byteCount(v.Size), v.ModTime.Format(time.RFC3339)))
+	}
+	items = append(items,
+		"Continue without dump loading",
+		"Run mocks loading",
+		"Run yaml file config",
+		"Edit and run yaml config",
+		"Exit",
+	)
+
+	prompt := promptui.Select{Label: "Select version or action", Items: items, Size: len(items)}
+	idx, _, err := prompt.Run()
+	if err != nil {
+		log.Err(err).Msgf("[dump] selection aborted: %v", err)
+		return err
+	}
+
+	n := len(versions)
+	switch {
+	case idx < n:
+		// apply version
+		ver := versions[idx]
+		applyVersionPrompt := promptui.Prompt{Label: fmt.Sprintf("Apply version %s?", ver.Name), IsConfirm: true}
+		if _, err := applyVersionPrompt.Run(); err != nil {
+			fmt.Println("Aborted.")
+			return err
+		}
+		fmt.Printf("Applying dump %q…\n", ver.Name)
+		if err := c.applyDump(ctx, ver); err != nil {
+			log.Err(err).Msgf("[dump] failed apply %s: %v", ver.Name, err)
+			return err
+		}
+
+	case idx == n:
+		fmt.Println("Continuing without dump.")
+
+	case idx == n+1:
+		numberOfMocksPrompt := promptui.Prompt{Label: "Number of mocks to load", Validate: func(in string) error {
+			if _, err := strconv.Atoi(in); err != nil {
+				return fmt.Errorf("invalid: %v", err)
+			}
+			return nil
+		}}
+		// mocks
+		res, _ := numberOfMocksPrompt.Run()
+		nMocks, _ := strconv.Atoi(res)
+		storage.LoadMocks(ctx, c.cfg, c.backend, c.db, nMocks)
+
+	case idx == n+2:
+		// YAML config selection menu
+		for {
+			actions := []string{
+				fmt.Sprintf("Local config (%s)", ConfigPathLocal),
+				fmt.Sprintf("Prod config (%s)", ConfigPath),
+				"Back",
+				"Exit",
+			}
+			cfgPrompt := promptui.Select{Label: "Select config action", Items: actions}
+			choice, _, err := cfgPrompt.Run()
+			if err != nil {
+				log.Err(err).Msgf("[dump] config selection aborted: %v", err)
+				return err
+			}
+
+			var path string
+			switch choice {
+			case 0:
+				path = ConfigPathLocal
+			case 1:
+				path = ConfigPath
+			case 2:
+				return c.selectData(ctx)
+			case 3:
+				fmt.Println("Exiting.")
+				os.Exit(0)
+			}
+
+			fmt.Printf("Using config: %s\n", path)
+			info, statErr := os.Stat(path)
+			if statErr != nil {
+				if os.IsNotExist(statErr) {
+					fmt.Printf("File %q not found\n", path)
+					continue
+				}
+				return statErr
+			}
+			if info.IsDir() {
+				fmt.Printf("%q is a directory\n", path)
+				continue
+			}
+			cfg, loadErr := config.LoadConfig(path)
+			if loadErr != nil {
+				fmt.Printf("Failed to load config: %v\n", loadErr)
+				continue
+			}
+			c.cfg = cfg
+			return useYamlCfgErr
+		}
+
+	case idx == n+3:
+		// Edit YAML config with fallback editors
+		path, err := c.getCfgPath()
+		if err != nil {
+			return err
+		}
+		fmt.Println() // reset prompt
+		editor := os.Getenv("EDITOR")
+		if editor == "" {
+			editor = "nano"
+		}
+		// Try user editor, then fallback to nano and vi
+		for _, ed := range uniqueStrings([]string{editor, "nano", "vi"}) {
+			fmt.Printf("Opening %s in %s...\n", path, ed)
+			cmd := exec.Command(ed, path)
+			cmd.Stdin = os.Stdin
+			cmd.Stdout = os.Stdout
+			cmd.Stderr = os.Stderr
+			if runErr := cmd.Run(); runErr != nil {
+				fmt.Printf("Editor %s error: %v\n", ed, runErr)
+				continue
+			}
+			// success
+			newCfg, loadErr := config.LoadConfig(path)
+			if loadErr != nil {
+				fmt.Printf("Failed to reload config: %v\n", loadErr)
+				return loadErr
+			}
+			c.cfg = newCfg
+			fmt.Printf("Config reloaded from %s\n", path)
+			return useYamlCfgErr
+		}
+		// all editors failed
+		return c.selectData(ctx)
+
+	case idx == n+4:
+		fmt.Println("Exiting.")
+		os.Exit(0)
+	}
+	return nil
+}
+
+func (c *Cache) getCfgPath() (string, error) {
+	path := ConfigPathLocal
+	_, statErr := os.Stat(path)
+	if statErr != nil {
+		path = ConfigPath
+		_, statErr = os.Stat(path)
+		if statErr != nil {
+			return "", statErr
+		}
+	}
+	return path, nil
+}
+
+func (c *Cache) byteCount(b int64) string {
+	const unit = 1000
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(b)/float64(div), "kMGTPE"[exp])
+}
+
+func (c *Cache) applyDump(ctx context.Context, v storage.DumpVersion) error {
+	return c.dumper.LoadVersion(ctx, v.Name)
+}
+
+func uniqueStrings(input []string) []string {
+	seen := make(map[string]struct{}, len(input))
+	result := make([]string, 0, len(input))
+	for _, s := range input {
+		if _, ok := seen[s]; !ok {
+			seen[s] = struct{}{}
+			result = append(result, s)
+		}
+	}
+	return result
+}
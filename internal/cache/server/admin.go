@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"github.com/Borislavv/advanced-cache/internal/cache/api"
+	"github.com/Borislavv/advanced-cache/pkg/cluster"
+	"github.com/Borislavv/advanced-cache/pkg/config"
+	"github.com/Borislavv/advanced-cache/pkg/k8s/probe/liveness"
+	controller2 "github.com/Borislavv/advanced-cache/pkg/prometheus/metrics/controller"
+	"github.com/Borislavv/advanced-cache/pkg/rules"
+	httpserver "github.com/Borislavv/advanced-cache/pkg/server"
+	"github.com/Borislavv/advanced-cache/pkg/server/auth"
+	"github.com/Borislavv/advanced-cache/pkg/server/controller"
+	"github.com/Borislavv/advanced-cache/pkg/server/middleware"
+	"github.com/Borislavv/advanced-cache/pkg/storage"
+	"github.com/Borislavv/advanced-cache/pkg/upstream"
+	"github.com/Borislavv/advanced-cache/pkg/warmup"
+	"github.com/rs/zerolog/log"
+	"sync/atomic"
+)
+
+// NewAdmin creates the management HttpServer listening on cfg.Cache.Admin.Addr.
+// All routes except the k8s probe are protected by the auth guard built from cfg.Cache.Admin.Auth.
+func NewAdmin(
+	ctx context.Context,
+	cfg *config.Cache,
+	db storage.Storage,
+	backend upstream.Gateway,
+	probe liveness.Prober,
+	rulesManager *rules.Manager,
+	dumps *storage.DumpManager,
+	warmer *warmup.Warmer,
+	node *cluster.Node,
+	ops *api.Operations,
+) (*HttpServer, error) {
+	guard, err := auth.NewGuard(cfg.Cache.Admin.Auth)
+	if err != nil {
+		log.Err(err).Msg(InitFailedErrorMessage)
+		return nil, err
+	}
+	if guard.IsOpen() {
+		log.Warn().Msgf("[admin] no authentication is configured, admin API on %s is open", cfg.Cache.Admin.Addr)
+	}
+
+	srv := &HttpServer{
+		ctx:           ctx,
+		cfg:           cfg,
+		db:            db,
+		backend:       backend,
+		probe:         probe,
+		rules:         rulesManager,
+		dumps:         dumps,
+		warmer:        warmer,
+		cluster:       node,
+		ops:           ops,
+		guard:         guard,
+		isServerAlive: &atomic.Bool{},
+	}
+
+	if err = srv.initServer(); err != nil {
+		log.Err(err).Msg(InitFailedErrorMessage)
+		return nil, errors.New(InitFailedErrorMessage)
+	}
+
+	return srv, nil
+}
+
+// initAdminServer creates the admin HTTP server instance with management controllers and auth middleware.
+func (s *HttpServer) initAdminServer() error {
+	if server, err := httpserver.NewAdmin(s.ctx, s.cfg, s.adminControllers(), s.adminMiddlewares()); err != nil {
+		log.Err(err).Msg(InitFailedErrorMessage)
+		return errors.New(InitFailedErrorMessage)
+	} else {
+		s.server = server
+	}
+	return nil
+}
+
+// adminControllers returns management controllers (probe, metrics, on/off, clear, purge, snapshot, rules, export/import, dumps, warm-up, cluster).
+func (s *HttpServer) adminControllers() []controller.HttpController {
+	controllers := []controller.HttpController{
+		liveness.NewController(s.probe),    // Liveness/healthcheck endpoint
+		controller2.NewPrometheusMetrics(), // Metrics endpoint
+		api.NewOnOffController(s.ops),      // Cache on-off controller
+		api.NewClearController(s.cfg, s.db, s.ops),
+		api.NewPurgeController(s.ops),                            // Purge and soft purge by rule or path prefix
+		api.NewSnapshotController(s.ctx, s.cfg, s.db),            // Snapshot stream for peer warm-up
+		api.NewRulesController(s.rules),                          // Runtime rules management
+		api.NewExchangeController(s.ctx, s.cfg, s.db, s.backend), // JSONL/HAR export and import
+	}
+	if s.dumps != nil {
+		controllers = append(controllers, api.NewDumpsController(s.dumps)) // Dump management
+	}
+	if s.warmer != nil {
+		controllers = append(controllers, api.NewWarmupController(s.warmer)) // Warm-up from URL lists
+	}
+	if s.cluster != nil {
+		controllers = append(controllers, api.NewClusterController(s.cluster, s.ops.Broadcaster())) // Entries and operations for other instances
+	}
+	return controllers
+}
+
+// adminMiddlewares returns the admin request middlewares, executed in reverse order.
+func (s *HttpServer) adminMiddlewares() []middleware.HttpMiddleware {
+	return []middleware.HttpMiddleware{
+		/** exec 1st. */ middleware.NewApplicationJsonMiddleware(), // Sets Content-Type
+		/** exec 2nd. */ middleware.NewAuthMiddleware(s.guard, liveness.ProbePath), // Rejects unauthenticated requests
+	}
+}
@@ -4,15 +4,18 @@ import (
 	"context"
 	"errors"
 	"github.com/Borislavv/advanced-cache/internal/cache/api"
+	"github.com/Borislavv/advanced-cache/pkg/cluster"
 	"github.com/Borislavv/advanced-cache/pkg/config"
 	"github.com/Borislavv/advanced-cache/pkg/k8s/probe/liveness"
 	"github.com/Borislavv/advanced-cache/pkg/prometheus/metrics"
-	controller2 "github.com/Borislavv/advanced-cache/pkg/prometheus/metrics/controller"
+	"github.com/Borislavv/advanced-cache/pkg/rules"
 	httpserver "github.com/Borislavv/advanced-cache/pkg/server"
+	"github.com/Borislavv/advanced-cache/pkg/server/auth"
 	"github.com/Borislavv/advanced-cache/pkg/server/controller"
 	"github.com/Borislavv/advanced-cache/pkg/server/middleware"
 	"github.com/Borislavv/advanced-cache/pkg/storage"
 	"github.com/Borislavv/advanced-cache/pkg/upstream"
+	"github.com/Borislavv/advanced-cache/pkg/warmup"
 	"github.com/rs/zerolog/log"
 	"sync"
 	"sync/atomic"
@@ -38,6 +41,12 @@ type HttpServer struct {
 	metrics       metrics.Meter
 	server        httpserver.Server
 	isServerAlive *atomic.Bool
+	guard         *auth.Guard          // Non-nil only for the admin server.
+	rules         *rules.Manager       // Admin server only.
+	dumps         *storage.DumpManager // Admin server only, nil if dumps are disabled.
+	warmer        *warmup.Warmer       // Admin server only, nil if the cache is disabled.
+	cluster       *cluster.Node        // Nil if the cluster mode is disabled.
+	ops           *api.Operations      // Admin operations, broadcast in the cluster mode.
 }
 
 // New creates a new HttpServer, initializing metrics and the HTTP server itself.
@@ -49,6 +58,7 @@ func New(
 	backend upstream.Gateway,
 	probe liveness.Prober,
 	meter metrics.Meter,
+	node *cluster.Node,
 ) (*HttpServer, error) {
 	var err error
 
@@ -59,6 +69,7 @@ func New(
 		backend:       backend,
 		probe:         probe,
 		metrics:       meter,
+		cluster:       node,
 		isServerAlive: &atomic.Bool{},
 	}
 
@@ -105,6 +116,10 @@ func (s *HttpServer) spawnServer(wg *sync.WaitGroup) {
 
 // initServer creates the HTTP server instance, sets up controllers and middlewares, and stores the result.
 func (s *HttpServer) initServer() error {
+	if s.guard != nil {
+		return s.initAdminServer()
+	}
+
 	// Compose server with controllers and middlewares.
 	if server, err := httpserver.New(s.ctx, s.cfg, s.controllers(), s.middlewares()); err != nil {
 		log.Err(err).Msg(InitFailedErrorMessage)
@@ -116,14 +131,11 @@ func (s *HttpServer) initServer() error {
 	return nil
 }
 
-// controllers returns all HTTP controllers for the server (endpoints/handlers).
+// controllers returns all HTTP controllers for the public server (endpoints/handlers).
+// Management endpoints are served by the admin server only (see admin.go).
 func (s *HttpServer) controllers() []controller.HttpController {
 	return []controller.HttpController{
-		liveness.NewController(s.probe),    // Liveness/healthcheck endpoint
-		controller2.NewPrometheusMetrics(), // Metrics endpoint
-		api.NewOnOffController(),           // Cache on-off controller
-		api.NewClearController(s.cfg, s.db),
-		api.NewCacheController(s.ctx, s.cfg, s.db, s.metrics, s.backend), // Main cache handler
+		api.NewCacheController(s.ctx, s.cfg, s.db, s.metrics, s.backend, s.cluster), // Main cache handler
 	}
 }
 
@@ -0,0 +1,401 @@
+package cluster
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Borislavv/advanced-cache/pkg/storage"
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
+)
+
+// ApplyPath is the admin route applying operations broadcast by other instances.
+const ApplyPath = "/cluster/ops/apply"
+
+// Kinds of operations.
+const (
+	OpPurge     = "purge"
+	OpSoftPurge = "soft_purge"
+	OpClear     = "clear"
+	OpOn        = "on"
+	OpOff       = "off"
+)
+
+// States of the delivery of an operation to a peer.
+const (
+	AckPending = "pending" // Being delivered or waiting for a retry.
+	AckDone    = "acked"
+	AckFailed  = "failed" // Retries are exhausted or the peer rejected the operation.
+)
+
+// maxRetryInterval caps the doubling interval between retries.
+const maxRetryInterval = time.Minute
+
+// maxSeenOperations is the number of IDs of applied operations kept to recognize repeated deliveries.
+const maxSeenOperations = 10000
+
+var (
+	UnknownOperationError      = errors.New("unknown operation")
+	OperationIDConflictedError = errors.New("operation ID is used by another operation")
+)
+
+// Operation is an admin operation applied on all instances. The ID makes it idempotent: an instance applies
+// an operation once however many times it's delivered (or submitted).
+type Operation struct {
+	ID        string              `json:"id"`
+	Kind      string              `json:"kind"`
+	Filter    storage.PurgeFilter `json:"filter"` // Entries of purge and soft_purge.
+	Origin    string              `json:"origin"` // Instance the operation was submitted to.
+	CreatedAt time.Time           `json:"createdAt"`
+}
+
+// Validate checks the kind and the filter of the operation.
+func (op Operation) Validate() error {
+	switch op.Kind {
+	case OpPurge, OpSoftPurge:
+		if op.Filter.Rule == "" && op.Filter.Prefix == "" {
+			return storage.PurgeFilterIsEmptyError
+		}
+	case OpClear, OpOn, OpOff:
+	default:
+		return fmt.Errorf("%w %q", UnknownOperationError, op.Kind)
+	}
+	return nil
+}
+
+// Applier applies an operation on the instance, returns the number of affected entries.
+type Applier func(op Operation) (affected int, err error)
+
+// Ack is the result of an operation on an instance.
+type Ack struct {
+	State      string    `json:"state"`
+	Attempts   int       `json:"attempts,omitempty"`
+	Affected   int       `json:"affected"`
+	Duplicate  bool      `json:"duplicate,omitempty"`  // The instance had applied the operation before.
+	Superseded bool      `json:"superseded,omitempty"` // An on/off older than the one the instance applied, it's skipped.
+	Error      string    `json:"error,omitempty"`      // The last error.
+	At         time.Time `json:"at,omitempty"`         // Time of the ack or of the last failed attempt.
+}
+
+// OperationStatus is an operation with its results on the instance it was submitted to and on the peers.
+type OperationStatus struct {
+	Operation
+	Local Ack            `json:"local"`
+	Peers map[string]Ack `json:"peers"` // By admin address.
+}
+
+// Acked reports whether all peers acknowledged the operation.
+func (s OperationStatus) Acked() bool {
+	for _, ack := range s.Peers {
+		if ack.State != AckDone {
+			return false
+		}
+	}
+	return true
+}
+
+// Broadcaster applies operations locally and delivers them to the peers of the node with retries.
+type Broadcaster struct {
+	ctx   context.Context
+	node  *Node
+	apply Applier
+
+	mu    sync.Mutex
+	ops   map[string]*OperationStatus
+	order []string            // IDs of ops, the oldest first
+	seen  map[string]struct{} // IDs of applied operations
+	seenQ []string            // IDs of seen, the oldest first
+	onOff time.Time           // CreatedAt of the latest applied on/off
+	wg    sync.WaitGroup
+}
+
+// NewBroadcaster creates the broadcaster of the node, apply is called for each operation once.
+// Deliveries waiting for a retry are abandoned (failed) once the context is done.
+func NewBroadcaster(ctx context.Context, node *Node, apply Applier) *Broadcaster {
+	return &Broadcaster{
+		ctx:   ctx,
+		node:  node,
+		apply: apply,
+		ops:   make(map[string]*OperationStatus),
+		seen:  make(map[string]struct{}),
+	}
+}
+
+// Submit applies the operation locally and starts its delivery to the current peers. An operation without an ID
+// gets a random one, a repeated ID of the same operation returns its status without applying it again.
+func (b *Broadcaster) Submit(op Operation) (OperationStatus, error) {
+	if err := op.Validate(); err != nil {
+		return OperationStatus{}, err
+	}
+	if op.ID == "" {
+		op.ID = newOperationID()
+	}
+
+	b.mu.Lock()
+	if status, ok := b.ops[op.ID]; ok {
+		defer b.mu.Unlock()
+		if status.Kind != op.Kind || status.Filter != op.Filter {
+			return OperationStatus{}, fmt.Errorf("%w: %s", OperationIDConflictedError, op.ID)
+		}
+		return status.clone(), nil
+	}
+	op.Origin, op.CreatedAt = b.node.Self(), time.Now()
+	status := &OperationStatus{Operation: op, Peers: make(map[string]Ack)}
+	for _, peer := range b.node.Ring().Peers() {
+		if peer != b.node.Self() {
+			status.Peers[peer] = Ack{State: AckPending}
+		}
+	}
+	b.remember(status)
+	b.mu.Unlock()
+
+	local := b.applyOnce(op)
+	b.mu.Lock()
+	status.Local = local
+	b.mu.Unlock()
+	log.Info().Msgf("[cluster] operation %s %s submitted: %d affected, delivering to %d peers", op.ID, op.Kind, local.Affected, len(status.Peers))
+
+	for peer := range status.Peers {
+		b.wg.Add(1)
+		go b.deliver(op, peer)
+	}
+	return b.Status(op.ID)
+}
+
+// ApplyLocal applies the operation on this instance only, it's not delivered to the peers.
+func (b *Broadcaster) ApplyLocal(op Operation) (Ack, error) {
+	if err := op.Validate(); err != nil {
+		return Ack{}, err
+	}
+	if op.ID == "" {
+		op.ID = newOperationID()
+	}
+	if op.CreatedAt.IsZero() {
+		op.CreatedAt = time.Now()
+	}
+	return b.applyOnce(op), nil
+}
+
+// Status returns the status of the operation.
+func (b *Broadcaster) Status(id string) (OperationStatus, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	status, ok := b.ops[id]
+	if !ok {
+		return OperationStatus{}, fmt.Errorf("operation %s is not found", id)
+	}
+	return status.clone(), nil
+}
+
+// Recent returns the statuses of the recent operations, the newest first.
+func (b *Broadcaster) Recent() []OperationStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	out := make([]OperationStatus, 0, len(b.order))
+	for i := len(b.order) - 1; i >= 0; i-- {
+		out = append(out, b.ops[b.order[i]].clone())
+	}
+	return out
+}
+
+// Wait blocks until the deliveries in progress are finished (acked or failed), e.g. on shutdown.
+func (b *Broadcaster) Wait() {
+	b.wg.Wait()
+}
+
+// ServeApply handles POST /cluster/ops/apply of other instances: the operation of the body is applied
+// unless it was applied before, the response is its Ack. Invalid operations are 400 (not retried).
+func (b *Broadcaster) ServeApply(ctx *fasthttp.RequestCtx) {
+	var op Operation
+	if err := json.Unmarshal(ctx.PostBody(), &op); err != nil || op.ID == "" {
+		ctx.Error("invalid operation", fasthttp.StatusBadRequest)
+		return
+	}
+	if err := op.Validate(); err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+	ack := b.applyOnce(op)
+	if ack.State != AckDone {
+		ctx.Error(ack.Error, fasthttp.StatusInternalServerError)
+		return
+	}
+	if ack.Superseded {
+		log.Info().Str("origin", op.Origin).Msgf("[cluster] operation %s %s skipped: a later on/off was applied", op.ID, op.Kind)
+	} else if !ack.Duplicate {
+		log.Info().Str("origin", op.Origin).Msgf("[cluster] operation %s %s applied: %d affected", op.ID, op.Kind, ack.Affected)
+	}
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType("application/json")
+	_ = json.NewEncoder(ctx).Encode(ack)
+}
+
+// applyOnce applies the operation unless its ID was seen, a failed operation may be applied again.
+// An on/off created before the latest applied one is skipped: a retried delivery must not undo a later switch.
+func (b *Broadcaster) applyOnce(op Operation) Ack {
+	b.mu.Lock()
+	if _, ok := b.seen[op.ID]; ok {
+		b.mu.Unlock()
+		return Ack{State: AckDone, Duplicate: true, At: time.Now()}
+	}
+	b.markSeen(op.ID)
+	onOff := b.onOff
+	if op.Kind == OpOn || op.Kind == OpOff {
+		if op.CreatedAt.Before(onOff) {
+			b.mu.Unlock()
+			return Ack{State: AckDone, Superseded: true, At: time.Now()}
+		}
+		b.onOff = op.CreatedAt
+	}
+	b.mu.Unlock()
+
+	b.node.dropHotCopies(op)
+	affected, err := b.apply(op)
+	if err != nil {
+		b.mu.Lock()
+		b.unmarkSeen(op.ID)
+		if b.onOff.Equal(op.CreatedAt) && (op.Kind == OpOn || op.Kind == OpOff) {
+			b.onOff = onOff
+		}
+		b.mu.Unlock()
+		return Ack{State: AckFailed, Error: err.Error(), At: time.Now()}
+	}
+	return Ack{State: AckDone, Affected: affected, At: time.Now()}
+}
+
+// deliver sends the operation to the peer until it's acked, rejected or the retries are exhausted.
+func (b *Broadcaster) deliver(op Operation, peer string) {
+	defer b.wg.Done()
+	broadcast := b.node.cfg.Cache.Cluster.Broadcast
+	interval := broadcast.RetryInterval
+	for attempt := 1; ; attempt++ {
+		ack, err := b.send(op, peer)
+		final := err == nil || errors.Is(err, rejectedError) || attempt > broadcast.Retries
+		b.mu.Lock()
+		if status, ok := b.ops[op.ID]; ok {
+			if err == nil {
+				ack.State, ack.Attempts = AckDone, attempt
+			} else {
+				ack = Ack{State: AckPending, Attempts: attempt, Error: err.Error(), At: time.Now()}
+				if final {
+					ack.State = AckFailed
+				}
+			}
+			status.Peers[peer] = ack
+		}
+		b.mu.Unlock()
+
+		if final {
+			if err != nil {
+				log.Error().Err(err).Str("peer", peer).Msgf("[cluster] operation %s %s was not delivered after %d attempts", op.ID, op.Kind, attempt)
+			}
+			return
+		}
+		select {
+		case <-b.ctx.Done():
+			b.mu.Lock()
+			if status, ok := b.ops[op.ID]; ok {
+				ack := status.Peers[peer]
+				ack.State = AckFailed
+				status.Peers[peer] = ack
+			}
+			b.mu.Unlock()
+			log.Error().Err(err).Str("peer", peer).Msgf("[cluster] operation %s %s was not delivered after %d attempts, the broadcaster is stopped", op.ID, op.Kind, attempt)
+			return
+		case <-time.After(interval):
+		}
+		interval = min(interval*2, maxRetryInterval)
+	}
+}
+
+// rejectedError is a 4xx response, the operation is not retried.
+var rejectedError = errors.New("operation rejected by the peer")
+
+// send delivers the operation to the peer once.
+func (b *Broadcaster) send(op Operation, peer string) (Ack, error) {
+	body, err := json.Marshal(op)
+	if err != nil {
+		return Ack{}, err
+	}
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.Header.SetMethod(fasthttp.MethodPost)
+	req.SetRequestURI(peer + ApplyPath)
+	req.Header.SetContentType("application/json")
+	req.SetBody(body)
+	b.node.authorize(req)
+
+	if err = b.node.client.DoTimeout(req, resp, b.node.cfg.Cache.Cluster.Timeout); err != nil {
+		return Ack{}, err
+	}
+	switch code := resp.StatusCode(); {
+	case code == http.StatusOK:
+	case code >= 400 && code < 500:
+		return Ack{}, fmt.Errorf("%w: status %d: %s", rejectedError, code, strings.TrimSpace(string(resp.Body())))
+	default:
+		return Ack{}, fmt.Errorf("status %d: %s", code, strings.TrimSpace(string(resp.Body())))
+	}
+	var ack Ack
+	if err = json.Unmarshal(resp.Body(), &ack); err != nil {
+		return Ack{}, err
+	}
+	return ack, nil
+}
+
+// remember keeps the status, the oldest one is dropped if there are too many. Must be called under the lock.
+func (b *Broadcaster) remember(status *OperationStatus) {
+	b.ops[status.ID] = status
+	b.order = append(b.order, status.ID)
+	for len(b.order) > max(b.node.cfg.Cache.Cluster.Broadcast.History, 1) {
+		delete(b.ops, b.order[0])
+		b.order = b.order[1:]
+	}
+}
+
+// markSeen must be called under the lock.
+func (b *Broadcaster) markSeen(id string) {
+	b.seen[id] = struct{}{}
+	b.seenQ = append(b.seenQ, id)
+	if len(b.seenQ) > maxSeenOperations {
+		delete(b.seen, b.seenQ[0])
+		b.seenQ = b.seenQ[1:]
+	}
+}
+
+// unmarkSeen forgets the ID of a failed operation, so it's applied again when it's delivered again.
+// Must be called under the lock.
+func (b *Broadcaster) unmarkSeen(id string) {
+	delete(b.seen, id)
+	for i := len(b.seenQ) - 1; i >= 0; i-- { // it's likely one of the latest
+		if b.seenQ[i] == id {
+			b.seenQ = append(b.seenQ[:i], b.seenQ[i+1:]...)
+			return
+		}
+	}
+}
+
+func (s *OperationStatus) clone() OperationStatus {
+	out := *s
+	out.Peers = make(map[string]Ack, len(s.Peers))
+	for peer, ack := range s.Peers {
+		out.Peers[peer] = ack
+	}
+	return out
+}
+
+func newOperationID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,207 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Borislavv/advanced-cache/pkg/config"
+	"github.com/Borislavv/advanced-cache/pkg/storage"
+)
+
+func (in *instance) appliedCount(id string) int {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	n := 0
+	for _, op := range in.applied {
+		if op.ID == id {
+			n++
+		}
+	}
+	return n
+}
+
+func TestBroadcast(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	o := &origin{requests: map[string]int{}}
+	srv := httptest.NewServer(o)
+	defer srv.Close()
+	instances := startCluster(t, ctx, 3, srv.URL, func(i int, cfg *config.Cache) {
+		cfg.Cache.Cluster.HotCopy.Enabled = i == 0
+		cfg.Cache.Cluster.Broadcast = config.Broadcast{Retries: 3, RetryInterval: 5 * time.Millisecond, History: 10}
+	})
+	submitter, flaky, broken := instances[0], instances[1], instances[2]
+
+	// Entries of all instances and a hot copy of the submitter.
+	for id := 0; id < 10; id++ {
+		for _, in := range instances {
+			in.get(t, fmt.Sprintf("id=%d", id))
+		}
+	}
+	if submitter.node.Stats().HotCopies == 0 {
+		t.Fatal("expected hot copies")
+	}
+
+	// The flaky peer acks after two failures, the broken one after none of the retries.
+	flaky.failWith.Store(http.StatusServiceUnavailable)
+	flaky.failures.Store(2)
+	broken.failWith.Store(http.StatusBadGateway)
+	broken.failures.Store(100)
+
+	op := Operation{ID: "purge-1", Kind: OpPurge, Filter: storage.PurgeFilter{Prefix: "/api"}}
+	status, err := submitter.ops.Submit(op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Local.State != AckDone || status.Local.Affected == 0 || status.Origin != submitter.addr {
+		t.Fatalf("expected the operation applied locally, got %+v", status)
+	}
+	if n := submitter.node.Stats().HotCopies; n != 0 {
+		t.Fatalf("expected the hot copies to be dropped, got %d", n)
+	}
+	submitter.ops.Wait()
+
+	status, err = submitter.ops.Status(op.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ack := status.Peers[flaky.addr]; ack.State != AckDone || ack.Attempts != 3 || ack.Affected == 0 {
+		t.Fatalf("expected the flaky peer to ack the 3rd attempt, got %+v", ack)
+	}
+	if ack := status.Peers[broken.addr]; ack.State != AckFailed || ack.Attempts != 4 || ack.Error == "" {
+		t.Fatalf("expected the broken peer to fail after 3 retries, got %+v", ack)
+	}
+	if status.Acked() {
+		t.Fatal("expected the operation not to be acked by all peers")
+	}
+	for _, in := range instances[:2] {
+		if n := in.db.RealLen(); n != 0 {
+			t.Fatalf("expected %s to be purged, got %d entries", in.addr, n)
+		}
+	}
+
+	// A repeated submission returns the status, a repeated delivery is not applied again.
+	broken.failures.Store(0)
+	if again, err := submitter.ops.Submit(op); err != nil || again.Peers[broken.addr].State != AckFailed {
+		t.Fatalf("expected the status of the submitted operation, got %+v (%v)", again, err)
+	}
+	if _, err = submitter.ops.Submit(Operation{ID: op.ID, Kind: OpClear}); !errors.Is(err, OperationIDConflictedError) {
+		t.Fatalf("expected a conflict of the ID, got %v", err)
+	}
+	resent, err := flaky.ops.Submit(op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flaky.ops.Wait()
+	if resent, err = flaky.ops.Status(op.ID); err != nil {
+		t.Fatal(err)
+	}
+	if !resent.Local.Duplicate || !resent.Peers[submitter.addr].Duplicate || resent.Peers[broken.addr].Duplicate || !resent.Acked() {
+		t.Fatalf("expected duplicates on the applied instances only, got %+v", resent)
+	}
+	for _, in := range instances {
+		if n := in.appliedCount(op.ID); n != 1 {
+			t.Fatalf("expected %s to apply the operation once, got %d", in.addr, n)
+		}
+	}
+
+	// A rejected operation is not retried.
+	flaky.failWith.Store(http.StatusForbidden)
+	flaky.failures.Store(100)
+	status, err = submitter.ops.Submit(Operation{Kind: OpOff})
+	if err != nil {
+		t.Fatal(err)
+	}
+	submitter.ops.Wait()
+	if status, _ = submitter.ops.Status(status.ID); status.Peers[flaky.addr].State != AckFailed || status.Peers[flaky.addr].Attempts != 1 {
+		t.Fatalf("expected the rejected operation to fail once, got %+v", status.Peers[flaky.addr])
+	}
+	if recent := submitter.ops.Recent(); len(recent) != 2 || recent[0].ID != status.ID || len(recent[0].ID) != 32 {
+		t.Fatalf("expected the recent operations, the newest first, got %+v", recent)
+	}
+}
+
+func TestBroadcastApplyOnce(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	o := &origin{requests: map[string]int{}}
+	srv := httptest.NewServer(o)
+	defer srv.Close()
+	in := startCluster(t, ctx, 1, srv.URL, nil)[0]
+	failing := errors.New("apply failed")
+	var fail bool
+	b := NewBroadcaster(ctx, in.node, func(op Operation) (int, error) {
+		if fail {
+			return 0, failing
+		}
+		return in.apply(op)
+	})
+
+	// A retried delivery of an on/off older than the applied one doesn't undo it.
+	now := time.Now()
+	if ack := b.applyOnce(Operation{ID: "off", Kind: OpOff, CreatedAt: now}); ack.State != AckDone || ack.Superseded {
+		t.Fatalf("expected the off to be applied, got %+v", ack)
+	}
+	if ack := b.applyOnce(Operation{ID: "on", Kind: OpOn, CreatedAt: now.Add(-time.Second)}); ack.State != AckDone || !ack.Superseded {
+		t.Fatalf("expected the older on to be superseded, got %+v", ack)
+	}
+	if n := in.appliedCount("on"); n != 0 {
+		t.Fatalf("expected the superseded on not to be applied, got %d", n)
+	}
+	if ack := b.applyOnce(Operation{ID: "purge", Kind: OpPurge, Filter: storage.PurgeFilter{Prefix: "/api"}, CreatedAt: now.Add(-time.Second)}); ack.Superseded {
+		t.Fatalf("expected other operations not to be superseded, got %+v", ack)
+	}
+
+	// A failed operation is forgotten, so it's applied once it's delivered again.
+	fail = true
+	if ack := b.applyOnce(Operation{ID: "clear", Kind: OpClear}); ack.State != AckFailed {
+		t.Fatalf("expected the clear to fail, got %+v", ack)
+	}
+	b.mu.Lock()
+	_, seen := b.seen["clear"]
+	seenQ := append([]string(nil), b.seenQ...)
+	b.mu.Unlock()
+	if seen || len(seenQ) != 3 || seenQ[2] != "purge" {
+		t.Fatalf("expected the failed operation to be forgotten, got %v", seenQ)
+	}
+	fail = false
+	if ack := b.applyOnce(Operation{ID: "clear", Kind: OpClear}); ack.State != AckDone || ack.Duplicate || in.appliedCount("clear") != 1 {
+		t.Fatalf("expected the clear to be applied again, got %+v", ack)
+	}
+}
+
+func TestBroadcastStop(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	o := &origin{requests: map[string]int{}}
+	srv := httptest.NewServer(o)
+	defer srv.Close()
+	instances := startCluster(t, ctx, 2, srv.URL, func(i int, cfg *config.Cache) {
+		cfg.Cache.Cluster.Broadcast = config.Broadcast{Retries: 10, RetryInterval: time.Minute, History: 10}
+	})
+	submitter, broken := instances[0], instances[1]
+	broken.failWith.Store(http.StatusServiceUnavailable)
+	broken.failures.Store(100)
+
+	// A delivery waiting for a retry is failed once the broadcaster is stopped.
+	opsCtx, stop := context.WithCancel(ctx)
+	ops := NewBroadcaster(opsCtx, submitter.node, submitter.apply)
+	status, err := ops.Submit(Operation{Kind: OpClear})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); status.Peers[broken.addr].Attempts == 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		status, _ = ops.Status(status.ID)
+	}
+	stop()
+	ops.Wait()
+	if status, _ = ops.Status(status.ID); status.Peers[broken.addr].State != AckFailed || status.Peers[broken.addr].Attempts != 1 {
+		t.Fatalf("expected the abandoned delivery to fail, got %+v", status.Peers[broken.addr])
+	}
+}
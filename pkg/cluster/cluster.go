@@ -0,0 +1,407 @@
+// Package cluster spreads the keys of the cache over its instances: a consistent-hash ring over
+// model.Entry.MapKey names the owner of each key, an instance requests a missed key of another owner
+// from it (see Node.Fetch) and the owner serves it from its storage or fetches it from the upstream
+// (see Node.ServeEntry), so the upstream is requested once per key for the whole cluster.
+// In the replicated mode every instance stores all keys and refreshes the keys it owns, the refreshed
+// entries are pushed to the other instances (see Node.OwnsRefresh).
+// Admin operations (purge, clear, on/off) are broadcast to all instances (see Broadcaster).
+package cluster
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Borislavv/advanced-cache/pkg/config"
+	"github.com/Borislavv/advanced-cache/pkg/model"
+	"github.com/Borislavv/advanced-cache/pkg/server/auth"
+	"github.com/Borislavv/advanced-cache/pkg/storage"
+	"github.com/Borislavv/advanced-cache/pkg/upstream"
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
+)
+
+// EntryPath is the admin route serving entries to other instances.
+const EntryPath = "/cluster/entry"
+
+// Headers of the responses of EntryPath.
+const (
+	EntryVersionHeader   = "X-Adv-Cache-Entry-Version" // model.EntryFormatVersion of the body.
+	UpstreamStatusHeader = "X-Adv-Cache-Upstream-Status"
+)
+
+var (
+	NotCacheableError     = errors.New("upstream response is not cacheable")
+	EntryMismatchError    = errors.New("owner returned another entry (rules differ?)")
+	EntryVersionError     = errors.New("owner entry format version differs")
+	PeersFileIsEmptyError = errors.New("peers file has no peers")
+)
+
+// entryRequest is the body of a request to EntryPath, byte fields keep binary values intact.
+type entryRequest struct {
+	Path    []byte      `json:"path"`
+	Query   []byte      `json:"query"`
+	Headers [][2][]byte `json:"headers"` // All request headers, the owner fetches the upstream with them.
+}
+
+// Stats are the counters of a node.
+type Stats struct {
+	Fetched   int64 `json:"fetched"`   // Entries fetched from owners.
+	HotHits   int64 `json:"hotHits"`   // Requests served from hot copies.
+	Failed    int64 `json:"failed"`    // Failed requests to owners (the upstream was requested).
+	Skipped   int64 `json:"skipped"`   // Requests to owners skipped because of a backoff.
+	Served    int64 `json:"served"`    // Entries served to other instances.
+	HotCopies int   `json:"hotCopies"` // Current number of hot copies.
+
+	// Refreshes of the replicated mode.
+	Pushed      int64 `json:"pushed"`      // Refreshed entries delivered to peers (per peer).
+	PushFailed  int64 `json:"pushFailed"`  // Refreshed entries not delivered to peers (per peer).
+	PushDropped int64 `json:"pushDropped"` // Refreshed entries dropped because the push queue was full.
+	Replicated  int64 `json:"replicated"`  // Entries refreshed by other instances and stored.
+	Orphans     int64 `json:"orphans"`     // Too old entries of other owners taken for a refresh.
+}
+
+// Node is the instance in the cluster.
+type Node struct {
+	ctx     context.Context
+	cfg     *config.Cache
+	db      storage.Storage
+	backend upstream.Gateway
+	self    string
+
+	ring     atomic.Pointer[Ring]
+	static   []string
+	fileHash [sha256.Size]byte
+
+	client *fasthttp.Client
+	token  string
+	hmac   *auth.HMAC
+	hot    *hotCopies  // nil if disabled
+	down   sync.Map    // peer -> *atomic.Int64, UnixNano until which the peer is skipped
+	pushes chan []byte // refreshed entries to push (see Refreshed), nil unless replicated
+
+	fetched, hotHits, failed, skipped, served            atomic.Int64
+	pushed, pushFailed, pushDropped, replicated, orphans atomic.Int64
+}
+
+// New creates the node of cfg.Cache.Cluster and builds the ring of its peers (the peers file must be readable).
+func New(ctx context.Context, cfg *config.Cache, db storage.Storage, backend upstream.Gateway) (*Node, error) {
+	cluster := cfg.Cache.Cluster
+	n := &Node{
+		ctx:     ctx,
+		cfg:     cfg,
+		db:      db,
+		backend: backend,
+		self:    normalizePeer(cluster.Self),
+		static:  cluster.Peers,
+		client: &fasthttp.Client{
+			Name:                "advanced-cache-cluster",
+			MaxConnsPerHost:     512,
+			ReadTimeout:         cluster.Timeout,
+			WriteTimeout:        cluster.Timeout,
+			MaxIdleConnDuration: time.Minute,
+		},
+	}
+	if cluster.TokenEnv != "" {
+		n.token = os.Getenv(cluster.TokenEnv)
+	}
+	if hmacCfg := cfg.Cache.Admin.Auth.HMAC; hmacCfg != nil {
+		h, err := auth.LoadHMAC(*hmacCfg)
+		if err != nil {
+			return nil, fmt.Errorf("hmac: %w", err)
+		}
+		n.hmac = h
+	}
+	if cluster.HotCopy.Enabled && cluster.HotCopy.Size > 0 {
+		n.hot = newHotCopies(cluster.HotCopy.Size, cluster.HotCopy.TTL)
+	}
+	if cluster.Mode == config.ClusterModeReplicated {
+		n.pushes = make(chan []byte, pushQueueSize)
+	}
+
+	peers := n.static
+	if cluster.PeersFile != "" {
+		filePeers, hash, err := readPeersFile(cluster.PeersFile)
+		if err != nil {
+			return nil, err
+		}
+		peers, n.fileHash = append(append([]string(nil), peers...), filePeers...), hash
+	}
+	n.setPeers(peers)
+	return n, nil
+}
+
+// Run pushes refreshed entries to the peers (replicated mode) and watches the peers file (if any)
+// until the context is done.
+func (n *Node) Run() {
+	n.runPushes()
+
+	file := n.cfg.Cache.Cluster.PeersFile
+	if file == "" {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(n.cfg.Cache.Cluster.WatchInterval)
+		defer ticker.Stop()
+		log.Info().Msgf("[cluster] watching %s every %s", file, n.cfg.Cache.Cluster.WatchInterval)
+		for {
+			select {
+			case <-n.ctx.Done():
+				return
+			case <-ticker.C:
+				peers, hash, err := readPeersFile(file)
+				if err != nil {
+					log.Warn().Err(err).Msg("[cluster] peers file was not applied, the ring is kept")
+					continue
+				}
+				if hash == n.fileHash {
+					continue
+				}
+				n.fileHash = hash
+				n.setPeers(append(append([]string(nil), n.static...), peers...))
+			}
+		}
+	}()
+}
+
+// setPeers replaces the ring.
+func (n *Node) setPeers(peers []string) {
+	ring := NewRing(peers, n.cfg.Cache.Cluster.VirtualNodes)
+	n.ring.Store(ring)
+	if !ring.Has(n.self) {
+		log.Warn().Msgf("[cluster] self %s is not a peer, all keys are owned by other instances", n.self)
+	}
+	log.Info().Msgf("[cluster] ring of %d peers: %s", len(ring.peers), strings.Join(ring.peers, ", "))
+}
+
+// Self returns the address of the instance.
+func (n *Node) Self() string { return n.self }
+
+// Ring returns the current ring.
+func (n *Node) Ring() *Ring { return n.ring.Load() }
+
+// Owner returns the peer owning the key.
+func (n *Node) Owner(key uint64) string { return n.ring.Load().Owner(key) }
+
+// IsOwner reports whether the instance owns the key.
+func (n *Node) IsOwner(key uint64) bool { return n.Owner(key) == n.self }
+
+// Stats returns the counters.
+func (n *Node) Stats() Stats {
+	s := Stats{
+		Fetched: n.fetched.Load(),
+		HotHits: n.hotHits.Load(),
+		Failed:  n.failed.Load(),
+		Skipped: n.skipped.Load(),
+		Served:  n.served.Load(),
+
+		Pushed:      n.pushed.Load(),
+		PushFailed:  n.pushFailed.Load(),
+		PushDropped: n.pushDropped.Load(),
+		Replicated:  n.replicated.Load(),
+		Orphans:     n.orphans.Load(),
+	}
+	if n.hot != nil {
+		s.HotCopies = n.hot.len()
+	}
+	return s
+}
+
+// Fetch returns the entry of a local miss from its owner (or from a hot copy). It reports false in the replicated mode,
+// if the instance owns the key, the owner failed recently or fails now, or the upstream response is not cacheable: the caller
+// requests the upstream then. req is the lightweight entry of the request (see model.NewEntryFastHttp),
+// queryHeaders are all headers of the request. The returned entry is not stored locally.
+func (n *Node) Fetch(req *model.Entry, path, query []byte, queryHeaders *[][2][]byte) (*model.Entry, bool) {
+	if n.cfg.Cache.Cluster.Mode == config.ClusterModeReplicated {
+		return nil, false
+	}
+	owner := n.Owner(req.MapKey())
+	if owner == "" || owner == n.self {
+		return nil, false
+	}
+	if n.hot != nil {
+		if e, ok := n.hot.get(req); ok {
+			n.hotHits.Add(1)
+			return e, true
+		}
+	}
+	if n.isDown(owner) {
+		n.skipped.Add(1)
+		return nil, false
+	}
+
+	e, err := n.fetch(owner, path, query, queryHeaders)
+	if err == nil && (e.MapKey() != req.MapKey() || !e.IsSameFingerprint(req.Fingerprint())) {
+		err = EntryMismatchError
+	}
+	switch {
+	case errors.Is(err, NotCacheableError):
+		return nil, false
+	case err != nil:
+		n.failed.Add(1)
+		if errors.Is(err, EntryMismatchError) || errors.Is(err, EntryVersionError) {
+			log.Error().Err(err).Str("peer", owner).Msg("[cluster] owner entry rejected")
+		} else if n.markDown(owner) {
+			log.Warn().Err(err).Str("peer", owner).Msgf("[cluster] owner failed, the upstream is requested for %s", n.cfg.Cache.Cluster.Backoff)
+		}
+		return nil, false
+	}
+	n.fetched.Add(1)
+	if n.hot != nil {
+		n.hot.set(e)
+	}
+	return e, true
+}
+
+// fetch requests the entry from the owner.
+func (n *Node) fetch(owner string, path, query []byte, queryHeaders *[][2][]byte) (*model.Entry, error) {
+	body, err := json.Marshal(entryRequest{Path: path, Query: query, Headers: *queryHeaders})
+	if err != nil {
+		return nil, err
+	}
+
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.Header.SetMethod(fasthttp.MethodPost)
+	req.SetRequestURI(owner + EntryPath)
+	req.Header.SetContentType("application/json")
+	req.SetBody(body)
+	n.authorize(req)
+
+	if err = n.client.DoTimeout(req, resp, n.cfg.Cache.Cluster.Timeout); err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode() {
+	case http.StatusOK:
+	case http.StatusNoContent, http.StatusNotFound:
+		return nil, NotCacheableError
+	default:
+		return nil, fmt.Errorf("status %d: %s", resp.StatusCode(), strings.TrimSpace(string(resp.Body())))
+	}
+	if version := string(resp.Header.Peek(EntryVersionHeader)); version != strconv.Itoa(int(model.EntryFormatVersion)) {
+		return nil, fmt.Errorf("%w: %q", EntryVersionError, version)
+	}
+	// The payload of the entry refers to the body, which is released with the response.
+	return model.EntryFromBytes(append([]byte(nil), resp.Body()...), n.cfg, n.backend)
+}
+
+// authorize sets the bearer token of cluster.token_env and signs the request if admin.auth.hmac is configured.
+func (n *Node) authorize(req *fasthttp.Request) {
+	if n.token != "" {
+		req.Header.Set(fasthttp.HeaderAuthorization, "Bearer "+n.token)
+	}
+	if n.hmac != nil {
+		n.hmac.SignRequest(req)
+	}
+}
+
+// dropHotCopies removes the hot copies an operation may invalidate (all of them, they are few and short-lived).
+func (n *Node) dropHotCopies(op Operation) {
+	if n.hot == nil {
+		return
+	}
+	switch op.Kind {
+	case OpPurge, OpSoftPurge, OpClear:
+		n.hot.clear()
+	}
+}
+
+// isDown reports whether the peer is in a backoff after a failure.
+func (n *Node) isDown(peer string) bool {
+	until, ok := n.down.Load(peer)
+	return ok && time.Now().UnixNano() < until.(*atomic.Int64).Load()
+}
+
+// markDown skips the peer for cluster.backoff, it reports false if the peer is already skipped,
+// so the failure is logged once per backoff.
+func (n *Node) markDown(peer string) bool {
+	until, _ := n.down.LoadOrStore(peer, &atomic.Int64{})
+	now := time.Now().UnixNano()
+	prev := until.(*atomic.Int64).Load()
+	return now >= prev && until.(*atomic.Int64).CompareAndSwap(prev, now+int64(n.cfg.Cache.Cluster.Backoff))
+}
+
+// ServeEntry handles POST /cluster/entry of other instances: it responds with the entry (see model.Entry.ToBytes)
+// from the storage or fetched from the upstream and stored. A path without a rule is 404, a non-200 upstream
+// response is 204 with its status in UpstreamStatusHeader, the requester fetches the upstream itself then.
+func (n *Node) ServeEntry(ctx *fasthttp.RequestCtx) {
+	var req entryRequest
+	if err := json.Unmarshal(ctx.PostBody(), &req); err != nil {
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+	keyHeaders := append([][2][]byte(nil), req.Headers...) // filtered in place
+	entry, err := model.NewEntryManual(n.cfg, req.Path, req.Query, &keyHeaders, n.backend.RevalidatorMaker())
+	if err != nil {
+		if model.IsRouteWasNotFound(err) {
+			ctx.Error(err.Error(), fasthttp.StatusNotFound)
+			return
+		}
+		ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+		return
+	}
+
+	if found, ok := n.db.Get(entry); ok {
+		if found.ClaimStale() {
+			if err = found.Revalidate(); err == nil {
+				n.db.Refreshed(found)
+			} else {
+				found.MarkStale()
+				log.Warn().Err(err).Msg("[cluster] stale entry revalidation failed, serving stale")
+			}
+		}
+		entry = found
+	} else {
+		status, headers, body, release, err := n.backend.Fetch(entry.Rule(), req.Path, req.Query, &req.Headers)
+		defer release()
+		if err != nil {
+			ctx.Error(err.Error(), fasthttp.StatusBadGateway)
+			return
+		}
+		if status != http.StatusOK {
+			ctx.Response.Header.Set(UpstreamStatusHeader, strconv.Itoa(status))
+			ctx.SetStatusCode(fasthttp.StatusNoContent)
+			return
+		}
+		entry.SetPayload(req.Path, req.Query, &req.Headers, headers, body, status)
+		n.db.Set(entry)
+	}
+
+	data, release := entry.ToBytes()
+	defer release()
+	n.served.Add(1)
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType("application/octet-stream")
+	ctx.Response.Header.Set(EntryVersionHeader, strconv.Itoa(int(model.EntryFormatVersion)))
+	ctx.SetBody(data)
+}
+
+// readPeersFile reads admin addresses (one per line, '#' for comments) and the hash of the file.
+func readPeersFile(name string) ([]string, [sha256.Size]byte, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, [sha256.Size]byte{}, err
+	}
+	var peers []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line, _, _ = strings.Cut(line, "#"); strings.TrimSpace(line) != "" {
+			peers = append(peers, strings.TrimSpace(line))
+		}
+	}
+	if len(peers) == 0 {
+		return nil, [sha256.Size]byte{}, fmt.Errorf("%w: %s", PeersFileIsEmptyError, name)
+	}
+	return peers, sha256.Sum256(data), nil
+}
@@ -0,0 +1,345 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Borislavv/advanced-cache/pkg/config"
+	"github.com/Borislavv/advanced-cache/pkg/model"
+	"github.com/Borislavv/advanced-cache/pkg/storage"
+	"github.com/Borislavv/advanced-cache/pkg/storage/lru"
+	"github.com/Borislavv/advanced-cache/pkg/upstream"
+	"github.com/rs/zerolog"
+	"github.com/valyala/fasthttp"
+)
+
+// origin counts requests per URL, "missing" ids are 404.
+type origin struct {
+	mu       sync.Mutex
+	requests map[string]int
+	version  atomic.Int32 // appended to the bodies if set
+}
+
+func (o *origin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	o.mu.Lock()
+	o.requests[r.URL.RequestURI()]++
+	o.mu.Unlock()
+	if r.URL.Query().Get("id") == "missing" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = fmt.Fprintf(w, "page %s (%s)", r.URL.RequestURI(), r.Header.Get("Accept-Language"))
+	if v := o.version.Load(); v > 0 {
+		_, _ = fmt.Fprintf(w, " v%d", v)
+	}
+}
+
+func (o *origin) count(uri string) int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.requests[uri]
+}
+
+func newConfig(originURL, self string, peers []string) *config.Cache {
+	return &config.Cache{
+		Cache: &config.CacheBox{
+			Enabled:     true,
+			Proxy:       &config.Proxy{FromUrl: []byte(originURL), Rate: 1000, Timeout: time.Second},
+			LifeTime:    config.Lifetime{MaxReqDuration: time.Second},
+			Preallocate: config.Preallocation{PerShard: 8},
+			Eviction:    &config.Eviction{Enabled: true, Threshold: 0.9},
+			Refresh:     &config.Refresh{TTL: time.Hour, Beta: 0.4},
+			Storage:     &config.Storage{Type: "malloc", Size: 1024 * 1024 * 64},
+			Rules: map[string]*config.Rule{"/api/pages": {
+				PathBytes: []byte("/api/pages"),
+				CacheKey: config.RuleKey{
+					Query:      []string{"id"},
+					QueryBytes: [][]byte{[]byte("id")},
+					Headers:    []string{"Accept-Language"},
+					HeadersMap: map[string]struct{}{"Accept-Language": {}},
+				},
+				CacheValue: config.RuleValue{Headers: []string{"Content-Type"}, HeadersMap: map[string]struct{}{"Content-Type": {}}},
+			}},
+			Admin: config.Admin{},
+			Cluster: &config.Cluster{
+				Enabled:       true,
+				Self:          self,
+				Peers:         peers,
+				WatchInterval: 10 * time.Millisecond,
+				VirtualNodes:  64,
+				Timeout:       time.Second,
+				Backoff:       time.Hour,
+				HotCopy:       config.HotCopy{Size: 10, TTL: time.Hour},
+			},
+		},
+	}
+}
+
+// instance is a cache of the cluster, only the cluster routes of the admin listener are served.
+type instance struct {
+	addr    string
+	ln      net.Listener
+	srv     *fasthttp.Server
+	cfg     *config.Cache
+	backend upstream.Gateway
+	db      storage.Storage
+	node    *Node
+	ops     *Broadcaster
+
+	mu       sync.Mutex
+	applied  []Operation
+	failWith atomic.Int32 // status of the next failing apply requests
+	failures atomic.Int32 // number of the next apply requests to fail
+}
+
+func startCluster(t *testing.T, ctx context.Context, n int, originURL string, configure func(i int, cfg *config.Cache)) []*instance {
+	t.Helper()
+	zerolog.SetGlobalLevel(zerolog.ErrorLevel)
+	instances := make([]*instance, n)
+	peers := make([]string, n)
+	for i := range instances {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		instances[i] = &instance{ln: ln, addr: "http://" + ln.Addr().String()}
+		peers[i] = instances[i].addr
+	}
+	for i, in := range instances {
+		in.cfg = newConfig(originURL, in.addr, peers)
+		if configure != nil {
+			configure(i, in.cfg)
+		}
+		in.backend = upstream.NewBackend(ctx, in.cfg)
+		in.db = lru.NewStorage(ctx, in.cfg, in.backend)
+		node, err := New(ctx, in.cfg, in.db, in.backend)
+		if err != nil {
+			t.Fatal(err)
+		}
+		in.node = node
+		in.ops = NewBroadcaster(ctx, node, in.apply)
+		in.srv = &fasthttp.Server{Handler: in.serve}
+		go func() { _ = in.srv.Serve(in.ln) }()
+		t.Cleanup(func() { _ = in.srv.Shutdown() })
+	}
+	return instances
+}
+
+func (in *instance) serve(ctx *fasthttp.RequestCtx) {
+	switch string(ctx.Path()) {
+	case EntryPath:
+		in.node.ServeEntry(ctx)
+		return
+	case RefreshedPath:
+		in.node.ServeRefreshed(ctx)
+		return
+	}
+	if in.failures.Add(-1) >= 0 {
+		ctx.Error("failure", int(in.failWith.Load()))
+		return
+	}
+	in.ops.ServeApply(ctx)
+}
+
+// apply records the operation and purges the storage.
+func (in *instance) apply(op Operation) (int, error) {
+	in.mu.Lock()
+	in.applied = append(in.applied, op)
+	in.mu.Unlock()
+	if op.Kind == OpPurge || op.Kind == OpSoftPurge {
+		return storage.Purge(context.Background(), in.db, op.Filter, op.Kind == OpSoftPurge)
+	}
+	return 0, nil
+}
+
+// get serves a request like the cache controller: the local storage, then the owner, then the origin.
+func (in *instance) get(t *testing.T, query string) (body, source string) {
+	t.Helper()
+	path := []byte("/api/pages")
+	headers := [][2][]byte{{[]byte("Accept-Language"), []byte("en")}}
+	keyHeaders := append([][2][]byte(nil), headers...)
+	req, err := model.NewEntryManual(in.cfg, path, []byte(query), &keyHeaders, in.backend.RevalidatorMaker())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, found := in.db.Get(req)
+	source = "local"
+	if !found {
+		e, found = in.node.Fetch(req, path, []byte(query), &headers)
+		source = "peer"
+	}
+	if !found {
+		status, respHeaders, respBody, release, err := in.backend.Fetch(req.Rule(), path, []byte(query), &headers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer release()
+		if status != http.StatusOK {
+			return string(respBody), "origin"
+		}
+		req.SetPayload(path, []byte(query), &headers, respHeaders, respBody, status)
+		in.db.Set(req)
+		e, source = req, "origin"
+	}
+
+	_, _, queryHeaders, respHeaders, respBody, _, release, err := e.Payload()
+	defer release(queryHeaders, respHeaders)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(respBody), source
+}
+
+func (in *instance) owns(t *testing.T, query string) bool {
+	headers := [][2][]byte{{[]byte("Accept-Language"), []byte("en")}}
+	req, err := model.NewEntryManual(in.cfg, []byte("/api/pages"), []byte(query), &headers, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return in.node.IsOwner(req.MapKey())
+}
+
+func TestClusterRoutesMissesToOwners(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	o := &origin{requests: map[string]int{}}
+	srv := httptest.NewServer(o)
+	defer srv.Close()
+	instances := startCluster(t, ctx, 3, srv.URL, nil)
+
+	const ids = 30
+	for id := 0; id < ids; id++ {
+		query := fmt.Sprintf("id=%d", id)
+		for _, in := range instances {
+			body, _ := in.get(t, query)
+			if want := fmt.Sprintf("page /api/pages?%s (en)", query); body != want {
+				t.Fatalf("expected %q, got %q", want, body)
+			}
+		}
+		if n := o.count("/api/pages?" + query); n != 1 {
+			t.Fatalf("expected one origin request of %s for the cluster, got %d", query, n)
+		}
+		owners := 0
+		for _, in := range instances {
+			headers := [][2][]byte{{[]byte("Accept-Language"), []byte("en")}}
+			req, _ := model.NewEntryManual(in.cfg, []byte("/api/pages"), []byte(query), &headers, nil)
+			if _, stored := in.db.Get(req); stored != in.owns(t, query) {
+				t.Fatalf("expected %s to be stored by its owner only (%s owns: %v)", query, in.addr, in.owns(t, query))
+			}
+			if in.owns(t, query) {
+				owners++
+			}
+		}
+		if owners != 1 {
+			t.Fatalf("expected one owner of %s, got %d", query, owners)
+		}
+	}
+
+	var fetched, served int64
+	for _, in := range instances {
+		fetched += in.node.Stats().Fetched
+		served += in.node.Stats().Served
+	}
+	if fetched != 2*ids || served != fetched {
+		t.Fatalf("expected %d entries fetched from owners and served by them, got %d and %d", 2*ids, fetched, served)
+	}
+
+	// A response which is not cacheable is fetched from the origin by the requester.
+	for _, in := range instances {
+		if in.owns(t, "id=missing") {
+			continue
+		}
+		if _, source := in.get(t, "id=missing"); source != "origin" || in.node.Stats().Failed != 0 {
+			t.Fatalf("expected a not cacheable response from the origin, got %s (stats %+v)", source, in.node.Stats())
+		}
+		break
+	}
+}
+
+func TestClusterOwnerFailure(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	o := &origin{requests: map[string]int{}}
+	srv := httptest.NewServer(o)
+	defer srv.Close()
+	instances := startCluster(t, ctx, 2, srv.URL, func(i int, cfg *config.Cache) {
+		cfg.Cache.Cluster.HotCopy.Enabled = i == 0
+	})
+	requester, owner := instances[0], instances[1]
+
+	var owned []string
+	for id := 0; len(owned) < 3; id++ {
+		if query := fmt.Sprintf("id=%d", id); owner.owns(t, query) {
+			owned = append(owned, query)
+		}
+	}
+
+	// The hot copy is served without the owner.
+	if _, source := requester.get(t, owned[0]); source != "peer" {
+		t.Fatalf("expected the entry of the owner, got %s", source)
+	}
+	_ = owner.srv.Shutdown()
+	if body, source := requester.get(t, owned[0]); source != "peer" || requester.node.Stats().HotHits != 1 || !strings.HasPrefix(body, "page") {
+		t.Fatalf("expected the hot copy, got %s %q (stats %+v)", source, body, requester.node.Stats())
+	}
+
+	// A failed owner is skipped for the backoff, the origin is requested meanwhile.
+	if _, source := requester.get(t, owned[1]); source != "origin" || requester.node.Stats().Failed != 1 {
+		t.Fatalf("expected the origin after the owner failed, got %s (stats %+v)", source, requester.node.Stats())
+	}
+	if _, source := requester.get(t, owned[2]); source != "origin" || requester.node.Stats().Skipped != 1 {
+		t.Fatalf("expected the owner to be skipped, got %s (stats %+v)", source, requester.node.Stats())
+	}
+}
+
+func TestPeersFile(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	file := filepath.Join(t.TempDir(), "peers")
+	if err := os.WriteFile(file, []byte("# instances\nhttp://a:8021\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := newConfig("http://localhost", "http://a:8021", nil)
+	cfg.Cache.Cluster.PeersFile = file
+	backend := upstream.NewBackend(ctx, cfg)
+	node, err := New(ctx, cfg, lru.NewStorage(ctx, cfg, backend), backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !node.IsOwner(42) {
+		t.Fatal("expected the only peer to own all keys")
+	}
+
+	node.Run()
+	if err = os.WriteFile(file, []byte("http://a:8021\nhttp://b:8021 # new\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(node.Ring().Peers()) != 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if peers := node.Ring().Peers(); len(peers) != 2 || peers[1] != "http://b:8021" {
+		t.Fatalf("expected the peers of the changed file, got %v", peers)
+	}
+
+	// An empty file doesn't empty the ring.
+	if err = os.WriteFile(file, []byte("# nobody\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if peers := node.Ring().Peers(); len(peers) != 2 {
+		t.Fatalf("expected the ring to be kept, got %v", peers)
+	}
+}
@@ -0,0 +1,89 @@
+package cluster
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Borislavv/advanced-cache/pkg/list"
+	"github.com/Borislavv/advanced-cache/pkg/model"
+)
+
+// hotCopy is a local copy of an entry owned by another peer.
+type hotCopy struct {
+	entry     *model.Entry
+	expiresAt int64 // UnixNano
+}
+
+func (c *hotCopy) Weight() int64 { return c.entry.Weight() }
+
+// hotCopies is a small LRU of entries fetched from their owners, a copy is served until its TTL
+// expires, then the entry is fetched from the owner again.
+type hotCopies struct {
+	mu    sync.Mutex
+	size  int
+	ttl   time.Duration
+	items map[uint64]*list.Element[*hotCopy]
+	lru   *list.List[*hotCopy]
+}
+
+func newHotCopies(size int, ttl time.Duration) *hotCopies {
+	return &hotCopies{size: size, ttl: ttl, items: make(map[uint64]*list.Element[*hotCopy], size), lru: list.New[*hotCopy]()}
+}
+
+// get returns the live copy of the requested entry.
+func (h *hotCopies) get(req *model.Entry) (*model.Entry, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	el, ok := h.items[req.MapKey()]
+	if !ok {
+		return nil, false
+	}
+	c := el.Value()
+	if !c.entry.IsSameFingerprint(req.Fingerprint()) {
+		return nil, false
+	}
+	if time.Now().UnixNano() >= c.expiresAt {
+		h.remove(el)
+		return nil, false
+	}
+	h.lru.MoveToFront(el)
+	return c.entry, true
+}
+
+// set stores a copy, the least recently used one is dropped if there are too many.
+func (h *hotCopies) set(e *model.Entry) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if el, ok := h.items[e.MapKey()]; ok {
+		h.remove(el)
+	}
+	h.items[e.MapKey()] = h.lru.PushFront(&hotCopy{entry: e, expiresAt: time.Now().Add(h.ttl).UnixNano()})
+	for h.lru.Len() > h.size {
+		h.remove(h.lru.Back())
+	}
+}
+
+// clear drops all copies.
+func (h *hotCopies) clear() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, el := range h.items {
+		h.remove(el)
+	}
+}
+
+// len returns the number of copies.
+func (h *hotCopies) len() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.items)
+}
+
+// remove must be called under the lock.
+func (h *hotCopies) remove(el *list.Element[*hotCopy]) {
+	delete(h.items, el.Value().entry.MapKey())
+	h.lru.Remove(el)
+	h.lru.FreeElement(el)
+}
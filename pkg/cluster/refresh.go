@@ -0,0 +1,192 @@
+package cluster
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Borislavv/advanced-cache/pkg/config"
+	"github.com/Borislavv/advanced-cache/pkg/model"
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
+)
+
+// RefreshedPath is the admin route applying entries refreshed by their owners.
+const RefreshedPath = "/cluster/refreshed"
+
+const (
+	pushQueueSize = 4096                   // refreshed entries waiting for a push, newer ones are dropped
+	pushBatchSize = 256                    // max entries of one push
+	pushInterval  = 100 * time.Millisecond // max delay of a refreshed entry before it's pushed
+)
+
+// orphanStaleness is the age of an entry of another refresh owner relative to its refresh TTL after which
+// the instance refreshes the entry itself: the owner failed to refresh and push it (e.g. it's down).
+const orphanStaleness = 3
+
+var RefreshedBodyError = errors.New("malformed body of refreshed entries")
+
+// OwnsRefresh reports whether the refresher of the instance revalidates the entry (see lru.RefreshOwner).
+// In the replicated mode each instance refreshes the keys it owns on the ring and receives the refreshes
+// of other keys from their owners, so the upstream is requested once per refresh for the whole cluster.
+// An entry of another owner is refreshed anyway once it's too old (see orphanStaleness).
+// In the sharded mode entries are stored by their owners, all of them are refreshed.
+func (n *Node) OwnsRefresh(e *model.Entry) bool {
+	if n.cfg.Cache.Cluster.Mode != config.ClusterModeReplicated || n.IsOwner(e.MapKey()) {
+		return true
+	}
+	age, _, ok := e.Staleness(n.cfg, time.Now())
+	if ok && age >= orphanStaleness {
+		n.orphans.Add(1)
+		return true
+	}
+	return false
+}
+
+// Refreshed queues the entry refreshed by the instance for a push to the other instances (replicated mode only).
+// The queue is pushed by Run, an entry is dropped if the queue is full: the replicas refresh it themselves
+// once it's too old.
+func (n *Node) Refreshed(e *model.Entry) {
+	if n.pushes == nil {
+		return
+	}
+	data, release := e.ToBytes()
+	record := append([]byte(nil), data...)
+	release()
+	select {
+	case n.pushes <- record:
+	default:
+		n.pushDropped.Add(1)
+	}
+}
+
+// runPushes sends the queued refreshed entries to the other instances in batches until the context is done.
+func (n *Node) runPushes() {
+	if n.pushes == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(pushInterval)
+		defer ticker.Stop()
+		batch := make([][]byte, 0, pushBatchSize)
+		for {
+			select {
+			case <-n.ctx.Done():
+				return
+			case record := <-n.pushes:
+				if batch = append(batch, record); len(batch) < pushBatchSize {
+					continue
+				}
+			case <-ticker.C:
+				if len(batch) == 0 {
+					continue
+				}
+			}
+			n.push(batch)
+			batch = batch[:0]
+		}
+	}()
+}
+
+// push sends the batch to all other peers concurrently, peers in a backoff are skipped.
+func (n *Node) push(batch [][]byte) {
+	var body bytes.Buffer
+	var scratch [4]byte
+	for _, record := range batch {
+		binary.LittleEndian.PutUint32(scratch[:], uint32(len(record)))
+		body.Write(scratch[:])
+		body.Write(record)
+	}
+
+	var wg sync.WaitGroup
+	for _, peer := range n.Ring().Peers() {
+		if peer == n.self {
+			continue
+		}
+		if n.isDown(peer) {
+			n.pushFailed.Add(int64(len(batch)))
+			continue
+		}
+		wg.Add(1)
+		go func(peer string) {
+			defer wg.Done()
+			if err := n.sendRefreshed(peer, body.Bytes()); err != nil {
+				n.pushFailed.Add(int64(len(batch)))
+				if n.markDown(peer) {
+					log.Warn().Err(err).Str("peer", peer).Msgf("[cluster] refreshed entries were not pushed, the peer is skipped for %s", n.cfg.Cache.Cluster.Backoff)
+				}
+				return
+			}
+			n.pushed.Add(int64(len(batch)))
+		}(peer)
+	}
+	wg.Wait()
+}
+
+// sendRefreshed posts the refreshed entries to the peer.
+func (n *Node) sendRefreshed(peer string, body []byte) error {
+	req := fasthttp.AcquireRequest()
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(req)
+	defer fasthttp.ReleaseResponse(resp)
+
+	req.Header.SetMethod(fasthttp.MethodPost)
+	req.SetRequestURI(peer + RefreshedPath)
+	req.Header.SetContentType("application/octet-stream")
+	req.Header.Set(EntryVersionHeader, strconv.Itoa(int(model.EntryFormatVersion)))
+	req.SetBody(body)
+	n.authorize(req)
+
+	if err := n.client.DoTimeout(req, resp, n.cfg.Cache.Cluster.Timeout); err != nil {
+		return err
+	}
+	if resp.StatusCode() != http.StatusOK {
+		return fmt.Errorf("status %d: %s", resp.StatusCode(), strings.TrimSpace(string(resp.Body())))
+	}
+	return nil
+}
+
+type refreshedResponse struct {
+	Replicated int `json:"replicated"`
+	Skipped    int `json:"skipped"` // Malformed entries, of paths without a rule or not admitted by the storage.
+}
+
+// ServeRefreshed handles POST /cluster/refreshed of refresh owners: the entries of the body (each one is
+// a little-endian uint32 length and model.Entry.ToBytes) are stored with their payloads and update times
+// through the update path of a local refresh (see lru.InMemoryStorage.Replicate).
+func (n *Node) ServeRefreshed(ctx *fasthttp.RequestCtx) {
+	if version := string(ctx.Request.Header.Peek(EntryVersionHeader)); version != strconv.Itoa(int(model.EntryFormatVersion)) {
+		ctx.Error(fmt.Sprintf("%s: %q", EntryVersionError, version), fasthttp.StatusBadRequest)
+		return
+	}
+	var resp refreshedResponse
+	body := ctx.PostBody()
+	for len(body) > 0 {
+		if len(body) < 4 || len(body)-4 < int(binary.LittleEndian.Uint32(body)) {
+			ctx.Error(RefreshedBodyError.Error(), fasthttp.StatusBadRequest)
+			return
+		}
+		size := int(binary.LittleEndian.Uint32(body))
+		// The payload of the entry refers to the data, the body is released with the request.
+		data := append([]byte(nil), body[4:4+size]...)
+		body = body[4+size:]
+
+		e, err := model.EntryFromBytes(data, n.cfg, n.backend)
+		if err == nil && n.db.Replicate(e) {
+			resp.Replicated++
+		} else {
+			resp.Skipped++
+		}
+	}
+	n.replicated.Add(int64(resp.Replicated))
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType("application/json")
+	_ = json.NewEncoder(ctx).Encode(resp)
+}
@@ -0,0 +1,101 @@
+package cluster
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Borislavv/advanced-cache/pkg/config"
+	"github.com/Borislavv/advanced-cache/pkg/model"
+)
+
+func (in *instance) stored(t *testing.T, query string) *model.Entry {
+	t.Helper()
+	headers := [][2][]byte{{[]byte("Accept-Language"), []byte("en")}}
+	req, err := model.NewEntryManual(in.cfg, []byte("/api/pages"), []byte(query), &headers, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, found := in.db.Get(req)
+	if !found {
+		t.Fatalf("expected %s to be stored by %s", query, in.addr)
+	}
+	return e
+}
+
+func TestReplicatedRefresh(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	o := &origin{requests: map[string]int{}}
+	srv := httptest.NewServer(o)
+	defer srv.Close()
+	instances := startCluster(t, ctx, 3, srv.URL, func(i int, cfg *config.Cache) {
+		cfg.Cache.Cluster.Mode = config.ClusterModeReplicated
+		cfg.Cache.Refresh.Enabled = true
+	})
+	for _, in := range instances {
+		in.db.SetRefreshOwner(in.node)
+		in.node.Run()
+	}
+
+	// Each replica stores the key, its owner only refreshes it.
+	const query = "id=1"
+	var owner *instance
+	for _, in := range instances {
+		if _, source := in.get(t, query); source != "origin" {
+			t.Fatalf("expected the replicated mode to request the origin, got %s", source)
+		}
+		owns := in.node.OwnsRefresh(in.stored(t, query))
+		if owns != in.owns(t, query) {
+			t.Fatalf("expected the refresh of %s to be owned by its owner only (%s owns: %v)", query, in.addr, owns)
+		}
+		if owns {
+			owner = in
+		}
+	}
+
+	// The refresh of the owner is pushed to the replicas with its update time.
+	o.version.Store(2)
+	e := owner.stored(t, query)
+	if err := e.Revalidate(); err != nil {
+		t.Fatal(err)
+	}
+	owner.db.Refreshed(e)
+	deadline := time.Now().Add(5 * time.Second)
+	for _, in := range instances {
+		for in.node.Stats().Replicated == 0 && in != owner && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if body, _ := in.get(t, query); !strings.HasSuffix(body, " v2") {
+			t.Fatalf("expected the refreshed body on %s, got %q", in.addr, body)
+		}
+		if got := in.stored(t, query).UpdateAt(); got != e.UpdateAt() {
+			t.Fatalf("expected the update time of the owner on %s, got %d instead of %d", in.addr, got, e.UpdateAt())
+		}
+	}
+	if n := o.count("/api/pages?" + query); n != 4 {
+		t.Fatalf("expected one origin request of the refresh, got %d requests", n-3)
+	}
+	if s := owner.node.Stats(); s.Pushed != 2 || s.PushFailed != 0 {
+		t.Fatalf("expected the refresh to be pushed to 2 peers, got %+v", s)
+	}
+
+	// An older refresh doesn't replace a newer payload.
+	replica := instances[0]
+	if replica == owner {
+		replica = instances[1]
+	}
+	stale := replica.stored(t, query)
+	older := model.NewEntryFromField(stale.MapKey(), stale.ShardKey(), stale.Fingerprint(), []byte("older"), stale.Rule(), nil, 0, e.UpdateAt()-1)
+	if !replica.db.Replicate(older) || replica.stored(t, query).UpdateAt() != e.UpdateAt() {
+		t.Fatal("expected the newer payload to be kept")
+	}
+
+	// A replica refreshes an entry the owner didn't refresh for too long.
+	stale.SetUpdatedAt(time.Now().Add(-orphanStaleness * owner.cfg.Cache.Refresh.TTL).UnixNano())
+	if !replica.node.OwnsRefresh(stale) || replica.node.Stats().Orphans != 1 {
+		t.Fatalf("expected the orphaned entry to be refreshed by the replica, got %+v", replica.node.Stats())
+	}
+}
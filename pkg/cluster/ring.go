@@ -0,0 +1,85 @@
+package cluster
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/zeebo/xxh3"
+)
+
+// Ring is an immutable consistent-hash ring of peers, each peer is placed on it at a number
+// of virtual nodes, so adding or removing a peer moves about 1/N of the keys.
+type Ring struct {
+	peers  []string
+	points []point // sorted by hash
+}
+
+type point struct {
+	hash uint64
+	peer int
+}
+
+// NewRing builds a ring of the peers (normalized, duplicates are dropped), the order of peers doesn't matter.
+func NewRing(peers []string, virtualNodes int) *Ring {
+	r := &Ring{peers: normalize(peers)}
+	r.points = make([]point, 0, len(r.peers)*virtualNodes)
+	for i, peer := range r.peers {
+		for v := 0; v < virtualNodes; v++ {
+			r.points = append(r.points, point{hash: xxh3.HashString(peer + "#" + strconv.Itoa(v)), peer: i})
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool {
+		if r.points[i].hash != r.points[j].hash {
+			return r.points[i].hash < r.points[j].hash
+		}
+		return r.points[i].peer < r.points[j].peer
+	})
+	return r
+}
+
+// Owner returns the peer owning the key (e.g. model.Entry.MapKey), empty if the ring has no peers.
+func (r *Ring) Owner(key uint64) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i].hash >= key })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.peers[r.points[i].peer]
+}
+
+// Peers returns the sorted peers of the ring.
+func (r *Ring) Peers() []string {
+	return append([]string(nil), r.peers...)
+}
+
+// Has reports whether the peer is on the ring.
+func (r *Ring) Has(peer string) bool {
+	peer = normalizePeer(peer)
+	i := sort.SearchStrings(r.peers, peer)
+	return i < len(r.peers) && r.peers[i] == peer
+}
+
+// normalize returns sorted unique non-empty peers without trailing slashes.
+func normalize(peers []string) []string {
+	out := make([]string, 0, len(peers))
+	for _, peer := range peers {
+		if peer = normalizePeer(peer); peer != "" {
+			out = append(out, peer)
+		}
+	}
+	sort.Strings(out)
+	unique := out[:0]
+	for i, peer := range out {
+		if i == 0 || peer != out[i-1] {
+			unique = append(unique, peer)
+		}
+	}
+	return unique
+}
+
+func normalizePeer(peer string) string {
+	return strings.TrimSuffix(strings.TrimSpace(peer), "/")
+}
@@ -0,0 +1,51 @@
+package cluster
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestRing(t *testing.T) {
+	peers := []string{"http://a:8021", "http://b:8021/", "http://c:8021", "http://a:8021"}
+	ring := NewRing(peers, 128)
+	if got := ring.Peers(); len(got) != 3 || !ring.Has("http://b:8021") {
+		t.Fatalf("expected 3 normalized peers, got %v", got)
+	}
+	if NewRing(nil, 128).Owner(1) != "" {
+		t.Fatal("expected no owner of an empty ring")
+	}
+
+	const keys = 30000
+	rnd := rand.New(rand.NewSource(1))
+	owners := make(map[uint64]string, keys)
+	counts := map[string]int{}
+	reordered := NewRing([]string{"http://c:8021", "http://b:8021", "http://a:8021"}, 128)
+	for i := 0; i < keys; i++ {
+		key := rnd.Uint64()
+		owners[key] = ring.Owner(key)
+		counts[owners[key]]++
+		if reordered.Owner(key) != owners[key] {
+			t.Fatalf("expected the owner not to depend on the order of peers")
+		}
+	}
+	for peer, n := range counts {
+		if n < keys/3*7/10 || n > keys/3*13/10 {
+			t.Errorf("expected about %d keys of %s, got %d", keys/3, peer, n)
+		}
+	}
+
+	// A new peer takes about a quarter of the keys, the rest keep their owners.
+	grown := NewRing(append(peers, "http://d:8021"), 128)
+	moved := 0
+	for key, owner := range owners {
+		if newOwner := grown.Owner(key); newOwner != owner {
+			if newOwner != "http://d:8021" {
+				t.Fatalf("expected keys to move to the new peer only, %d moved from %s to %s", key, owner, newOwner)
+			}
+			moved++
+		}
+	}
+	if moved < keys/4*7/10 || moved > keys/4*13/10 {
+		t.Fatalf("expected about %d moved keys, got %d", keys/4, moved)
+	}
+}
@@ -1,10 +1,13 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"gopkg.in/yaml.v3"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sync/atomic"
 	"time"
 )
 
@@ -20,6 +23,30 @@ type TraefikIntermediateConfig struct {
 
 type Cache struct {
 	Cache *CacheBox `yaml:"cache"`
+	Path  string    `yaml:"-"` // Absolute path of the file the config was loaded from.
+
+	node      *yaml.Node            // merged config document, used to point validation errors to lines
+	sources   map[*yaml.Node]string // key node -> include file, env variable or flag the value came from
+	files     []string              // config file and include files
+	overrides []Override            // overrides given to LoadConfig (re-applied on reload)
+}
+
+// Files returns the config file and the included files.
+func (c *Cache) Files() []string {
+	return c.files
+}
+
+// IncludeDir returns the absolute path of the include dir or an empty string.
+func (c *Cache) IncludeDir() string {
+	if c.Cache.Include == "" || filepath.IsAbs(c.Cache.Include) {
+		return c.Cache.Include
+	}
+	return filepath.Join(filepath.Dir(c.Path), c.Cache.Include)
+}
+
+// Overrides returns the overrides the config was loaded with.
+func (c *Cache) Overrides() []Override {
+	return c.overrides
 }
 
 func (c *Cache) IsProd() bool {
@@ -53,7 +80,63 @@ type CacheBox struct {
 	ForceGC     ForceGC          `yaml:"forceGC"`
 	LifeTime    Lifetime         `yaml:"lifetime"`
 	Preallocate Preallocation    `yaml:"preallocate"`
+	Admin       Admin            `yaml:"admin"`
+	Include     string           `yaml:"include"`   // Dir of *.yaml files with rules and templates (relative to the config file).
+	Templates   map[string]*Rule `yaml:"templates"` // Named rule templates (see Rule.Extends).
 	Rules       map[string]*Rule `yaml:"rules"`
+
+	Reload  Reload   `yaml:"reload"`
+	Cluster *Cluster `yaml:"cluster"`
+
+	// rules is the live rule set (see Cache.Rules), Rules above is the set the config was loaded with.
+	rules atomic.Pointer[map[string]*Rule]
+	// refresh is the live refresh policy (see Cache.RefreshPolicy).
+	refresh atomic.Pointer[Refresh]
+}
+
+// Modes of the cluster, see cluster.mode.
+const (
+	ClusterModeSharded    = "sharded"    // keys are spread over the instances
+	ClusterModeReplicated = "replicated" // each instance caches all keys and refreshes the keys it owns, refreshes are pushed to the peers
+)
+
+// Cluster configures the cluster mode: keys are spread over the instances by a consistent-hash ring,
+// a local miss of a key owned by another instance is fetched from the owner (which fetches it from the upstream
+// on its own miss) before the upstream is requested directly. Peers are admin addresses of the instances.
+// In both modes purge, soft-purge, clear and on/off operations are broadcast to the peers.
+type Cluster struct {
+	Enabled       bool          `yaml:"enabled"`
+	Mode          string        `yaml:"mode"`           // sharded (default) or replicated.
+	Self          string        `yaml:"self"`           // Admin address of this instance as other instances know it, e.g. "http://cache-0.cache:8021".
+	Peers         []string      `yaml:"peers"`          // Admin addresses of all instances including self.
+	PeersFile     string        `yaml:"peers_file"`     // File with admin addresses (one per line, '#' for comments), watched for changes.
+	WatchInterval time.Duration `yaml:"watch_interval"` // Poll interval of peers_file (5s by default).
+	VirtualNodes  int           `yaml:"virtual_nodes"`  // Points of each peer on the ring (128 by default).
+	Timeout       time.Duration `yaml:"timeout"`        // Max duration of a request to the owner (2s by default).
+	Backoff       time.Duration `yaml:"backoff"`        // A failed owner is skipped (the upstream is requested) for this long (5s by default).
+	TokenEnv      string        `yaml:"token_env"`      // Env variable name with a bearer token of the peer admin API.
+	HotCopy       HotCopy       `yaml:"hot_copy"`
+	Broadcast     Broadcast     `yaml:"broadcast"`
+}
+
+// Broadcast configures the delivery of admin operations to the peers.
+type Broadcast struct {
+	Retries       int           `yaml:"retries"`        // Attempts after the first failed one (10 by default).
+	RetryInterval time.Duration `yaml:"retry_interval"` // Interval before the first retry, doubled after each one up to a minute (1s by default).
+	History       int           `yaml:"history"`        // Number of recent operations kept for the status view (100 by default).
+}
+
+// HotCopy keeps local copies of the most recently used entries fetched from their owners.
+type HotCopy struct {
+	Enabled bool          `yaml:"enabled"`
+	Size    int           `yaml:"size"` // Max number of copies (1000 by default).
+	TTL     time.Duration `yaml:"ttl"`  // A copy is fetched from the owner again after it (10s by default).
+}
+
+// Reload configures hot reload of the config file (SIGHUP always triggers a reload).
+type Reload struct {
+	Watch    bool          `yaml:"watch"`    // Poll the config file for changes.
+	Interval time.Duration `yaml:"interval"` // Poll interval.
 }
 
 type Runtime struct {
@@ -90,30 +173,192 @@ type Logs struct {
 type Lifetime struct {
 	MaxReqDuration                  time.Duration `yaml:"max_req_dur"`               // If a request lifetime is longer than 100ms then request will be canceled by context.
 	EscapeMaxReqDurationHeader      string        `yaml:"escape_max_req_dur_header"` // If the header exists the timeout above will be skipped.
-	EscapeMaxReqDurationHeaderBytes []byte        // The same value but converted into slice bytes.
+	EscapeMaxReqDurationHeaderBytes []byte        `yaml:"-"`                         // The same value but converted into slice bytes.
 }
 
 type Proxy struct {
 	Name    string        `yaml:"name"`
-	FromUrl []byte        // Reverse Proxy url (can be found in Caddyfile). URL to underlying backend.
+	FromUrl []byte        `yaml:"-"` // Reverse Proxy url (can be found in Caddyfile). URL to underlying backend.
 	From    string        `yaml:"from"`
 	To      string        `yaml:"to"`
 	Rate    int           `yaml:"rate"`    // Rate limiting reqs to backend per second.
 	Timeout time.Duration `yaml:"timeout"` // Timeout for requests to backend.
 }
 
+const (
+	// DefaultAdminAddr is used when the admin listener address is not configured, it's local only:
+	// other hosts (e.g. probes of Kubernetes) reach an explicitly configured one.
+	DefaultAdminAddr = "127.0.0.1:8021"
+	// DefaultRulesHistoryDir is used when admin.rules.history_dir is not configured.
+	DefaultRulesHistoryDir = "public/rules"
+	// DefaultRulesMaxVersions is used when admin.rules.max_versions is not configured.
+	DefaultRulesMaxVersions = 20
+)
+
+// Admin configures the management listener (on/off, clear, metrics, probe, etc.).
+// Management routes are never served on the public proxy port.
+type Admin struct {
+	Name  string     `yaml:"name"`
+	Addr  string     `yaml:"addr"` // Admin listener address, e.g. ":8021".
+	Auth  AdminAuth  `yaml:"auth"`
+	Rules AdminRules `yaml:"rules"`
+}
+
+// AdminRules configures runtime rule management.
+type AdminRules struct {
+	Persist     bool   `yaml:"persist"`      // Write changed rules back into the config file.
+	HistoryDir  string `yaml:"history_dir"`  // Directory of rule set versions used for rollback.
+	MaxVersions int    `yaml:"max_versions"` // Number of rule set versions to keep.
+}
+
+// AdminAuth configures authentication of admin requests.
+// If allow_cidrs is set the remote address must match one of the networks.
+// If any credential source is set (tokens or hmac) the request must pass at least one of them.
+type AdminAuth struct {
+	TokensFile string    `yaml:"tokens_file"` // File with static bearer tokens (one per line, '#' for comments).
+	TokensEnv  string    `yaml:"tokens_env"`  // Env variable name with comma separated bearer tokens.
+	HMAC       *HMACAuth `yaml:"hmac"`
+	AllowCIDRs []string  `yaml:"allow_cidrs"` // e.g. ["10.0.0.0/8", "127.0.0.1/32"]
+}
+
+// HMACAuth configures HMAC-SHA256 signed admin requests.
+type HMACAuth struct {
+	SecretFile string        `yaml:"secret_file"` // File with a shared secret.
+	SecretEnv  string        `yaml:"secret_env"`  // Env variable name with a shared secret.
+	MaxSkew    time.Duration `yaml:"max_skew"`    // Max allowed difference between signature timestamp and now.
+}
+
+// Formats of dumps, see persistence.dump.format.
+const (
+	DumpFormatSnapshot = "snapshot"
+	DumpFormatLegacy   = "legacy"
+)
+
+// Codecs of snapshot blocks, see persistence.dump.codec.
+const (
+	DumpCodecNone = "none"
+	DumpCodecGzip = "gzip"
+	DumpCodecZstd = "zstd"
+)
+
 type Dump struct {
-	IsEnabled    bool   `yaml:"enabled"`
-	Dir          string `yaml:"dump_dir"`
-	Name         string `yaml:"dump_name"`
-	MaxVersions  int    `yaml:"max_versions"`
-	Gzip         bool   `yaml:"gzip"`
-	Crc32Control bool   `yaml:"crc32_control_sum"`
+	IsEnabled    bool          `yaml:"enabled"`
+	Dir          string        `yaml:"dump_dir"`
+	Name         string        `yaml:"dump_name"`
+	MaxVersions  int           `yaml:"max_versions"` // Retention: number of versions to keep, 0 means no limit.
+	MaxAge       time.Duration `yaml:"max_age"`      // Retention: versions older than it are removed, 0 means no limit.
+	MaxSize      int64         `yaml:"max_size"`     // Retention: max total size of versions in bytes, 0 means no limit.
+	Gzip         bool          `yaml:"gzip"`         // The same as codec: gzip (the legacy format supports gzip only).
+	Codec        string        `yaml:"codec"`        // Codec of snapshot blocks: none, gzip or zstd (none by default, gzip if the legacy gzip flag is set).
+	Level        int           `yaml:"level"`        // zstd level, 1 (fastest) to 22 (best), 0 is the default level.
+	Crc32Control bool          `yaml:"crc32_control_sum"`
+	Format       string        `yaml:"format"`     // "snapshot" (default) or "legacy" (a file per shard).
+	Segments     int           `yaml:"segments"`   // Number of snapshot files, written and read in parallel (1 by default).
+	BlockSize    int           `yaml:"block_size"` // Raw size of snapshot blocks in bytes (1MiB by default).
+	Mmap         bool          `yaml:"mmap"`       // Map snapshot files on load, payloads of plain (codec none, unencrypted) blocks are not copied.
+	Schedule     DumpSchedule  `yaml:"schedule"`
+	Encryption   Encryption    `yaml:"encryption"`
+	Restore      DumpRestore   `yaml:"restore"`
+}
+
+// Policies of expired restored entries, see persistence.dump.restore.expired.
+const (
+	RestoreExpiredStale = "stale"
+	RestoreExpiredDrop  = "drop"
+)
+
+// DumpRestore configures how restored entries are classified against the refresh TTL of their rules.
+// Fresh entries are loaded as is, entries past the refresh coefficient are refreshed right after the load
+// (the most stale first), expired ones (past the TTL) are dropped or loaded as stale.
+type DumpRestore struct {
+	Expired     string `yaml:"expired"`      // "stale" (default): revalidated on the first hit and served only if it fails; "drop".
+	RefreshRate int    `yaml:"refresh_rate"` // Refreshes of restored entries per second (100 by default), negative disables them.
+}
+
+// Encryption configures AES-GCM encryption of snapshot files at rest. Snapshots are encrypted with the first key,
+// its id is stored in the header, so snapshots encrypted with any of the keys stay readable while keys are rotated.
+type Encryption struct {
+	Enabled bool            `yaml:"enabled"`
+	Keys    []EncryptionKey `yaml:"keys"`
+}
+
+// EncryptionKey is an AES key of 16, 24 or 32 bytes, base64 or hex encoded.
+type EncryptionKey struct {
+	ID   string `yaml:"id"`   // Id of the key stored in snapshot headers.
+	File string `yaml:"file"` // File with the key.
+	Env  string `yaml:"env"`  // Env variable name with the key (if there is no file).
+}
+
+// DumpSchedule configures periodic snapshots taken while serving.
+// A snapshot is taken every interval or after the number of changed entries (sets, refreshes, removals),
+// whichever comes first, but not more often than min_interval.
+type DumpSchedule struct {
+	Enabled     bool          `yaml:"enabled"`
+	Interval    time.Duration `yaml:"interval"`     // 0 means by changes only.
+	Changes     int64         `yaml:"changes"`      // 0 means by interval only.
+	MinInterval time.Duration `yaml:"min_interval"` // 1m by default.
+	Rate        int           `yaml:"rate"`         // Max entries written per second, 0 means unlimited.
+	BytesRate   int           `yaml:"bytes_rate"`   // Max bytes written per second, 0 means unlimited.
+	Workers     int           `yaml:"workers"`      // Number of files written concurrently (1 by default).
+}
+
+// WAL sync policies, see persistence.wal.fsync.
+const (
+	WALFsyncBatch    = "batch"    // fsync after each written batch.
+	WALFsyncInterval = "interval" // fsync every sync_interval.
+	WALFsyncNone     = "none"     // leave it to the OS.
+)
+
+// WAL configures the write-ahead log of storage changes, it's replayed on top of the latest dump on startup
+// and compacted when a new dump is finished (requires persistence.dump.enabled).
+type WAL struct {
+	Enabled      bool          `yaml:"enabled"`
+	Dir          string        `yaml:"dir"`           // "<dump_dir>/wal" by default.
+	Fsync        string        `yaml:"fsync"`         // "batch" (default), "interval" or "none".
+	SyncInterval time.Duration `yaml:"sync_interval"` // 1s by default, used by the "interval" policy.
+	BatchSize    int           `yaml:"batch_size"`    // Max records written between flushes (1024 by default).
+	Buffer       int           `yaml:"buffer"`        // Size of the queue of changes, writers block when it's full (65536 by default).
+	SegmentSize  int64         `yaml:"segment_size"`  // Size of a segment file to start a new one (64MiB by default).
+}
+
+// Peer configures warming up from a running instance on startup: the storage is loaded from a snapshot
+// streamed by the admin API of the peer before the cache is served. The local dump is loaded only if it fails.
+type Peer struct {
+	URL      string        `yaml:"url"`       // Admin address of the peer, e.g. "http://cache-0.cache:8021", empty means disabled.
+	TokenEnv string        `yaml:"token_env"` // Env variable name with a bearer token of the peer admin API.
+	Timeout  time.Duration `yaml:"timeout"`   // Max duration of the warm-up (5m by default).
+}
+
+// Formats of warm-up URL lists, see persistence.warmup.sources.
+const (
+	WarmupFormatText    = "text"    // a URL (or a path with a query) per line, # comments
+	WarmupFormatJSONL   = "jsonl"   // {"path": "...", "query": "...", "headers": {"...": "..."}} per line
+	WarmupFormatSitemap = "sitemap" // <loc> elements of a sitemap XML
+)
+
+// Warmup fills the storage from URL lists: each URL is fetched from the upstream (under its own rate limit)
+// and stored. It runs at startup (before serving) if enabled and on demand via the admin API.
+type Warmup struct {
+	Enabled bool           `yaml:"enabled"` // Warm up at startup.
+	Sources []WarmupSource `yaml:"sources"`
+	Rate    int            `yaml:"rate"`    // Upstream requests per second (50 by default).
+	Workers int            `yaml:"workers"` // Concurrent upstream requests (4 by default).
+	Timeout time.Duration  `yaml:"timeout"` // Max duration of the startup warm-up (5m by default).
+}
+
+// WarmupSource is a local URL list.
+type WarmupSource struct {
+	File    string            `yaml:"file"`
+	Format  string            `yaml:"format"`  // text, jsonl or sitemap, by the file extension by default (.jsonl, .xml).
+	Headers map[string]string `yaml:"headers"` // Request headers of each URL (JSONL headers take precedence).
 }
 
 type Persistence struct {
-	Dump *Dump `yaml:"dump"`
-	Mock *Mock `yaml:"mock"`
+	Dump   *Dump   `yaml:"dump"`
+	WAL    *WAL    `yaml:"wal"`
+	Peer   *Peer   `yaml:"peer"`
+	Mock   *Mock   `yaml:"mock"`
+	Warmup *Warmup `yaml:"warmup"`
 }
 
 type Preallocation struct {
@@ -167,32 +412,31 @@ type Gzip struct {
 }
 
 type Rule struct {
+	Extends    string       `yaml:"extends,omitempty"` // Name of a template the rule is based on (already merged in).
 	Gzip       Gzip         `yaml:"gzip"`
 	CacheKey   RuleKey      `yaml:"cache_key"`
 	CacheValue RuleValue    `yaml:"cache_value"`
 	Refresh    *RuleRefresh `yaml:"refresh"`
-	PathBytes  []byte       // Virtual field
+	PathBytes  []byte       `yaml:"-"` // Virtual field
 }
 
 type RuleKey struct {
-	Query      []string            `yaml:"query"` // Параметры, которые будут участвовать в ключе кэширования
-	QueryBytes [][]byte            // Virtual field
+	Query      []string            `yaml:"query"`   // Параметры, которые будут участвовать в ключе кэширования
+	QueryBytes [][]byte            `yaml:"-"`       // Virtual field
 	Headers    []string            `yaml:"headers"` // Хедеры, которые будут участвовать в ключе кэширования
-	HeadersMap map[string]struct{} // Virtual field
+	HeadersMap map[string]struct{} `yaml:"-"`       // Virtual field
 }
 
 type RuleValue struct {
 	Headers    []string            `yaml:"headers"` // Хедеры ответа, которые будут сохранены в кэше вместе с body
-	HeadersMap map[string]struct{} // Virtual field
+	HeadersMap map[string]struct{} `yaml:"-"`       // Virtual field
 }
 
-func LoadConfig(path string) (*Cache, error) {
-	dir, err := os.Getwd()
-	if err != nil {
-		return nil, err
-	}
-
-	path, err = filepath.Abs(filepath.Clean(filepath.Join(dir, path)))
+// LoadConfig builds the config from layers (each next one wins): the config file, rule files of the `include` dir,
+// ADVCACHE_* env variables (see EnvOverrides) and the given overrides (e.g. CLI flags). Then rule templates
+// are resolved, the result is decoded strictly (unknown fields are errors), the defaults are filled and it's validated.
+func LoadConfig(path string, overrides ...Override) (*Cache, error) {
+	path, err := filepath.Abs(filepath.Clean(path))
 	if err != nil {
 		return nil, fmt.Errorf("failed to resolve absolute config filepath: %w", err)
 	}
@@ -206,36 +450,39 @@ func LoadConfig(path string) (*Cache, error) {
 		return nil, fmt.Errorf("read config yaml file %s: %w", path, err)
 	}
 
+	l, err := newLayers(path, data)
+	if err != nil {
+		return nil, err
+	}
+	if err = l.include(); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	l.override(EnvOverrides(os.Environ()))
+	l.override(overrides)
+	l.resolveTemplates()
+	l.checkFields(l.doc.Content[0], reflect.TypeOf(Cache{}), nil, "")
+	if err = errors.Join(l.errs...); err != nil {
+		return nil, fmt.Errorf("invalid config %s:\n%w", path, err)
+	}
+
 	var cfg *Cache
-	if err = yaml.Unmarshal(data, &cfg); err != nil {
-		return nil, fmt.Errorf("unmarshal yaml from %s: %w", path, err)
+	if err = l.doc.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("invalid config %s:\n%w", path, decodeError(l.doc, err))
+	}
+
+	cfg.Path = path
+	cfg.node, cfg.sources, cfg.files, cfg.overrides = l.doc, l.sources, l.files, overrides
+	cfg.setDefaults()
+
+	if err = cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config %s:\n%w", path, err)
 	}
 
 	for rulePath, rule := range cfg.Cache.Rules {
-		rule.PathBytes = []byte(rulePath)
-
-		// Query
-		for _, query := range rule.CacheKey.Query {
-			rule.CacheKey.QueryBytes = append(rule.CacheKey.QueryBytes, []byte(query))
-		}
-
-		// Request headers
-		keyHeadersMap := make(map[string]struct{}, len(rule.CacheKey.Headers))
-		for _, header := range rule.CacheKey.Headers {
-			keyHeadersMap[header] = struct{}{}
-		}
-		rule.CacheKey.HeadersMap = keyHeadersMap
-
-		// Response headers
-		valueHeadersMap := make(map[string]struct{}, len(rule.CacheValue.Headers))
-		for _, header := range rule.CacheValue.Headers {
-			valueHeadersMap[header] = struct{}{}
-		}
-		rule.CacheValue.HeadersMap = valueHeadersMap
+		PrepareRule(rulePath, rule)
 	}
 
 	cfg.Cache.Proxy.FromUrl = []byte(cfg.Cache.Proxy.From)
-
 	cfg.Cache.LifeTime.EscapeMaxReqDurationHeaderBytes = []byte(cfg.Cache.LifeTime.EscapeMaxReqDurationHeader)
 
 	return cfg, nil
@@ -0,0 +1,407 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix is the prefix of environment variables which override config values.
+// Path segments (relative to `cache`) are separated by double underscores and matched case-insensitively,
+// e.g. ADVCACHE_PROXY__RATE=120 or ADVCACHE_ADMIN__AUTH__ALLOW_CIDRS='["10.0.0.0/8"]'. A name without
+// the separator is not an override (e.g. a secret like ADVCACHE_DUMP_KEY), a top-level key is written
+// with a leading one: ADVCACHE__ENV=prod.
+const EnvPrefix = "ADVCACHE_"
+
+const envPathSeparator = "__"
+
+// Override sets a value of the config path (relative to `cache`) on top of the config files.
+type Override struct {
+	Path   []string // e.g. []string{"proxy", "rate"}
+	Value  any      // Go value or *yaml.Node
+	Source string   // e.g. "flag -upstreamrate", used in validation errors
+}
+
+// EnvOverrides parses ADVCACHE_* variables of the given environment (os.Environ format).
+// Values are parsed as YAML, so lists and maps may be passed in the flow style.
+func EnvOverrides(environ []string) []Override {
+	var overrides []Override
+	for _, kv := range environ {
+		name, value, ok := strings.Cut(kv, "=")
+		if !ok || !strings.HasPrefix(name, EnvPrefix) || !strings.Contains(name, envPathSeparator) {
+			continue
+		}
+		// ADVCACHE__ENV: the underscore of the prefix and the one left make the separator of a top-level key
+		path := strings.TrimPrefix(strings.ToLower(name[len(EnvPrefix):]), "_")
+		if path == "" {
+			continue
+		}
+
+		node := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+		var doc yaml.Node
+		if err := yaml.Unmarshal([]byte(value), &doc); err == nil && len(doc.Content) == 1 {
+			node = doc.Content[0]
+		}
+
+		overrides = append(overrides, Override{
+			Path:   strings.Split(path, envPathSeparator),
+			Value:  node,
+			Source: "env " + name,
+		})
+	}
+	sort.Slice(overrides, func(i, j int) bool { return overrides[i].Source < overrides[j].Source })
+	return overrides
+}
+
+// layers builds the config document: the base file, then rule files of the include dir, then env variables,
+// then the given overrides, then resolves rule templates.
+type layers struct {
+	path    string
+	doc     *yaml.Node
+	cache   *yaml.Node            // value of the `cache` key
+	files   []string              // base file and include files
+	sources map[*yaml.Node]string // key node -> where the value came from if it's not the base file
+	errs    []error
+}
+
+func newLayers(path string, data []byte) (*layers, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("unmarshal yaml from %s: %w", path, err)
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("invalid config %s: document is not a mapping", path)
+	}
+	cache := mappingValue(doc.Content[0], "cache")
+	if cache == nil || cache.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("invalid config %s: section 'cache' is required", path)
+	}
+	return &layers{path: path, doc: &doc, cache: cache, files: []string{path}, sources: make(map[*yaml.Node]string)}, nil
+}
+
+func (l *layers) report(source string, line int, msg string, path ...string) {
+	l.errs = append(l.errs, &ValidationError{Path: strings.Join(path, "."), Source: source, Line: line, Msg: msg})
+}
+
+// include merges `rules` and `templates` of *.yaml files of the include dir (sorted by name).
+// An entry of an include file replaces the entry with the same name of the base file,
+// the same entry in two include files is an error.
+func (l *layers) include() error {
+	includeNode := mappingValue(l.cache, "include")
+	if includeNode == nil || includeNode.Value == "" {
+		return nil
+	}
+
+	dir := includeNode.Value
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(filepath.Dir(l.path), dir)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("read include dir: %w", err)
+	}
+
+	included := make(map[string]string) // section.name -> file
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if entry.IsDir() || (ext != ".yaml" && ext != ".yml") {
+			continue
+		}
+		file := filepath.Join(dir, entry.Name())
+		source, _ := filepath.Rel(filepath.Dir(l.path), file)
+
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("read include file: %w", err)
+		}
+		var doc yaml.Node
+		if err = yaml.Unmarshal(data, &doc); err != nil {
+			return fmt.Errorf("unmarshal yaml from %s: %w", file, err)
+		}
+		l.files = append(l.files, file)
+		if len(doc.Content) == 0 {
+			continue
+		}
+
+		top := doc.Content[0]
+		if top.Kind != yaml.MappingNode {
+			l.report(source, top.Line, "include file must be a mapping of rules and templates")
+			continue
+		}
+		for i := 0; i+1 < len(top.Content); i += 2 {
+			section, entries := top.Content[i], top.Content[i+1]
+			if section.Value != "rules" && section.Value != "templates" {
+				l.report(source, section.Line, "unknown field (only rules and templates can be included)", section.Value)
+				continue
+			}
+			if entries.Kind != yaml.MappingNode {
+				l.report(source, section.Line, "must be a mapping", section.Value)
+				continue
+			}
+
+			target := mappingValue(l.cache, section.Value)
+			if target == nil || target.Kind != yaml.MappingNode {
+				target = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+				setMappingValue(l.cache, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: section.Value}, target)
+			}
+			for j := 0; j+1 < len(entries.Content); j += 2 {
+				key, value := entries.Content[j], entries.Content[j+1]
+				id := section.Value + "." + key.Value
+				if other, ok := included[id]; ok {
+					l.report(source, key.Line, "is already defined in "+other, "cache", section.Value, key.Value)
+					continue
+				}
+				included[id] = source
+				l.sources[key] = source
+				setMappingValue(target, key, value)
+			}
+		}
+	}
+	return nil
+}
+
+// override sets the values of the overrides (env variables and flags).
+func (l *layers) override(overrides []Override) {
+	for _, o := range overrides {
+		value, ok := o.Value.(*yaml.Node)
+		if !ok {
+			value = &yaml.Node{}
+			if err := value.Encode(o.Value); err != nil {
+				l.report(o.Source, 0, err.Error(), append([]string{"cache"}, o.Path...)...)
+				continue
+			}
+		}
+		if err := l.set(l.cache, reflect.TypeOf(CacheBox{}), o.Path, value, o.Source); err != nil {
+			l.report(o.Source, 0, err.Error(), append([]string{"cache"}, o.Path...)...)
+		}
+	}
+}
+
+// set puts the value by the path into the mapping node, keys are matched case-insensitively
+// against the yaml names of typ (struct fields or existing map keys).
+func (l *layers) set(node *yaml.Node, typ reflect.Type, path []string, value *yaml.Node, source string) error {
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	if len(path) == 0 || path[0] == "" {
+		return errors.New("invalid path")
+	}
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+		*node = yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	}
+	if node.Kind != yaml.MappingNode {
+		return errors.New("is not a section")
+	}
+
+	var key string
+	var elem reflect.Type
+	switch typ.Kind() {
+	case reflect.Struct:
+		for i := 0; i < typ.NumField(); i++ {
+			if name := yamlName(typ.Field(i)); name != "" && strings.EqualFold(name, path[0]) {
+				key, elem = name, typ.Field(i).Type
+				break
+			}
+		}
+		if key == "" {
+			return errors.New("unknown field")
+		}
+	case reflect.Map:
+		key, elem = path[0], typ.Elem()
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if strings.EqualFold(node.Content[i].Value, path[0]) {
+				key = node.Content[i].Value
+				break
+			}
+		}
+	default:
+		return errors.New("is not a section")
+	}
+
+	if len(path) == 1 {
+		keyNode := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key} // no line: it's not from a file
+		l.sources[keyNode] = source
+		setMappingValue(node, keyNode, value)
+		return nil
+	}
+
+	child := mappingValue(node, key)
+	if child == nil {
+		keyNode := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}
+		child = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		l.sources[keyNode] = source
+		setMappingValue(node, keyNode, child)
+	}
+	return l.set(child, elem, path[1:], value, source)
+}
+
+// resolveTemplates replaces each rule which `extends` a template with the template merged with the rule
+// (the rule's values win, nested mappings are merged, lists are replaced). Templates may extend templates.
+func (l *layers) resolveTemplates() {
+	rules := mappingValue(l.cache, "rules")
+	if rules == nil || rules.Kind != yaml.MappingNode {
+		return
+	}
+	templates := mappingValue(l.cache, "templates")
+
+	var resolve func(node *yaml.Node, path []string, stack []string) *yaml.Node
+	resolve = func(node *yaml.Node, path []string, stack []string) *yaml.Node {
+		extends := mappingValue(node, "extends")
+		if extends == nil || extends.Value == "" {
+			return node
+		}
+		name := extends.Value
+
+		var tpl *yaml.Node
+		if templates != nil {
+			tpl = mappingValue(templates, name)
+		}
+		if tpl == nil || tpl.Kind != yaml.MappingNode {
+			l.report(l.sourceOf(path), extends.Line, fmt.Sprintf("unknown template %q", name), append(path, "extends")...)
+			return node
+		}
+		for _, seen := range stack {
+			if seen == name {
+				l.report(l.sourceOf(path), extends.Line, fmt.Sprintf("template %q extends itself", name), append(path, "extends")...)
+				return node
+			}
+		}
+		base := resolve(tpl, []string{"cache", "templates", name}, append(stack, name))
+		return mergeNodes(base, node)
+	}
+
+	for i := 0; i+1 < len(rules.Content); i += 2 {
+		if rules.Content[i+1].Kind != yaml.MappingNode {
+			continue
+		}
+		rules.Content[i+1] = resolve(rules.Content[i+1], []string{"cache", "rules", rules.Content[i].Value}, nil)
+	}
+}
+
+// sourceOf returns the source of the path's entry (include file) or an empty string for the base file.
+func (l *layers) sourceOf(path []string) string {
+	node := l.doc.Content[0]
+	source := ""
+	for _, key := range path {
+		keyNode := mappingKey(node, key)
+		if keyNode == nil {
+			break
+		}
+		if s, ok := l.sources[keyNode]; ok {
+			source = s
+		}
+		node = mappingValue(node, key)
+	}
+	return source
+}
+
+// checkFields reports keys which have no corresponding config fields (strict decoding).
+func (l *layers) checkFields(node *yaml.Node, typ reflect.Type, path []string, source string) {
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	switch {
+	case typ.Kind() == reflect.Struct && node.Kind == yaml.MappingNode:
+		fields := make(map[string]reflect.Type, typ.NumField())
+		for i := 0; i < typ.NumField(); i++ {
+			if name := yamlName(typ.Field(i)); name != "" {
+				fields[name] = typ.Field(i).Type
+			}
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			keySource := source
+			if s, ok := l.sources[key]; ok {
+				keySource = s
+			}
+			fieldType, ok := fields[key.Value]
+			if !ok {
+				l.report(keySource, key.Line, "unknown field", append(path, key.Value)...)
+				continue
+			}
+			l.checkFields(value, fieldType, append(path, key.Value), keySource)
+		}
+	case typ.Kind() == reflect.Map && node.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			keySource := source
+			if s, ok := l.sources[node.Content[i]]; ok {
+				keySource = s
+			}
+			l.checkFields(node.Content[i+1], typ.Elem(), append(path, node.Content[i].Value), keySource)
+		}
+	case typ.Kind() == reflect.Slice && node.Kind == yaml.SequenceNode:
+		for i, item := range node.Content {
+			l.checkFields(item, typ.Elem(), append(path, strconv.Itoa(i)), source)
+		}
+	}
+}
+
+// mergeNodes returns a copy of base with the keys of override set on top of it.
+func mergeNodes(base, override *yaml.Node) *yaml.Node {
+	if base.Kind != yaml.MappingNode || override.Kind != yaml.MappingNode {
+		return override
+	}
+	merged := *base
+	merged.Content = append([]*yaml.Node(nil), base.Content...)
+	for i := 0; i+1 < len(override.Content); i += 2 {
+		key, value := override.Content[i], override.Content[i+1]
+		if existing := mappingValue(&merged, key.Value); existing != nil {
+			value = mergeNodes(existing, value)
+		}
+		setMappingValue(&merged, key, value)
+	}
+	return &merged
+}
+
+// yamlName returns the yaml key of a struct field or an empty string if the field is not decoded.
+func yamlName(field reflect.StructField) string {
+	if !field.IsExported() {
+		return ""
+	}
+	name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		return strings.ToLower(field.Name)
+	}
+	return name
+}
+
+// mappingKey returns the key node of key in a mapping node or nil.
+func mappingKey(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i]
+		}
+	}
+	return nil
+}
+
+// mappingValue returns the value node of key in a mapping node or nil.
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// setMappingValue replaces the value of the key (and the key node itself) or appends the pair.
+func setMappingValue(mapping *yaml.Node, key, value *yaml.Node) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key.Value {
+			mapping.Content[i], mapping.Content[i+1] = key, value
+			return
+		}
+	}
+	mapping.Content = append(mapping.Content, key, value)
+}
@@ -0,0 +1,136 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const layersBaseConfig = `cache:
+  proxy:
+    from: "http://localhost:8080"
+    rate: 10
+  include: "rules.d"
+  templates:
+    base:
+      refresh:
+        enabled: true
+        ttl: "1h"
+        beta: 0.4
+      cache_value:
+        headers:
+          - Content-Type
+          - Content-Encoding
+  rules:
+    /api/v1/page:
+      extends: base
+      refresh:
+        ttl: "20m"
+    /api/v1/shadowed:
+      cache_key:
+        query:
+          - from-base
+`
+
+const layersIncludeFile = `rules:
+  /api/v1/included:
+    extends: base
+    cache_key:
+      query:
+        - user
+  /api/v1/shadowed:
+    cache_key:
+      query:
+        - from-include
+`
+
+func writeLayers(t *testing.T, base string, includes map[string]string) string {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "rules.d"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range includes {
+		if err := os.WriteFile(filepath.Join(dir, "rules.d", name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(dir, "cfg.yaml")
+	if err := os.WriteFile(path, []byte(base), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigLayers(t *testing.T) {
+	path := writeLayers(t, layersBaseConfig, map[string]string{"a.yaml": layersIncludeFile})
+	t.Setenv("ADVCACHE_PROXY__RATE", "20")
+	t.Setenv("ADVCACHE_FORCEGC__INTERVAL", "3s")
+	t.Setenv("ADVCACHE__ENV", "prod")
+	t.Setenv("ADVCACHE_DUMP_KEY", "secret") // not an override, it has no path separator
+
+	cfg, err := LoadConfig(path, Override{Path: []string{"proxy", "rate"}, Value: 30, Source: "flag -upstreamrate"})
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+
+	if cfg.Cache.Proxy.Rate != 30 {
+		t.Errorf("proxy.rate = %d, flag must win over env", cfg.Cache.Proxy.Rate)
+	}
+	if cfg.Cache.ForceGC.Interval != 3*time.Second {
+		t.Errorf("forceGC.interval = %s, want 3s from env", cfg.Cache.ForceGC.Interval)
+	}
+	if cfg.Cache.Env != "prod" {
+		t.Errorf("env = %q, want prod from env", cfg.Cache.Env)
+	}
+
+	page := cfg.Rule("/api/v1/page")
+	if page == nil || page.Refresh == nil || page.Refresh.TTL != 20*time.Minute || page.Refresh.Beta != 0.4 || !page.Refresh.Enabled {
+		t.Fatalf("template is not merged into /api/v1/page: %+v", page)
+	}
+	if !reflect.DeepEqual(page.CacheValue.Headers, []string{"Content-Type", "Content-Encoding"}) {
+		t.Errorf("cache_value.headers = %v", page.CacheValue.Headers)
+	}
+
+	included := cfg.Rule("/api/v1/included")
+	if included == nil || included.Refresh == nil || included.Refresh.TTL != time.Hour {
+		t.Fatalf("included rule is not loaded or not merged with template: %+v", included)
+	}
+	if source := cfg.RuleSource("/api/v1/included"); source != filepath.Join("rules.d", "a.yaml") {
+		t.Errorf("RuleSource = %q", source)
+	}
+	if source := cfg.RuleSource("/api/v1/page"); source != "" {
+		t.Errorf("RuleSource of a base rule = %q", source)
+	}
+
+	if q := cfg.Rule("/api/v1/shadowed").CacheKey.Query; !reflect.DeepEqual(q, []string{"from-include"}) {
+		t.Errorf("include must override the base file, got %v", q)
+	}
+}
+
+func TestLoadConfigLayersErrors(t *testing.T) {
+	path := writeLayers(t, layersBaseConfig+`    /api/v1/broken:
+      extends: missing
+`, map[string]string{
+		"a.yaml": layersIncludeFile,
+		"b.yaml": "rules:\n  /api/v1/included:\n    gzip:\n      enabld: true\n",
+	})
+	t.Setenv("ADVCACHE_PROXY__NOPE", "1")
+
+	want := map[string]string{
+		"cache.rules./api/v1/broken.extends": "",
+		"cache.rules./api/v1/included":       filepath.Join("rules.d", "b.yaml"),
+		"cache.proxy.nope":                   "env ADVCACHE_PROXY__NOPE",
+	}
+	_, err := LoadConfig(path)
+	errs := validationErrors(err)
+	if len(errs) != len(want) {
+		t.Fatalf("unexpected errors: %v", err)
+	}
+	for _, e := range errs {
+		if source, ok := want[e.Path]; !ok || source != e.Source {
+			t.Errorf("unexpected error %v", e)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package config
+
+import (
+	"github.com/rs/zerolog"
+)
+
+// RefreshPolicy returns the live global refresh settings. It's safe for concurrent use with SetRefreshPolicy.
+func (c *Cache) RefreshPolicy() *Refresh {
+	if refresh := c.Cache.refresh.Load(); refresh != nil {
+		return refresh
+	}
+	return c.Cache.Refresh
+}
+
+// SetRefreshPolicy atomically replaces the live global refresh settings.
+func (c *Cache) SetRefreshPolicy(refresh *Refresh) {
+	c.Cache.refresh.Store(refresh)
+}
+
+// MemoryThreshold returns the number of bytes after which eviction starts (storage.size * eviction.threshold).
+func (c *Cache) MemoryThreshold() int64 {
+	return int64(float64(c.Cache.Storage.Size) * c.Cache.Eviction.Threshold)
+}
+
+// LogLevel returns the configured zerolog level, info is used for empty or unknown values.
+func (c *Cache) LogLevel() zerolog.Level {
+	level, err := zerolog.ParseLevel(c.Cache.Logs.Level)
+	if err != nil || c.Cache.Logs.Level == "" {
+		return zerolog.InfoLevel
+	}
+	return level
+}
@@ -0,0 +1,125 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/zeebo/xxh3"
+	"gopkg.in/yaml.v3"
+)
+
+// Rules returns the live rule set. It's safe for concurrent use with SetRules.
+// The returned map must be treated as read-only, use SetRules to change the rule set.
+func (c *Cache) Rules() map[string]*Rule {
+	if rules := c.Cache.rules.Load(); rules != nil {
+		return *rules
+	}
+	return c.Cache.Rules
+}
+
+// Rule returns the live rule for the given path or nil.
+func (c *Cache) Rule(path string) *Rule {
+	return c.Rules()[path]
+}
+
+// RuleSource returns the include file the rule of the loaded config came from, empty for the config file itself.
+func (c *Cache) RuleSource(path string) string {
+	source, _ := c.locate("cache", "rules", path)
+	return source
+}
+
+// RulesHash returns a hash of the live rule set, it changes whenever any rule does.
+func (c *Cache) RulesHash() uint64 {
+	data, err := yaml.Marshal(c.Rules())
+	if err != nil {
+		return 0
+	}
+	return xxh3.Hash(data)
+}
+
+// KeyHash returns a hash of the cache key definition of the rule (key queries and headers, in any order).
+// Entries keyed by a rule with another hash can't be hit by requests, see storage re-keying on load.
+func (r *Rule) KeyHash() uint64 {
+	query := slices.Sorted(slices.Values(r.CacheKey.Query))
+	headers := slices.Sorted(slices.Values(r.CacheKey.Headers))
+	return xxh3.HashString(strings.Join(query, "\x00") + "\x01" + strings.Join(headers, "\x00"))
+}
+
+// SetRules atomically replaces the live rule set. Rules must be prepared (see PrepareRule).
+func (c *Cache) SetRules(rules map[string]*Rule) {
+	c.Cache.rules.Store(&rules)
+}
+
+// PrepareRule fills the virtual fields of a rule which are used on the hot path.
+func PrepareRule(path string, rule *Rule) *Rule {
+	rule.PathBytes = []byte(path)
+
+	// Query
+	rule.CacheKey.QueryBytes = make([][]byte, 0, len(rule.CacheKey.Query))
+	for _, query := range rule.CacheKey.Query {
+		rule.CacheKey.QueryBytes = append(rule.CacheKey.QueryBytes, []byte(query))
+	}
+
+	// Request headers
+	keyHeadersMap := make(map[string]struct{}, len(rule.CacheKey.Headers))
+	for _, header := range rule.CacheKey.Headers {
+		keyHeadersMap[header] = struct{}{}
+	}
+	rule.CacheKey.HeadersMap = keyHeadersMap
+
+	// Response headers
+	valueHeadersMap := make(map[string]struct{}, len(rule.CacheValue.Headers))
+	for _, header := range rule.CacheValue.Headers {
+		valueHeadersMap[header] = struct{}{}
+	}
+	rule.CacheValue.HeadersMap = valueHeadersMap
+
+	return rule
+}
+
+// Validate checks a rule which is going to be served under the given path.
+func (r *Rule) Validate(path string) error {
+	var errs []error
+	r.check(path, func(msg string, field ...string) {
+		if len(field) == 0 {
+			errs = append(errs, fmt.Errorf("rule %q: %s", path, msg))
+		} else {
+			errs = append(errs, fmt.Errorf("rule %q: %s %s", path, strings.Join(field, "."), msg))
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// check reports each problem of the rule with the path of the field (relative to the rule).
+func (r *Rule) check(path string, report func(msg string, field ...string)) {
+	if !strings.HasPrefix(path, "/") {
+		report("path must start with '/'")
+	}
+	if r.Gzip.Threshold < 0 {
+		report("must not be negative", "gzip", "threshold")
+	}
+	for i, q := range r.CacheKey.Query {
+		if q == "" {
+			report("must not be empty", "cache_key", "query", strconv.Itoa(i))
+		}
+	}
+	for i, h := range r.CacheKey.Headers {
+		if h == "" {
+			report("must not be empty", "cache_key", "headers", strconv.Itoa(i))
+		}
+	}
+	if r.Refresh != nil {
+		if r.Refresh.TTL < 0 {
+			report("must not be negative", "refresh", "ttl")
+		}
+		if r.Refresh.Beta < 0 || r.Refresh.Beta > 1 {
+			report("must be within [0, 1]", "refresh", "beta")
+		}
+		if r.Refresh.Coefficient < 0 || r.Refresh.Coefficient > 1 {
+			report("must be within [0, 1]", "refresh", "coefficient")
+		}
+	}
+}
@@ -0,0 +1,604 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	sharded "github.com/Borislavv/advanced-cache/pkg/storage/map"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// DefaultEvictionThreshold is used when eviction.threshold is not configured.
+	DefaultEvictionThreshold = 0.9
+	// DefaultDumpBlockSize is used when dump.block_size is not configured.
+	DefaultDumpBlockSize = 1 << 20
+	// DefaultDumpMinInterval is used when dump.schedule.min_interval is not configured.
+	DefaultDumpMinInterval = time.Minute
+	// DefaultWALSyncInterval, DefaultWALBatchSize, DefaultWALBuffer and DefaultWALSegmentSize are used
+	// when the persistence.wal values are not configured.
+	DefaultWALSyncInterval = time.Second
+	DefaultWALBatchSize    = 1024
+	DefaultWALBuffer       = 64 * 1024
+	DefaultWALSegmentSize  = 64 << 20
+	// DefaultRestoreRefreshRate is used when dump.restore.refresh_rate is not configured.
+	DefaultRestoreRefreshRate = 100
+	// DefaultPeerTimeout is used when persistence.peer.timeout is not configured.
+	DefaultPeerTimeout = 5 * time.Minute
+	// DefaultWarmupRate, DefaultWarmupWorkers and DefaultWarmupTimeout are used when the persistence.warmup
+	// values are not configured.
+	DefaultWarmupRate    = 50
+	DefaultWarmupWorkers = 4
+	DefaultWarmupTimeout = 5 * time.Minute
+	// DefaultClusterWatchInterval, DefaultClusterVirtualNodes, DefaultClusterTimeout, DefaultClusterBackoff,
+	// DefaultHotCopySize and DefaultHotCopyTTL are used when the cluster values are not configured.
+	DefaultClusterWatchInterval = 5 * time.Second
+	DefaultClusterVirtualNodes  = 128
+	DefaultClusterTimeout       = 2 * time.Second
+	DefaultClusterBackoff       = 5 * time.Second
+	DefaultHotCopySize          = 1000
+	DefaultHotCopyTTL           = 10 * time.Second
+	// DefaultBroadcastRetries, DefaultBroadcastRetryInterval and DefaultBroadcastHistory are used
+	// when the cluster.broadcast values are not configured.
+	DefaultBroadcastRetries       = 10
+	DefaultBroadcastRetryInterval = time.Second
+	DefaultBroadcastHistory       = 100
+	// DefaultNumOfShards is the only supported preallocate.num_shards value (sharded.NumOfShards without the collisions shard).
+	DefaultNumOfShards = int(sharded.NumOfShards) - 1
+)
+
+// ValidationError describes a single problem of a config file.
+type ValidationError struct {
+	Path   string // YAML path, e.g. "cache.eviction.threshold".
+	Source string // Include file, env variable or flag the value came from, empty for the config file itself.
+	Line   int    // Line in the file, 0 if unknown.
+	Msg    string
+}
+
+func (e *ValidationError) Error() string {
+	switch {
+	case e.Source != "" && e.Line > 0:
+		return fmt.Sprintf("%s (%s:%d): %s", e.Path, e.Source, e.Line, e.Msg)
+	case e.Source != "":
+		return fmt.Sprintf("%s (%s): %s", e.Path, e.Source, e.Msg)
+	case e.Line > 0:
+		return fmt.Sprintf("%s (line %d): %s", e.Path, e.Line, e.Msg)
+	default:
+		return fmt.Sprintf("%s: %s", e.Path, e.Msg)
+	}
+}
+
+// setDefaults fills the optional sections and values which were not configured.
+func (c *Cache) setDefaults() {
+	box := c.Cache
+	if box.Runtime == nil {
+		box.Runtime = &Runtime{}
+	}
+	if box.Persistence == nil {
+		box.Persistence = &Persistence{}
+	}
+	if box.Persistence.Dump == nil {
+		box.Persistence.Dump = &Dump{}
+	}
+	if box.Persistence.Dump.Format == "" {
+		box.Persistence.Dump.Format = DumpFormatSnapshot
+	}
+	if box.Persistence.Dump.Codec == "" {
+		box.Persistence.Dump.Codec = DumpCodecNone
+		if box.Persistence.Dump.Gzip {
+			box.Persistence.Dump.Codec = DumpCodecGzip
+		}
+	}
+	box.Persistence.Dump.Gzip = box.Persistence.Dump.Codec == DumpCodecGzip
+	if box.Persistence.Dump.Segments == 0 {
+		box.Persistence.Dump.Segments = 1
+	}
+	if box.Persistence.Dump.BlockSize == 0 {
+		box.Persistence.Dump.BlockSize = DefaultDumpBlockSize
+	}
+	if box.Persistence.Dump.Schedule.MinInterval == 0 {
+		box.Persistence.Dump.Schedule.MinInterval = DefaultDumpMinInterval
+	}
+	if box.Persistence.Dump.Schedule.Workers == 0 {
+		box.Persistence.Dump.Schedule.Workers = 1
+	}
+	if box.Persistence.Dump.Restore.Expired == "" {
+		box.Persistence.Dump.Restore.Expired = RestoreExpiredStale
+	}
+	if box.Persistence.Dump.Restore.RefreshRate == 0 {
+		box.Persistence.Dump.Restore.RefreshRate = DefaultRestoreRefreshRate
+	}
+	if box.Persistence.WAL == nil {
+		box.Persistence.WAL = &WAL{}
+	}
+	if wal := box.Persistence.WAL; wal.Dir == "" && box.Persistence.Dump.Dir != "" {
+		wal.Dir = filepath.Join(box.Persistence.Dump.Dir, "wal")
+	}
+	if box.Persistence.WAL.Fsync == "" {
+		box.Persistence.WAL.Fsync = WALFsyncBatch
+	}
+	if box.Persistence.WAL.SyncInterval == 0 {
+		box.Persistence.WAL.SyncInterval = DefaultWALSyncInterval
+	}
+	if box.Persistence.WAL.BatchSize == 0 {
+		box.Persistence.WAL.BatchSize = DefaultWALBatchSize
+	}
+	if box.Persistence.WAL.Buffer == 0 {
+		box.Persistence.WAL.Buffer = DefaultWALBuffer
+	}
+	if box.Persistence.WAL.SegmentSize == 0 {
+		box.Persistence.WAL.SegmentSize = DefaultWALSegmentSize
+	}
+	if box.Persistence.Peer == nil {
+		box.Persistence.Peer = &Peer{}
+	}
+	if box.Persistence.Peer.Timeout == 0 {
+		box.Persistence.Peer.Timeout = DefaultPeerTimeout
+	}
+	if box.Persistence.Mock == nil {
+		box.Persistence.Mock = &Mock{}
+	}
+	if box.Persistence.Warmup == nil {
+		box.Persistence.Warmup = &Warmup{}
+	}
+	if box.Persistence.Warmup.Rate == 0 {
+		box.Persistence.Warmup.Rate = DefaultWarmupRate
+	}
+	if box.Persistence.Warmup.Workers == 0 {
+		box.Persistence.Warmup.Workers = DefaultWarmupWorkers
+	}
+	if box.Persistence.Warmup.Timeout == 0 {
+		box.Persistence.Warmup.Timeout = DefaultWarmupTimeout
+	}
+	if box.Cluster == nil {
+		box.Cluster = &Cluster{}
+	}
+	if box.Cluster.Mode == "" {
+		box.Cluster.Mode = ClusterModeSharded
+	}
+	if box.Cluster.Broadcast.Retries == 0 {
+		box.Cluster.Broadcast.Retries = DefaultBroadcastRetries
+	}
+	if box.Cluster.Broadcast.RetryInterval == 0 {
+		box.Cluster.Broadcast.RetryInterval = DefaultBroadcastRetryInterval
+	}
+	if box.Cluster.Broadcast.History == 0 {
+		box.Cluster.Broadcast.History = DefaultBroadcastHistory
+	}
+	if box.Cluster.WatchInterval == 0 {
+		box.Cluster.WatchInterval = DefaultClusterWatchInterval
+	}
+	if box.Cluster.VirtualNodes == 0 {
+		box.Cluster.VirtualNodes = DefaultClusterVirtualNodes
+	}
+	if box.Cluster.Timeout == 0 {
+		box.Cluster.Timeout = DefaultClusterTimeout
+	}
+	if box.Cluster.Backoff == 0 {
+		box.Cluster.Backoff = DefaultClusterBackoff
+	}
+	if box.Cluster.HotCopy.Size == 0 {
+		box.Cluster.HotCopy.Size = DefaultHotCopySize
+	}
+	if box.Cluster.HotCopy.TTL == 0 {
+		box.Cluster.HotCopy.TTL = DefaultHotCopyTTL
+	}
+	if box.Refresh == nil {
+		box.Refresh = &Refresh{}
+	}
+	if box.Eviction == nil {
+		box.Eviction = &Eviction{}
+	}
+	if box.Eviction.Threshold == 0 {
+		box.Eviction.Threshold = DefaultEvictionThreshold
+	}
+	if box.Storage == nil {
+		box.Storage = &Storage{}
+	}
+	if box.Preallocate.Shards == 0 {
+		box.Preallocate.Shards = DefaultNumOfShards
+	}
+	if box.Admin.Addr == "" {
+		box.Admin.Addr = DefaultAdminAddr
+	}
+	if box.Admin.Rules.HistoryDir == "" {
+		box.Admin.Rules.HistoryDir = DefaultRulesHistoryDir
+	}
+	if box.Admin.Rules.MaxVersions <= 0 {
+		box.Admin.Rules.MaxVersions = DefaultRulesMaxVersions
+	}
+	if box.Admin.Name == "" && box.Proxy != nil {
+		box.Admin.Name = box.Proxy.Name + ".admin"
+	}
+}
+
+// Validate checks the semantic of the config. All found problems are returned joined,
+// each one is a *ValidationError with the YAML path and (if the config was loaded from a file) the line.
+func (c *Cache) Validate() error {
+	var errs []error
+	report := func(msg string, path ...string) {
+		path = append([]string{"cache"}, path...)
+		source, line := c.locate(path...)
+		errs = append(errs, &ValidationError{Path: strings.Join(path, "."), Source: source, Line: line, Msg: msg})
+	}
+
+	box := c.Cache
+	if box.Proxy == nil {
+		report("section is required", "proxy")
+	} else {
+		if box.Proxy.From == "" {
+			report("is required", "proxy", "from")
+		}
+		if box.Proxy.Rate <= 0 {
+			report("must be positive", "proxy", "rate")
+		}
+		if box.Proxy.Timeout < 0 {
+			report("must not be negative", "proxy", "timeout")
+		}
+	}
+
+	if box.Enabled && box.Storage.Size == 0 {
+		report("must be positive when the cache is enabled", "storage", "size")
+	}
+	if box.Eviction.Threshold < 0 || box.Eviction.Threshold > 1 {
+		report("must be within [0, 1]", "eviction", "threshold")
+	}
+	if box.Preallocate.Shards != DefaultNumOfShards {
+		report(fmt.Sprintf("must be %d (see sharded.NumOfShards)", DefaultNumOfShards), "preallocate", "num_shards")
+	}
+	if box.Preallocate.PerShard < 0 {
+		report("must not be negative", "preallocate", "per_shard")
+	}
+
+	if refresh := box.Refresh; refresh.Enabled {
+		if refresh.TTL <= 0 {
+			report("must be positive when refresh is enabled", "refresh", "ttl")
+		}
+		if refresh.Rate <= 0 {
+			report("must be positive when refresh is enabled", "refresh", "rate")
+		}
+		if refresh.ScanRate <= 0 {
+			report("must be positive when refresh is enabled", "refresh", "scan_rate")
+		}
+	}
+	if box.Refresh.Beta < 0 || box.Refresh.Beta > 1 {
+		report("must be within [0, 1]", "refresh", "beta")
+	}
+	if box.Refresh.Coefficient < 0 || box.Refresh.Coefficient > 1 {
+		report("must be within [0, 1]", "refresh", "coefficient")
+	}
+
+	if dump := box.Persistence.Dump; dump.IsEnabled {
+		if dump.Dir == "" {
+			report("is required when dump is enabled", "persistence", "dump", "dump_dir")
+		}
+		if dump.Name == "" {
+			report("is required when dump is enabled", "persistence", "dump", "dump_name")
+		}
+	}
+	if dump := box.Persistence.Dump; dump.Format != DumpFormatSnapshot && dump.Format != DumpFormatLegacy {
+		report(fmt.Sprintf("must be %q or %q", DumpFormatSnapshot, DumpFormatLegacy), "persistence", "dump", "format")
+	}
+	switch dump := box.Persistence.Dump; dump.Codec {
+	case DumpCodecNone, DumpCodecGzip:
+	case DumpCodecZstd:
+		if dump.Format == DumpFormatLegacy {
+			report("zstd is supported by the snapshot format only", "persistence", "dump", "codec")
+		}
+	default:
+		report(fmt.Sprintf("must be %q, %q or %q", DumpCodecNone, DumpCodecGzip, DumpCodecZstd), "persistence", "dump", "codec")
+	}
+	if enc := box.Persistence.Dump.Encryption; enc.Enabled {
+		if len(enc.Keys) == 0 {
+			report("at least one key is required when encryption is enabled", "persistence", "dump", "encryption", "keys")
+		}
+		if box.Persistence.Dump.Format == DumpFormatLegacy {
+			report("encryption is supported by the snapshot format only", "persistence", "dump", "encryption", "enabled")
+		}
+		ids := make(map[string]struct{}, len(enc.Keys))
+		for i, key := range enc.Keys {
+			idx := strconv.Itoa(i)
+			if key.ID == "" {
+				report("is required", "persistence", "dump", "encryption", "keys", idx, "id")
+			} else if _, ok := ids[key.ID]; ok {
+				report("must be unique", "persistence", "dump", "encryption", "keys", idx, "id")
+			}
+			ids[key.ID] = struct{}{}
+			if key.File == "" && key.Env == "" {
+				report("file or env is required", "persistence", "dump", "encryption", "keys", idx)
+			}
+		}
+	}
+	if dump := box.Persistence.Dump; dump.Mmap {
+		switch {
+		case dump.Format == DumpFormatLegacy:
+			report("mmap is supported by the snapshot format only", "persistence", "dump", "mmap")
+		case dump.Codec != DumpCodecNone:
+			report(fmt.Sprintf("mmap requires codec %q (compressed blocks can't be mapped)", DumpCodecNone), "persistence", "dump", "mmap")
+		case dump.Encryption.Enabled:
+			report("mmap requires encryption to be disabled (encrypted blocks can't be mapped)", "persistence", "dump", "mmap")
+		}
+	}
+	if restore := box.Persistence.Dump.Restore; restore.Expired != RestoreExpiredStale && restore.Expired != RestoreExpiredDrop {
+		report(fmt.Sprintf("must be %q or %q", RestoreExpiredStale, RestoreExpiredDrop), "persistence", "dump", "restore", "expired")
+	}
+	if dump := box.Persistence.Dump; dump.MaxVersions < 0 || dump.MaxAge < 0 || dump.MaxSize < 0 {
+		report("retention limits must not be negative", "persistence", "dump")
+	}
+	if dump := box.Persistence.Dump; dump.Level < 0 || dump.Level > 22 {
+		report("must be within [0, 22]", "persistence", "dump", "level")
+	}
+	if dump := box.Persistence.Dump; dump.Segments < 1 || dump.Segments > DefaultNumOfShards {
+		report(fmt.Sprintf("must be within [1, %d]", DefaultNumOfShards), "persistence", "dump", "segments")
+	}
+	if box.Persistence.Dump.BlockSize < 0 {
+		report("must not be negative", "persistence", "dump", "block_size")
+	}
+	if schedule := box.Persistence.Dump.Schedule; schedule.Enabled {
+		if schedule.Interval <= 0 && schedule.Changes <= 0 {
+			report("interval or changes is required when the schedule is enabled", "persistence", "dump", "schedule")
+		}
+		if !box.Persistence.Dump.IsEnabled {
+			report("requires persistence.dump.enabled", "persistence", "dump", "schedule", "enabled")
+		}
+	}
+	schedule := box.Persistence.Dump.Schedule
+	for _, field := range []struct {
+		name  string
+		value int64
+	}{
+		{"interval", int64(schedule.Interval)},
+		{"changes", schedule.Changes},
+		{"min_interval", int64(schedule.MinInterval)},
+		{"rate", int64(schedule.Rate)},
+		{"bytes_rate", int64(schedule.BytesRate)},
+		{"workers", int64(schedule.Workers)},
+	} {
+		if field.value < 0 {
+			report("must not be negative", "persistence", "dump", "schedule", field.name)
+		}
+	}
+	if wal := box.Persistence.WAL; wal.Enabled {
+		if !box.Persistence.Dump.IsEnabled {
+			report("requires persistence.dump.enabled", "persistence", "wal", "enabled")
+		}
+		if wal.Dir == "" {
+			report("is required when wal is enabled", "persistence", "wal", "dir")
+		}
+		if box.Persistence.Dump.Encryption.Enabled {
+			report("is not supported with persistence.dump.encryption (the wal is written in plain text)", "persistence", "wal", "enabled")
+		}
+	}
+	if wal := box.Persistence.WAL; wal.Fsync != WALFsyncBatch && wal.Fsync != WALFsyncInterval && wal.Fsync != WALFsyncNone {
+		report(fmt.Sprintf("must be %q, %q or %q", WALFsyncBatch, WALFsyncInterval, WALFsyncNone), "persistence", "wal", "fsync")
+	}
+	wal := box.Persistence.WAL
+	for _, field := range []struct {
+		name  string
+		value int64
+	}{
+		{"sync_interval", int64(wal.SyncInterval)},
+		{"batch_size", int64(wal.BatchSize)},
+		{"buffer", int64(wal.Buffer)},
+		{"segment_size", wal.SegmentSize},
+	} {
+		if field.value <= 0 {
+			report("must be positive", "persistence", "wal", field.name)
+		}
+	}
+	if peer := box.Persistence.Peer; peer.URL != "" {
+		if u, err := url.Parse(peer.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			report("must be an http(s) address, e.g. http://cache-0.cache:8021", "persistence", "peer", "url")
+		}
+	}
+	if box.Persistence.Peer.Timeout < 0 {
+		report("must not be negative", "persistence", "peer", "timeout")
+	}
+	if box.Persistence.Mock.Length < 0 {
+		report("must not be negative", "persistence", "mock", "length")
+	}
+	warmup := box.Persistence.Warmup
+	if warmup.Rate < 0 {
+		report("must not be negative", "persistence", "warmup", "rate")
+	}
+	if warmup.Workers < 0 {
+		report("must not be negative", "persistence", "warmup", "workers")
+	}
+	if warmup.Timeout < 0 {
+		report("must not be negative", "persistence", "warmup", "timeout")
+	}
+	for i, src := range warmup.Sources {
+		idx := strconv.Itoa(i)
+		if src.File == "" {
+			report("is required", "persistence", "warmup", "sources", idx, "file")
+		}
+		switch src.Format {
+		case "", WarmupFormatText, WarmupFormatJSONL, WarmupFormatSitemap:
+		default:
+			report(fmt.Sprintf("must be %q, %q or %q", WarmupFormatText, WarmupFormatJSONL, WarmupFormatSitemap), "persistence", "warmup", "sources", idx, "format")
+		}
+	}
+
+	if cluster := box.Cluster; cluster.Enabled {
+		isAddr := func(addr string) bool {
+			u, err := url.Parse(addr)
+			return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+		}
+		if !isAddr(cluster.Self) {
+			report("must be the http(s) admin address of the instance, e.g. http://cache-0.cache:8021", "cluster", "self")
+		}
+		if cluster.Mode != ClusterModeSharded && cluster.Mode != ClusterModeReplicated {
+			report(fmt.Sprintf("must be %q or %q", ClusterModeSharded, ClusterModeReplicated), "cluster", "mode")
+		}
+		if len(cluster.Peers) == 0 && cluster.PeersFile == "" {
+			report("peers or peers_file is required", "cluster")
+		}
+		selfListed := cluster.PeersFile != "" // the file is checked on load
+		for i, peer := range cluster.Peers {
+			if !isAddr(peer) {
+				report("must be an http(s) address", "cluster", "peers", strconv.Itoa(i))
+			}
+			selfListed = selfListed || strings.TrimSuffix(peer, "/") == strings.TrimSuffix(cluster.Self, "/")
+		}
+		if !selfListed {
+			report("must contain self", "cluster", "peers")
+		}
+		for _, field := range []struct {
+			name  string
+			value int64
+		}{
+			{"watch_interval", int64(cluster.WatchInterval)},
+			{"virtual_nodes", int64(cluster.VirtualNodes)},
+			{"timeout", int64(cluster.Timeout)},
+			{"backoff", int64(cluster.Backoff)},
+		} {
+			if field.value <= 0 {
+				report("must be positive", "cluster", field.name)
+			}
+		}
+		for _, field := range []struct {
+			name  string
+			value int64
+		}{
+			{"retries", int64(cluster.Broadcast.Retries)},
+			{"retry_interval", int64(cluster.Broadcast.RetryInterval)},
+			{"history", int64(cluster.Broadcast.History)},
+		} {
+			if field.value < 0 {
+				report("must not be negative", "cluster", "broadcast", field.name)
+			}
+		}
+		if cluster.HotCopy.Size < 0 {
+			report("must not be negative", "cluster", "hot_copy", "size")
+		}
+		if cluster.HotCopy.TTL < 0 {
+			report("must not be negative", "cluster", "hot_copy", "ttl")
+		}
+	}
+
+	for i, cidr := range box.Admin.Auth.AllowCIDRs {
+		// a bare IP is a single host network (see auth.NewAllowlist)
+		if cidr = strings.TrimSpace(cidr); strings.Contains(cidr, "/") {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				report(fmt.Sprintf("invalid CIDR %q", cidr), "admin", "auth", "allow_cidrs", strconv.Itoa(i))
+			}
+		} else if net.ParseIP(cidr) == nil {
+			report(fmt.Sprintf("invalid IP %q", cidr), "admin", "auth", "allow_cidrs", strconv.Itoa(i))
+		}
+	}
+	if box.Reload.Interval < 0 {
+		report("must not be negative", "reload", "interval")
+	}
+
+	for path, rule := range box.Rules {
+		rule.check(path, func(msg string, field ...string) {
+			report(msg, append([]string{"rules", path}, field...)...)
+		})
+	}
+
+	return errors.Join(errs...)
+}
+
+// locate returns the source and the line of the deepest existing key of the path, 0 if unknown.
+func (c *Cache) locate(path ...string) (source string, line int) {
+	if c.node == nil || len(c.node.Content) == 0 {
+		return "", 0
+	}
+
+	node := c.node.Content[0]
+	for _, key := range path {
+		var next *yaml.Node
+		switch node.Kind {
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == key {
+					line, next = node.Content[i].Line, node.Content[i+1]
+					if s, ok := c.sources[node.Content[i]]; ok {
+						source = s
+					}
+					break
+				}
+			}
+		case yaml.SequenceNode:
+			if i, err := strconv.Atoi(key); err == nil && i >= 0 && i < len(node.Content) {
+				line, next = node.Content[i].Line, node.Content[i]
+			}
+		}
+		if next == nil {
+			break
+		}
+		node = next
+	}
+	return source, line
+}
+
+var decodeErrorRe = regexp.MustCompile(`^line (\d+): (?:field (\S+) not found in type \S+|(.*))$`)
+
+// decodeError converts errors of strict decoding into ValidationErrors.
+func decodeError(root *yaml.Node, err error) error {
+	var typeErr *yaml.TypeError
+	if !errors.As(err, &typeErr) {
+		return err
+	}
+
+	errs := make([]error, 0, len(typeErr.Errors))
+	for _, msg := range typeErr.Errors {
+		m := decodeErrorRe.FindStringSubmatch(msg)
+		if m == nil {
+			errs = append(errs, errors.New(msg))
+			continue
+		}
+		line, _ := strconv.Atoi(m[1])
+		field, path := m[2], "cache"
+		if found := pathAtLine(root, line, field, nil); found != nil {
+			path = strings.Join(found, ".")
+		}
+		if field != "" {
+			errs = append(errs, &ValidationError{Path: path, Line: line, Msg: "unknown field"})
+		} else {
+			errs = append(errs, &ValidationError{Path: path, Line: line, Msg: m[3]})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// pathAtLine returns the path of the first key (preferably named as field) or sequence item on the given line.
+func pathAtLine(node *yaml.Node, line int, field string, path []string) []string {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			if found := pathAtLine(child, line, field, path); found != nil {
+				return found
+			}
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			keyPath := append(append([]string{}, path...), key.Value)
+			if key.Line == line && (field == "" || key.Value == field) {
+				return keyPath
+			}
+			if found := pathAtLine(value, line, field, keyPath); found != nil {
+				return found
+			}
+		}
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			itemPath := append(append([]string{}, path...), strconv.Itoa(i))
+			if item.Line == line && item.Kind == yaml.ScalarNode && field == "" {
+				return itemPath
+			}
+			if found := pathAtLine(item, line, field, itemPath); found != nil {
+				return found
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func loadTestConfig(t *testing.T, data string) (*Cache, error) {
+	path := filepath.Join(t.TempDir(), "cfg.yaml")
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return LoadConfig(path)
+}
+
+func validationErrors(err error) []*ValidationError {
+	var result []*ValidationError
+	var joined interface{ Unwrap() []error }
+	if errors.As(err, &joined) {
+		for _, e := range joined.Unwrap() {
+			result = append(result, validationErrors(e)...)
+		}
+		return result
+	}
+	var vErr *ValidationError
+	if errors.As(err, &vErr) {
+		result = append(result, vErr)
+	}
+	return result
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	cfg, err := loadTestConfig(t, `cache:
+  proxy:
+    from: "http://localhost:8080"
+    rate: 10
+`)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if cfg.Cache.Refresh == nil || cfg.Cache.Persistence.Dump == nil || cfg.Cache.Persistence.Mock == nil || cfg.Cache.Runtime == nil {
+		t.Fatal("optional sections must be defaulted")
+	}
+	if cfg.Cache.Eviction.Threshold != DefaultEvictionThreshold {
+		t.Fatalf("eviction.threshold = %v, want %v", cfg.Cache.Eviction.Threshold, DefaultEvictionThreshold)
+	}
+	if cfg.Cache.Preallocate.Shards != DefaultNumOfShards {
+		t.Fatalf("preallocate.num_shards = %d, want %d", cfg.Cache.Preallocate.Shards, DefaultNumOfShards)
+	}
+}
+
+func TestLoadConfigUnknownField(t *testing.T) {
+	_, err := loadTestConfig(t, `cache:
+  proxy:
+    from: "http://localhost:8080"
+    rate: 10
+    timout: "5s"
+`)
+	errs := validationErrors(err)
+	if len(errs) != 1 || errs[0].Path != "cache.proxy.timout" || errs[0].Line != 5 {
+		t.Fatalf("unexpected errors: %v", err)
+	}
+}
+
+func TestLoadConfigSemanticErrors(t *testing.T) {
+	_, err := loadTestConfig(t, `cache:
+  proxy:
+    from: "http://localhost:8080"
+    rate: 10
+  eviction:
+    threshold: 1.5
+  preallocate:
+    num_shards: 1024
+  rules:
+    /api/v1/page:
+      refresh:
+        coefficient: -1
+`)
+	want := map[string]int{
+		"cache.eviction.threshold":                     6,
+		"cache.preallocate.num_shards":                 8,
+		"cache.rules./api/v1/page.refresh.coefficient": 12,
+	}
+	errs := validationErrors(err)
+	if len(errs) != len(want) {
+		t.Fatalf("unexpected errors: %v", err)
+	}
+	for _, e := range errs {
+		if line, ok := want[e.Path]; !ok || line != e.Line {
+			t.Errorf("unexpected error %v", e)
+		}
+	}
+}
+
+func TestLoadConfigMissingProxy(t *testing.T) {
+	_, err := loadTestConfig(t, `cache:
+  env: "dev"
+`)
+	errs := validationErrors(err)
+	if len(errs) != 1 || errs[0].Path != "cache.proxy" || errs[0].Line != 1 {
+		t.Fatalf("unexpected errors: %v", err)
+	}
+}
+
+func TestLoadConfigWALWithEncryption(t *testing.T) {
+	_, err := loadTestConfig(t, `cache:
+  proxy:
+    from: "http://localhost:8080"
+    rate: 10
+  persistence:
+    dump:
+      enabled: true
+      dump_dir: "dump"
+      dump_name: "cache.dump"
+      encryption:
+        enabled: true
+        keys:
+          - id: "k1"
+            env: "ADV_CACHE_DUMP_KEY"
+    wal:
+      enabled: true
+`)
+	errs := validationErrors(err)
+	if len(errs) != 1 || errs[0].Path != "cache.persistence.wal.enabled" || errs[0].Line != 16 {
+		t.Fatalf("unexpected errors: %v", err)
+	}
+}
+
+func TestLoadConfigAllowCIDRs(t *testing.T) {
+	_, err := loadTestConfig(t, `cache:
+  proxy:
+    from: "http://localhost:8080"
+    rate: 10
+  admin:
+    auth:
+      allow_cidrs: ["10.0.0.0/8", "10.0.0.5", "::1", "10.0.0.0/33", "localhost"]
+`)
+	errs := validationErrors(err)
+	if len(errs) != 2 || errs[0].Path != "cache.admin.auth.allow_cidrs.3" || errs[1].Path != "cache.admin.auth.allow_cidrs.4" {
+		t.Fatalf("unexpected errors: %v", err)
+	}
+}
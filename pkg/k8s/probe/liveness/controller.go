@@ -6,6 +6,9 @@ import (
 	"github.com/valyala/fasthttp"
 )
 
+// ProbePath is served without admin authentication for kubelet.
+const ProbePath = "/k8s/probe"
+
 var (
 	successResponseBytes = []byte(`{
 	  "status": 200,
@@ -41,5 +44,5 @@ func (c *Controller) Probe(ctx *fasthttp.RequestCtx) {
 }
 
 func (c *Controller) AddRoute(router *router.Router) {
-	router.GET("/k8s/probe", c.Probe)
+	router.GET(ProbePath, c.Probe)
 }
@@ -10,6 +10,7 @@ import (
 	"math"
 	"math/rand/v2"
 	"net/http"
+	"runtime"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -25,10 +26,18 @@ import (
 	"github.com/zeebo/xxh3"
 )
 
+const (
+	// EntryFormatVersion is the version of the record layout of ToBytes, it's stored in snapshots.
+	EntryFormatVersion uint16 = 1
+	// HashSeed is the seed of keys and fingerprints hashing (xxh3 is used unseeded).
+	HashSeed uint64 = 0
+)
+
 var (
-	bufPool           = &sync.Pool{New: func() any { return new(bytes.Buffer) }}
-	hasherPool        = &sync.Pool{New: func() any { return xxh3.New() }}
-	ruleNotFoundError = errors.New("rule not found")
+	bufPool             = &sync.Pool{New: func() any { return new(bytes.Buffer) }}
+	hasherPool          = &sync.Pool{New: func() any { return xxh3.New() }}
+	ruleNotFoundError   = errors.New("rule not found")
+	entryTruncatedError = errors.New("entry record is truncated")
 )
 
 // Entry is the packed request+response payload
@@ -41,13 +50,18 @@ type Entry struct {
 	lruListElem  *atomic.Pointer[list.Element[*Entry]]
 	revalidator  Revalidator
 	updatedAt    int64 // atomic: unix nano (last update was at)
+	touchedAt    int64 // atomic: unix nano (last access was at)
 	isCompressed int64 // atomic: bool as int64
+	isStale      int32 // atomic: bool as int32 (expired, served only until it's revalidated)
+	isPayloadRef int32 // atomic: bool as int32 (the payload is referenced memory, see SetPayloadRef)
 }
 
 func (e *Entry) Init() *Entry {
 	e.payload = &atomic.Pointer[[]byte]{}
 	e.lruListElem = &atomic.Pointer[list.Element[*Entry]]{}
-	atomic.StoreInt64(&e.updatedAt, time.Now().UnixNano())
+	now := time.Now().UnixNano()
+	atomic.StoreInt64(&e.updatedAt, now)
+	atomic.StoreInt64(&e.touchedAt, now)
 	return e
 }
 
@@ -232,7 +246,11 @@ func (e *Entry) Fingerprint() [16]byte {
 }
 
 func (e *Entry) IsSamePayload(another *Entry) bool {
-	return e.isPayloadsAreEquals(e.PayloadBytes(), another.PayloadBytes())
+	a, _ := e.loadPayload()
+	b, _ := another.loadPayload()
+	defer runtime.KeepAlive(a)
+	defer runtime.KeepAlive(b)
+	return e.isPayloadsAreEquals(deref(a), deref(b))
 }
 
 func (e *Entry) IsSameFingerprint(another [16]byte) bool {
@@ -240,16 +258,83 @@ func (e *Entry) IsSameFingerprint(another [16]byte) bool {
 }
 
 func (e *Entry) IsSameEntry(another *Entry) bool {
-	return subtle.ConstantTimeCompare(e.fingerprint[:], another.fingerprint[:]) == 1 &&
-		e.isPayloadsAreEquals(e.PayloadBytes(), another.PayloadBytes())
+	return subtle.ConstantTimeCompare(e.fingerprint[:], another.fingerprint[:]) == 1 && e.IsSamePayload(another)
 }
 
 func (e *Entry) SwapPayloads(another *Entry) {
+	// Both entries are marked while the pointers move, so a referenced payload is never seen unmarked (see loadPayload).
+	eRef, anotherRef := atomic.LoadInt32(&e.isPayloadRef), atomic.LoadInt32(&another.isPayloadRef)
+	atomic.StoreInt32(&e.isPayloadRef, eRef|anotherRef)
+	atomic.StoreInt32(&another.isPayloadRef, eRef|anotherRef)
 	another.payload.Store(e.payload.Swap(another.payload.Load()))
+	atomic.StoreInt32(&e.isPayloadRef, anotherRef)
+	atomic.StoreInt32(&another.isPayloadRef, eRef)
+}
+
+// SetPayloadRef stores the payload pointer as is, its bytes are neither copied nor ever modified, so it may
+// reference read-only memory (e.g. a slot of a mapped snapshot, see storage.Dump). Owners of the memory
+// may track the pointer: it's dropped once the payload is replaced (SetPayload copies to the heap) or the entry is gone,
+// readers keep it reachable until they are done (see Payload).
+func (e *Entry) SetPayloadRef(payload *[]byte) {
+	atomic.StoreInt32(&e.isPayloadRef, 1)
+	e.payload.Store(payload)
 }
 
+// loadPayload returns the payload pointer, isRef is true if it may point to referenced memory (see SetPayloadRef):
+// the pointer must be kept reachable while the payload is read. The mark is checked around the load, it's set
+// before a referenced payload is stored and cleared after it's replaced.
+func (e *Entry) loadPayload() (ptr *[]byte, isRef bool) {
+	isRef = atomic.LoadInt32(&e.isPayloadRef) == 1
+	ptr = e.payload.Load()
+	return ptr, isRef || atomic.LoadInt32(&e.isPayloadRef) == 1
+}
+
+func deref(ptr *[]byte) []byte {
+	if ptr == nil {
+		return nil
+	}
+	return *ptr
+}
+
+// TouchUpdatedAt sets the time of the last update (unix nano like all update times) to now.
 func (e *Entry) TouchUpdatedAt() {
-	atomic.StoreInt64(&e.updatedAt, time.Now().Unix())
+	atomic.StoreInt64(&e.updatedAt, time.Now().UnixNano())
+}
+
+// SetUpdatedAt sets the time (unix nano) of the last update, e.g. of a refresh made by another instance.
+func (e *Entry) SetUpdatedAt(updatedAt int64) {
+	atomic.StoreInt64(&e.updatedAt, updatedAt)
+}
+
+// MarkStale marks an expired entry (e.g. restored from a dump): it must be revalidated before it's served
+// and is served as is only if the revalidation fails. A successful Revalidate clears the mark.
+func (e *Entry) MarkStale() {
+	atomic.StoreInt32(&e.isStale, 1)
+}
+
+// IsStale reports whether the entry is marked stale (see MarkStale).
+func (e *Entry) IsStale() bool {
+	return atomic.LoadInt32(&e.isStale) == 1
+}
+
+// ClaimStale clears the stale mark and reports whether it was set, so only one of concurrent callers revalidates the entry.
+func (e *Entry) ClaimStale() bool {
+	return atomic.CompareAndSwapInt32(&e.isStale, 1, 0)
+}
+
+// Touch records an access of the entry at now (unix nano).
+func (e *Entry) Touch(now int64) {
+	atomic.StoreInt64(&e.touchedAt, now)
+}
+
+// TouchedAt returns unix nano of the last access (or of the creation if there were none).
+func (e *Entry) TouchedAt() int64 {
+	return atomic.LoadInt64(&e.touchedAt)
+}
+
+// SetTouchedAt restores the time of the last access, e.g. from a dump.
+func (e *Entry) SetTouchedAt(unixNano int64) {
+	atomic.StoreInt64(&e.touchedAt, unixNano)
 }
 
 func (e *Entry) SetRevalidator(revalidator Revalidator) *Entry {
@@ -364,6 +449,7 @@ func (e *Entry) SetPayload(
 	// === 5) Store raw ===
 	payloadBuf = payloadBuf[:]
 	e.payload.Store(&payloadBuf)
+	atomic.StoreInt32(&e.isPayloadRef, 0)
 	atomic.StoreInt64(&e.isCompressed, 0)
 }
 
@@ -385,7 +471,8 @@ func (e *Entry) Payload() (
 	releaseFn func(q, h *[][2][]byte),
 	err error,
 ) {
-	payload := e.PayloadBytes()
+	ptr, isRef := e.loadPayload()
+	payload := deref(ptr)
 	if len(payload) == 0 {
 		return nil, nil, nil, nil, nil, 0, emptyReleaser, fmt.Errorf("payload is empty")
 	}
@@ -452,6 +539,13 @@ func (e *Entry) Payload() (
 	body = payload[offset:]
 
 	releaseFn = payloadReleaser
+	if isRef {
+		// The referenced memory may be released once the pointer is dropped, e.g. when the entry is refreshed.
+		releaseFn = func(q, h *[][2][]byte) {
+			payloadReleaser(q, h)
+			runtime.KeepAlive(ptr)
+		}
+	}
 
 	return
 }
@@ -460,13 +554,9 @@ func (e *Entry) Rule() *config.Rule {
 	return e.rule
 }
 
+// PayloadBytes returns the raw payload, a referenced one (see SetPayloadRef) is valid until the payload is replaced.
 func (e *Entry) PayloadBytes() []byte {
-	var payload []byte
-	ptr := e.payload.Load()
-	if ptr != nil {
-		return *ptr
-	}
-	return payload
+	return deref(e.payload.Load())
 }
 
 func (e *Entry) Weight() int64 {
@@ -784,26 +874,9 @@ func (e *Entry) ShouldBeRefreshed(cfg *config.Cache) bool {
 		return false
 	}
 
-	var (
-		ttl         = cfg.Cache.Refresh.TTL.Nanoseconds()
-		beta        = cfg.Cache.Refresh.Beta
-		coefficient = cfg.Cache.Refresh.Coefficient
-	)
-
-	if e.rule.Refresh != nil {
-		if !e.rule.Refresh.Enabled {
-			return false
-		}
-
-		if e.rule.Refresh.TTL.Nanoseconds() > 0 {
-			ttl = e.rule.Refresh.TTL.Nanoseconds()
-		}
-		if e.rule.Refresh.Beta > 0 {
-			beta = e.rule.Refresh.Beta
-		}
-		if e.rule.Refresh.Coefficient > 0 {
-			coefficient = e.rule.Refresh.Coefficient
-		}
+	ttl, beta, coefficient, ok := e.refreshParams(cfg)
+	if !ok {
+		return false
 	}
 
 	// время, прошедшее с последнего обновления
@@ -827,6 +900,52 @@ func (e *Entry) ShouldBeRefreshed(cfg *config.Cache) bool {
 	return rand.Float64() < prob
 }
 
+// Staleness returns the age of the entry relative to the refresh TTL of its rule (1 means the TTL is over)
+// and the coefficient of the TTL after which the entry should be refreshed.
+// ok is false if the entry is not refreshed at all (the rule was removed or its refresh is disabled,
+// the global refresh is disabled and the rule doesn't enable it).
+func (e *Entry) Staleness(cfg *config.Cache, now time.Time) (age, coefficient float64, ok bool) {
+	ttl, _, coefficient, ok := e.refreshParams(cfg)
+	if !ok || ttl <= 0 {
+		return 0, 0, false
+	}
+	if !cfg.RefreshPolicy().Enabled && MatchRule(cfg, e.rule.PathBytes).Refresh == nil {
+		return 0, 0, false
+	}
+	return float64(now.UnixNano()-atomic.LoadInt64(&e.updatedAt)) / float64(ttl), coefficient, true
+}
+
+// refreshParams returns the refresh TTL (ns), beta and coefficient of the live rule of the entry (the global policy
+// fills the gaps), ok is false if the entry is not refreshed.
+func (e *Entry) refreshParams(cfg *config.Cache) (ttl int64, beta, coefficient float64, ok bool) {
+	policy := cfg.RefreshPolicy()
+	ttl, beta, coefficient = policy.TTL.Nanoseconds(), policy.Beta, policy.Coefficient
+
+	// the rule may have been changed at runtime, so the live one is used instead of the captured pointer
+	rule := MatchRule(cfg, e.rule.PathBytes)
+	if rule == nil {
+		// the rule was removed, such entries are not refreshed anymore and will be evicted
+		return 0, 0, 0, false
+	}
+
+	if rule.Refresh != nil {
+		if !rule.Refresh.Enabled {
+			return 0, 0, 0, false
+		}
+
+		if rule.Refresh.TTL.Nanoseconds() > 0 {
+			ttl = rule.Refresh.TTL.Nanoseconds()
+		}
+		if rule.Refresh.Beta > 0 {
+			beta = rule.Refresh.Beta
+		}
+		if rule.Refresh.Coefficient > 0 {
+			coefficient = rule.Refresh.Coefficient
+		}
+	}
+	return ttl, beta, coefficient, true
+}
+
 var invalidUpstreamStatusCodeReceivedError = errors.New("invalid upstream status code")
 
 // Revalidate calls the revalidator closure to fetch fresh data and updates the timestamp.
@@ -850,6 +969,7 @@ func (e *Entry) Revalidate() error {
 
 	// successful refresh, set up current timestamp as last update point
 	atomic.StoreInt64(&e.updatedAt, time.Now().UnixNano())
+	atomic.StoreInt32(&e.isStale, 0)
 
 	return nil
 }
@@ -858,7 +978,9 @@ func (e *Entry) ToBytes() (data []byte, releaseFn func()) {
 	var scratch8 [8]byte
 	var scratch4 [4]byte
 
-	payload := e.PayloadBytes()
+	ptr, _ := e.loadPayload()
+	defer runtime.KeepAlive(ptr)
+	payload := deref(ptr)
 	rulePath := e.Rule().PathBytes
 
 	// Забираем buffer из пула и очищаем
@@ -905,17 +1027,31 @@ func (e *Entry) ToBytes() (data []byte, releaseFn func()) {
 }
 
 func EntryFromBytes(data []byte, cfg *config.Cache, backend upstream.Gateway) (*Entry, error) {
+	return EntryFromBytesWithRule(data, func(path []byte) *config.Rule {
+		return MatchRule(cfg, path)
+	}, backend.RevalidatorMaker())
+}
+
+// EntryFromBytesWithRule unpacks a record made by ToBytes, the rule is resolved by its stored path with ruleFn.
+// A nil rule is reported as an error matching IsRouteWasNotFound, truncated records are errors too.
+func EntryFromBytesWithRule(data []byte, ruleFn func(path []byte) *config.Rule, revalidator Revalidator) (*Entry, error) {
 	var offset int
 
 	// RulePath
-	rulePathLen := binary.LittleEndian.Uint32(data[offset:])
+	if len(data) < 4 {
+		return nil, entryTruncatedError
+	}
+	rulePathLen := int(binary.LittleEndian.Uint32(data[offset:]))
 	offset += 4
-	rulePath := data[offset : offset+int(rulePathLen)]
-	offset += int(rulePathLen)
+	if len(data) < offset+rulePathLen+8+8+16+1+8+4 {
+		return nil, entryTruncatedError
+	}
+	rulePath := data[offset : offset+rulePathLen]
+	offset += rulePathLen
 
-	rule := MatchRule(cfg, rulePath)
+	rule := ruleFn(rulePath)
 	if rule == nil {
-		return nil, fmt.Errorf("rule not found for path: '%s'", string(rulePath))
+		return nil, fmt.Errorf("%w for path: '%s'", ruleNotFoundError, string(rulePath))
 	}
 
 	// RuleKey
@@ -944,23 +1080,41 @@ func EntryFromBytes(data []byte, cfg *config.Cache, backend upstream.Gateway) (*
 	offset += 8
 
 	// Payload
-	payloadLen := binary.LittleEndian.Uint32(data[offset:])
+	payloadLen := int(binary.LittleEndian.Uint32(data[offset:]))
 	offset += 4
-	payload := data[offset : offset+int(payloadLen)]
+	if len(data) < offset+payloadLen {
+		return nil, entryTruncatedError
+	}
+	payload := data[offset : offset+payloadLen]
 
 	return NewEntryFromField(
 		key, shard, fp, payload, rule,
-		backend.RevalidatorMaker(), isCompressed, updatedAt,
+		revalidator, isCompressed, updatedAt,
 	), nil
 }
 
-func MatchRule(cfg *config.Cache, path []byte) *config.Rule {
-	if rule, ok := cfg.Cache.Rules[unsafe.String(unsafe.SliceData(path), len(path))]; ok {
-		return rule
+// Rekey recomputes the keys of a restored (not yet stored) entry by its stored path, query and request headers
+// with the live rule of the path (see NewEntryManual), e.g. after cache_key of the rule was changed.
+// The payload and the update time are kept. A path without a rule is an error matching IsRouteWasNotFound.
+func (e *Entry) Rekey(cfg *config.Cache) error {
+	path, query, queryHeaders, responseHeaders, _, _, releaseFn, err := e.Payload()
+	defer releaseFn(queryHeaders, responseHeaders)
+	if err != nil {
+		return err
+	}
+	keyed, err := NewEntryManual(cfg, path, query, queryHeaders, e.revalidator)
+	if err != nil {
+		return err
 	}
+	e.key, e.shard, e.fingerprint, e.rule = keyed.key, keyed.shard, keyed.fingerprint, keyed.rule
 	return nil
 }
 
+// MatchRule returns the live rule for the given path or nil (see config.Cache.Rules).
+func MatchRule(cfg *config.Cache, path []byte) *config.Rule {
+	return cfg.Rule(unsafe.String(unsafe.SliceData(path), len(path)))
+}
+
 // SetMapKey is really dangerous - must be used exclusively in tests.
 func (e *Entry) SetMapKey(key uint64) *Entry {
 	e.key = key
@@ -0,0 +1,28 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Borislavv/advanced-cache/pkg/config"
+)
+
+func TestTouchUpdatedAtIsUnixNano(t *testing.T) {
+	cfg := &config.Cache{Cache: &config.CacheBox{Refresh: &config.Refresh{Enabled: true, TTL: time.Hour, Coefficient: 0.5}}}
+	rule := config.PrepareRule("/api", &config.Rule{})
+	cfg.SetRules(map[string]*config.Rule{"/api": rule})
+
+	e := NewEntryFromField(1, 1, [16]byte{}, nil, rule, nil, 0, time.Now().Add(-2*time.Hour).UnixNano())
+	if age, _, ok := e.Staleness(cfg, time.Now()); !ok || age < 2 {
+		t.Fatalf("expected an entry updated 2 TTLs ago, got age %v (%v)", age, ok)
+	}
+
+	before := time.Now().UnixNano()
+	e.TouchUpdatedAt()
+	if updatedAt := e.UpdateAt(); updatedAt < before || updatedAt > time.Now().UnixNano() {
+		t.Fatalf("expected the update time in unix nano, got %d", updatedAt)
+	}
+	if age, _, ok := e.Staleness(cfg, time.Now()); !ok || age < 0 || age > 0.01 {
+		t.Fatalf("expected a just updated entry, got age %v (%v)", age, ok)
+	}
+}
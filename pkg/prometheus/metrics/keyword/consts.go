@@ -12,6 +12,19 @@ var (
 	Misses                   = "cache_misses"
 	MapMemoryUsageMetricName = "cache_memory_usage"
 	MapLength                = "cache_length"
+	/* Snapshots */
+	SnapshotLastSuccess = "snapshot_last_success_timestamp_seconds"
+	SnapshotDuration    = "snapshot_duration_seconds" // of the last successful snapshot
+	SnapshotEntries     = "snapshot_entries"          // of the last successful snapshot
+	SnapshotFailures    = "snapshot_failures"
+	/* Restore of the last loaded dump */
+	RestoreFresh            = "restore_fresh_entries"
+	RestoreRefreshQueued    = "restore_refresh_queued_entries" // past the refresh coefficient
+	RestoreStale            = "restore_stale_entries"          // expired, loaded as stale
+	RestoreDropped          = "restore_dropped_entries"        // expired, dropped
+	RestoreRefreshesDone    = "restore_refreshes_done"
+	RestoreRefreshesFailed  = "restore_refreshes_failed"
+	RestoreRefreshesPending = "restore_refreshes_pending"
 )
 
 func MetricsCounters() []string {
@@ -25,5 +38,16 @@ func MetricsCounters() []string {
 		Misses,
 		MapMemoryUsageMetricName,
 		MapLength,
+		SnapshotLastSuccess,
+		SnapshotDuration,
+		SnapshotEntries,
+		SnapshotFailures,
+		RestoreFresh,
+		RestoreRefreshQueued,
+		RestoreStale,
+		RestoreDropped,
+		RestoreRefreshesDone,
+		RestoreRefreshesFailed,
+		RestoreRefreshesPending,
 	}
 }
@@ -1,6 +1,8 @@
 package metrics
 
 import (
+	"time"
+
 	"github.com/Borislavv/advanced-cache/pkg/prometheus/metrics/keyword"
 	"github.com/VictoriaMetrics/metrics"
 )
@@ -16,6 +18,10 @@ type Meter interface {
 	SetCacheLength(count uint64)
 	SetCacheMemory(bytes uint64)
 	SetAvgResponseTime(avg float64)
+	SetSnapshot(finishedAt time.Time, duration time.Duration, entries int)
+	IncSnapshotFailures()
+	SetRestore(fresh, refreshQueued, stale, dropped int)
+	SetRestoreRefreshes(done, failed, pending int)
 }
 
 // Metrics implements Meter using VictoriaMetrics metrics.
@@ -61,3 +67,26 @@ func (m *Metrics) SetCacheLength(count uint64) {
 func (m *Metrics) SetAvgResponseTime(avgDuration float64) {
 	metrics.GetOrCreateGauge(keyword.AvgDuration, nil).Set(avgDuration)
 }
+
+func (m *Metrics) SetSnapshot(finishedAt time.Time, duration time.Duration, entries int) {
+	metrics.GetOrCreateGauge(keyword.SnapshotLastSuccess, nil).Set(float64(finishedAt.Unix()))
+	metrics.GetOrCreateGauge(keyword.SnapshotDuration, nil).Set(duration.Seconds())
+	metrics.GetOrCreateGauge(keyword.SnapshotEntries, nil).Set(float64(entries))
+}
+
+func (m *Metrics) IncSnapshotFailures() {
+	metrics.GetOrCreateCounter(keyword.SnapshotFailures).Inc()
+}
+
+func (m *Metrics) SetRestore(fresh, refreshQueued, stale, dropped int) {
+	metrics.GetOrCreateGauge(keyword.RestoreFresh, nil).Set(float64(fresh))
+	metrics.GetOrCreateGauge(keyword.RestoreRefreshQueued, nil).Set(float64(refreshQueued))
+	metrics.GetOrCreateGauge(keyword.RestoreStale, nil).Set(float64(stale))
+	metrics.GetOrCreateGauge(keyword.RestoreDropped, nil).Set(float64(dropped))
+}
+
+func (m *Metrics) SetRestoreRefreshes(done, failed, pending int) {
+	metrics.GetOrCreateGauge(keyword.RestoreRefreshesDone, nil).Set(float64(done))
+	metrics.GetOrCreateGauge(keyword.RestoreRefreshesFailed, nil).Set(float64(failed))
+	metrics.GetOrCreateGauge(keyword.RestoreRefreshesPending, nil).Set(float64(pending))
+}
@@ -0,0 +1,115 @@
+package reload
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/Borislavv/advanced-cache/pkg/config"
+	"gopkg.in/yaml.v3"
+)
+
+// livePaths are config paths (and their subtrees) which are applied without a restart.
+var livePaths = []string{
+	"cache.rules",
+	"cache.templates", // applied through the rules which extend them
+	"cache.include",   // applied through the included rules
+	"cache.proxy.rate",
+	"cache.refresh.ttl",
+	"cache.refresh.rate",
+	"cache.refresh.scan_rate",
+	"cache.refresh.beta",
+	"cache.refresh.coefficient",
+	"cache.logs.level",
+	"cache.eviction.threshold",
+	"cache.storage.size",
+}
+
+// Change is a single changed leaf of the config tree.
+type Change struct {
+	Path string
+	Old  any
+	New  any
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s: %v -> %v", c.Path, c.Old, c.New)
+}
+
+// IsLive reports whether the change is applied without a restart.
+func (c Change) IsLive() bool {
+	for _, path := range livePaths {
+		if c.Path == path || strings.HasPrefix(c.Path, path+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// Diff returns the changed leaves between two configs sorted by path.
+func Diff(prev, next *config.Cache) ([]Change, error) {
+	prevTree, err := toTree(prev)
+	if err != nil {
+		return nil, err
+	}
+	nextTree, err := toTree(next)
+	if err != nil {
+		return nil, err
+	}
+
+	prevLeaves, nextLeaves := make(map[string]any), make(map[string]any)
+	flatten("", prevTree, prevLeaves)
+	flatten("", nextTree, nextLeaves)
+
+	var changes []Change
+	for path, prevValue := range prevLeaves {
+		nextValue, ok := nextLeaves[path]
+		if !ok || !reflect.DeepEqual(prevValue, nextValue) {
+			changes = append(changes, Change{Path: path, Old: prevValue, New: nextValue})
+		}
+	}
+	for path, nextValue := range nextLeaves {
+		if _, ok := prevLeaves[path]; !ok {
+			changes = append(changes, Change{Path: path, New: nextValue})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+
+	return changes, nil
+}
+
+// toTree represents the config as a generic tree using yaml field names.
+func toTree(cfg *config.Cache) (any, error) {
+	b, err := yaml.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var tree any
+	if err = yaml.Unmarshal(b, &tree); err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+func flatten(prefix string, node any, leaves map[string]any) {
+	switch v := node.(type) {
+	case map[string]any:
+		for key, child := range v {
+			flatten(join(prefix, key), child, leaves)
+		}
+	case []any:
+		for i, child := range v {
+			flatten(join(prefix, fmt.Sprint(i)), child, leaves)
+		}
+	default:
+		leaves[prefix] = v
+	}
+}
+
+func join(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
@@ -0,0 +1,212 @@
+package reload
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Borislavv/advanced-cache/pkg/config"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultInterval is used when reload.interval is not configured.
+const DefaultInterval = 5 * time.Second
+
+var ConfigPathIsEmptyError = errors.New("config path is empty")
+
+// Reloadable is implemented by components which can apply changed settings at runtime.
+type Reloadable interface {
+	Reload(cfg *config.Cache)
+}
+
+// Reloader re-reads the config on SIGHUP and (if reload.watch is enabled) when the config or included files change,
+// validates it, logs the diff and applies the live settings to the subscribers.
+// Settings which cannot be changed at runtime are reported as requiring a restart and are not applied.
+type Reloader struct {
+	ctx         context.Context
+	cfg         *config.Cache // live config, its atomic accessors are updated on reload
+	mu          sync.Mutex
+	last        *config.Cache // last successfully applied config
+	hash        [sha256.Size]byte
+	subscribers []Reloadable
+}
+
+// NewReloader creates a reloader of the file the config was loaded from.
+func NewReloader(ctx context.Context, cfg *config.Cache, subscribers ...Reloadable) (*Reloader, error) {
+	if cfg.Path == "" {
+		return nil, ConfigPathIsEmptyError
+	}
+	r := &Reloader{ctx: ctx, cfg: cfg, last: cfg, subscribers: subscribers}
+	r.hash, _ = r.filesHash()
+	return r, nil
+}
+
+// Run starts listening for SIGHUP and watching the config file.
+func (r *Reloader) Run() {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(sigCh)
+
+		var tickCh <-chan time.Time
+		if r.cfg.Cache.Reload.Watch {
+			interval := r.cfg.Cache.Reload.Interval
+			if interval <= 0 {
+				interval = DefaultInterval
+			}
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			tickCh = ticker.C
+			log.Info().Msgf("[reload] watching %s every %s", r.cfg.Path, interval)
+		}
+
+		for {
+			select {
+			case <-r.ctx.Done():
+				return
+			case <-sigCh:
+				log.Info().Msg("[reload] received SIGHUP")
+				if err := r.Reload(); err != nil {
+					log.Error().Err(err).Msg("[reload] config was not applied")
+				}
+			case <-tickCh:
+				if !r.changed() {
+					continue
+				}
+				log.Info().Msgf("[reload] %s has been changed", r.cfg.Path)
+				if err := r.Reload(); err != nil {
+					log.Error().Err(err).Msg("[reload] config was not applied")
+				}
+			}
+		}
+	}()
+}
+
+// filesHash hashes the content of the config file and the included files (with the current include dir listing).
+func (r *Reloader) filesHash() ([sha256.Size]byte, error) {
+	r.mu.Lock()
+	files := append([]string{}, r.last.Files()...)
+	includeDir := r.last.IncludeDir()
+	r.mu.Unlock()
+
+	if includeDir != "" {
+		if entries, err := os.ReadDir(includeDir); err == nil {
+			for _, entry := range entries {
+				files = append(files, filepath.Join(includeDir, entry.Name()))
+			}
+		}
+	}
+	sort.Strings(files)
+
+	h := sha256.New()
+	for _, file := range uniqueStrings(files) {
+		data, err := os.ReadFile(file)
+		if err != nil && file == r.cfg.Path {
+			return [sha256.Size]byte{}, err
+		}
+		h.Write([]byte(file))
+		h.Write(data)
+	}
+
+	var sum [sha256.Size]byte
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
+
+// changed reports whether the content of the config files differs from the last seen one.
+func (r *Reloader) changed() bool {
+	hash, err := r.filesHash()
+	if err != nil {
+		log.Warn().Err(err).Msg("[reload] failed to read config file")
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if bytes.Equal(hash[:], r.hash[:]) {
+		return false
+	}
+	r.hash = hash
+	return true
+}
+
+// Reload loads, validates and applies the config file. On error nothing is applied.
+// The loaded content is not reloaded by the watch again (e.g. after SIGHUP).
+func (r *Reloader) Reload() error {
+	// hashed before the load: a change made meanwhile is noticed by the next check
+	hash, hashErr := r.filesHash()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next, err := config.LoadConfig(r.cfg.Path, r.cfg.Overrides()...) // strictly decoded and validated
+	if err != nil {
+		return err
+	}
+	if hashErr == nil {
+		r.hash = hash
+	}
+
+	changes, err := Diff(r.last, next)
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		log.Info().Msg("[reload] config has not been changed")
+		return nil
+	}
+
+	var applied, skipped int
+	for _, change := range changes {
+		if change.IsLive() {
+			applied++
+			log.Info().Msgf("[reload] %s", change)
+		} else {
+			skipped++
+			log.Warn().Msgf("[reload] %s (requires restart, not applied)", change)
+		}
+	}
+
+	if applied > 0 {
+		r.apply(next)
+	}
+	r.last = next
+
+	log.Info().Msgf("[reload] config has been reloaded: %d applied, %d require restart", applied, skipped)
+	return nil
+}
+
+// apply swaps the live settings and notifies the subscribers.
+func (r *Reloader) apply(next *config.Cache) {
+	// Only the live fields are copied, the others (e.g. refresh.enabled) take effect after a restart.
+	refresh := *r.cfg.RefreshPolicy()
+	refresh.TTL, refresh.Rate, refresh.ScanRate = next.Cache.Refresh.TTL, next.Cache.Refresh.Rate, next.Cache.Refresh.ScanRate
+	refresh.Beta, refresh.Coefficient = next.Cache.Refresh.Beta, next.Cache.Refresh.Coefficient
+	r.cfg.SetRefreshPolicy(&refresh)
+	if level := next.LogLevel(); level != zerolog.GlobalLevel() {
+		zerolog.SetGlobalLevel(level)
+	}
+	for _, subscriber := range r.subscribers {
+		subscriber.Reload(next)
+	}
+}
+
+func uniqueStrings(sorted []string) []string {
+	result := sorted[:0]
+	for i, s := range sorted {
+		if i == 0 || s != sorted[i-1] {
+			result = append(result, s)
+		}
+	}
+	return result
+}
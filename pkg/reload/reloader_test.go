@@ -0,0 +1,129 @@
+package reload
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Borislavv/advanced-cache/pkg/config"
+)
+
+const testConfig = `cache:
+  env: "test"
+  proxy:
+    from: "http://localhost:8080"
+    to: ":8020"
+    rate: 80
+  eviction:
+    threshold: 0.9
+  storage:
+    size: 1024
+  refresh:
+    ttl: "1h"
+    rate: 10
+  rules:
+    /api/v2/pagedata:
+      refresh:
+        enabled: true
+        ttl: "20m"
+`
+
+type subscriber struct {
+	calls int
+	cfg   *config.Cache
+}
+
+func (s *subscriber) Reload(cfg *config.Cache) {
+	s.calls++
+	s.cfg = cfg
+}
+
+func newTestReloader(t *testing.T) (*Reloader, *subscriber, string) {
+	path := filepath.Join(t.TempDir(), "cfg.yaml")
+	if err := os.WriteFile(path, []byte(testConfig), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	sub := &subscriber{}
+	r, err := NewReloader(context.Background(), cfg, sub)
+	if err != nil {
+		t.Fatalf("NewReloader: %v", err)
+	}
+	return r, sub, path
+}
+
+func TestReloadAppliesLiveSettings(t *testing.T) {
+	r, sub, path := newTestReloader(t)
+
+	changed := strings.NewReplacer(`rate: 80`, `rate: 120`, `ttl: "1h"`, "enabled: true\n    scan_rate: 10\n    ttl: \"2h\"", `to: ":8020"`, `to: ":9000"`).Replace(testConfig)
+	if err := os.WriteFile(path, []byte(changed), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+
+	if sub.calls != 1 || sub.cfg.Cache.Proxy.Rate != 120 {
+		t.Fatalf("subscriber was not reloaded with the new config: calls=%d", sub.calls)
+	}
+	if ttl := r.cfg.RefreshPolicy().TTL; ttl != 2*time.Hour {
+		t.Fatalf("refresh policy ttl = %s, want 2h", ttl)
+	}
+	if r.cfg.RefreshPolicy().Enabled {
+		t.Fatal("restart-required refresh.enabled has been applied")
+	}
+	if r.cfg.Cache.Proxy.To != ":8020" {
+		t.Fatalf("restart-required setting has been applied: %s", r.cfg.Cache.Proxy.To)
+	}
+	if r.changed() {
+		t.Fatal("the reloaded file is seen as changed by the watch")
+	}
+}
+
+func TestReloadRejectsInvalidConfig(t *testing.T) {
+	r, sub, path := newTestReloader(t)
+
+	invalid := strings.Replace(testConfig, `threshold: 0.9`, `threshold: 1.5`, 1)
+	if err := os.WriteFile(path, []byte(invalid), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Reload(); err == nil {
+		t.Fatal("expected validation error")
+	}
+	if sub.calls != 0 {
+		t.Fatal("invalid config must not be applied")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	r, _, path := newTestReloader(t)
+
+	changed := strings.NewReplacer(`ttl: "20m"`, `ttl: "30m"`, `to: ":8020"`, `to: ":9000"`).Replace(testConfig)
+	if err := os.WriteFile(path, []byte(changed), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	next, err := config.LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := Diff(r.cfg, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 {
+		t.Fatalf("expected 2 changes, got %v", changes)
+	}
+	if changes[0].Path != "cache.proxy.to" || changes[0].IsLive() {
+		t.Fatalf("unexpected change %v", changes[0])
+	}
+	if changes[1].Path != "cache.rules./api/v2/pagedata.refresh.ttl" || !changes[1].IsLive() {
+		t.Fatalf("unexpected change %v", changes[1])
+	}
+}
@@ -0,0 +1,118 @@
+package rules
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Borislavv/advanced-cache/pkg/config"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+// history stores rule set versions as <dir>/v<N>.yaml files and keeps only the newest maxVersions of them.
+type history struct {
+	dir         string
+	maxVersions int
+}
+
+func newHistory(dir string, maxVersions int) *history {
+	return &history{dir: dir, maxVersions: maxVersions}
+}
+
+func (h *history) store(version int, action string, rules map[string]*config.Rule) (int, error) {
+	if err := os.MkdirAll(h.dir, 0o755); err != nil {
+		return 0, fmt.Errorf("create rules history dir: %w", err)
+	}
+
+	data, err := yaml.Marshal(&Version{Version: version, CreatedAt: time.Now(), Action: action, Rules: rules})
+	if err != nil {
+		return 0, fmt.Errorf("marshal rule set v%d: %w", version, err)
+	}
+
+	if err = writeFileAtomic(h.filename(version), data, 0o644); err != nil {
+		return 0, fmt.Errorf("store rule set v%d: %w", version, err)
+	}
+	return version, nil
+}
+
+// remove drops a stored version which was not applied.
+func (h *history) remove(version int) {
+	if err := os.Remove(h.filename(version)); err != nil && !os.IsNotExist(err) {
+		log.Warn().Err(err).Msgf("[rules] failed to remove not applied rule set v%d", version)
+	}
+}
+
+func (h *history) load(version int) (*Version, error) {
+	data, err := os.ReadFile(h.filename(version))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, VersionNotFoundError
+		}
+		return nil, err
+	}
+
+	v := &Version{}
+	if err = yaml.Unmarshal(data, v); err != nil {
+		return nil, fmt.Errorf("unmarshal rule set v%d: %w", version, err)
+	}
+	if v.Rules == nil {
+		v.Rules = make(map[string]*config.Rule)
+	}
+	return v, nil
+}
+
+func (h *history) latest() (*Version, error) {
+	versions := h.numbers()
+	if len(versions) == 0 {
+		return nil, VersionNotFoundError
+	}
+	return h.load(versions[0])
+}
+
+// list returns all stored versions, newest first.
+func (h *history) list() ([]*Version, error) {
+	numbers := h.numbers()
+	versions := make([]*Version, 0, len(numbers))
+	for _, n := range numbers {
+		v, err := h.load(n)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	return versions, nil
+}
+
+// numbers returns stored version numbers sorted desc.
+func (h *history) numbers() []int {
+	files, _ := filepath.Glob(filepath.Join(h.dir, "v*.yaml"))
+	numbers := make([]int, 0, len(files))
+	for _, file := range files {
+		var n int
+		if _, err := fmt.Sscanf(strings.TrimSuffix(filepath.Base(file), ".yaml"), "v%d", &n); err == nil {
+			numbers = append(numbers, n)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(numbers)))
+	return numbers
+}
+
+func (h *history) rotate() {
+	numbers := h.numbers()
+	if h.maxVersions <= 0 || len(numbers) <= h.maxVersions {
+		return
+	}
+	for _, n := range numbers[h.maxVersions:] {
+		if err := os.Remove(h.filename(n)); err != nil {
+			log.Warn().Err(err).Msgf("[rules] failed to remove old rule set v%d", n)
+		}
+	}
+}
+
+func (h *history) filename(version int) string {
+	return filepath.Join(h.dir, fmt.Sprintf("v%d.yaml", version))
+}
@@ -0,0 +1,244 @@
+package rules
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Borislavv/advanced-cache/pkg/config"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	RuleNotFoundError    = errors.New("rule not found")
+	RuleExistsError      = errors.New("rule already exists")
+	VersionNotFoundError = errors.New("rule set version not found")
+)
+
+// Version is a persisted snapshot of the whole rule set.
+type Version struct {
+	Version   int                     `yaml:"version" json:"version"`
+	CreatedAt time.Time               `yaml:"created_at" json:"createdAt"`
+	Action    string                  `yaml:"action" json:"action"`
+	Rules     map[string]*config.Rule `yaml:"rules" json:"-"`
+}
+
+// Manager changes the live rule set at runtime.
+// Every change is validated, stored as a new Version (for rollback), optionally written back
+// into the config file and only then atomically applied to the config used by model.MatchRule.
+type Manager struct {
+	mu      sync.Mutex
+	cfg     *config.Cache
+	history *history
+	version int
+}
+
+// NewManager creates a Manager. If the live rule set differs from the latest stored version
+// (e.g. the config file was edited by hand), it's recorded as a new version.
+func NewManager(cfg *config.Cache) (*Manager, error) {
+	m := &Manager{
+		cfg:     cfg,
+		history: newHistory(cfg.Cache.Admin.Rules.HistoryDir, cfg.Cache.Admin.Rules.MaxVersions),
+	}
+
+	latest, err := m.history.latest()
+	if err != nil && !errors.Is(err, VersionNotFoundError) {
+		return nil, err
+	}
+
+	if latest != nil {
+		m.version = latest.Version
+		if same, err := isSameRuleSet(latest.Rules, cfg.Rules()); err != nil {
+			return nil, err
+		} else if same {
+			return m, nil
+		}
+	}
+
+	if m.version, err = m.history.store(m.version+1, "startup", cfg.Rules()); err != nil {
+		return nil, err
+	}
+	m.history.rotate()
+	return m, nil
+}
+
+// Version returns the current rule set version.
+func (m *Manager) Version() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.version
+}
+
+// List returns the live rule set (read-only).
+func (m *Manager) List() map[string]*config.Rule {
+	return m.cfg.Rules()
+}
+
+// Get returns the live rule by path.
+func (m *Manager) Get(path string) (*config.Rule, bool) {
+	rule := m.cfg.Rule(path)
+	return rule, rule != nil
+}
+
+// Create adds a new rule, returns the new rule set version.
+func (m *Manager) Create(path string, rule *config.Rule) (int, error) {
+	return m.apply("create "+path, func(rules map[string]*config.Rule) error {
+		if _, ok := rules[path]; ok {
+			return RuleExistsError
+		}
+		rules[path] = rule
+		return nil
+	})
+}
+
+// Update replaces an existing rule, returns the new rule set version.
+func (m *Manager) Update(path string, rule *config.Rule) (int, error) {
+	return m.apply("update "+path, func(rules map[string]*config.Rule) error {
+		if _, ok := rules[path]; !ok {
+			return RuleNotFoundError
+		}
+		rules[path] = rule
+		return nil
+	})
+}
+
+// Delete removes a rule, returns the new rule set version.
+// Entries cached under the removed rule are not refreshed anymore and leave the cache by eviction.
+func (m *Manager) Delete(path string) (int, error) {
+	return m.apply("delete "+path, func(rules map[string]*config.Rule) error {
+		if _, ok := rules[path]; !ok {
+			return RuleNotFoundError
+		}
+		delete(rules, path)
+		return nil
+	})
+}
+
+// Versions returns stored rule set versions, newest first.
+func (m *Manager) Versions() ([]*Version, error) {
+	return m.history.list()
+}
+
+// Rollback applies the rule set of a previous version, the result is stored as a new version.
+func (m *Manager) Rollback(version int) (int, error) {
+	target, err := m.history.load(version)
+	if err != nil {
+		return 0, err
+	}
+	return m.apply(fmt.Sprintf("rollback to v%d", version), func(rules map[string]*config.Rule) error {
+		clear(rules)
+		for path, rule := range target.Rules {
+			rules[path] = rule
+		}
+		return nil
+	})
+}
+
+// Reload replaces the live rule set with the rules of a reloaded config file.
+// The change is stored as a new version but not written back (the file is already the source of it).
+func (m *Manager) Reload(cfg *config.Cache) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if same, err := isSameRuleSet(m.cfg.Rules(), cfg.Rules()); err == nil && same {
+		return
+	}
+	if _, err := m.replace("reload from file", cfg.Rules(), false); err != nil {
+		log.Error().Err(err).Msg("[rules] failed to apply reloaded rules")
+	}
+}
+
+// replace stores and applies a whole prepared rule set, m.mu must be held.
+func (m *Manager) replace(action string, rules map[string]*config.Rule, persist bool) (int, error) {
+	version, err := m.history.store(m.version+1, action, rules)
+	if err != nil {
+		return m.version, err
+	}
+	if persist {
+		live, changed := m.cfg.Rules(), make(map[string]bool)
+		for rulePath, rule := range rules {
+			changed[rulePath] = live[rulePath] != rule
+		}
+		if err = writeRules(m.cfg.Path, m.ownRules(rules), changed); err != nil {
+			m.history.remove(version)
+			return m.version, fmt.Errorf("write rules into %s: %w", m.cfg.Path, err)
+		}
+	}
+
+	m.cfg.SetRules(rules)
+	m.version = version
+	m.history.rotate()
+
+	log.Info().Msgf("[rules] rule set v%d applied (%s)", version, action)
+	return version, nil
+}
+
+// ownRules returns the rules which belong to the config file itself. Rules of include files are never
+// written into the config file (it would shadow nothing but duplicate them), their changes live until a restart.
+func (m *Manager) ownRules(rules map[string]*config.Rule) map[string]*config.Rule {
+	live := m.cfg.Rules()
+	own := make(map[string]*config.Rule, len(rules))
+	for path, rule := range rules {
+		source := m.cfg.RuleSource(path)
+		if source == "" {
+			own[path] = rule
+			continue
+		}
+		if live[path] != rule {
+			log.Warn().Msgf("[rules] rule %s is defined in %s, the change is not persisted", path, source)
+		}
+	}
+	return own
+}
+
+// apply copies the live rule set, mutates and validates the copy, persists it and swaps it in.
+func (m *Manager) apply(action string, mutate func(rules map[string]*config.Rule) error) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	live := m.cfg.Rules()
+	next := make(map[string]*config.Rule, len(live)+1)
+	for path, rule := range live {
+		next[path] = rule
+	}
+
+	if err := mutate(next); err != nil {
+		return m.version, err
+	}
+
+	var errs []error
+	for path, rule := range next {
+		if rule == nil {
+			errs = append(errs, fmt.Errorf("rule %q is empty", path))
+			continue
+		}
+		if err := rule.Validate(path); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		// rules are never mutated after publishing, so a changed rule is always a new object
+		if rule != live[path] {
+			config.PrepareRule(path, rule)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return m.version, err
+	}
+
+	return m.replace(action, next, m.cfg.Cache.Admin.Rules.Persist)
+}
+
+func isSameRuleSet(a, b map[string]*config.Rule) (bool, error) {
+	ab, err := yaml.Marshal(a)
+	if err != nil {
+		return false, err
+	}
+	bb, err := yaml.Marshal(b)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(ab, bb), nil
+}
@@ -0,0 +1,134 @@
+package rules
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Borislavv/advanced-cache/pkg/config"
+)
+
+const testConfig = `cache:
+  env: "test"
+  # proxy comment must survive write back
+  proxy:
+    from: "http://localhost:8080"
+    rate: 80
+  admin:
+    rules:
+      persist: true
+  rules:
+    /api/v2/pagedata:
+      cache_key:
+        query:
+          - project[id]
+          - domain
+`
+
+func newTestManager(t *testing.T) (*Manager, *config.Cache) {
+	dir := t.TempDir()
+	cfgPath := filepath.Join(dir, "cfg.yaml")
+	if err := os.WriteFile(cfgPath, []byte(testConfig), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := config.LoadConfig(cfgPath)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	cfg.Cache.Admin.Rules.HistoryDir = filepath.Join(dir, "history")
+
+	m, err := NewManager(cfg)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+	return m, cfg
+}
+
+func TestManagerChangesAndRollback(t *testing.T) {
+	m, cfg := newTestManager(t)
+	if m.Version() != 1 {
+		t.Fatalf("expected initial version 1, got %d", m.Version())
+	}
+
+	rule := &config.Rule{
+		CacheKey: config.RuleKey{Query: []string{"language"}, Headers: []string{"Accept-Encoding"}},
+		Refresh:  &config.RuleRefresh{Enabled: true, TTL: time.Hour, Beta: 0.4, Coefficient: 0.5},
+	}
+	if _, err := m.Create("/api/v1/pagecontent", rule); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := m.Create("/api/v1/pagecontent", rule); err != RuleExistsError {
+		t.Fatalf("expected RuleExistsError, got %v", err)
+	}
+	if got := cfg.Rule("/api/v1/pagecontent"); got == nil || string(got.PathBytes) != "/api/v1/pagecontent" {
+		t.Fatalf("created rule is not live or not prepared: %+v", got)
+	}
+
+	invalid := &config.Rule{Refresh: &config.RuleRefresh{Beta: 2}}
+	if _, err := m.Update("/api/v1/pagecontent", invalid); err == nil {
+		t.Fatal("expected validation error")
+	}
+	if cfg.Rule("/api/v1/pagecontent") != rule {
+		t.Fatal("invalid update must not be applied")
+	}
+
+	if _, err := m.Delete("/api/v2/pagedata"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if cfg.Rule("/api/v2/pagedata") != nil {
+		t.Fatal("deleted rule is still live")
+	}
+
+	data, err := os.ReadFile(cfg.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "/api/v2/pagedata") || !strings.Contains(string(data), "/api/v1/pagecontent") {
+		t.Fatalf("rules were not written back:\n%s", data)
+	}
+	if !strings.Contains(string(data), "# proxy comment must survive write back") {
+		t.Fatalf("comments were lost:\n%s", data)
+	}
+
+	version, err := m.Rollback(1)
+	if err != nil {
+		t.Fatalf("Rollback: %v", err)
+	}
+	if version != 4 {
+		t.Fatalf("expected rollback to be stored as v4, got v%d", version)
+	}
+	if cfg.Rule("/api/v2/pagedata") == nil || cfg.Rule("/api/v1/pagecontent") != nil {
+		t.Fatal("rollback did not restore the initial rule set")
+	}
+
+	versions, err := m.Versions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 4 || versions[0].Version != 4 {
+		t.Fatalf("unexpected versions: %d", len(versions))
+	}
+}
+
+func TestManagerFailedPersist(t *testing.T) {
+	m, cfg := newTestManager(t)
+	cfg.Path = filepath.Join(t.TempDir(), "missing", "cfg.yaml")
+
+	rule := &config.Rule{CacheKey: config.RuleKey{Query: []string{"language"}}}
+	if _, err := m.Create("/api/v1/pagecontent", rule); err == nil {
+		t.Fatal("expected the write of the rules to fail")
+	}
+	if m.Version() != 1 || cfg.Rule("/api/v1/pagecontent") != nil {
+		t.Fatal("a not persisted rule set must not be applied")
+	}
+	versions, err := m.Versions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 1 {
+		t.Fatalf("expected no history version of the failed change, got %d versions", len(versions))
+	}
+}
@@ -0,0 +1,119 @@
+package rules
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/Borislavv/advanced-cache/pkg/config"
+	"gopkg.in/yaml.v3"
+)
+
+// writeRules replaces the cache.rules section of the YAML config file, the rest of the document
+// (including comments) is kept as is. Rules which are not changed keep their nodes (comments, `extends`).
+// The file is replaced atomically.
+func writeRules(path string, rules map[string]*config.Rule, changed map[string]bool) error {
+	if path == "" {
+		return errors.New("config path is unknown")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var doc yaml.Node
+	if err = yaml.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return errors.New("config document is not a mapping")
+	}
+
+	cacheNode := mappingValue(doc.Content[0], "cache")
+	if cacheNode == nil || cacheNode.Kind != yaml.MappingNode {
+		return errors.New("config has no 'cache' section")
+	}
+
+	rulesNode := &yaml.Node{}
+	if err = rulesNode.Encode(rules); err != nil {
+		return fmt.Errorf("encode rules: %w", err)
+	}
+
+	existing := mappingValue(cacheNode, "rules")
+	if existing != nil && existing.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(rulesNode.Content); i += 2 {
+			rulePath := rulesNode.Content[i].Value
+			if changed[rulePath] {
+				continue
+			}
+			if node := mappingValue(existing, rulePath); node != nil {
+				rulesNode.Content[i+1] = node
+			}
+		}
+	}
+
+	if existing != nil {
+		rulesNode.HeadComment, rulesNode.LineComment, rulesNode.FootComment =
+			existing.HeadComment, existing.LineComment, existing.FootComment
+		*existing = *rulesNode
+	} else {
+		cacheNode.Content = append(cacheNode.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "rules"},
+			rulesNode,
+		)
+	}
+
+	buf := &bytes.Buffer{}
+	enc := yaml.NewEncoder(buf)
+	enc.SetIndent(2)
+	if err = enc.Encode(&doc); err != nil {
+		return err
+	}
+	if err = enc.Close(); err != nil {
+		return err
+	}
+
+	mode := os.FileMode(0o644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	return writeFileAtomic(path, buf.Bytes(), mode)
+}
+
+// mappingValue returns the value node of key in a mapping node or nil.
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// writeFileAtomic writes data into a temp file in the same dir and renames it over path.
+func writeFileAtomic(path string, data []byte, mode os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Borislavv/advanced-cache/pkg/config"
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	UnauthorizedError = errors.New("unauthorized")
+	ForbiddenError    = errors.New("forbidden")
+)
+
+// Authenticator checks credentials of a single admin request.
+type Authenticator interface {
+	// Authenticate returns nil if the request carries valid credentials of this kind.
+	Authenticate(ctx *fasthttp.RequestCtx) error
+}
+
+// Guard combines an optional network allowlist with a set of credential authenticators.
+// A request passes when its remote address is allowed and at least one authenticator accepts it.
+type Guard struct {
+	allowlist      *Allowlist
+	authenticators []Authenticator
+}
+
+// NewGuard builds a Guard from the admin auth config, loading tokens and secrets from files/env.
+func NewGuard(cfg config.AdminAuth) (*Guard, error) {
+	g := &Guard{}
+
+	if len(cfg.AllowCIDRs) > 0 {
+		allowlist, err := NewAllowlist(cfg.AllowCIDRs)
+		if err != nil {
+			return nil, fmt.Errorf("admin auth allowlist: %w", err)
+		}
+		g.allowlist = allowlist
+	}
+
+	if cfg.TokensFile != "" || cfg.TokensEnv != "" {
+		tokens, err := LoadBearerTokens(cfg.TokensFile, cfg.TokensEnv)
+		if err != nil {
+			return nil, fmt.Errorf("admin auth tokens: %w", err)
+		}
+		g.authenticators = append(g.authenticators, tokens)
+	}
+
+	if cfg.HMAC != nil {
+		signer, err := LoadHMAC(*cfg.HMAC)
+		if err != nil {
+			return nil, fmt.Errorf("admin auth hmac: %w", err)
+		}
+		g.authenticators = append(g.authenticators, signer)
+	}
+
+	return g, nil
+}
+
+// IsOpen reports whether the guard lets through any request (nothing is configured).
+func (g *Guard) IsOpen() bool {
+	return g.allowlist == nil && len(g.authenticators) == 0
+}
+
+// Check returns ForbiddenError if the remote address is not allowed
+// and UnauthorizedError if none of the authenticators accepted the request.
+func (g *Guard) Check(ctx *fasthttp.RequestCtx) error {
+	if g.allowlist != nil && !g.allowlist.Contains(ctx.RemoteIP()) {
+		return ForbiddenError
+	}
+	if len(g.authenticators) == 0 {
+		return nil
+	}
+	for _, a := range g.authenticators {
+		if a.Authenticate(ctx) == nil {
+			return nil
+		}
+	}
+	return UnauthorizedError
+}
@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"encoding/hex"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Borislavv/advanced-cache/pkg/config"
+	"github.com/valyala/fasthttp"
+)
+
+func newRequestCtx(method, uri, ip string, body []byte) *fasthttp.RequestCtx {
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod(method)
+	ctx.Request.SetRequestURI(uri)
+	ctx.Request.SetBody(body)
+	ctx.SetRemoteAddr(&net.TCPAddr{IP: net.ParseIP(ip), Port: 40000})
+	return ctx
+}
+
+func TestGuardBearerTokens(t *testing.T) {
+	t.Setenv("ADV_CACHE_TEST_TOKENS", "first, second")
+
+	guard, err := NewGuard(config.AdminAuth{TokensEnv: "ADV_CACHE_TEST_TOKENS"})
+	if err != nil {
+		t.Fatalf("NewGuard: %v", err)
+	}
+
+	ctx := newRequestCtx(fasthttp.MethodPost, "/cache/clear", "127.0.0.1", nil)
+	if err = guard.Check(ctx); err != UnauthorizedError {
+		t.Fatalf("expected UnauthorizedError without token, got %v", err)
+	}
+
+	ctx.Request.Header.Set(fasthttp.HeaderAuthorization, "Bearer wrong")
+	if err = guard.Check(ctx); err != UnauthorizedError {
+		t.Fatalf("expected UnauthorizedError for wrong token, got %v", err)
+	}
+
+	ctx.Request.Header.Set(fasthttp.HeaderAuthorization, "Bearer second")
+	if err = guard.Check(ctx); err != nil {
+		t.Fatalf("expected valid token to pass, got %v", err)
+	}
+}
+
+func TestGuardAllowlist(t *testing.T) {
+	guard, err := NewGuard(config.AdminAuth{AllowCIDRs: []string{"10.0.0.0/8", "127.0.0.1"}})
+	if err != nil {
+		t.Fatalf("NewGuard: %v", err)
+	}
+
+	if err = guard.Check(newRequestCtx(fasthttp.MethodGet, "/metrics", "10.1.2.3", nil)); err != nil {
+		t.Fatalf("expected 10.1.2.3 to be allowed, got %v", err)
+	}
+	if err = guard.Check(newRequestCtx(fasthttp.MethodGet, "/metrics", "127.0.0.1", nil)); err != nil {
+		t.Fatalf("expected 127.0.0.1 to be allowed, got %v", err)
+	}
+	if err = guard.Check(newRequestCtx(fasthttp.MethodGet, "/metrics", "192.168.0.1", nil)); err != ForbiddenError {
+		t.Fatalf("expected ForbiddenError for 192.168.0.1, got %v", err)
+	}
+}
+
+func TestGuardHMAC(t *testing.T) {
+	t.Setenv("ADV_CACHE_TEST_SECRET", "s3cr3t")
+
+	guard, err := NewGuard(config.AdminAuth{HMAC: &config.HMACAuth{SecretEnv: "ADV_CACHE_TEST_SECRET", MaxSkew: time.Minute}})
+	if err != nil {
+		t.Fatalf("NewGuard: %v", err)
+	}
+
+	sign := func(ctx *fasthttp.RequestCtx, secret string, at time.Time) {
+		ts := []byte(strconv.FormatInt(at.Unix(), 10))
+		sig := Sign([]byte(secret), ctx.Method(), ctx.Path(), ctx.QueryArgs().QueryString(), ts, ctx.PostBody())
+		ctx.Request.Header.SetBytesV(TimestampHeader, ts)
+		ctx.Request.Header.Set(SignatureHeader, hex.EncodeToString(sig))
+	}
+
+	ctx := newRequestCtx(fasthttp.MethodPost, "/cache/off?reason=test", "127.0.0.1", []byte(`{}`))
+	sign(ctx, "s3cr3t", time.Now())
+	if err = guard.Check(ctx); err != nil {
+		t.Fatalf("expected signed request to pass, got %v", err)
+	}
+
+	ctx = newRequestCtx(fasthttp.MethodPost, "/cache/off?reason=test", "127.0.0.1", []byte(`{}`))
+	sign(ctx, "another", time.Now())
+	if err = guard.Check(ctx); err != UnauthorizedError {
+		t.Fatalf("expected UnauthorizedError for wrong secret, got %v", err)
+	}
+
+	ctx = newRequestCtx(fasthttp.MethodPost, "/cache/off?reason=test", "127.0.0.1", []byte(`{}`))
+	sign(ctx, "s3cr3t", time.Now().Add(-time.Hour))
+	if err = guard.Check(ctx); err != UnauthorizedError {
+		t.Fatalf("expected UnauthorizedError for expired signature, got %v", err)
+	}
+}
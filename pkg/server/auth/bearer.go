@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+var bearerPrefix = []byte("Bearer ")
+
+// BearerTokens accepts requests with "Authorization: Bearer <token>" matching one of the static tokens.
+type BearerTokens struct {
+	tokens [][]byte
+}
+
+// LoadBearerTokens reads tokens from a file (one per line, '#' starts a comment)
+// and from a comma separated env variable. At least one token must be found.
+func LoadBearerTokens(file, env string) (*BearerTokens, error) {
+	b := &BearerTokens{}
+
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, fmt.Errorf("open tokens file: %w", err)
+		}
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			b.tokens = append(b.tokens, []byte(line))
+		}
+		if err = scanner.Err(); err != nil {
+			return nil, fmt.Errorf("read tokens file: %w", err)
+		}
+	}
+
+	if env != "" {
+		for _, token := range strings.Split(os.Getenv(env), ",") {
+			if token = strings.TrimSpace(token); token != "" {
+				b.tokens = append(b.tokens, []byte(token))
+			}
+		}
+	}
+
+	if len(b.tokens) == 0 {
+		return nil, errors.New("no bearer tokens found")
+	}
+	return b, nil
+}
+
+func (b *BearerTokens) Authenticate(ctx *fasthttp.RequestCtx) error {
+	header := ctx.Request.Header.Peek(fasthttp.HeaderAuthorization)
+	if !bytes.HasPrefix(header, bearerPrefix) {
+		return UnauthorizedError
+	}
+	provided := bytes.TrimSpace(header[len(bearerPrefix):])
+
+	// compare against every token to not leak which one matched by timing
+	matched := 0
+	for _, token := range b.tokens {
+		matched |= subtle.ConstantTimeCompare(provided, token)
+	}
+	if matched == 1 {
+		return nil
+	}
+	return UnauthorizedError
+}
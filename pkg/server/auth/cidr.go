@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Allowlist is a set of networks admin requests may come from.
+type Allowlist struct {
+	nets []*net.IPNet
+}
+
+// NewAllowlist parses CIDRs; a bare IP is treated as a single host network.
+func NewAllowlist(cidrs []string) (*Allowlist, error) {
+	l := &Allowlist{nets: make([]*net.IPNet, 0, len(cidrs))}
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid ip %q", cidr)
+			}
+			if ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cidr %q: %w", cidr, err)
+		}
+		l.nets = append(l.nets, ipNet)
+	}
+	return l, nil
+}
+
+// Contains reports whether ip belongs to any of the allowed networks.
+func (l *Allowlist) Contains(ip net.IP) bool {
+	for _, n := range l.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -14,18 +14,34 @@ import (
 )
 
 type HTTP struct {
-	ctx    context.Context
-	config *config.Cache
-	server *fasthttp.Server
+	ctx     context.Context
+	config  *config.Cache
+	server  *fasthttp.Server
+	name    string
+	port    string
+	getOnly bool
 }
 
+// New creates the public (proxy) server which serves GET requests only on cfg.Cache.Proxy.To.
 func New(
 	ctx context.Context,
 	config *config.Cache,
 	controllers []controller.HttpController,
 	middlewares []middleware.HttpMiddleware,
 ) (*HTTP, error) {
-	s := &HTTP{ctx: ctx, config: config}
+	s := &HTTP{ctx: ctx, config: config, name: config.Cache.Proxy.Name, port: config.Cache.Proxy.To, getOnly: true}
+	s.initServer(s.buildRouter(controllers), middlewares)
+	return s, nil
+}
+
+// NewAdmin creates the management server on cfg.Cache.Admin.Addr, it accepts any http method.
+func NewAdmin(
+	ctx context.Context,
+	config *config.Cache,
+	controllers []controller.HttpController,
+	middlewares []middleware.HttpMiddleware,
+) (*HTTP, error) {
+	s := &HTTP{ctx: ctx, config: config, name: config.Cache.Admin.Name, port: config.Cache.Admin.Addr}
 	s.initServer(s.buildRouter(controllers), middlewares)
 	return s, nil
 }
@@ -44,8 +60,8 @@ func (s *HTTP) ListenAndServe() {
 func (s *HTTP) serve(wg *sync.WaitGroup) {
 	defer wg.Done()
 
-	name := s.config.Cache.Proxy.Name
-	port := s.config.Cache.Proxy.To
+	name := s.name
+	port := s.port
 
 	log.Info().Msgf("[server] %v was started (port: %v)", name, port)
 	defer log.Info().Msgf("[server] %v was stopped (port: %v)", name, port)
@@ -65,7 +81,7 @@ func (s *HTTP) shutdown(wg *sync.WaitGroup) {
 
 	if err := s.server.ShutdownWithContext(ctx); err != nil {
 		if !errors.Is(err, context.Canceled) {
-			log.Warn().Msgf("[server] %v shutdown failed: %v", s.config.Cache.Proxy.Name, err.Error())
+			log.Warn().Msgf("[server] %v shutdown failed: %v", s.name, err.Error())
 		}
 		return
 	}
@@ -103,7 +119,7 @@ func (s *HTTP) mergeMiddlewares(
 
 func (s *HTTP) initServer(r *router.Router, middlewares []middleware.HttpMiddleware) {
 	s.server = &fasthttp.Server{
-		GetOnly:                       true,
+		GetOnly:                       s.getOnly,
 		ReduceMemoryUsage:             true,
 		DisablePreParseMultipartForm:  true,
 		DisableHeaderNamesNormalizing: true,
@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"errors"
+
+	"github.com/Borislavv/advanced-cache/pkg/server/auth"
+	"github.com/rs/zerolog/log"
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	unauthorizedResponseBytes = []byte(`{"status":401,"error":"Unauthorized"}`)
+	forbiddenResponseBytes    = []byte(`{"status":403,"error":"Forbidden"}`)
+)
+
+// AuthMiddleware rejects admin requests which didn't pass the auth.Guard.
+// Paths from the skip list (e.g. the k8s probe) are served without checks.
+type AuthMiddleware struct {
+	guard *auth.Guard
+	skip  map[string]struct{}
+}
+
+func NewAuthMiddleware(guard *auth.Guard, skipPaths ...string) AuthMiddleware {
+	skip := make(map[string]struct{}, len(skipPaths))
+	for _, path := range skipPaths {
+		skip[path] = struct{}{}
+	}
+	return AuthMiddleware{guard: guard, skip: skip}
+}
+
+func (m AuthMiddleware) Middleware(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		if _, ok := m.skip[string(ctx.Path())]; ok {
+			next(ctx)
+			return
+		}
+
+		if err := m.guard.Check(ctx); err != nil {
+			log.Warn().
+				Str("ip", ctx.RemoteIP().String()).
+				Str("method", string(ctx.Method())).
+				Str("path", string(ctx.Path())).
+				Msgf("[admin] request rejected: %s", err.Error())
+
+			if errors.Is(err, auth.ForbiddenError) {
+				ctx.SetStatusCode(fasthttp.StatusForbidden)
+				_, _ = ctx.Write(forbiddenResponseBytes)
+			} else {
+				ctx.SetStatusCode(fasthttp.StatusUnauthorized)
+				_, _ = ctx.Write(unauthorizedResponseBytes)
+			}
+			return
+		}
+
+		next(ctx)
+	}
+}
@@ -1,18 +1,15 @@
 package storage
 
 import (
-	"bufio"
-	"compress/gzip"
 	"context"
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"github.com/Borislavv/advanced-cache/pkg/upstream"
-	"hash/crc32"
-	"io"
 	"os"
 	"path/filepath"
+	"runtime"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -20,11 +17,19 @@ import (
 
 	"github.com/Borislavv/advanced-cache/pkg/config"
 	"github.com/Borislavv/advanced-cache/pkg/model"
+	"github.com/Borislavv/advanced-cache/pkg/prometheus/metrics"
+	"github.com/Borislavv/advanced-cache/pkg/storage/lru"
 	sharded "github.com/Borislavv/advanced-cache/pkg/storage/map"
+	"github.com/Borislavv/advanced-cache/pkg/storage/snapshot"
+	"github.com/Borislavv/advanced-cache/pkg/storage/wal"
 	"github.com/rs/zerolog/log"
+	"golang.org/x/time/rate"
 )
 
-var errDumpNotEnabled = errors.New("persistence mode is not enabled")
+var (
+	errDumpNotEnabled         = errors.New("persistence mode is not enabled")
+	errMemoryThresholdReached = errors.New("memory threshold is reached")
+)
 
 type Dumper interface {
 	Dump(ctx context.Context) error
@@ -33,200 +38,474 @@ type Dumper interface {
 }
 
 type Dump struct {
+	mu      sync.Mutex // dumps are taken one at a time
 	cfg     *config.Cache
 	storage Storage
 	backend upstream.Gateway
+	wal     *wal.Log      // compacted by finished dumps, may be nil
+	meter   metrics.Meter // reports restores, may be nil
 }
 
 func NewDumper(cfg *config.Cache, storage Storage, backend upstream.Gateway) *Dump {
 	return &Dump{cfg: cfg, storage: storage, backend: backend}
 }
 
+// SetWAL sets up the write-ahead log which is compacted whenever a dump is finished.
+func (d *Dump) SetWAL(l *wal.Log) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.wal = l
+}
+
+// SetMeter sets up the meter restores are reported to.
+func (d *Dump) SetMeter(m metrics.Meter) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.meter = m
+}
+
+// dumpLimits cap resources used by a dump, zero values mean no limits.
+type dumpLimits struct {
+	workers  int           // files written concurrently
+	entries  *rate.Limiter // entries per second
+	bytes    *rate.Limiter // bytes per second
+	progress *Progress     // written entries, may be nil
+}
+
+// newDumpLimits returns the limits of scheduled snapshots.
+func newDumpLimits(schedule config.DumpSchedule) dumpLimits {
+	limits := dumpLimits{workers: schedule.Workers}
+	if schedule.Rate > 0 {
+		limits.entries = rate.NewLimiter(rate.Limit(schedule.Rate), max(schedule.Rate/10, 1))
+	}
+	if schedule.BytesRate > 0 {
+		limits.bytes = rate.NewLimiter(rate.Limit(schedule.BytesRate), max(schedule.BytesRate/10, dumpBufferSize))
+	}
+	return limits
+}
+
+// wait blocks until a record of the given size may be written.
+func (l dumpLimits) wait(ctx context.Context, size int) error {
+	if l.entries != nil {
+		if err := l.entries.Wait(ctx); err != nil {
+			return err
+		}
+	}
+	if l.bytes != nil {
+		for size > 0 {
+			n := min(size, l.bytes.Burst())
+			if err := l.bytes.WaitN(ctx, n); err != nil {
+				return err
+			}
+			size -= n
+		}
+	}
+	return nil
+}
+
+// semaphore returns a channel limiting the number of concurrent writers to the workers limit (or n).
+func (l dumpLimits) semaphore(n int) chan struct{} {
+	if l.workers > 0 {
+		n = min(n, l.workers)
+	}
+	return make(chan struct{}, max(n, 1))
+}
+
 func (d *Dump) Dump(ctx context.Context) error {
+	_, err := d.dump(ctx, dumpLimits{})
+	return err
+}
+
+// dump writes a new version dir, a dump interrupted by the context is removed. Returns the number of written entries.
+func (d *Dump) dump(ctx context.Context, limits dumpLimits) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
 	start := time.Now()
 	cfg := d.cfg.Cache.Persistence.Dump
 	if !d.cfg.Cache.Enabled || !cfg.IsEnabled {
-		return errDumpNotEnabled
+		return 0, errDumpNotEnabled
 	}
-	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
-		return fmt.Errorf("create base dump dir: %w", err)
+
+	versionDir, err := NewDumpVersionDir(cfg.Dir)
+	if err != nil {
+		return 0, err
+	}
+	// The version is incomplete until the manifest is replaced by the one of the finished dump.
+	manifest := newManifest(config.DumpFormatSnapshot, NewSnapshotOptions(d.cfg).Codec.Name(), d.cfg.RulesHash())
+	if cfg.Format == config.DumpFormatLegacy {
+		manifest.Format, manifest.Codec = config.DumpFormatLegacy, ""
+		if cfg.Gzip {
+			manifest.Codec = config.DumpCodecGzip
+		}
+	}
+	if err = WriteManifest(versionDir, manifest); err != nil {
+		_ = os.RemoveAll(versionDir)
+		return 0, fmt.Errorf("write manifest: %w", err)
 	}
 
-	versionDir := filepath.Join(cfg.Dir, fmt.Sprintf("v%d", nextVersionDir(cfg.Dir)))
-	if err := os.MkdirAll(versionDir, 0o755); err != nil {
-		return fmt.Errorf("create version dir: %w", err)
+	// Changes made from now on go into a new wal segment, the previous segments are covered by this dump.
+	var walSeq uint64
+	if d.wal != nil {
+		if walSeq, err = d.wal.Rotate(); err != nil {
+			log.Warn().Err(err).Msg("[dump] wal rotation failed, the wal will not be compacted")
+		}
+	}
+
+	var (
+		files             []ManifestFile
+		success, failures int32
+	)
+	if cfg.Format == config.DumpFormatLegacy {
+		files, success, failures = d.dumpShardFiles(ctx, versionDir, limits)
+	} else {
+		files, success, failures = d.dumpSnapshot(ctx, versionDir, limits)
+	}
+
+	if err = ctx.Err(); err != nil {
+		_ = os.RemoveAll(versionDir)
+		log.Warn().Err(err).Str("dir", versionDir).Msg("[dump] interrupted, the incomplete version is removed")
+		return 0, err
+	}
+
+	var dumpErr error
+	if failures > 0 {
+		dumpErr = fmt.Errorf("dump finished with %d errors", failures)
+	}
+	manifest.finish(files, dumpErr)
+	if err = WriteManifest(versionDir, manifest); err != nil {
+		log.Error().Err(err).Str("dir", versionDir).Msg("[dump] manifest write error, the version stays incomplete")
+		if dumpErr == nil {
+			dumpErr = fmt.Errorf("write manifest: %w", err)
+		}
 	}
-	timestamp := time.Now().Format("20060102T150405")
+
+	applyRetention(cfg, time.Now())
+
+	log.Info().Msgf("[dump] finished: %d entries, errors: %d, elapsed: %s", success, failures, time.Since(start))
+	if dumpErr != nil {
+		return int(success), dumpErr
+	}
+	if walSeq > 0 {
+		if err = d.wal.Compact(walSeq); err != nil {
+			log.Error().Err(err).Msg("[dump] wal compaction failed")
+		}
+	}
+	return int(success), nil
+}
+
+// dumpSnapshot writes the storage into snapshot files, shards are distributed between segments by their keys.
+func (d *Dump) dumpSnapshot(ctx context.Context, versionDir string, limits dumpLimits) (files []ManifestFile, success, failures int32) {
+	cfg := d.cfg.Cache.Persistence.Dump
+	opts := NewSnapshotOptions(d.cfg)
+	keys, err := LoadDumpKeys(d.cfg)
+	if err != nil {
+		log.Error().Err(err).Msg("[dump] encryption keys are not loaded")
+		return nil, 0, 1
+	}
+	opts.Keys = keys
+
+	writers, err := newSnapshotWriters(versionDir, cfg.Name, opts)
+	if err != nil {
+		log.Error().Err(err).Str("dir", versionDir).Msg("[dump] create error")
+		return nil, 0, 1
+	}
+
+	// Entries are written hottest first, so a restore limited by memory keeps the most valuable ones.
+	segments := make([][]lru.HotEntry, len(writers))
+	for _, hot := range d.storage.Hottest(ctx) {
+		segment := hot.Entry.ShardKey() % uint64(len(writers))
+		segments[segment] = append(segments[segment], hot)
+	}
+
 	var wg sync.WaitGroup
-	var success, failures int32
+	sem := limits.semaphore(len(writers))
+	for i, w := range writers {
+		wg.Add(1)
+		go func(w *SnapshotFileWriter, entries []lru.HotEntry) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			n, err := writeHot(ctx, entries, w, limits)
+			atomic.AddInt32(&success, int32(n))
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Error().Err(err).Str("file", w.name).Msg("[dump] write error")
+				}
+				atomic.AddInt32(&failures, 1)
+			}
+			if err = w.Close(); err != nil {
+				log.Error().Err(err).Str("file", w.name).Msg("[dump] close error")
+				atomic.AddInt32(&failures, 1)
+			}
+		}(w, segments[i])
+	}
+	wg.Wait()
+
+	// Writers are in the segment order, so are files of the manifest.
+	for _, w := range writers {
+		files = append(files, w.Summary())
+	}
+	return files, success, failures
+}
+
+// writeHot writes the entries with their heat in the given order. Returns the number of written entries.
+func writeHot(ctx context.Context, entries []lru.HotEntry, w *SnapshotFileWriter, limits dumpLimits) (int, error) {
+	for i, hot := range entries {
+		data, release := hot.Entry.ToBytes()
+		err := limits.wait(ctx, len(data))
+		if err == nil {
+			err = w.WriteHot(data, hot.Heat)
+		}
+		release()
+		if err != nil {
+			return i, err
+		}
+		limits.progress.Add(1)
+	}
+	return len(entries), ctx.Err()
+}
+
+// dumpShardFiles writes the storage in the legacy format: a file per shard.
+func (d *Dump) dumpShardFiles(ctx context.Context, versionDir string, limits dumpLimits) (files []ManifestFile, success, failures int32) {
+	cfg := d.cfg.Cache.Persistence.Dump
+	timestamp := dumpTimestamp(time.Now())
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+	sem := limits.semaphore(int(sharded.NumOfShards))
 
 	d.storage.WalkShards(ctx, func(shardKey uint64, shard *sharded.Shard[*model.Entry]) {
 		wg.Add(1)
 		go func(key uint64, s *sharded.Shard[*model.Entry]) {
 			defer wg.Done()
-			ext := ".dump"
-			if cfg.Gzip {
-				ext += ".gz"
-			}
-			name := fmt.Sprintf("%s/%s-shard-%d-%s%s", versionDir, cfg.Name, key, timestamp, ext)
-			tmp := name + ".tmp"
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			name := dumpFileName(versionDir, cfg.Name, key, timestamp, cfg.Gzip)
 
-			f, err := os.Create(tmp)
+			w, err := NewDumpFileWriter(name, cfg.Crc32Control)
 			if err != nil {
-				log.Error().Err(err).Str("file", tmp).Msg("[dump] create error")
+				log.Error().Err(err).Str("file", name).Msg("[dump] create error")
 				atomic.AddInt32(&failures, 1)
 				return
 			}
-			var (
-				writer io.Writer = f
-				gw     *gzip.Writer
-			)
-			if cfg.Gzip {
-				gw = gzip.NewWriter(f)
-				writer = gw
-			}
-			bw := bufio.NewWriterSize(writer, 512*1024)
-
-			s.Walk(ctx, func(_ uint64, e *model.Entry) bool {
-				data, release := e.ToBytes()
-				defer release()
-				var crc uint32
-				if cfg.Crc32Control {
-					crc = crc32.ChecksumIEEE(data)
-				}
 
-				var lenBuf [8]byte
-				binary.LittleEndian.PutUint32(lenBuf[0:4], uint32(len(data)))
-				binary.LittleEndian.PutUint32(lenBuf[4:8], crc)
-				if _, err := bw.Write(lenBuf[:]); err != nil {
-					atomic.AddInt32(&failures, 1)
-					return true
-				}
-				if _, err := bw.Write(data); err != nil {
-					atomic.AddInt32(&failures, 1)
-					return true
-				}
-				atomic.AddInt32(&success, 1)
-				return true
-			}, true)
+			n, err := writeShard(ctx, s, w, limits)
+			atomic.AddInt32(&success, int32(n))
+			if err != nil {
+				atomic.AddInt32(&failures, 1)
+			}
 
-			_ = bw.Flush()
-			if gw != nil {
-				_ = gw.Close()
+			if err = w.Close(); err != nil {
+				log.Error().Err(err).Str("file", name).Msg("[dump] close error")
+				atomic.AddInt32(&failures, 1)
+				return
 			}
-			_ = f.Close()
-			_ = os.Rename(tmp, name)
+			mu.Lock()
+			files = append(files, w.Summary())
+			mu.Unlock()
 		}(shardKey, shard)
 	})
 
 	wg.Wait()
-	if cfg.MaxVersions > 0 {
-		rotateVersionDirs(cfg.Dir, cfg.MaxVersions)
-	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return files, success, failures
+}
 
-	log.Info().Msgf("[dump] finished: %d entries, errors: %d, elapsed: %s", success, failures, time.Since(start))
-	if failures > 0 {
-		return fmt.Errorf("dump finished with %d errors", failures)
+// writeShard writes entries of the shard. Entries are collected under the shard lock and written without it,
+// so throttled dumps don't block the traffic. Returns the number of written entries.
+func writeShard(ctx context.Context, shard *sharded.Shard[*model.Entry], w DumpWriter, limits dumpLimits) (int, error) {
+	entries := make([]*model.Entry, 0, shard.Len())
+	shard.Walk(ctx, func(_ uint64, e *model.Entry) bool {
+		entries = append(entries, e)
+		return true
+	}, false)
+
+	for i, e := range entries {
+		data, release := e.ToBytes()
+		err := limits.wait(ctx, len(data))
+		if err == nil {
+			err = w.Write(data)
+		}
+		release()
+		if err != nil {
+			return i, err
+		}
+		limits.progress.Add(1)
 	}
-	return nil
+	return len(entries), ctx.Err()
 }
 
+// Load restores the newest complete version, incomplete ones (interrupted or failed dumps) are skipped.
 func (d *Dump) Load(ctx context.Context) error {
 	cfg := d.cfg.Cache.Persistence.Dump
-	dir := getLatestVersionDir(cfg.Dir)
-	if dir == "" {
-		return fmt.Errorf("no versioned dump dirs found in %s", cfg.Dir)
+	versions, err := ListDumpVersions(cfg.Dir, cfg.Name)
+	if err != nil {
+		return fmt.Errorf("no versioned dump dirs found in %s: %w", cfg.Dir, err)
 	}
-	return d.load(ctx, dir)
+	for _, v := range versions {
+		if err = v.Loadable(); err != nil {
+			log.Warn().Err(err).Str("dir", v.Dir).Msg("[dump] version skipped, falling back to the previous one")
+			continue
+		}
+		if v.Manifest == nil {
+			log.Info().Str("dir", v.Dir).Msg("[dump] version has no manifest, loaded as is")
+		}
+		return d.load(ctx, v.Dir, nil)
+	}
+	return fmt.Errorf("no complete dump versions found in %s", cfg.Dir)
 }
 
 func (d *Dump) LoadVersion(ctx context.Context, v string) error {
 	dir := filepath.Join(d.cfg.Cache.Persistence.Dump.Dir, v)
-	return d.load(ctx, dir)
+	return d.load(ctx, dir, nil)
 }
 
-func (d *Dump) load(ctx context.Context, dir string) error {
+// load restores the version dir, progress (may be nil) counts read records.
+func (d *Dump) load(ctx context.Context, dir string, progress *Progress) error {
 	start := time.Now()
 	cfg := d.cfg.Cache.Persistence.Dump
 
-	pattern := filepath.Join(dir, fmt.Sprintf("%s-shard-*.dump*", cfg.Name))
-	files, _ := filepath.Glob(pattern)
-	if len(files) == 0 {
+	parts := DumpParts(dir, cfg.Name)
+	if len(parts) == 0 {
 		return fmt.Errorf("no dump files found in %s", dir)
 	}
-	ts := extractLatestTimestamp(files)
-	files = filterFilesByTimestamp(files, ts)
+	keys, err := LoadDumpKeys(d.cfg)
+	if err != nil {
+		return err
+	}
 
-	var wg sync.WaitGroup
-	var success, failures int32
+	var (
+		keyErr            error
+		wg                sync.WaitGroup
+		success, failures int32
+		rekeyed, dropped  int32
+		rulesHash         = d.cfg.RulesHash()
+		// Records are written hottest first: the restore stops when the memory threshold is reached,
+		// so the coldest entries are dropped instead of random ones dropped by the admission.
+		budget   = d.cfg.MemoryThreshold() - d.storage.RealMem()
+		full     atomic.Bool
+		mu       sync.Mutex
+		restored []lru.HotEntry
+		heated   atomic.Bool
+		restore  = newRestorer(d.cfg)
+		// Files are read in parallel, the rest of CPUs decompress blocks of each file.
+		workers = max(runtime.GOMAXPROCS(0)/len(parts), 1)
+	)
 
-	for _, file := range files {
+	for _, part := range parts {
 		wg.Add(1)
-		go func(fn string) {
+		go func(part DumpPart) {
 			defer wg.Done()
 
-			f, err := os.Open(fn)
-			if err != nil {
-				log.Error().Err(err).Str("file", fn).Msg("[load] open error")
-				atomic.AddInt32(&failures, 1)
+			var (
+				rk     *rekeyer
+				mapped *mappedPayloads // non-nil if records alias the mapped file
+			)
+			err := part.Read(DumpReadOptions{
+				Crc32Control: cfg.Crc32Control,
+				Workers:      workers,
+				Keys:         keys,
+				Mmap:         cfg.Mmap,
+				Mapped: func(m *snapshot.Mapping) {
+					mapped = newMappedPayloads(m)
+				},
+				Header: func(h snapshot.Header) error {
+					if h.RulesHash != rulesHash {
+						log.Info().Str("file", part.File).Msg("[load] snapshot was made with another rule set, entries of rules with changed keys are re-keyed")
+					}
+					rk = newRekeyer(d.cfg, h)
+					return nil
+				},
+				Record: func(buf []byte, heat Heat) error {
+					if full.Load() {
+						return errMemoryThresholdReached
+					}
+					progress.Add(1)
+					e, err := model.EntryFromBytes(buf, d.cfg, d.backend)
+					if err == nil {
+						var changed bool
+						if changed, err = rk.rekey(e); changed && err == nil {
+							atomic.AddInt32(&rekeyed, 1)
+						}
+					}
+					if model.IsRouteWasNotFound(err) {
+						atomic.AddInt32(&dropped, 1) // the rule was removed
+						return nil
+					}
+					if err != nil {
+						log.Error().Err(err).Str("file", part.File).Msg("[load] entry decode error")
+						atomic.AddInt32(&failures, 1)
+						return nil
+					}
+					if !restore.admit(e) {
+						return nil // expired
+					}
+					if mapped != nil {
+						mapped.alias(e) // before the entry is visible
+					}
+					if d.storage.Set(e) {
+						atomic.AddInt32(&success, 1)
+						if atomic.AddInt64(&budget, -e.Weight()) <= 0 {
+							full.Store(true)
+						}
+						if heat.AccessedAt != 0 {
+							heated.Store(true)
+						}
+						mu.Lock()
+						restored = append(restored, lru.HotEntry{Entry: e, Heat: heat})
+						mu.Unlock()
+					}
+					return ctx.Err()
+				},
+				Corrupt: func(err error) {
+					log.Error().Err(err).Str("file", part.File).Msg("[load] corrupted data skipped")
+					atomic.AddInt32(&failures, 1)
+				},
+			})
+			if errors.Is(err, snapshot.KeyError) {
+				mu.Lock()
+				keyErr = fmt.Errorf("%s: %w", part.File, err)
+				mu.Unlock()
 				return
 			}
-			defer f.Close()
-
-			var reader io.Reader = f
-			if strings.HasSuffix(fn, ".gz") {
-				gzr, err := gzip.NewReader(f)
-				if err != nil {
-					log.Error().Err(err).Str("file", fn).Msg("[load] gzip open error")
-					atomic.AddInt32(&failures, 1)
-					return
-				}
-				defer gzr.Close()
-				reader = gzr
+			if err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, errMemoryThresholdReached) {
+				log.Error().Err(err).Str("file", part.File).Msg("[load] read error")
+				atomic.AddInt32(&failures, 1)
 			}
+		}(part)
+	}
+	wg.Wait()
 
-			br := bufio.NewReaderSize(reader, 512*1024)
-			var metaBuf [8]byte
-			for {
-				if _, err := io.ReadFull(br, metaBuf[:]); err == io.EOF {
-					break
-				} else if err != nil {
-					log.Error().Err(err).Str("file", fn).Msg("[load] read meta error")
-					atomic.AddInt32(&failures, 1)
-					break
-				}
-
-				sz := binary.LittleEndian.Uint32(metaBuf[0:4])
-				expCRC := binary.LittleEndian.Uint32(metaBuf[4:8])
-				buf := make([]byte, sz)
-				if _, err := io.ReadFull(br, buf); err != nil {
-					log.Error().Err(err).Str("file", fn).Msg("[load] read entry error")
-					atomic.AddInt32(&failures, 1)
-					break
-				}
-				if cfg.Crc32Control && crc32.ChecksumIEEE(buf) != expCRC {
-					log.Error().Str("file", fn).Msg("[load] crc mismatch")
-					atomic.AddInt32(&failures, 1)
-					continue
-				}
-				e, err := model.EntryFromBytes(buf, d.cfg, d.backend)
-				if err != nil {
-					log.Error().Err(err).Str("file", fn).Msg("[load] entry decode error")
-					atomic.AddInt32(&failures, 1)
-					continue
-				}
-				d.storage.Set(e)
-				atomic.AddInt32(&success, 1)
-				select {
-				case <-ctx.Done():
-					return
-				default:
-				}
-			}
-		}(file)
+	if keyErr != nil {
+		// Entries of files with another key are missing, the instance must not serve a partial cache silently.
+		return keyErr
+	}
+	if rekeyed > 0 || dropped > 0 {
+		log.Info().Msgf("[dump] rules changed since the dump: %d entries re-keyed, %d entries without a rule dropped", rekeyed, dropped)
+	}
+	if full.Load() {
+		log.Warn().Msgf("[dump] memory threshold is reached, the rest (the coldest entries) is skipped")
+	}
+	stats, queue := restore.classify(restored)
+	if heated.Load() {
+		d.restoreLruOrder(restored)
+	}
+	log.Info().Msgf("[dump] restored entries by staleness: fresh: %d, queued for refresh: %d (stale: %d), expired dropped: %d",
+		stats.Fresh, stats.RefreshQueued, stats.Stale, stats.Dropped)
+	if d.meter != nil {
+		d.meter.SetRestore(stats.Fresh, stats.RefreshQueued, stats.Stale, stats.Dropped)
+	}
+	if len(queue) > 0 && cfg.Restore.RefreshRate > 0 {
+		// The refresh goes on in background while the instance is serving, stale entries are revalidated on hits meanwhile.
+		go d.refreshRestored(ctx, queue)
 	}
 
-	wg.Wait()
+	if cfg.Mmap {
+		log.Info().Msgf("[dump] snapshot files mapped: %d bytes, payloads are copied to the heap on refreshes and updates", snapshot.MappedBytes())
+	}
 	log.Info().Msgf("[dump] restored: %d entries, errors: %d, elapsed: %s", success, failures, time.Since(start))
 	if failures > 0 {
 		return fmt.Errorf("load finished with %d errors", failures)
@@ -234,53 +513,72 @@ func (d *Dump) load(ctx context.Context, dir string) error {
 	return nil
 }
 
+// restoreLruOrder touches the restored entries from the least to the most recently used,
+// so LRU lists of shards get the order they had when the dump was taken, access times and TinyLFU
+// estimates are restored too.
+func (d *Dump) restoreLruOrder(restored []lru.HotEntry) {
+	sort.SliceStable(restored, func(i, j int) bool { return restored[i].Heat.AccessedAt < restored[j].Heat.AccessedAt })
+	for _, hot := range restored {
+		d.storage.RestoreHeat(hot)
+	}
+}
+
 // nextVersionDir picks the next sequential version number.
 func nextVersionDir(baseDir string) int {
 	entries, _ := filepath.Glob(filepath.Join(baseDir, "v*"))
 	maxV := 0
 	for _, dir := range entries {
-		name := filepath.Base(dir)
-		if !strings.HasPrefix(name, "v") {
-			continue
-		}
-		var v int
-		fmt.Sscanf(name, "v%d", &v)
-		if v > maxV {
-			maxV = v
-		}
+		maxV = max(maxV, versionNumber(filepath.Base(dir)))
 	}
 	return maxV + 1
 }
 
-// rotateVersionDirs keeps only the newest `max` dirs, removes the rest.
-func rotateVersionDirs(baseDir string, max int) {
-	entries, _ := filepath.Glob(filepath.Join(baseDir, "v*"))
-	if len(entries) <= max {
-		return
-	}
-	sort.Slice(entries, func(i, j int) bool {
-		fi, _ := os.Stat(entries[i])
-		fj, _ := os.Stat(entries[j])
-		return fi.ModTime().After(fj.ModTime())
-	})
-	for _, dir := range entries[max:] {
-		os.RemoveAll(dir)
-		log.Info().Msgf("[dump] removed old dump dir: %s", dir)
+// versionNumber parses the number of a version dir name, 0 if it's not one.
+func versionNumber(name string) int {
+	if !strings.HasPrefix(name, "v") {
+		return 0
 	}
+	v, _ := strconv.Atoi(name[1:])
+	return v
 }
 
-// getLatestVersionDir returns the most recently modified version dir.
-func getLatestVersionDir(baseDir string) string {
-	entries, _ := filepath.Glob(filepath.Join(baseDir, "v*"))
-	if len(entries) == 0 {
-		return ""
+// applyRetention removes versions beyond max_versions, older than max_age or exceeding max_size in total
+// (counted from the newest one), the newest complete version is always kept. Incomplete versions older
+// than it are removed too, newer ones may be dumps in progress.
+func applyRetention(cfg *config.Dump, now time.Time) {
+	versions, err := ListDumpVersions(cfg.Dir, cfg.Name)
+	if err != nil {
+		return
+	}
+	var (
+		kept int
+		size int64
+	)
+	for _, v := range versions {
+		complete := v.Loadable() == nil
+		remove := kept > 0 && !complete
+		if kept > 0 && complete {
+			created := v.ModTime
+			if v.Manifest != nil {
+				created = v.Manifest.Started
+			}
+			remove = (cfg.MaxVersions > 0 && kept >= cfg.MaxVersions) ||
+				(cfg.MaxAge > 0 && now.Sub(created) > cfg.MaxAge) ||
+				(cfg.MaxSize > 0 && size+v.Size > cfg.MaxSize)
+		}
+		if !remove {
+			if complete {
+				kept++
+				size += v.Size
+			}
+			continue
+		}
+		if err = os.RemoveAll(v.Dir); err != nil {
+			log.Error().Err(err).Str("dir", v.Dir).Msg("[dump] old dump dir removal error")
+			continue
+		}
+		log.Info().Msgf("[dump] removed old dump dir: %s (%s)", v.Dir, v.Status)
 	}
-	sort.Slice(entries, func(i, j int) bool {
-		fi, _ := os.Stat(entries[i])
-		fj, _ := os.Stat(entries[j])
-		return fi.ModTime().After(fj.ModTime())
-	})
-	return entries[0]
 }
 
 // extractLatestTimestamp picks the largest timestamp suffix among files.
@@ -0,0 +1,244 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Borislavv/advanced-cache/pkg/config"
+	"github.com/Borislavv/advanced-cache/pkg/model"
+	"github.com/Borislavv/advanced-cache/pkg/storage/lru"
+	"github.com/Borislavv/advanced-cache/pkg/storage/snapshot"
+)
+
+func TestDumpAndLoad(t *testing.T) {
+	for _, dump := range []*config.Dump{
+		{Format: config.DumpFormatSnapshot, Segments: 1, Gzip: true},
+		{Format: config.DumpFormatSnapshot, Segments: 4, BlockSize: 4096},
+		{Format: config.DumpFormatSnapshot, Segments: 2, BlockSize: 4096, Codec: config.DumpCodecZstd, Level: 3},
+		{Format: config.DumpFormatLegacy, Crc32Control: true},
+	} {
+		dump.IsEnabled, dump.Dir, dump.Name = true, t.TempDir(), "cache.dump"
+
+		t.Run(dump.Format+"/"+dump.Codec, func(t *testing.T) {
+			ctx := t.Context()
+			cfg := newTestConfig(&config.Persistence{Dump: dump})
+			backend, src, _ := newTestDB(t, cfg, 1000)
+			if err := NewDumper(cfg, src, backend).Dump(ctx); err != nil {
+				t.Fatalf("Dump: %v", err)
+			}
+
+			versions, err := ListDumpVersions(dump.Dir, dump.Name)
+			if err != nil || len(versions) != 1 || versions[0].Format != dump.Format {
+				t.Fatalf("expected a single %s version, got %+v (%v)", dump.Format, versions, err)
+			}
+			if dump.Format == config.DumpFormatSnapshot && len(versions[0].Files) != dump.Segments {
+				t.Fatalf("expected %d snapshot files, got %v", dump.Segments, versions[0].Files)
+			}
+
+			dst := lru.NewStorage(ctx, cfg, backend)
+			if err = NewDumper(cfg, dst, backend).Load(ctx); err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			if dst.RealLen() != src.RealLen() {
+				t.Fatalf("expected %d loaded entries, got %d", src.RealLen(), dst.RealLen())
+			}
+		})
+	}
+}
+
+func TestConvertLegacyDump(t *testing.T) {
+	dir := t.TempDir()
+	ctx := t.Context()
+	cfg := newTestConfig(&config.Persistence{Dump: &config.Dump{
+		IsEnabled: true, Dir: dir, Name: "cache.dump", Format: config.DumpFormatLegacy,
+	}})
+	backend, src, _ := newTestDB(t, cfg, 100)
+	if err := NewDumper(cfg, src, backend).Dump(ctx); err != nil {
+		t.Fatalf("Dump: %v", err)
+	}
+
+	newDir, records, err := ConvertDumpVersion(filepath.Join(dir, "v1"), dir, "cache.dump", NewSnapshotOptions(cfg))
+	if err != nil || records != int(src.RealLen()) {
+		t.Fatalf("expected %d converted records, got %d (%v)", src.RealLen(), records, err)
+	}
+	if _, err = os.Stat(filepath.Join(newDir, "cache.dump.snap")); err != nil {
+		t.Fatalf("expected a single snapshot file: %v", err)
+	}
+
+	dst := lru.NewStorage(ctx, cfg, backend)
+	if err = NewDumper(cfg, dst, backend).LoadVersion(ctx, filepath.Base(newDir)); err != nil {
+		t.Fatalf("LoadVersion: %v", err)
+	}
+	if dst.RealLen() != src.RealLen() {
+		t.Fatalf("expected %d loaded entries, got %d", src.RealLen(), dst.RealLen())
+	}
+}
+
+func TestLoadHottestWithinMemoryThreshold(t *testing.T) {
+	ctx := t.Context()
+	dump := &config.Dump{IsEnabled: true, Dir: t.TempDir(), Name: "cache.dump", Format: config.DumpFormatSnapshot, Segments: 1}
+	cfg := newTestConfig(&config.Persistence{Dump: dump})
+	backend, src, entries := newTestDB(t, cfg, 1000)
+	hot := entries[:100]
+	time.Sleep(200 * time.Millisecond) // access times are coarse (see lru.clockResolution)
+	for _, entry := range hot {
+		for i := 0; i < 10; i++ {
+			src.Set(entry) // the same payload: an access counted by TinyLFU
+		}
+	}
+	frequency := make(map[uint64]uint32, len(hot))
+	for _, dumped := range src.Hottest(ctx) {
+		frequency[dumped.Entry.MapKey()] = dumped.Heat.Frequency
+	}
+	if err := NewDumper(cfg, src, backend).Dump(ctx); err != nil {
+		t.Fatalf("Dump: %v", err)
+	}
+
+	// Only about a half of the dump fits into the memory threshold of the new instance.
+	cfg.Cache.Storage.Size = uint(float64(src.RealMem()) / 2 / cfg.Cache.Eviction.Threshold)
+
+	dst := lru.NewStorage(ctx, cfg, backend)
+	if err := NewDumper(cfg, dst, backend).Load(ctx); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if dst.RealLen() == 0 || dst.RealLen() >= src.RealLen() {
+		t.Fatalf("expected a part of %d entries to be loaded, got %d", src.RealLen(), dst.RealLen())
+	}
+	heat := make(map[uint64]lru.Heat, dst.RealLen())
+	for _, restored := range dst.Hottest(ctx) {
+		heat[restored.Entry.MapKey()] = restored.Heat
+	}
+	for _, entry := range hot {
+		restored, found := heat[entry.MapKey()]
+		if !found {
+			t.Fatal("expected hot entries to be loaded first")
+		}
+		if restored.AccessedAt != entry.TouchedAt() {
+			t.Fatalf("expected the access time %d to be restored, got %d", entry.TouchedAt(), restored.AccessedAt)
+		}
+		if dumped := frequency[entry.MapKey()]; dumped == 0 || restored.Frequency < dumped {
+			t.Fatalf("expected the frequency %d to be restored, got %d", dumped, restored.Frequency)
+		}
+	}
+}
+
+func TestEncryptedDump(t *testing.T) {
+	ctx := t.Context()
+	dir := t.TempDir()
+	writeKey := func(name, key string) string {
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, []byte(key+"\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+	k1 := writeKey("k1", "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=") // base64
+	k2 := writeKey("k2", "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+	dump := &config.Dump{IsEnabled: true, Dir: dir, Name: "cache.dump", Format: config.DumpFormatSnapshot, Segments: 2}
+	dump.Encryption = config.Encryption{Enabled: true, Keys: []config.EncryptionKey{{ID: "k1", File: k1}}}
+	cfg := newTestConfig(&config.Persistence{Dump: dump})
+	backend, src, _ := newTestDB(t, cfg, 100)
+	if err := NewDumper(cfg, src, backend).Dump(ctx); err != nil {
+		t.Fatalf("Dump: %v", err)
+	}
+
+	// k2 is the new primary key, snapshots of k1 stay readable.
+	dump.Encryption.Keys = []config.EncryptionKey{{ID: "k2", File: k2}, {ID: "k1", File: k1}}
+	dst := lru.NewStorage(ctx, cfg, backend)
+	if err := NewDumper(cfg, dst, backend).Load(ctx); err != nil || dst.RealLen() != src.RealLen() {
+		t.Fatalf("expected %d loaded entries, got %d (%v)", src.RealLen(), dst.RealLen(), err)
+	}
+
+	for _, keys := range [][]config.EncryptionKey{
+		{{ID: "k2", File: k2}},
+		{{ID: "k1", File: k2}},
+	} {
+		dump.Encryption.Keys = keys
+		dst = lru.NewStorage(ctx, cfg, backend)
+		if err := NewDumper(cfg, dst, backend).Load(ctx); !errors.Is(err, snapshot.KeyError) {
+			t.Fatalf("expected KeyError, got %v", err)
+		}
+	}
+}
+
+func BenchmarkDumpAndLoad(b *testing.B) {
+	ctx := b.Context()
+	cfg := newTestConfig(nil)
+	backend, src, _ := newTestDB(b, cfg, 10000)
+
+	for _, codec := range []string{config.DumpCodecNone, config.DumpCodecGzip, config.DumpCodecZstd} {
+		dump := &config.Dump{
+			IsEnabled: true, Dir: b.TempDir(), Name: "cache.dump", Format: config.DumpFormatSnapshot,
+			Segments: 1, BlockSize: config.DefaultDumpBlockSize, Codec: codec, MaxVersions: 1,
+		}
+		cfg.Cache.Persistence = &config.Persistence{Dump: dump}
+		dumper := NewDumper(cfg, src, backend)
+
+		b.Run(codec+"/dump", func(b *testing.B) {
+			b.SetBytes(src.RealMem())
+			for i := 0; i < b.N; i++ {
+				if err := dumper.Dump(ctx); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(codec+"/load", func(b *testing.B) {
+			b.SetBytes(src.RealMem())
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				dst := lru.NewStorage(ctx, cfg, backend)
+				b.StartTimer()
+				if err := NewDumper(cfg, dst, backend).Load(ctx); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadRekeysEntriesOfChangedRules(t *testing.T) {
+	ctx := t.Context()
+	dump := &config.Dump{IsEnabled: true, Dir: t.TempDir(), Name: "cache.dump", Format: config.DumpFormatSnapshot, Segments: 1}
+	cfg := newTestConfig(&config.Persistence{Dump: dump})
+	backend, src, entries := newTestDB(t, cfg, 100)
+	if err := NewDumper(cfg, src, backend).Dump(ctx); err != nil {
+		t.Fatalf("Dump: %v", err)
+	}
+
+	// The key of the rule doesn't depend on the language anymore.
+	rule := *cfg.Rule(string(path))
+	rule.CacheKey.Query = []string{"project[id]", "domain", "choice"}
+	cfg.SetRules(map[string]*config.Rule{string(path): config.PrepareRule(string(path), &rule)})
+
+	dst := lru.NewStorage(ctx, cfg, backend)
+	if err := NewDumper(cfg, dst, backend).Load(ctx); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if dst.RealLen() == 0 {
+		t.Fatal("expected entries to be loaded")
+	}
+	for _, entry := range entries {
+		reqPath, query, queryHeaders, respHeaders, _, _, release, err := entry.Payload()
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := model.NewEntryManual(cfg, reqPath, query, queryHeaders, nil)
+		release(queryHeaders, respHeaders)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, found := dst.Get(req); !found {
+			t.Fatalf("expected the entry of %s?%s to be re-keyed", reqPath, query)
+		}
+	}
+
+	// Entries of a removed rule are dropped.
+	cfg.SetRules(map[string]*config.Rule{})
+	dst = lru.NewStorage(ctx, cfg, backend)
+	if err := NewDumper(cfg, dst, backend).Load(ctx); err != nil || dst.RealLen() != 0 {
+		t.Fatalf("expected entries without a rule to be dropped, got %d (%v)", dst.RealLen(), err)
+	}
+}
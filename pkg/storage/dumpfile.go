@@ -0,0 +1,594 @@
+package storage
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Borislavv/advanced-cache/pkg/config"
+	"github.com/Borislavv/advanced-cache/pkg/model"
+	"github.com/Borislavv/advanced-cache/pkg/storage/lru"
+	"github.com/Borislavv/advanced-cache/pkg/storage/snapshot"
+)
+
+const (
+	dumpBufferSize = 512 * 1024
+	snapshotExt    = ".snap"
+)
+
+// Layout of snapshot records (snapshot.Header.EntryVersion): the low byte is model.EntryFormatVersion,
+// the high byte is the envelope of entry records: 0 is a bare record, 1 prefixes it with the heat of the entry
+// (last access unix nano i64, frequency u32).
+const (
+	recordEnvelopeHeat           = 1
+	heatSize                     = 8 + 4
+	SnapshotRecordVersion uint16 = recordEnvelopeHeat<<8 | model.EntryFormatVersion
+)
+
+// EntryVersionError means a snapshot was written with a record layout this build can't decode.
+var EntryVersionError = errors.New("unsupported entry format version")
+
+// Heat of an entry, records of legacy dumps and of snapshots without heat have a zero one.
+type Heat = lru.Heat
+
+// DumpVersion describes a version dir of a dump.
+type DumpVersion struct {
+	Name     string    `json:"name"`
+	Dir      string    `json:"dir"`
+	Format   string    `json:"format"`  // snapshot or legacy
+	Status   string    `json:"status"`  // of the manifest (DumpStatusUnknown if there is none)
+	Entries  int       `json:"entries"` // by the manifest
+	Files    []string  `json:"files"`   // files of the manifest, snapshot files or shard files of the latest timestamp otherwise
+	Size     int64     `json:"size"`    // total size of the files (bytes)
+	ModTime  time.Time `json:"modTime"`
+	Manifest *Manifest `json:"-"` // nil if there is none
+}
+
+// Loadable returns nil if the version is a complete dump, IncompleteDumpError otherwise.
+// Versions without a manifest (taken before manifests were introduced) are trusted.
+func (v DumpVersion) Loadable() error {
+	switch {
+	case v.Manifest != nil:
+		return v.Manifest.Check(v.Dir, false)
+	case v.Status == DumpStatusUnknown:
+		return nil
+	default:
+		return fmt.Errorf("%w: unreadable manifest", IncompleteDumpError)
+	}
+}
+
+// ListDumpVersions returns version dirs of the dump dir, the newest (by the version number) first.
+func ListDumpVersions(dir, name string) ([]DumpVersion, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []DumpVersion
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), "v") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		version := DumpVersion{
+			Name:    entry.Name(),
+			Dir:     filepath.Join(dir, entry.Name()),
+			ModTime: info.ModTime(),
+			Format:  config.DumpFormatLegacy,
+			Status:  DumpStatusUnknown,
+		}
+		if m, err := ReadManifest(version.Dir); err == nil {
+			version.Manifest, version.Status, version.Entries = m, m.Status, m.Entries
+		} else if !errors.Is(err, os.ErrNotExist) {
+			version.Status = DumpStatusFailed // unreadable
+		}
+		for _, part := range DumpParts(version.Dir, name) {
+			version.Files = append(version.Files, part.File)
+			if part.Snapshot {
+				version.Format = config.DumpFormatSnapshot
+			}
+		}
+		for _, file := range version.Files {
+			if fi, err := os.Stat(file); err == nil {
+				version.Size += fi.Size()
+			}
+		}
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versionNumber(versions[i].Name) > versionNumber(versions[j].Name) })
+
+	return versions, nil
+}
+
+// DumpPart is an independently readable file of a dump version: a snapshot file or a legacy shard file.
+type DumpPart struct {
+	File     string
+	Snapshot bool
+}
+
+// DumpParts returns the files of a version dir: the ones of its manifest, otherwise snapshot files if there are any
+// or legacy shard files.
+func DumpParts(dir, name string) []DumpPart {
+	var parts []DumpPart
+	if m, err := ReadManifest(dir); err == nil && len(m.Files) > 0 {
+		for _, file := range m.Files {
+			parts = append(parts, DumpPart{File: filepath.Join(dir, file.Name), Snapshot: m.Format != config.DumpFormatLegacy})
+		}
+		return parts
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, name+"*"+snapshotExt))
+	sort.Strings(files)
+	for _, file := range files {
+		parts = append(parts, DumpPart{File: file, Snapshot: true})
+	}
+	if len(parts) > 0 {
+		return parts
+	}
+	for _, file := range DumpFiles(dir, name) {
+		parts = append(parts, DumpPart{File: file})
+	}
+	return parts
+}
+
+// DumpReadOptions are callbacks of DumpPart.Read, only Record is required.
+type DumpReadOptions struct {
+	Crc32Control bool                               // Verify CRC32 of legacy records (snapshot blocks are always verified).
+	Workers      int                                // Snapshot blocks read and decompressed in parallel (records are passed in order).
+	Keys         snapshot.Keyring                   // Keys of encrypted snapshots (see LoadDumpKeys).
+	Mmap         bool                               // Map snapshot files instead of reading them (see snapshot.MapFile).
+	Mapped       func(m *snapshot.Mapping)          // Called before records of a mapped file which alias the mapping (see Record).
+	Header       func(h snapshot.Header) error      // Called with the header of a snapshot file before its records.
+	Record       func(data []byte, heat Heat) error // Each record has its own slice (or aliases the mapping passed to Mapped), so it may be retained.
+	Corrupt      func(err error)                    // A damaged record or block, it's skipped (errors match snapshot.ChecksumError or snapshot.TruncatedError if so).
+}
+
+// Read calls the callbacks for the file. The reader of a snapshot is chosen by its format version,
+// records of an entry layout other than model.EntryFormatVersion are rejected with EntryVersionError,
+// an encrypted snapshot without a matching key of the keyring is rejected with snapshot.KeyError.
+// With Mmap and Mapped set, records of a plain snapshot (see snapshot.Mapping.Zerocopy) are not copied,
+// their holders must acquire the mapping. Returns errors of the callbacks and errors which make the rest
+// of the file unreadable.
+func (p DumpPart) Read(opts DumpReadOptions) error {
+	corrupt := opts.Corrupt
+	if corrupt == nil {
+		corrupt = func(error) {}
+	}
+
+	if !p.Snapshot {
+		return ReadDumpFile(p.File, func(data []byte, crc uint32) error {
+			if opts.Crc32Control && crc32.ChecksumIEEE(data) != crc {
+				corrupt(fmt.Errorf("record: %w", snapshot.ChecksumError))
+				return nil
+			}
+			return opts.Record(data, Heat{})
+		})
+	}
+
+	var (
+		f       *snapshot.Reader
+		mapping *snapshot.Mapping // non-nil if records alias the mapping
+	)
+	if opts.Mmap {
+		m, err := snapshot.MapFile(p.File)
+		if err != nil {
+			return err
+		}
+		defer m.Release() // holders of records acquire the mapping themselves
+		f = m.Reader
+		if m.Zerocopy() && opts.Mapped != nil {
+			mapping = m
+		}
+	} else {
+		file, err := snapshot.OpenFile(p.File)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		f = file.Reader
+	}
+
+	header := f.Header()
+	envelope, entryVersion := header.EntryVersion>>8, header.EntryVersion&0xff
+	if entryVersion != model.EntryFormatVersion || envelope > recordEnvelopeHeat {
+		return fmt.Errorf("%w: %d (supported: %d)", EntryVersionError, header.EntryVersion, SnapshotRecordVersion)
+	}
+	if err := f.SetKeys(opts.Keys); err != nil {
+		return err
+	}
+	if opts.Header != nil {
+		if err := opts.Header(header); err != nil {
+			return err
+		}
+	}
+	if f.Incomplete() {
+		corrupt(fmt.Errorf("%w: no index, %d complete blocks recovered", snapshot.TruncatedError, len(f.Blocks())))
+	}
+	if mapping != nil {
+		opts.Mapped(mapping)
+	}
+
+	return f.ReadBlocks(opts.Workers, func(record []byte) error {
+		var heat Heat
+		if envelope == recordEnvelopeHeat {
+			if len(record) < heatSize {
+				corrupt(fmt.Errorf("record: %w", snapshot.TruncatedError))
+				return nil
+			}
+			heat = decodeHeat(record)
+			record = record[heatSize:]
+		}
+		if mapping != nil {
+			return opts.Record(record, heat)
+		}
+		return opts.Record(append([]byte(nil), record...), heat)
+	}, corrupt)
+}
+
+// DumpFiles returns shard files of the latest timestamp of a version dir (the legacy format).
+func DumpFiles(dir, name string) []string {
+	files, _ := filepath.Glob(filepath.Join(dir, fmt.Sprintf("%s-shard-*.dump*", name)))
+	files = filterFilesByTimestamp(files, extractLatestTimestamp(files))
+	filtered := files[:0]
+	for _, file := range files {
+		if !strings.HasSuffix(file, ".tmp") {
+			filtered = append(filtered, file)
+		}
+	}
+	sort.Strings(filtered)
+	return filtered
+}
+
+// ReadDumpFile calls fn for each record of a shard dump file with the stored CRC32 (0 if it was not computed).
+// Each record gets its own data slice, so fn may retain it (entries reference their payload).
+// Stops and returns the first error of fn.
+func ReadDumpFile(file string, fn func(data []byte, crc uint32) error) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var reader io.Reader = f
+	if strings.HasSuffix(file, ".gz") {
+		gzr, err := gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("gzip open: %w", err)
+		}
+		defer gzr.Close()
+		reader = gzr
+	}
+
+	br := bufio.NewReaderSize(reader, dumpBufferSize)
+	var metaBuf [8]byte
+	for {
+		if _, err = io.ReadFull(br, metaBuf[:]); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("read meta: %w", err)
+		}
+
+		buf := make([]byte, binary.LittleEndian.Uint32(metaBuf[0:4]))
+		if _, err = io.ReadFull(br, buf); err != nil {
+			return fmt.Errorf("read entry: %w", err)
+		}
+		if err = fn(buf, binary.LittleEndian.Uint32(metaBuf[4:8])); err != nil {
+			return err
+		}
+	}
+}
+
+// DumpWriter writes records of a dump file.
+type DumpWriter interface {
+	Write(data []byte) error
+	Close() error
+}
+
+// DumpFileWriter writes records of a shard dump file into a temp file which is renamed on Close.
+type DumpFileWriter struct {
+	name string
+	crc  bool
+	f    *os.File
+	sum  *summingWriter
+	gw   *gzip.Writer
+	bw   *bufio.Writer
+}
+
+// NewDumpFileWriter creates a writer of the file (gzipped if the name has the .gz extension).
+func NewDumpFileWriter(name string, crc bool) (*DumpFileWriter, error) {
+	f, err := os.Create(name + ".tmp")
+	if err != nil {
+		return nil, err
+	}
+	w := &DumpFileWriter{name: name, crc: crc, f: f, sum: &summingWriter{w: f}}
+
+	var writer io.Writer = w.sum
+	if strings.HasSuffix(name, ".gz") {
+		w.gw = gzip.NewWriter(w.sum)
+		writer = w.gw
+	}
+	w.bw = bufio.NewWriterSize(writer, dumpBufferSize)
+
+	return w, nil
+}
+
+// Write appends a record.
+func (w *DumpFileWriter) Write(data []byte) error {
+	var crc uint32
+	if w.crc {
+		crc = crc32.ChecksumIEEE(data)
+	}
+
+	var lenBuf [8]byte
+	binary.LittleEndian.PutUint32(lenBuf[0:4], uint32(len(data)))
+	binary.LittleEndian.PutUint32(lenBuf[4:8], crc)
+	if _, err := w.bw.Write(lenBuf[:]); err != nil {
+		return err
+	}
+	if _, err := w.bw.Write(data); err != nil {
+		return err
+	}
+	w.sum.entries++
+	return nil
+}
+
+// Summary describes the written file for the manifest, it's complete after Close.
+func (w *DumpFileWriter) Summary() ManifestFile {
+	return w.sum.file(w.name)
+}
+
+// Close flushes and syncs the data and renames the temp file to the final name.
+func (w *DumpFileWriter) Close() error {
+	err := w.bw.Flush()
+	if w.gw != nil {
+		if gzErr := w.gw.Close(); err == nil {
+			err = gzErr
+		}
+	}
+	if syncErr := w.f.Sync(); err == nil {
+		err = syncErr
+	}
+	if closeErr := w.f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(w.f.Name())
+		return err
+	}
+	return os.Rename(w.f.Name(), w.name)
+}
+
+// dumpFileName builds a shard file name of a version dir.
+func dumpFileName(versionDir, name string, shardKey uint64, timestamp string, gzipped bool) string {
+	ext := ".dump"
+	if gzipped {
+		ext += ".gz"
+	}
+	return fmt.Sprintf("%s/%s-shard-%d-%s%s", versionDir, name, shardKey, timestamp, ext)
+}
+
+// dumpTimestamp is the timestamp part of shard file names.
+func dumpTimestamp(t time.Time) string {
+	return t.Format("20060102T150405")
+}
+
+// NewDumpVersionDir creates the next version dir of the dump dir.
+func NewDumpVersionDir(dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("create base dump dir: %w", err)
+	}
+	versionDir := filepath.Join(dir, fmt.Sprintf("v%d", nextVersionDir(dir)))
+	if err := os.MkdirAll(versionDir, 0o755); err != nil {
+		return "", fmt.Errorf("create version dir: %w", err)
+	}
+	return versionDir, nil
+}
+
+// SnapshotFileWriter writes a snapshot file into a temp file which is renamed on Close.
+type SnapshotFileWriter struct {
+	name    string
+	f       *os.File
+	sum     *summingWriter
+	bw      *bufio.Writer
+	sw      *snapshot.Writer
+	scratch []byte // heat and record
+}
+
+// NewSnapshotFileWriter creates a writer of the snapshot file, blocks are encrypted with the key if it's not nil.
+func NewSnapshotFileWriter(name string, h snapshot.Header, codec snapshot.Codec, blockSize int, key *snapshot.Key) (*SnapshotFileWriter, error) {
+	f, err := os.Create(name + ".tmp")
+	if err != nil {
+		return nil, err
+	}
+	w := &SnapshotFileWriter{name: name, f: f, sum: &summingWriter{w: f}}
+	w.bw = bufio.NewWriterSize(w.sum, dumpBufferSize)
+	if w.sw, err = snapshot.NewEncryptedWriter(w.bw, h, codec, blockSize, key); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return nil, err
+	}
+	return w, nil
+}
+
+// Write appends a record without heat.
+func (w *SnapshotFileWriter) Write(data []byte) error {
+	return w.WriteHot(data, Heat{})
+}
+
+// WriteHot appends a record with the heat of the entry.
+func (w *SnapshotFileWriter) WriteHot(data []byte, heat Heat) error {
+	w.scratch = appendHeat(w.scratch[:0], heat)
+	w.scratch = append(w.scratch, data...)
+	if err := w.sw.Write(w.scratch); err != nil {
+		return err
+	}
+	w.sum.entries++
+	return nil
+}
+
+// Summary describes the written file for the manifest, it's complete after Close.
+func (w *SnapshotFileWriter) Summary() ManifestFile {
+	return w.sum.file(w.name)
+}
+
+func appendHeat(dst []byte, heat Heat) []byte {
+	dst = binary.LittleEndian.AppendUint64(dst, uint64(heat.AccessedAt))
+	return binary.LittleEndian.AppendUint32(dst, heat.Frequency)
+}
+
+func decodeHeat(record []byte) Heat {
+	return Heat{
+		AccessedAt: int64(binary.LittleEndian.Uint64(record)),
+		Frequency:  binary.LittleEndian.Uint32(record[8:]),
+	}
+}
+
+// Close writes the index, flushes and syncs the data and renames the temp file to the final name.
+func (w *SnapshotFileWriter) Close() error {
+	err := w.sw.Close()
+	if flushErr := w.bw.Flush(); err == nil {
+		err = flushErr
+	}
+	if syncErr := w.f.Sync(); err == nil {
+		err = syncErr
+	}
+	if closeErr := w.f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(w.f.Name())
+		return err
+	}
+	return os.Rename(w.f.Name(), w.name)
+}
+
+// SnapshotOptions configure written snapshots.
+type SnapshotOptions struct {
+	Codec     snapshot.Codec
+	Segments  int
+	BlockSize int
+	RulesHash uint64
+	RuleKeys  string           // Hashes of key definitions of rules (see config.Rule.KeyHash), empty if unknown.
+	Keys      snapshot.Keyring // The primary key encrypts snapshots, all keys decrypt sources (see ConvertDumpVersion).
+}
+
+// NewSnapshotOptions returns the options of persistence.dump, keys are loaded separately (see LoadDumpKeys).
+func NewSnapshotOptions(cfg *config.Cache) SnapshotOptions {
+	dump := cfg.Cache.Persistence.Dump
+	codec, err := snapshot.CodecByName(dump.Codec)
+	switch {
+	case dump.Codec == config.DumpCodecZstd:
+		codec, err = snapshot.NewZstdCodec(dump.Level)
+	case err != nil && dump.Gzip:
+		codec, err = snapshot.CodecByName(config.DumpCodecGzip)
+	}
+	if err != nil {
+		codec, _ = snapshot.CodecByName(config.DumpCodecNone)
+	}
+	return SnapshotOptions{Codec: codec, Segments: max(dump.Segments, 1), BlockSize: dump.BlockSize, RulesHash: cfg.RulesHash(), RuleKeys: ruleKeysMeta(cfg)}
+}
+
+// newSnapshotWriters creates the writers of all segments of a snapshot.
+func newSnapshotWriters(versionDir, name string, opts SnapshotOptions) ([]*SnapshotFileWriter, error) {
+	created := time.Now()
+	writers := make([]*SnapshotFileWriter, 0, opts.Segments)
+	for segment := 0; segment < opts.Segments; segment++ {
+		w, err := NewSnapshotFileWriter(snapshotFileName(versionDir, name, segment, opts.Segments), snapshot.Header{
+			EntryVersion: SnapshotRecordVersion,
+			Segment:      uint16(segment),
+			Segments:     uint16(opts.Segments),
+			Created:      created,
+			HashSeed:     model.HashSeed,
+			RulesHash:    opts.RulesHash,
+			Meta:         snapshotMeta(opts),
+		}, opts.Codec, opts.BlockSize, opts.Keys.Primary())
+		if err != nil {
+			for _, w := range writers {
+				_ = w.Close()
+			}
+			return nil, err
+		}
+		writers = append(writers, w)
+	}
+	return writers, nil
+}
+
+func snapshotMeta(opts SnapshotOptions) map[string]string {
+	if opts.RuleKeys == "" {
+		return nil
+	}
+	return map[string]string{metaRuleKeys: opts.RuleKeys}
+}
+
+// snapshotFileName builds a snapshot file name of a version dir, a single file has no segment number.
+func snapshotFileName(versionDir, name string, segment, segments int) string {
+	if segments <= 1 {
+		return filepath.Join(versionDir, name+snapshotExt)
+	}
+	return filepath.Join(versionDir, fmt.Sprintf("%s-%04d%s", name, segment, snapshotExt))
+}
+
+// ConvertDumpVersion rewrites a version dir (of any format) as a new snapshot version of the dump dir.
+// Returns the new version dir and the number of records.
+func ConvertDumpVersion(srcDir, dumpDir, name string, opts SnapshotOptions) (string, int, error) {
+	parts := DumpParts(srcDir, name)
+	if len(parts) == 0 {
+		return "", 0, fmt.Errorf("no dump files found in %s", srcDir)
+	}
+	opts.Segments = max(opts.Segments, 1)
+	opts.RulesHash, opts.RuleKeys = 0, "" // unknown for legacy dumps
+	if parts[0].Snapshot {
+		if f, err := snapshot.OpenFile(parts[0].File); err == nil {
+			opts.RulesHash, opts.RuleKeys = f.Header().RulesHash, f.Header().Meta[metaRuleKeys]
+			_ = f.Close()
+		}
+	}
+
+	dstDir, err := NewDumpVersionDir(dumpDir)
+	if err != nil {
+		return "", 0, err
+	}
+	manifest := newManifest(config.DumpFormatSnapshot, opts.Codec.Name(), opts.RulesHash)
+	if err = WriteManifest(dstDir, manifest); err != nil {
+		return dstDir, 0, err
+	}
+	writers, err := newSnapshotWriters(dstDir, name, opts)
+	if err != nil {
+		return dstDir, 0, err
+	}
+
+	var records int
+	for i, part := range parts {
+		w := writers[i%len(writers)]
+		if err = part.Read(DumpReadOptions{Keys: opts.Keys, Record: func(data []byte, heat Heat) error {
+			records++
+			return w.WriteHot(data, heat)
+		}}); err != nil {
+			err = fmt.Errorf("read %s: %w", part.File, err)
+			break
+		}
+	}
+	files := make([]ManifestFile, 0, len(writers))
+	for _, w := range writers {
+		if closeErr := w.Close(); err == nil {
+			err = closeErr
+		}
+		files = append(files, w.Summary())
+	}
+	manifest.finish(files, err)
+	if manifestErr := WriteManifest(dstDir, manifest); err == nil {
+		err = manifestErr
+	}
+	return dstDir, records, err
+}
@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/Borislavv/advanced-cache/pkg/config"
+	"github.com/rs/zerolog/log"
+)
+
+// Kinds of dump jobs.
+const (
+	JobDump = "dump"
+	JobLoad = "load"
+)
+
+// Modes of loading a version into the running cache.
+const (
+	LoadMerge   = "merge"   // entries of the version are added to (and replace) the cached ones.
+	LoadReplace = "replace" // the storage is cleared first.
+)
+
+var (
+	VersionNotFoundError = errors.New("dump version not found")
+	VersionBusyError     = errors.New("dump version is in use")
+)
+
+// DumpVersionView is a version with its manifest.
+type DumpVersionView struct {
+	DumpVersion
+	Manifest *Manifest `json:"manifest,omitempty"`
+}
+
+// DumpManager manages dumps of the running instance: dumps on demand, lists, loads and deletes versions.
+// Dumps and loads run as jobs (one at a time), they are triggered by the admin API and by signals on unix:
+// SIGUSR1 dumps, SIGUSR2 loads the newest complete version (merging it), see Run.
+type DumpManager struct {
+	ctx     context.Context
+	cfg     *config.Cache
+	dumper  *Dump
+	storage Storage
+	jobs    *Jobs
+}
+
+func NewDumpManager(ctx context.Context, cfg *config.Cache, dumper *Dump, storage Storage) *DumpManager {
+	return &DumpManager{ctx: ctx, cfg: cfg, dumper: dumper, storage: storage, jobs: NewJobs()}
+}
+
+// Jobs returns the jobs of the manager.
+func (m *DumpManager) Jobs() *Jobs {
+	return m.jobs
+}
+
+// Versions returns the versions of the dump dir with their manifests, the newest first.
+func (m *DumpManager) Versions() ([]DumpVersionView, error) {
+	dump := m.cfg.Cache.Persistence.Dump
+	versions, err := ListDumpVersions(dump.Dir, dump.Name)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	views := make([]DumpVersionView, 0, len(versions))
+	for _, v := range versions {
+		views = append(views, DumpVersionView{DumpVersion: v, Manifest: v.Manifest})
+	}
+	return views, nil
+}
+
+// Dump starts a dump job.
+func (m *DumpManager) Dump() (JobInfo, error) {
+	return m.jobs.Start(m.ctx, JobDump, "", func(ctx context.Context, p *Progress) error {
+		p.SetTotal(m.storage.RealLen())
+		_, err := m.dumper.dump(ctx, dumpLimits{progress: p})
+		return err
+	})
+}
+
+// Load starts a job loading the version into the running cache (see LoadMerge and LoadReplace).
+// An incomplete version is rejected with IncompleteDumpError.
+func (m *DumpManager) Load(version, mode string) (JobInfo, error) {
+	if mode != LoadMerge && mode != LoadReplace {
+		return JobInfo{}, fmt.Errorf("mode must be %q or %q", LoadMerge, LoadReplace)
+	}
+	v, err := m.version(version)
+	if err != nil {
+		return JobInfo{}, err
+	}
+	if err = v.Loadable(); err != nil {
+		return JobInfo{}, err
+	}
+	return m.load(v, mode)
+}
+
+// LoadLatest starts a job merging the newest complete version into the running cache.
+func (m *DumpManager) LoadLatest() (JobInfo, error) {
+	versions, err := m.Versions()
+	if err != nil {
+		return JobInfo{}, err
+	}
+	for _, v := range versions {
+		if v.Loadable() == nil {
+			return m.load(v.DumpVersion, LoadMerge)
+		}
+	}
+	return JobInfo{}, VersionNotFoundError
+}
+
+func (m *DumpManager) load(v DumpVersion, mode string) (JobInfo, error) {
+	return m.jobs.Start(m.ctx, JobLoad, v.Name, func(ctx context.Context, p *Progress) error {
+		p.SetTotal(int64(v.Entries))
+		if mode == LoadReplace {
+			m.storage.Clear()
+		}
+		log.Info().Msgf("[dump] loading version %s (%s)", v.Name, mode)
+		return m.dumper.load(ctx, v.Dir, p)
+	})
+}
+
+// Delete removes the version, the one of a running job and versions of a running dump are busy (VersionBusyError).
+func (m *DumpManager) Delete(version string) error {
+	v, err := m.version(version)
+	if err != nil {
+		return err
+	}
+	if running, ok := m.jobs.Running(); ok && running.Kind == JobLoad && running.Target == v.Name {
+		return fmt.Errorf("%w: %s job %s is running", VersionBusyError, running.Kind, running.ID)
+	}
+	if !m.dumper.mu.TryLock() {
+		return fmt.Errorf("%w: a dump is running", VersionBusyError)
+	}
+	defer m.dumper.mu.Unlock()
+	if err = os.RemoveAll(v.Dir); err != nil {
+		return err
+	}
+	log.Info().Msgf("[dump] version %s deleted", v.Name)
+	return nil
+}
+
+// version returns the version of the name, names other than version dirs (e.g. paths) are not found.
+func (m *DumpManager) version(name string) (DumpVersion, error) {
+	if versionNumber(name) == 0 || filepath.Base(name) != name {
+		return DumpVersion{}, fmt.Errorf("%w: %q", VersionNotFoundError, name)
+	}
+	versions, err := m.Versions()
+	if err != nil {
+		return DumpVersion{}, err
+	}
+	for _, v := range versions {
+		if v.Name == name {
+			return v.DumpVersion, nil
+		}
+	}
+	return DumpVersion{}, fmt.Errorf("%w: %q", VersionNotFoundError, name)
+}
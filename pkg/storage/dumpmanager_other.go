@@ -0,0 +1,7 @@
+//go:build !unix
+
+package storage
+
+// Run does nothing: there are no SIGUSR1 and SIGUSR2 on this platform, dumps and loads are triggered
+// by the admin API only.
+func (m *DumpManager) Run() {}
@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Borislavv/advanced-cache/pkg/config"
+	"github.com/Borislavv/advanced-cache/pkg/mock"
+)
+
+// awaitJob polls the job until it's finished.
+func awaitJob(t *testing.T, jobs *Jobs, id string) JobInfo {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		job, err := jobs.Get(id)
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		if job.Status != JobRunning {
+			return job
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("job %s is not finished", id)
+	return JobInfo{}
+}
+
+func TestDumpManager(t *testing.T) {
+	ctx := t.Context()
+	dump := &config.Dump{IsEnabled: true, Dir: t.TempDir(), Name: "cache.dump", Format: config.DumpFormatSnapshot, Segments: 1}
+	cfg := newTestConfig(&config.Persistence{Dump: dump})
+	backend, db, _ := newTestDB(t, cfg, 60)
+	manager := NewDumpManager(ctx, cfg, NewDumper(cfg, db, backend), db)
+
+	job, err := manager.Dump()
+	if err != nil {
+		t.Fatalf("Dump: %v", err)
+	}
+	if job = awaitJob(t, manager.Jobs(), job.ID); job.Status != JobDone || job.Done != 60 || job.Total != 60 {
+		t.Fatalf("expected a finished dump of 60 entries, got %+v", job)
+	}
+	versions, err := manager.Versions()
+	if err != nil || len(versions) != 1 || versions[0].Manifest == nil || versions[0].Manifest.Entries != 60 {
+		t.Fatalf("expected a version with a manifest of 60 entries, got %+v (%v)", versions, err)
+	}
+
+	// Merge keeps cached entries, replace drops them.
+	db.Clear()
+	for _, entry := range mock.GenerateEntryPointersConsecutive(cfg, backend, path, 100)[60:] {
+		db.Set(entry)
+	}
+	for _, tc := range []struct {
+		mode string
+		want int64
+	}{{LoadMerge, 100}, {LoadReplace, 60}} {
+		if job, err = manager.Load("v1", tc.mode); err != nil {
+			t.Fatalf("Load %s: %v", tc.mode, err)
+		}
+		if job = awaitJob(t, manager.Jobs(), job.ID); job.Status != JobDone || job.Done != 60 {
+			t.Fatalf("expected a finished load of 60 entries, got %+v", job)
+		}
+		if db.RealLen() != tc.want {
+			t.Fatalf("expected %d entries after %s, got %d", tc.want, tc.mode, db.RealLen())
+		}
+	}
+	if jobs := manager.Jobs().List(); len(jobs) != 3 || jobs[0].Kind != JobLoad || jobs[2].Kind != JobDump {
+		t.Fatalf("expected 3 jobs, the newest first, got %+v", jobs)
+	}
+
+	for _, name := range []string{"v2", "../v1", "cache.dump", ""} {
+		if _, err = manager.Load(name, LoadMerge); !errors.Is(err, VersionNotFoundError) {
+			t.Fatalf("expected %q not to be found, got %v", name, err)
+		}
+	}
+	if err = manager.Delete("v1"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if versions, _ = manager.Versions(); len(versions) != 0 {
+		t.Fatalf("expected no versions, got %+v", versions)
+	}
+}
+
+func TestJobsRunOneAtATime(t *testing.T) {
+	jobs := NewJobs()
+	release := make(chan struct{})
+	first, err := jobs.Start(context.Background(), "test", "", func(_ context.Context, p *Progress) error {
+		p.SetTotal(2)
+		p.Add(1)
+		<-release
+		return errors.New("boom")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if running, err := jobs.Start(context.Background(), "test", "", nil); !errors.Is(err, JobBusyError) || running.ID != first.ID {
+		t.Fatalf("expected the running job %s, got %+v (%v)", first.ID, running, err)
+	}
+	close(release)
+	if job := awaitJob(t, jobs, first.ID); job.Status != JobFailed || job.Error != "boom" || job.Done != 1 || job.Total != 2 {
+		t.Fatalf("expected a failed job, got %+v", job)
+	}
+	if _, err = jobs.Get("404"); !errors.Is(err, JobNotFoundError) {
+		t.Fatalf("expected JobNotFoundError, got %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+//go:build unix
+
+package storage
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Run starts listening for SIGUSR1 and SIGUSR2.
+func (m *DumpManager) Run() {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGUSR1, syscall.SIGUSR2)
+
+	go func() {
+		defer signal.Stop(sigCh)
+		for {
+			select {
+			case <-m.ctx.Done():
+				return
+			case sig := <-sigCh:
+				var (
+					job JobInfo
+					err error
+				)
+				if sig == syscall.SIGUSR1 {
+					job, err = m.Dump()
+				} else {
+					job, err = m.LoadLatest()
+				}
+				if err != nil {
+					log.Error().Err(err).Msgf("[dump] %s is ignored", sig)
+					continue
+				}
+				log.Info().Msgf("[dump] %s: %s job %s started", sig, job.Kind, job.ID)
+			}
+		}
+	}()
+}
@@ -0,0 +1,269 @@
+// Package exchange moves cache entries between instances in human-readable formats: JSONL records
+// and HAR files with the path, query, key headers, status, response headers and body of each entry.
+// Exported entries are rebuilt on import like fetched ones (see model.NewEntryManual), so a rule set
+// with other cache keys re-keys them.
+package exchange
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Borislavv/advanced-cache/pkg/config"
+	"github.com/Borislavv/advanced-cache/pkg/model"
+	"github.com/Borislavv/advanced-cache/pkg/storage"
+	sharded "github.com/Borislavv/advanced-cache/pkg/storage/map"
+)
+
+// Formats of exported entries.
+const (
+	FormatJSONL = "jsonl"
+	FormatHAR   = "har"
+)
+
+// BodyBase64 is the Record.BodyEncoding of bodies which are not valid UTF-8.
+const BodyBase64 = "base64"
+
+var (
+	UnknownFormatError = errors.New("unknown export format")
+	InvalidRecordError = errors.New("invalid record")
+)
+
+// Record is an exported entry.
+type Record struct {
+	Rule            string      `json:"rule"`
+	Path            string      `json:"path"`
+	Query           string      `json:"query,omitempty"`
+	Headers         [][2]string `json:"headers,omitempty"` // Request headers the entry is keyed and revalidated with.
+	Status          int         `json:"status"`
+	ResponseHeaders [][2]string `json:"responseHeaders,omitempty"`
+	Body            string      `json:"body"`
+	BodyEncoding    string      `json:"bodyEncoding,omitempty"` // BodyBase64 if the body is binary, empty for text.
+	UpdatedAt       time.Time   `json:"updatedAt"`
+}
+
+// NewRecord unpacks the entry.
+func NewRecord(e *model.Entry) (Record, error) {
+	path, query, queryHeaders, headers, body, status, release, err := e.Payload()
+	defer release(queryHeaders, headers)
+	if err != nil {
+		return Record{}, err
+	}
+	r := Record{
+		Rule:            string(e.Rule().PathBytes),
+		Path:            string(path),
+		Query:           string(query),
+		Headers:         pairs(queryHeaders),
+		Status:          status,
+		ResponseHeaders: pairs(headers),
+		UpdatedAt:       time.Unix(0, e.UpdateAt()),
+	}
+	if utf8.Valid(body) {
+		r.Body = string(body)
+	} else {
+		r.Body, r.BodyEncoding = base64.StdEncoding.EncodeToString(body), BodyBase64
+	}
+	return r, nil
+}
+
+// Entry rebuilds the entry of the record with the live rule of its path, the entry keeps the update time
+// of the record (now if it has none).
+// A path without a rule is an error matching model.IsRouteWasNotFound.
+func (r Record) Entry(cfg *config.Cache, revalidator model.Revalidator) (*model.Entry, error) {
+	if !strings.HasPrefix(r.Path, "/") || r.Status <= 0 {
+		return nil, fmt.Errorf("%w: path %q, status %d", InvalidRecordError, r.Path, r.Status)
+	}
+	body, err := r.BodyBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	path, query := []byte(r.Path), []byte(r.Query)
+	headers := kvs(r.Headers)
+	keyHeaders := append([][2][]byte(nil), headers...) // filtered in place
+	entry, err := model.NewEntryManual(cfg, path, query, &keyHeaders, revalidator)
+	if err != nil {
+		return nil, err
+	}
+	responseHeaders := kvs(r.ResponseHeaders)
+	entry.SetPayload(path, query, &headers, &responseHeaders, body, r.Status)
+	if !r.UpdatedAt.IsZero() {
+		entry.SetUpdatedAt(r.UpdatedAt.UnixNano())
+	}
+	return entry, nil
+}
+
+// BodyBytes returns the decoded body.
+func (r Record) BodyBytes() ([]byte, error) {
+	switch r.BodyEncoding {
+	case "":
+		return []byte(r.Body), nil
+	case BodyBase64:
+		body, err := base64.StdEncoding.DecodeString(r.Body)
+		if err != nil {
+			return nil, fmt.Errorf("%w: body of %s: %v", InvalidRecordError, r.Path, err)
+		}
+		return body, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown body encoding %q", InvalidRecordError, r.BodyEncoding)
+	}
+}
+
+// Filter of exported entries, zero fields match everything.
+type Filter struct {
+	Rule   string        // Rule path, exact match.
+	Prefix string        // Request path prefix.
+	MinAge time.Duration // Updated at least MinAge ago.
+	MaxAge time.Duration // Updated not longer than MaxAge ago.
+}
+
+// matchEntry checks the fields available without unpacking the payload.
+func (f Filter) matchEntry(e *model.Entry, now time.Time) bool {
+	age := now.Sub(time.Unix(0, e.UpdateAt()))
+	return (f.Rule == "" || string(e.Rule().PathBytes) == f.Rule) &&
+		(f.MinAge <= 0 || age >= f.MinAge) &&
+		(f.MaxAge <= 0 || age <= f.MaxAge)
+}
+
+// Encoder writes records in one of the formats.
+type Encoder interface {
+	Encode(r Record) error
+	Close() error // Finishes the document, the underlying writer is not closed.
+}
+
+// NewEncoder returns an encoder of the format, absolute URLs of HAR entries are built on baseURL.
+func NewEncoder(w io.Writer, format, baseURL string) (Encoder, error) {
+	switch format {
+	case FormatJSONL, "":
+		return newJSONLEncoder(w), nil
+	case FormatHAR:
+		return newHAREncoder(w, baseURL), nil
+	default:
+		return nil, fmt.Errorf("%w %q", UnknownFormatError, format)
+	}
+}
+
+// Decode calls fn for each record of the document of the format, an error of fn stops decoding.
+func Decode(r io.Reader, format string, fn func(Record) error) error {
+	switch format {
+	case FormatJSONL, "":
+		return decodeJSONL(r, fn)
+	case FormatHAR:
+		return decodeHAR(r, fn)
+	default:
+		return fmt.Errorf("%w %q", UnknownFormatError, format)
+	}
+}
+
+// FormatOf returns the format of a file by its extension.
+func FormatOf(name string) string {
+	if strings.HasSuffix(strings.ToLower(name), ".har") {
+		return FormatHAR
+	}
+	return FormatJSONL
+}
+
+// Export encodes the entries of the storage matching the filter (the encoder is not closed).
+// Entries are collected under shard locks and encoded without them. Returns the number of exported entries.
+func Export(ctx context.Context, db storage.Storage, filter Filter, enc Encoder) (int, error) {
+	now := time.Now()
+	var (
+		mu      sync.Mutex
+		entries []*model.Entry
+	)
+	db.WalkShards(ctx, func(_ uint64, shard *sharded.Shard[*model.Entry]) {
+		var batch []*model.Entry
+		shard.Walk(ctx, func(_ uint64, e *model.Entry) bool {
+			if filter.matchEntry(e, now) {
+				batch = append(batch, e)
+			}
+			return true
+		}, false)
+		mu.Lock()
+		entries = append(entries, batch...)
+		mu.Unlock()
+	})
+
+	var n int
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+		r, err := NewRecord(e)
+		if err != nil {
+			continue // removed meanwhile
+		}
+		if filter.Prefix != "" && !strings.HasPrefix(r.Path, filter.Prefix) {
+			continue
+		}
+		if err = enc.Encode(r); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// ImportStats is the outcome of an import.
+type ImportStats struct {
+	Records  int      `json:"records"`
+	Imported int      `json:"imported"`
+	NoRule   int      `json:"noRule"`           // Paths of no rule, skipped.
+	Rejected int      `json:"rejected"`         // Not admitted by the storage.
+	Invalid  int      `json:"invalid"`          // Malformed records, skipped.
+	Errors   []string `json:"errors,omitempty"` // The first problems.
+}
+
+// maxImportErrors is the number of problems kept in ImportStats.Errors.
+const maxImportErrors = 20
+
+// Import rebuilds the entries of the document and stores them with their update times (see lru.Storage.Replicate),
+// a stored entry updated later than its record is kept. Malformed records are skipped.
+// Returns the stats and an error if the document is broken (the records before it are imported).
+func Import(cfg *config.Cache, db storage.Storage, revalidator model.Revalidator, r io.Reader, format string) (ImportStats, error) {
+	var stats ImportStats
+	err := Decode(r, format, func(rec Record) error {
+		stats.Records++
+		entry, err := rec.Entry(cfg, revalidator)
+		switch {
+		case model.IsRouteWasNotFound(err):
+			stats.NoRule++
+		case err != nil:
+			stats.Invalid++
+			if len(stats.Errors) < maxImportErrors {
+				stats.Errors = append(stats.Errors, err.Error())
+			}
+		case db.Replicate(entry):
+			stats.Imported++
+		default:
+			stats.Rejected++
+		}
+		return nil
+	})
+	return stats, err
+}
+
+func pairs(kvs *[][2][]byte) [][2]string {
+	if kvs == nil || len(*kvs) == 0 {
+		return nil
+	}
+	out := make([][2]string, 0, len(*kvs))
+	for _, kv := range *kvs {
+		out = append(out, [2]string{string(kv[0]), string(kv[1])})
+	}
+	return out
+}
+
+func kvs(pairs [][2]string) [][2][]byte {
+	out := make([][2][]byte, 0, len(pairs))
+	for _, kv := range pairs {
+		out = append(out, [2][]byte{[]byte(kv[0]), []byte(kv[1])})
+	}
+	return out
+}
@@ -0,0 +1,175 @@
+package exchange
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/Borislavv/advanced-cache/pkg/config"
+	"github.com/Borislavv/advanced-cache/pkg/storage/lru"
+	"github.com/Borislavv/advanced-cache/pkg/upstream"
+	"github.com/rs/zerolog"
+)
+
+func newConfig() *config.Cache {
+	zerolog.SetGlobalLevel(zerolog.ErrorLevel)
+	rule := func(path string) *config.Rule {
+		return &config.Rule{
+			PathBytes: []byte(path),
+			CacheKey: config.RuleKey{
+				Query:      []string{"id"},
+				QueryBytes: [][]byte{[]byte("id")},
+				Headers:    []string{"Accept-Language"},
+				HeadersMap: map[string]struct{}{"Accept-Language": {}},
+			},
+			CacheValue: config.RuleValue{
+				Headers:    []string{"Content-Type"},
+				HeadersMap: map[string]struct{}{"Content-Type": {}},
+			},
+		}
+	}
+	return &config.Cache{
+		Cache: &config.CacheBox{
+			Enabled:     true,
+			Proxy:       &config.Proxy{FromUrl: []byte("http://localhost:8080"), Rate: 1000, Timeout: time.Second},
+			Preallocate: config.Preallocation{PerShard: 8},
+			Eviction:    &config.Eviction{Enabled: true, Threshold: 0.9},
+			Refresh:     &config.Refresh{TTL: time.Hour, Beta: 0.4},
+			Storage:     &config.Storage{Type: "malloc", Size: 1024 * 1024 * 64},
+			Rules:       map[string]*config.Rule{"/api/pages": rule("/api/pages"), "/api/images": rule("/api/images")},
+		},
+	}
+}
+
+var records = []Record{
+	{
+		Rule: "/api/pages", Path: "/api/pages", Query: "id=1&lang=en%20US",
+		Headers: [][2]string{{"Accept-Language", "en"}}, Status: 200,
+		ResponseHeaders: [][2]string{{"Content-Type", "application/json"}}, Body: `{"title":"<b>one</b>"}`,
+	},
+	{
+		Rule: "/api/pages", Path: "/api/pages", Query: "id=2", Status: 200, Body: "two",
+	},
+	{
+		Rule: "/api/images", Path: "/api/images", Query: "id=1", Status: 200,
+		ResponseHeaders: [][2]string{{"Content-Type", "image/png"}},
+		Body:            "iVBORw0KGgr/AA==", BodyEncoding: BodyBase64,
+	},
+}
+
+// export returns the exported records sorted by path and query, update times are dropped.
+func export(t *testing.T, db *lru.InMemoryStorage, format string, filter Filter) []Record {
+	t.Helper()
+	var buf bytes.Buffer
+	enc, err := NewEncoder(&buf, format, "http://example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Export(context.Background(), db, filter, enc); err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+	if err = enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if format == FormatHAR && !json.Valid(buf.Bytes()) {
+		t.Fatalf("invalid HAR document: %s", buf.String())
+	}
+
+	var out []Record
+	if err = Decode(&buf, format, func(r Record) error {
+		r.UpdatedAt = time.Time{}
+		out = append(out, r)
+		return nil
+	}); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Path+out[i].Query < out[j].Path+out[j].Query })
+	return out
+}
+
+func TestExportImport(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg := newConfig()
+	backend := upstream.NewBackend(ctx, cfg)
+	src := lru.NewStorage(ctx, cfg, backend)
+
+	var doc bytes.Buffer
+	enc, _ := NewEncoder(&doc, FormatJSONL, "")
+	for _, r := range records {
+		_ = enc.Encode(r)
+	}
+	doc.WriteString(`{"path":"/unknown","status":200}` + "\n" + `{"path":"/api/pages","status":200,"body":"!","bodyEncoding":"base64"}` + "\n")
+	stats, err := Import(cfg, src, backend.RevalidatorMaker(), &doc, FormatJSONL)
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	if stats.Records != 5 || stats.Imported != 3 || stats.NoRule != 1 || stats.Invalid != 1 {
+		t.Fatalf("unexpected import stats %+v", stats)
+	}
+
+	want := []Record{records[2], records[0], records[1]} // by path and query
+	for _, format := range []string{FormatJSONL, FormatHAR} {
+		exported := export(t, src, format, Filter{})
+		if !reflect.DeepEqual(exported, want) {
+			t.Fatalf("%s: expected %+v, got %+v", format, want, exported)
+		}
+
+		// The export of one instance is the import of another one.
+		var buf bytes.Buffer
+		enc, _ := NewEncoder(&buf, format, "http://example.com")
+		_, _ = Export(ctx, src, Filter{}, enc)
+		_ = enc.Close()
+		dst := lru.NewStorage(ctx, cfg, backend)
+		if stats, err = Import(cfg, dst, backend.RevalidatorMaker(), &buf, format); err != nil || stats.Imported != 3 {
+			t.Fatalf("%s: expected 3 imported entries, got %+v (%v)", format, stats, err)
+		}
+		if got := export(t, dst, FormatJSONL, Filter{}); !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s: expected %+v after the import, got %+v", format, want, got)
+		}
+	}
+
+	if got := export(t, src, FormatJSONL, Filter{Rule: "/api/images"}); len(got) != 1 {
+		t.Fatalf("expected 1 entry of the rule, got %+v", got)
+	}
+	if got := export(t, src, FormatJSONL, Filter{Prefix: "/api/pa"}); len(got) != 2 {
+		t.Fatalf("expected 2 entries of the prefix, got %+v", got)
+	}
+	if got := export(t, src, FormatJSONL, Filter{MinAge: time.Hour}); len(got) != 0 {
+		t.Fatalf("expected no entries older than an hour, got %+v", got)
+	}
+	if got := export(t, src, FormatJSONL, Filter{MaxAge: time.Hour}); len(got) != 3 {
+		t.Fatalf("expected 3 entries updated within an hour, got %+v", got)
+	}
+
+	// The update time of a record is restored.
+	old := records[1]
+	old.UpdatedAt = time.Now().Add(-2 * time.Hour)
+	doc.Reset()
+	enc, _ = NewEncoder(&doc, FormatJSONL, "")
+	_ = enc.Encode(old)
+	dst := lru.NewStorage(ctx, cfg, backend)
+	if stats, err = Import(cfg, dst, backend.RevalidatorMaker(), &doc, FormatJSONL); err != nil || stats.Imported != 1 {
+		t.Fatalf("expected 1 imported entry, got %+v (%v)", stats, err)
+	}
+	if got := export(t, dst, FormatJSONL, Filter{MinAge: time.Hour}); len(got) != 1 {
+		t.Fatalf("expected the entry updated 2 hours ago, got %+v", got)
+	}
+
+	// A stored entry takes the payload of a later record with its update time.
+	newer := old
+	newer.Body, newer.UpdatedAt = "two, updated", time.Now().Add(-90*time.Minute)
+	doc.Reset()
+	enc, _ = NewEncoder(&doc, FormatJSONL, "")
+	_ = enc.Encode(newer)
+	if stats, err = Import(cfg, dst, backend.RevalidatorMaker(), &doc, FormatJSONL); err != nil || stats.Imported != 1 {
+		t.Fatalf("expected 1 imported entry, got %+v (%v)", stats, err)
+	}
+	if got := export(t, dst, FormatJSONL, Filter{MinAge: time.Hour}); len(got) != 1 || got[0].Body != newer.Body {
+		t.Fatalf("expected the entry updated 90 minutes ago, got %+v", got)
+	}
+}
@@ -0,0 +1,251 @@
+package exchange
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// maxLineSize is the max length of a JSONL record.
+const maxLineSize = 64 << 20
+
+type jsonlEncoder struct {
+	enc *json.Encoder
+}
+
+func newJSONLEncoder(w io.Writer) *jsonlEncoder {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return &jsonlEncoder{enc: enc}
+}
+
+func (e *jsonlEncoder) Encode(r Record) error { return e.enc.Encode(r) }
+func (e *jsonlEncoder) Close() error          { return nil }
+
+func decodeJSONL(r io.Reader, fn func(Record) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	for line := 1; scanner.Scan(); line++ {
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// HAR 1.2 (http://www.softwareishard.com/blog/har-12-spec/), the rule of an entry is the custom "_rule" field,
+// startedDateTime is the time the entry was updated at.
+type (
+	harDocument struct {
+		Log struct {
+			Version string     `json:"version"`
+			Creator harCreator `json:"creator"`
+			Entries []harEntry `json:"entries"`
+		} `json:"log"`
+	}
+	harCreator struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	}
+	harEntry struct {
+		StartedDateTime time.Time   `json:"startedDateTime"`
+		Time            float64     `json:"time"`
+		Request         harRequest  `json:"request"`
+		Response        harResponse `json:"response"`
+		Cache           struct{}    `json:"cache"`
+		Timings         harTimings  `json:"timings"`
+		Rule            string      `json:"_rule,omitempty"`
+	}
+	harRequest struct {
+		Method      string    `json:"method"`
+		URL         string    `json:"url"`
+		HTTPVersion string    `json:"httpVersion"`
+		Cookies     []harPair `json:"cookies"`
+		Headers     []harPair `json:"headers"`
+		QueryString []harPair `json:"queryString"`
+		HeadersSize int       `json:"headersSize"`
+		BodySize    int       `json:"bodySize"`
+	}
+	harResponse struct {
+		Status      int        `json:"status"`
+		StatusText  string     `json:"statusText"`
+		HTTPVersion string     `json:"httpVersion"`
+		Cookies     []harPair  `json:"cookies"`
+		Headers     []harPair  `json:"headers"`
+		Content     harContent `json:"content"`
+		RedirectURL string     `json:"redirectURL"`
+		HeadersSize int        `json:"headersSize"`
+		BodySize    int        `json:"bodySize"`
+	}
+	harContent struct {
+		Size     int    `json:"size"`
+		MimeType string `json:"mimeType"`
+		Text     string `json:"text"`
+		Encoding string `json:"encoding,omitempty"`
+	}
+	harPair struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}
+	harTimings struct {
+		Send    float64 `json:"send"`
+		Wait    float64 `json:"wait"`
+		Receive float64 `json:"receive"`
+	}
+)
+
+// harEncoder streams entries into the entries array of a HAR document.
+type harEncoder struct {
+	w       io.Writer
+	baseURL string
+	entries int
+	err     error
+}
+
+func newHAREncoder(w io.Writer, baseURL string) *harEncoder {
+	return &harEncoder{w: w, baseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+func (e *harEncoder) Encode(r Record) error {
+	if e.entries == 0 {
+		e.write(`{"log":{"version":"1.2","creator":{"name":"advanced-cache","version":"1"},"entries":[`)
+	} else {
+		e.write(",")
+	}
+	data, err := json.Marshal(e.entry(r))
+	if err != nil {
+		return err
+	}
+	e.write(string(data))
+	e.entries++
+	return e.err
+}
+
+func (e *harEncoder) Close() error {
+	if e.entries == 0 {
+		e.write(`{"log":{"version":"1.2","creator":{"name":"advanced-cache","version":"1"},"entries":[`)
+	}
+	e.write("]}}\n")
+	return e.err
+}
+
+func (e *harEncoder) write(s string) {
+	if e.err == nil {
+		_, e.err = io.WriteString(e.w, s)
+	}
+}
+
+func (e *harEncoder) entry(r Record) harEntry {
+	rawURL := e.baseURL + r.Path
+	if r.Query != "" {
+		rawURL += "?" + r.Query
+	}
+	query := []harPair{}
+	for _, param := range strings.Split(r.Query, "&") {
+		if param == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(param, "=")
+		if n, err := url.QueryUnescape(name); err == nil {
+			name = n
+		}
+		if v, err := url.QueryUnescape(value); err == nil {
+			value = v
+		}
+		query = append(query, harPair{Name: name, Value: value})
+	}
+	mimeType := "application/octet-stream"
+	for _, kv := range r.ResponseHeaders {
+		if strings.EqualFold(kv[0], "Content-Type") {
+			mimeType = kv[1]
+		}
+	}
+	body := len(r.Body)
+	if r.BodyEncoding == BodyBase64 {
+		body = base64.StdEncoding.DecodedLen(len(r.Body)) - strings.Count(r.Body[max(len(r.Body)-2, 0):], "=")
+	}
+
+	return harEntry{
+		StartedDateTime: r.UpdatedAt,
+		Rule:            r.Rule,
+		Request: harRequest{
+			Method:      http.MethodGet,
+			URL:         rawURL,
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []harPair{},
+			Headers:     harPairs(r.Headers),
+			QueryString: query,
+			HeadersSize: -1,
+		},
+		Response: harResponse{
+			Status:      r.Status,
+			StatusText:  http.StatusText(r.Status),
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []harPair{},
+			Headers:     harPairs(r.ResponseHeaders),
+			Content:     harContent{Size: body, MimeType: mimeType, Text: r.Body, Encoding: r.BodyEncoding},
+			HeadersSize: -1,
+			BodySize:    body,
+		},
+	}
+}
+
+// decodeHAR reads a HAR document, the host of request URLs is ignored.
+func decodeHAR(r io.Reader, fn func(Record) error) error {
+	var doc harDocument
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return err
+	}
+	for _, e := range doc.Log.Entries {
+		u, err := url.Parse(e.Request.URL)
+		if err != nil {
+			u = &url.URL{} // an invalid path of the record
+		}
+		rec := Record{
+			Rule:            e.Rule,
+			Path:            u.Path,
+			Query:           u.RawQuery,
+			Headers:         recordPairs(e.Request.Headers),
+			Status:          e.Response.Status,
+			ResponseHeaders: recordPairs(e.Response.Headers),
+			Body:            e.Response.Content.Text,
+			BodyEncoding:    e.Response.Content.Encoding,
+			UpdatedAt:       e.StartedDateTime,
+		}
+		if err = fn(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func harPairs(pairs [][2]string) []harPair {
+	out := make([]harPair, 0, len(pairs))
+	for _, kv := range pairs {
+		out = append(out, harPair{Name: kv[0], Value: kv[1]})
+	}
+	return out
+}
+
+func recordPairs(pairs []harPair) [][2]string {
+	var out [][2]string
+	for _, p := range pairs {
+		out = append(out, [2]string{p.Name, p.Value})
+	}
+	return out
+}
@@ -0,0 +1,228 @@
+package exchange
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// StaticIndexName is the index file of a static site export, it is written last.
+const StaticIndexName = "_index.json"
+
+// HeadersSuffix is appended to the file of a page to name its sidecar headers file.
+const HeadersSuffix = ".headers"
+
+// staticExtensions are the file extensions of the content types a static server has to recognize.
+var staticExtensions = map[string]string{
+	"text/html":              ".html",
+	"application/json":       ".json",
+	"application/ld+json":    ".json",
+	"text/xml":               ".xml",
+	"application/xml":        ".xml",
+	"application/rss+xml":    ".xml",
+	"application/atom+xml":   ".xml",
+	"text/plain":             ".txt",
+	"text/css":               ".css",
+	"text/javascript":        ".js",
+	"application/javascript": ".js",
+	"image/png":              ".png",
+	"image/jpeg":             ".jpg",
+	"image/gif":              ".gif",
+	"image/webp":             ".webp",
+	"image/svg+xml":          ".svg",
+}
+
+// StaticPage is an entry of the index of a static site export.
+type StaticPage struct {
+	URL         string      `json:"url"`               // Path and query of the request.
+	Variant     [][2]string `json:"variant,omitempty"` // Key headers of the request.
+	File        string      `json:"file"`              // Relative to the export directory.
+	HeadersFile string      `json:"headersFile"`
+	ContentType string      `json:"contentType,omitempty"`
+	Size        int         `json:"size"`
+	UpdatedAt   time.Time   `json:"updatedAt"`
+}
+
+// StaticSite is an Encoder writing each 200 response as a file a plain static server can serve:
+//
+//	/about                    -> about/index.html
+//	/api/v2/pagedata?id=1 + Accept-Language: en -> api/v2/pagedata/index.<hash>.json
+//
+// The path of a request is a directory (so /a and /a/b do not conflict), the file is "index" with a hash of
+// the query and the key headers (if any) and the extension of the content type. Next to each file its status
+// and response headers are written to the sidecar file of HeadersSuffix ("Name: value" lines after
+// "Status: 200"), and Close writes StaticIndexName mapping URLs and variants to files. Files left
+// by previous exports are not removed, the index is the list of the current ones.
+type StaticSite struct {
+	dir     string
+	pages   []StaticPage
+	skipped int
+}
+
+// NewStaticSite creates the directory of the export if it doesn't exist.
+func NewStaticSite(dir string) (*StaticSite, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &StaticSite{dir: dir}, nil
+}
+
+// Encode writes the page and its headers, responses with another status than 200 are skipped.
+func (s *StaticSite) Encode(r Record) error {
+	if r.Status != http.StatusOK {
+		s.skipped++
+		return nil
+	}
+	body, err := r.BodyBytes()
+	if err != nil {
+		return err
+	}
+	contentType := ""
+	for _, kv := range r.ResponseHeaders {
+		if strings.EqualFold(kv[0], "Content-Type") {
+			contentType = kv[1]
+		}
+	}
+
+	file := StaticFile(r.Path, r.Query, r.Headers, contentType)
+	page := StaticPage{
+		URL:         r.Path,
+		Variant:     r.Headers,
+		File:        file,
+		HeadersFile: file + HeadersSuffix,
+		ContentType: contentType,
+		Size:        len(body),
+		UpdatedAt:   r.UpdatedAt,
+	}
+	if r.Query != "" {
+		page.URL += "?" + r.Query
+	}
+
+	var headers strings.Builder
+	fmt.Fprintf(&headers, "Status: %d\n", r.Status)
+	for _, kv := range r.ResponseHeaders {
+		fmt.Fprintf(&headers, "%s: %s\n", kv[0], kv[1])
+	}
+	name := filepath.Join(s.dir, filepath.FromSlash(file))
+	if err = os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+	if err = writeFile(name, body); err != nil {
+		return err
+	}
+	if err = writeFile(name+HeadersSuffix, []byte(headers.String())); err != nil {
+		return err
+	}
+	s.pages = append(s.pages, page)
+	return nil
+}
+
+// Close writes the index sorted by URL and variant.
+func (s *StaticSite) Close() error {
+	sort.Slice(s.pages, func(i, j int) bool {
+		if s.pages[i].URL != s.pages[j].URL {
+			return s.pages[i].URL < s.pages[j].URL
+		}
+		return s.pages[i].File < s.pages[j].File
+	})
+	index := struct {
+		Pages   []StaticPage `json:"pages"`
+		Skipped int          `json:"skipped"` // Responses of other statuses than 200.
+	}{Pages: s.pages, Skipped: s.skipped}
+	if index.Pages == nil {
+		index.Pages = []StaticPage{}
+	}
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(s.dir, StaticIndexName), append(data, '\n'))
+}
+
+// Pages returns the number of written pages.
+func (s *StaticSite) Pages() int { return len(s.pages) }
+
+// StaticFile returns the slash-separated path of the file of a response relative to the export directory.
+// Path segments are sanitized, a hash of the original path, the query and the key headers distinguishes
+// files of the same directory (it is omitted for a plain path, so /about is served from about/index.html).
+func StaticFile(path, query string, headers [][2]string, contentType string) string {
+	segments := make([]string, 0, strings.Count(path, "/"))
+	sanitized := false
+	for _, segment := range strings.Split(path, "/") {
+		clean := sanitizeSegment(segment)
+		if clean != segment {
+			sanitized = true
+		}
+		if clean != "" {
+			segments = append(segments, clean)
+		}
+	}
+
+	name := "index"
+	if query != "" || len(headers) > 0 || sanitized {
+		h := sha256.New()
+		h.Write([]byte(path))
+		h.Write([]byte{0})
+		h.Write([]byte(query))
+		for _, kv := range headers {
+			h.Write([]byte{0})
+			h.Write([]byte(strings.ToLower(kv[0])))
+			h.Write([]byte{':'})
+			h.Write([]byte(kv[1]))
+		}
+		name += "." + hex.EncodeToString(h.Sum(nil)[:8])
+	}
+	return strings.Join(append(segments, name+staticExtension(contentType)), "/")
+}
+
+// sanitizeSegment keeps letters, digits and "-_.~" of a path segment, "." and ".." are dropped.
+// Segments starting with "index" or "_index" are prefixed with "_", so a directory never takes the name
+// of a page, of its headers file or of the index.
+func sanitizeSegment(segment string) string {
+	if segment == "." || segment == ".." {
+		return ""
+	}
+	if strings.HasPrefix(segment, "index") || strings.HasPrefix(segment, "_index") {
+		segment = "_" + segment
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.', r == '~':
+			return r
+		default:
+			return '_'
+		}
+	}, segment)
+}
+
+func staticExtension(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ".bin"
+	}
+	if ext, ok := staticExtensions[mediaType]; ok {
+		return ext
+	}
+	return ".bin"
+}
+
+// writeFile replaces the file atomically, so a static server never serves a partially written one.
+func writeFile(name string, data []byte) error {
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		return errors.Join(err, os.Remove(tmp))
+	}
+	return nil
+}
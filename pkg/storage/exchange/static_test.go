@@ -0,0 +1,105 @@
+package exchange
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Borislavv/advanced-cache/pkg/storage/lru"
+	"github.com/Borislavv/advanced-cache/pkg/upstream"
+)
+
+func TestStaticFile(t *testing.T) {
+	variant := [][2]string{{"Accept-Language", "en"}}
+	for _, tc := range []struct {
+		path, query string
+		headers     [][2]string
+		contentType string
+		want        string
+	}{
+		{path: "/", contentType: "text/html; charset=utf-8", want: "index.html"},
+		{path: "/about", contentType: "text/html", want: "about/index.html"},
+		{path: "/about/", contentType: "text/html", want: "about/index.html"},
+		{path: "/api/pages", query: "id=1", contentType: "application/json", want: "api/pages/index.d196c2c494b18ce4.json"},
+		{path: "/api/pages", query: "id=1", headers: variant, contentType: "application/json", want: "api/pages/index.a8f7ccf46f2a5cd4.json"},
+		{path: "/../etc/pass wd", want: "etc/pass_wd/index.e42367b17aea27a9.bin"},
+		{path: "/_index.json", contentType: "application/json", want: "__index.json/index.860958c521ce0762.json"},
+	} {
+		got := StaticFile(tc.path, tc.query, tc.headers, tc.contentType)
+		if got != tc.want {
+			t.Errorf("StaticFile(%q, %q, %v, %q): expected %q, got %q", tc.path, tc.query, tc.headers, tc.contentType, tc.want, got)
+		}
+		if again := StaticFile(tc.path, tc.query, tc.headers, tc.contentType); again != got {
+			t.Errorf("StaticFile(%q) is not deterministic: %q != %q", tc.path, got, again)
+		}
+	}
+}
+
+func TestStaticSite(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg := newConfig()
+	backend := upstream.NewBackend(ctx, cfg)
+	db := lru.NewStorage(ctx, cfg, backend)
+	for _, r := range append(records, Record{Path: "/api/pages", Query: "id=3", Status: 404, Body: "not found"}) {
+		e, err := r.Entry(cfg, backend.RevalidatorMaker())
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.Set(e)
+	}
+
+	dir := t.TempDir()
+	site, err := NewStaticSite(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Export(ctx, db, Filter{}, site); err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+	if err = site.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, StaticIndexName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var index struct {
+		Pages   []StaticPage `json:"pages"`
+		Skipped int          `json:"skipped"`
+	}
+	if err = json.Unmarshal(data, &index); err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Pages) != 3 || index.Skipped != 1 {
+		t.Fatalf("expected 3 pages and 1 skipped response, got %+v", index)
+	}
+	for _, page := range index.Pages {
+		body, err := os.ReadFile(filepath.Join(dir, page.File))
+		if err != nil || len(body) != page.Size {
+			t.Fatalf("expected %d bytes in %s, got %d (%v)", page.Size, page.File, len(body), err)
+		}
+		headers, err := os.ReadFile(filepath.Join(dir, page.HeadersFile))
+		if err != nil || string(headers[:len("Status: 200\n")]) != "Status: 200\n" {
+			t.Fatalf("expected the status in %s, got %q (%v)", page.HeadersFile, headers, err)
+		}
+	}
+	if page := index.Pages[0]; page.URL != "/api/images?id=1" || string(mustRead(t, filepath.Join(dir, page.File))[:4]) != "\x89PNG" {
+		t.Fatalf("expected the decoded image first, got %+v", page)
+	}
+	if page := index.Pages[1]; page.URL != "/api/pages?id=1&lang=en%20US" || len(page.Variant) != 1 || page.ContentType != "application/json" {
+		t.Fatalf("expected the page variant, got %+v", page)
+	}
+}
+
+func mustRead(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
@@ -0,0 +1,278 @@
+// Package inspect reads dump files offline (without a running cache): verifies records,
+// decodes them into entries and collects statistics.
+package inspect
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Borislavv/advanced-cache/pkg/config"
+	"github.com/Borislavv/advanced-cache/pkg/model"
+	"github.com/Borislavv/advanced-cache/pkg/storage"
+	"github.com/Borislavv/advanced-cache/pkg/storage/snapshot"
+)
+
+// maxProblems is the number of problems kept in Stats.Problems.
+const maxProblems = 100
+
+// ageBuckets are the upper bounds of Stats.Ages, the last bucket has no bound.
+var ageBuckets = []time.Duration{time.Minute, 10 * time.Minute, time.Hour, 6 * time.Hour, 24 * time.Hour, 7 * 24 * time.Hour}
+
+// Options of Inspect.
+type Options struct {
+	Crc32Control bool             // Verify CRC32 of records (dump.crc32_control_sum).
+	Keys         snapshot.Keyring // Keys of encrypted snapshots (see storage.LoadDumpKeys).
+	Filter       Filter           // Only matching entries are passed to fn and counted in the rule and age statistics.
+	BodyLimit    int              // Number of body bytes put into Record.Body, 0 means none.
+	Now          time.Time        // Ages are counted relative to it, time.Now() if zero.
+}
+
+// Filter of entries, empty fields match everything.
+type Filter struct {
+	Rule  string // Rule path, exact match.
+	Path  string // Request path prefix.
+	Query string // Substring of the raw query.
+}
+
+func (f Filter) match(r *Record) bool {
+	return (f.Rule == "" || r.Rule == f.Rule) &&
+		(f.Path == "" || strings.HasPrefix(r.Path, f.Path)) &&
+		(f.Query == "" || strings.Contains(r.Query, f.Query))
+}
+
+// Record is a decoded entry of a dump.
+type Record struct {
+	File         string      `json:"file"`
+	Rule         string      `json:"rule"`
+	NoRule       bool        `json:"noRule,omitempty"` // The rule is not configured (the entry would be dropped on load).
+	Key          uint64      `json:"key"`
+	Shard        uint64      `json:"shard"`
+	Path         string      `json:"path"`
+	Query        string      `json:"query,omitempty"`
+	QueryHeaders [][2]string `json:"queryHeaders,omitempty"`
+	Status       int         `json:"status"`
+	Headers      [][2]string `json:"headers,omitempty"`
+	BodySize     int         `json:"bodySize"`
+	Body         string      `json:"body,omitempty"`
+	Size         int         `json:"size"` // Size of the record in the dump (bytes).
+	UpdatedAt    time.Time   `json:"updatedAt"`
+	Age          string      `json:"age"`
+	AccessedAt   time.Time   `json:"accessedAt,omitzero"` // Last access, zero for dumps without heat (see lru.Heat).
+	Frequency    uint32      `json:"frequency"`           // Recent writes of the key when dumped.
+}
+
+// RuleStats are statistics of entries of a single rule.
+type RuleStats struct {
+	Entries int   `json:"entries"`
+	Bytes   int64 `json:"bytes"`
+	MinSize int   `json:"minSize"`
+	MaxSize int   `json:"maxSize"`
+	NoRule  bool  `json:"noRule,omitempty"`
+}
+
+// AgeBucket counts entries updated not longer than Le ago (the last bucket is unbounded: Le is 0).
+type AgeBucket struct {
+	Le      time.Duration `json:"le"`
+	Entries int           `json:"entries"`
+}
+
+// Stats of an inspected dump.
+type Stats struct {
+	Files        int                   `json:"files"`
+	BrokenFiles  int                   `json:"brokenFiles"`  // Files which could not be read till the end.
+	Records      int                   `json:"records"`      // Verified records (passed CRC checks).
+	Bytes        int64                 `json:"bytes"`        // Size of verified records.
+	CRCErrors    int                   `json:"crcErrors"`    // Records (legacy) or blocks (snapshot) with mismatched CRC32.
+	DecodeErrors int                   `json:"decodeErrors"` // Records which could not be decoded.
+	NoRule       int                   `json:"noRule"`       // Decoded records of not configured rules.
+	Matched      int                   `json:"matched"`      // Decoded records matching the filter.
+	Rules        map[string]*RuleStats `json:"rules"`        // Matched records by rule.
+	Ages         []AgeBucket           `json:"ages"`         // Matched records by age.
+	Oldest       time.Time             `json:"oldest"`
+	Newest       time.Time             `json:"newest"`
+	Problems     []string              `json:"problems,omitempty"`  // The first problems as "file: message".
+	Snapshots    []snapshot.Header     `json:"snapshots,omitempty"` // Headers of snapshot files.
+}
+
+// Corrupted reports whether any record or file could not be read, verified or decoded.
+// Records of not configured rules are not corruption.
+func (s *Stats) Corrupted() bool {
+	return s.BrokenFiles+s.CRCErrors+s.DecodeErrors > 0
+}
+
+func (s *Stats) problem(file string, err error) {
+	if len(s.Problems) < maxProblems {
+		s.Problems = append(s.Problems, filepath.Base(file)+": "+err.Error())
+	}
+}
+
+// Inspect reads the dump files (see storage.DumpParts), fn is called for each decoded entry matching the filter.
+// An error of fn stops the inspection and is returned with the stats collected so far.
+func Inspect(cfg *config.Cache, parts []storage.DumpPart, opts Options, fn func(*Record) error) (*Stats, error) {
+	if opts.Now.IsZero() {
+		opts.Now = time.Now()
+	}
+	stats := &Stats{Files: len(parts), Rules: make(map[string]*RuleStats)}
+	for _, le := range append(ageBuckets, 0) {
+		stats.Ages = append(stats.Ages, AgeBucket{Le: le})
+	}
+
+	var fnErr error
+	for _, part := range parts {
+		file := part.File
+		err := part.Read(storage.DumpReadOptions{
+			Crc32Control: opts.Crc32Control,
+			Keys:         opts.Keys,
+			Header: func(h snapshot.Header) error {
+				stats.Snapshots = append(stats.Snapshots, h)
+				return nil
+			},
+			Record: func(data []byte, heat storage.Heat) error {
+				stats.Records++
+				stats.Bytes += int64(len(data))
+
+				record, err := decode(cfg, data, opts)
+				if err != nil {
+					stats.DecodeErrors++
+					stats.problem(file, err)
+					return nil
+				}
+				record.File = filepath.Base(file)
+				if heat.AccessedAt != 0 {
+					record.AccessedAt = time.Unix(0, heat.AccessedAt)
+				}
+				record.Frequency = heat.Frequency
+				if record.NoRule {
+					stats.NoRule++
+				}
+				if !opts.Filter.match(record) {
+					return nil
+				}
+				stats.add(record, opts.Now)
+
+				if fn != nil {
+					if fnErr = fn(record); fnErr != nil {
+						return fnErr
+					}
+				}
+				return nil
+			},
+			Corrupt: func(err error) {
+				switch {
+				case errors.Is(err, snapshot.ChecksumError):
+					stats.CRCErrors++
+				case errors.Is(err, snapshot.TruncatedError):
+					stats.BrokenFiles++
+				default:
+					stats.DecodeErrors++
+				}
+				stats.problem(file, err)
+			},
+		})
+		if fnErr != nil {
+			return stats, fnErr
+		}
+		if errors.Is(err, snapshot.KeyError) {
+			return stats, fmt.Errorf("%s: %w", file, err)
+		}
+		if err != nil {
+			stats.BrokenFiles++
+			stats.problem(file, err)
+		}
+	}
+	return stats, nil
+}
+
+func (s *Stats) add(r *Record, now time.Time) {
+	s.Matched++
+
+	rule, ok := s.Rules[r.Rule]
+	if !ok {
+		rule = &RuleStats{MinSize: r.Size, NoRule: r.NoRule}
+		s.Rules[r.Rule] = rule
+	}
+	rule.Entries++
+	rule.Bytes += int64(r.Size)
+	rule.MinSize = min(rule.MinSize, r.Size)
+	rule.MaxSize = max(rule.MaxSize, r.Size)
+
+	age := now.Sub(r.UpdatedAt)
+	bucket := len(s.Ages) - 1
+	for i, le := range ageBuckets {
+		if age <= le {
+			bucket = i
+			break
+		}
+	}
+	s.Ages[bucket].Entries++
+
+	if s.Oldest.IsZero() || r.UpdatedAt.Before(s.Oldest) {
+		s.Oldest = r.UpdatedAt
+	}
+	if r.UpdatedAt.After(s.Newest) {
+		s.Newest = r.UpdatedAt
+	}
+}
+
+// decode unpacks a record, entries of not configured rules are decoded with an empty rule.
+func decode(cfg *config.Cache, data []byte, opts Options) (record *Record, err error) {
+	var noRule bool
+	entry, err := model.EntryFromBytesWithRule(data, func(path []byte) *config.Rule {
+		if rule := model.MatchRule(cfg, path); rule != nil {
+			return rule
+		}
+		noRule = true
+		return &config.Rule{PathBytes: append([]byte(nil), path...)}
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Payload does not check bounds, a corrupted payload panics.
+	defer func() {
+		if r := recover(); r != nil {
+			record, err = nil, fmt.Errorf("malformed payload: %v", r)
+		}
+	}()
+
+	path, query, queryHeaders, headers, body, status, release, err := entry.Payload()
+	defer release(queryHeaders, headers)
+	if err != nil {
+		return nil, err
+	}
+
+	updatedAt := time.Unix(0, entry.UpdateAt())
+	record = &Record{
+		Rule:         string(entry.Rule().PathBytes),
+		NoRule:       noRule,
+		Key:          entry.MapKey(),
+		Shard:        entry.ShardKey(),
+		Path:         string(path),
+		Query:        string(query),
+		QueryHeaders: pairs(queryHeaders),
+		Status:       status,
+		Headers:      pairs(headers),
+		BodySize:     len(body),
+		Size:         len(data),
+		UpdatedAt:    updatedAt,
+		Age:          opts.Now.Sub(updatedAt).Truncate(time.Second).String(),
+	}
+	if opts.BodyLimit > 0 {
+		record.Body = string(body[:min(len(body), opts.BodyLimit)])
+	}
+	return record, nil
+}
+
+func pairs(kvs *[][2][]byte) [][2]string {
+	if kvs == nil || len(*kvs) == 0 {
+		return nil
+	}
+	out := make([][2]string, 0, len(*kvs))
+	for _, kv := range *kvs {
+		out = append(out, [2]string{string(kv[0]), string(kv[1])})
+	}
+	return out
+}
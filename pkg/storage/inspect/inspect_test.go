@@ -0,0 +1,132 @@
+package inspect
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Borislavv/advanced-cache/pkg/config"
+	"github.com/Borislavv/advanced-cache/pkg/model"
+	"github.com/Borislavv/advanced-cache/pkg/storage"
+)
+
+const testConfig = `cache:
+  env: "test"
+  proxy:
+    from: "http://localhost:8080"
+    rate: 80
+  rules:
+    /api/v1/page:
+      cache_key:
+        query: [id]
+    /api/v1/gone:
+      cache_key:
+        query: [id]
+`
+
+func loadTestConfig(t *testing.T, data string) *config.Cache {
+	path := filepath.Join(t.TempDir(), "cfg.yaml")
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	return cfg
+}
+
+func writeEntry(t *testing.T, w *storage.DumpFileWriter, cfg *config.Cache, path, query string, updatedAgo time.Duration) {
+	entry, err := model.NewEntryManual(cfg, []byte(path), []byte(query), &[][2][]byte{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry.SetPayload([]byte(path), []byte(query), &[][2][]byte{}, &[][2][]byte{{[]byte("Content-Type"), []byte("text/plain")}}, []byte("hello"), 200)
+	data, release := entry.ToBytes()
+	defer release()
+
+	// UpdateAt is the last 8 bytes before the payload length and the payload.
+	record := append([]byte(nil), data...)
+	at := len(record) - len(entry.PayloadBytes()) - 4 - 8
+	ts := time.Now().Add(-updatedAgo).UnixNano()
+	for i := 0; i < 8; i++ {
+		record[at+i] = byte(ts >> (8 * i))
+	}
+	if err = w.Write(record); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInspect(t *testing.T) {
+	cfg := loadTestConfig(t, testConfig)
+	dir := t.TempDir()
+
+	good, err := storage.NewDumpFileWriter(filepath.Join(dir, "dump-shard-1-20250101T000000.dump"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeEntry(t, good, cfg, "/api/v1/page", "id=1", time.Second)
+	writeEntry(t, good, cfg, "/api/v1/page", "id=2", 2*time.Hour)
+	writeEntry(t, good, cfg, "/api/v1/gone", "id=3", 30*24*time.Hour)
+	if err = good.Write([]byte{1, 2, 3}); err != nil { // truncated record
+		t.Fatal(err)
+	}
+	if err = good.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	noCRC, err := storage.NewDumpFileWriter(filepath.Join(dir, "dump-shard-2-20250101T000000.dump"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeEntry(t, noCRC, cfg, "/api/v1/page", "id=4", time.Second)
+	if err = noCRC.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The rule was removed from the config after the dump was made.
+	current := loadTestConfig(t, testConfig[:len(testConfig)-len("    /api/v1/gone:\n      cache_key:\n        query: [id]\n")])
+	parts := storage.DumpParts(dir, "dump")
+
+	var records []*Record
+	stats, err := Inspect(current, parts, Options{Crc32Control: true, BodyLimit: 3}, func(r *Record) error {
+		records = append(records, r)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats.Records != 4 || stats.CRCErrors != 1 || stats.DecodeErrors != 1 || stats.NoRule != 1 || stats.Matched != 3 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	if !stats.Corrupted() || len(stats.Problems) != 2 {
+		t.Fatalf("expected the dump to be corrupted with 2 problems, got %v", stats.Problems)
+	}
+	if page := stats.Rules["/api/v1/page"]; page == nil || page.Entries != 2 {
+		t.Fatalf("unexpected rule stats: %+v", stats.Rules)
+	}
+	if gone := stats.Rules["/api/v1/gone"]; gone == nil || !gone.NoRule {
+		t.Fatalf("expected stats of the removed rule, got %+v", stats.Rules)
+	}
+	if stats.Ages[0].Entries != 1 || stats.Ages[3].Entries != 1 || stats.Ages[len(stats.Ages)-1].Entries != 1 {
+		t.Fatalf("unexpected ages: %+v", stats.Ages)
+	}
+
+	first := records[0]
+	if first.Path != "/api/v1/page" || first.Query != "id=1" || first.Status != 200 || first.Body != "hel" || first.BodySize != 5 {
+		t.Fatalf("unexpected record: %+v", first)
+	}
+	if len(first.Headers) != 1 || first.Headers[0] != [2]string{"Content-Type", "text/plain"} {
+		t.Fatalf("unexpected headers: %+v", first.Headers)
+	}
+
+	stats, err = Inspect(current, parts, Options{Filter: Filter{Query: "id=2"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Matched != 1 || stats.CRCErrors != 0 {
+		t.Fatalf("unexpected filtered stats: %+v", stats)
+	}
+}
@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// maxFinishedJobs is the number of finished jobs kept for polling.
+const maxFinishedJobs = 32
+
+// Job statuses.
+const (
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+var (
+	JobBusyError     = errors.New("another job is running")
+	JobNotFoundError = errors.New("job not found")
+)
+
+// Progress of a job, methods are safe to call on nil.
+type Progress struct {
+	done   atomic.Int64
+	failed atomic.Int64
+	total  atomic.Int64
+}
+
+// Add adds n processed items.
+func (p *Progress) Add(n int64) {
+	if p != nil {
+		p.done.Add(n)
+	}
+}
+
+// Fail adds n failed items (they are counted as processed too).
+func (p *Progress) Fail(n int64) {
+	if p != nil {
+		p.failed.Add(n)
+		p.done.Add(n)
+	}
+}
+
+// SetTotal sets up the expected number of items, 0 means unknown.
+func (p *Progress) SetTotal(n int64) {
+	if p != nil {
+		p.total.Store(n)
+	}
+}
+
+// JobInfo is a point-in-time view of a job.
+type JobInfo struct {
+	ID       string    `json:"id"`
+	Kind     string    `json:"kind"`
+	Target   string    `json:"target,omitempty"`
+	Status   string    `json:"status"`
+	Done     int64     `json:"done"`
+	Failed   int64     `json:"failed"`
+	Total    int64     `json:"total"` // 0 if unknown
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished,omitzero"`
+	Error    string    `json:"error,omitempty"`
+}
+
+type job struct {
+	mu       sync.Mutex
+	info     JobInfo
+	progress Progress
+}
+
+func (j *job) view() JobInfo {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	info := j.info
+	info.Done, info.Failed, info.Total = j.progress.done.Load(), j.progress.failed.Load(), j.progress.total.Load()
+	return info
+}
+
+// Jobs runs long operations one at a time in background and keeps them for polling.
+type Jobs struct {
+	mu      sync.Mutex
+	seq     int
+	running *job
+	jobs    []*job // the oldest first
+}
+
+func NewJobs() *Jobs {
+	return &Jobs{}
+}
+
+// Start runs fn in background unless another job is running (JobBusyError).
+func (j *Jobs) Start(ctx context.Context, kind, target string, fn func(ctx context.Context, p *Progress) error) (JobInfo, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.running != nil {
+		return j.running.view(), JobBusyError
+	}
+
+	j.seq++
+	started := &job{info: JobInfo{ID: strconv.Itoa(j.seq), Kind: kind, Target: target, Status: JobRunning, Started: time.Now()}}
+	j.running = started
+	j.jobs = append(j.jobs, started)
+	if len(j.jobs) > maxFinishedJobs+1 {
+		j.jobs = append(j.jobs[:0], j.jobs[len(j.jobs)-maxFinishedJobs-1:]...)
+	}
+
+	go func() {
+		err := fn(ctx, &started.progress)
+
+		// The job is finished and not running at once, so a next one may start as soon as it's polled finished.
+		j.mu.Lock()
+		started.mu.Lock()
+		started.info.Status, started.info.Finished = JobDone, time.Now()
+		if err != nil {
+			started.info.Status, started.info.Error = JobFailed, err.Error()
+		}
+		started.mu.Unlock()
+		j.running = nil
+		j.mu.Unlock()
+
+		info := started.view()
+		log.Info().Msgf("[jobs] %s %s %s: %s, %d/%d (failed: %d), elapsed: %s",
+			info.Kind, info.Target, info.ID, info.Status, info.Done, info.Total, info.Failed, info.Finished.Sub(info.Started))
+	}()
+	return started.view(), nil
+}
+
+// Running returns the running job if there is one.
+func (j *Jobs) Running() (JobInfo, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.running == nil {
+		return JobInfo{}, false
+	}
+	return j.running.view(), true
+}
+
+// Get returns the job of the id.
+func (j *Jobs) Get(id string) (JobInfo, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, job := range j.jobs {
+		if job.info.ID == id {
+			return job.view(), nil
+		}
+	}
+	return JobInfo{}, JobNotFoundError
+}
+
+// List returns the running and recently finished jobs, the newest first.
+func (j *Jobs) List() []JobInfo {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	infos := make([]JobInfo, 0, len(j.jobs))
+	for i := len(j.jobs) - 1; i >= 0; i-- {
+		infos = append(infos, j.jobs[i].view())
+	}
+	return infos
+}
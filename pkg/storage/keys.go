@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Borislavv/advanced-cache/pkg/config"
+	"github.com/Borislavv/advanced-cache/pkg/storage/snapshot"
+)
+
+// LoadDumpKeys loads the keys of persistence.dump.encryption, the first one encrypts new snapshots.
+// Returns an empty keyring if encryption is disabled.
+func LoadDumpKeys(cfg *config.Cache) (snapshot.Keyring, error) {
+	enc := cfg.Cache.Persistence.Dump.Encryption
+	if !enc.Enabled {
+		return nil, nil
+	}
+	keys := make(snapshot.Keyring, 0, len(enc.Keys))
+	for _, k := range enc.Keys {
+		secret, err := loadDumpKey(k)
+		if err != nil {
+			return nil, fmt.Errorf("dump key %q: %w", k.ID, err)
+		}
+		key, err := snapshot.NewKey(k.ID, secret)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func loadDumpKey(k config.EncryptionKey) ([]byte, error) {
+	var encoded string
+	if k.File != "" {
+		data, err := os.ReadFile(k.File)
+		if err != nil {
+			return nil, fmt.Errorf("read key file: %w", err)
+		}
+		encoded = strings.TrimSpace(string(data))
+	}
+	if encoded == "" && k.Env != "" {
+		encoded = strings.TrimSpace(os.Getenv(k.Env))
+	}
+	if encoded == "" {
+		return nil, errors.New("key is empty")
+	}
+	if secret, err := hex.DecodeString(encoded); err == nil {
+		return secret, nil
+	}
+	secret, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.New("key must be base64 or hex encoded")
+	}
+	return secret, nil
+}
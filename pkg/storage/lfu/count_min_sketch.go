@@ -24,10 +24,14 @@ func newCountMinSketch() *countMinSketch {
 }
 
 func (c *countMinSketch) Increment(key uint64) {
+	c.add(key, 1)
+}
+
+func (c *countMinSketch) add(key uint64, n uint32) {
 	for i := 0; i < sketchDepth; i++ {
 		h := hash64(c.seeds[i], key)
 		idx := h % sketchWidth
-		atomic.AddUint32(&c.table[i][idx], 1)
+		atomic.AddUint32(&c.table[i][idx], n)
 	}
 }
 
@@ -41,6 +41,17 @@ func (t *TinyLFU) Increment(key uint64) {
 	t.door.Allow(key)
 }
 
+// Seed adds the estimate of the key taken earlier (e.g. restored from a dump) to both sketches,
+// so it outlives a rotation like recent accesses do.
+func (t *TinyLFU) Seed(key uint64, estimate uint32) {
+	if estimate == 0 {
+		return
+	}
+	t.curr.Load().add(key, estimate)
+	t.prev.Load().add(key, estimate)
+	t.door.Allow(key)
+}
+
 func (t *TinyLFU) Admit(new, old *model.Entry) bool {
 	newKey := new.MapKey()
 	oldKey := old.MapKey()
@@ -67,6 +78,11 @@ func (t *TinyLFU) Rotate() {
 	t.door = newDoorkeeper(doorkeeperCapacity)
 }
 
+// Estimate returns the approximate number of recent accesses of the key.
+func (t *TinyLFU) Estimate(key uint64) uint32 {
+	return t.estimate(key)
+}
+
 func (t *TinyLFU) estimate(key uint64) uint32 {
 	c := t.curr.Load().estimate(key)
 	p := t.prev.Load().estimate(key)
@@ -6,6 +6,7 @@ import (
 	"github.com/rs/zerolog/log"
 	"runtime"
 	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/Borislavv/advanced-cache/pkg/utils"
@@ -30,7 +31,7 @@ type Evict struct {
 	cfg                 *config.Cache
 	db                  Storage
 	balancer            Balancer
-	memoryThreshold     int64
+	memoryThreshold     int64 // atomic
 	fatShardsPercentage int
 }
 
@@ -41,7 +42,7 @@ func NewEvictor(ctx context.Context, cfg *config.Cache, db Storage, balancer Bal
 		db:                  db,
 		balancer:            balancer,
 		fatShardsPercentage: int(float64(cfg.Cache.Preallocate.Shards) * fatShardsPercent),
-		memoryThreshold:     int64(float64(cfg.Cache.Storage.Size) * cfg.Cache.Eviction.Threshold),
+		memoryThreshold:     cfg.MemoryThreshold(),
 	}
 }
 
@@ -76,14 +77,19 @@ func (e *Evict) runEvictor() {
 	}()
 }
 
+// Reload applies a new memory threshold.
+func (e *Evict) Reload(cfg *config.Cache) {
+	atomic.StoreInt64(&e.memoryThreshold, cfg.MemoryThreshold())
+}
+
 // ShouldEvict [HOT PATH METHOD] (max stale value = 25ms) checks if current Weight usage has reached or exceeded the threshold.
 func (e *Evict) ShouldEvict() bool {
-	return e.db.Mem() >= e.memoryThreshold
+	return e.db.Mem() >= atomic.LoadInt64(&e.memoryThreshold)
 }
 
 // shouldEvictRightNow (returns a honest memory usage) checks if current Weight usage has reached or exceeded the threshold.
 func (e *Evict) shouldEvictRightNow() bool {
-	return e.db.RealMem() >= e.memoryThreshold
+	return e.db.RealMem() >= atomic.LoadInt64(&e.memoryThreshold)
 }
 
 // evictUntilWithinLimit repeatedly removes entries from the most loaded Shard (tail of InMemoryStorage)
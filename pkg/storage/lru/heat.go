@@ -0,0 +1,75 @@
+package lru
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Borislavv/advanced-cache/pkg/model"
+	sharded "github.com/Borislavv/advanced-cache/pkg/storage/map"
+)
+
+// Heat describes how valuable an entry is at the moment it was taken.
+type Heat struct {
+	AccessedAt int64  // Unix nano of the last access (see model.Entry.TouchedAt).
+	Frequency  uint32 // TinyLFU estimate of recent writes of the key.
+}
+
+// Score orders entries by value, the higher the hotter: the frequency is divided by the minutes since the last access.
+func (h Heat) Score(now int64) float64 {
+	idle := max(now-h.AccessedAt, 0)
+	return float64(1+h.Frequency) / (1 + float64(idle)/float64(time.Minute))
+}
+
+// HotEntry is an entry with its heat.
+type HotEntry struct {
+	Entry *model.Entry
+	Heat  Heat
+}
+
+// Hottest returns all entries ordered by Heat.Score, the hottest first.
+func (s *InMemoryStorage) Hottest(ctx context.Context) []HotEntry {
+	var mu sync.Mutex
+	hot := make([]HotEntry, 0, s.shardedMap.RealLen())
+	s.shardedMap.WalkShards(ctx, func(_ uint64, shard *sharded.Shard[*model.Entry]) {
+		shardHot := make([]HotEntry, 0, shard.Len())
+		shard.Walk(ctx, func(key uint64, e *model.Entry) bool {
+			shardHot = append(shardHot, HotEntry{Entry: e, Heat: Heat{AccessedAt: e.TouchedAt(), Frequency: s.tinyLFU.Estimate(key)}})
+			return true
+		}, false)
+		mu.Lock()
+		hot = append(hot, shardHot...)
+		mu.Unlock()
+	})
+
+	now := time.Now().UnixNano()
+	scores := make([]float64, len(hot))
+	for i := range hot {
+		scores[i] = hot[i].Heat.Score(now)
+	}
+	sort.Stable(byScore{hot: hot, scores: scores})
+	return hot
+}
+
+// RestoreHeat applies the heat of a restored entry (see Hottest): the stored entry moves to the front of its LRU list
+// and gets the access time and the TinyLFU estimate it had. Restored entries are passed from the coldest to the hottest.
+func (s *InMemoryStorage) RestoreHeat(hot HotEntry) {
+	if e, found := s.Get(hot.Entry); found {
+		e.SetTouchedAt(hot.Heat.AccessedAt)
+		s.tinyLFU.Seed(e.MapKey(), hot.Heat.Frequency)
+	}
+}
+
+// byScore orders hot entries by their precomputed scores, the highest first.
+type byScore struct {
+	hot    []HotEntry
+	scores []float64
+}
+
+func (b byScore) Len() int           { return len(b.hot) }
+func (b byScore) Less(i, j int) bool { return b.scores[i] > b.scores[j] }
+func (b byScore) Swap(i, j int) {
+	b.hot[i], b.hot[j] = b.hot[j], b.hot[i]
+	b.scores[i], b.scores[j] = b.scores[j], b.scores[i]
+}
@@ -3,6 +3,7 @@ package lru
 import (
 	"context"
 	"github.com/Borislavv/advanced-cache/pkg/rate"
+	xrate "golang.org/x/time/rate"
 	"sync/atomic"
 	"time"
 
@@ -29,9 +30,11 @@ type Refresher interface {
 // (from the end of each shard's InMemoryStorage list) to refreshItem if necessary.
 // Communication: provider->consumer (MPSC).
 type Refresh struct {
-	ctx     context.Context
-	cfg     *config.Cache
-	storage *InMemoryStorage
+	ctx             context.Context
+	cfg             *config.Cache
+	storage         *InMemoryStorage
+	scanLimiter     *rate.Limiter
+	upstreamLimiter *rate.Limiter
 }
 
 // NewRefresher constructs a Refresh.
@@ -54,9 +57,24 @@ func (r *Refresh) Run() *Refresh {
 	return r
 }
 
+// Reload applies new scan and upstream rates (works only if the refresher is running).
+func (r *Refresh) Reload(cfg *config.Cache) {
+	if r.scanLimiter != nil {
+		r.scanLimiter.SetLimit(xrate.Limit(cfg.Cache.Refresh.ScanRate))
+		r.scanLimiter.SetBurst(burst(cfg.Cache.Refresh.ScanRate))
+	}
+	if r.upstreamLimiter != nil {
+		r.upstreamLimiter.SetLimit(xrate.Limit(cfg.Cache.Refresh.Rate))
+		r.upstreamLimiter.SetBurst(burst(cfg.Cache.Refresh.Rate))
+	}
+}
+
 func (r *Refresh) run() {
-	scanRateCh := rate.NewLimiter(r.ctx, r.cfg.Cache.Refresh.ScanRate, r.cfg.Cache.Refresh.ScanRate/10).Chan()
-	upstreamRateCh := rate.NewLimiter(r.ctx, r.cfg.Cache.Refresh.Rate, r.cfg.Cache.Refresh.Rate/10).Chan()
+	r.scanLimiter = rate.NewLimiter(r.ctx, r.cfg.Cache.Refresh.ScanRate, burst(r.cfg.Cache.Refresh.ScanRate))
+	r.upstreamLimiter = rate.NewLimiter(r.ctx, r.cfg.Cache.Refresh.Rate, burst(r.cfg.Cache.Refresh.Rate))
+
+	scanRateCh := r.scanLimiter.Chan()
+	upstreamRateCh := r.upstreamLimiter.Chan()
 
 	for i := 0; i < workersNum; i++ {
 		go func() {
@@ -66,7 +84,7 @@ func (r *Refresh) run() {
 					return
 				case <-scanRateCh:
 					scansNumCounter.Add(1)
-					if entry, found := r.storage.Rand(); found && entry.ShouldBeRefreshed(r.cfg) {
+					if entry, found := r.storage.Rand(); found && entry.ShouldBeRefreshed(r.cfg) && r.storage.ownsRefresh(entry) {
 						scansFoundNumCounter.Add(1)
 						select {
 						case <-r.ctx.Done():
@@ -77,6 +95,7 @@ func (r *Refresh) run() {
 									failedRefreshesNumCounter.Add(1)
 								} else {
 									successRefreshesNumCounter.Add(1)
+									r.storage.Refreshed(entry)
 								}
 							}()
 						}
@@ -87,6 +106,11 @@ func (r *Refresh) run() {
 	}
 }
 
+// burst is a tenth of the rate, at least 1 (a zero burst lets no events through).
+func burst(rate int) int {
+	return max(rate/10, 1)
+}
+
 // runLogger periodically logs the number of successful and failed refreshItem attempts.
 // This runs only if debugging is enabled in the config.
 func (r *Refresh) runLogger() {
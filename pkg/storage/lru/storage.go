@@ -6,6 +6,7 @@ import (
 	"github.com/Borislavv/advanced-cache/pkg/upstream"
 	"runtime"
 	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/Borislavv/advanced-cache/pkg/config"
@@ -53,18 +54,68 @@ type Storage interface {
 	Rand() (entry *model.Entry, ok bool)
 
 	WalkShards(ctx context.Context, fn func(key uint64, shard *sharded.Shard[*model.Entry]))
+
+	// Hottest returns all entries with their heat, the hottest first.
+	Hottest(ctx context.Context) []HotEntry
+
+	// RestoreHeat applies the heat an entry had when it was dumped.
+	RestoreHeat(HotEntry)
+
+	// Refreshed records a successful refresh of a stored entry made outside the refresher (see model.Entry.Revalidate).
+	Refreshed(*model.Entry)
+
+	// Changes returns the number of changes since start: new, updated, refreshed and removed entries.
+	Changes() int64
+
+	// SetJournal sets up (nil removes) a journal of changes, e.g. the write-ahead log.
+	SetJournal(Journal)
+
+	// Replicate stores an entry with its payload and update time, e.g. refreshed by another instance or replayed from the wal.
+	Replicate(*model.Entry) (persisted bool)
+
+	// SetRefreshOwner sets up (nil removes) the owner of refreshes, e.g. the cluster node.
+	SetRefreshOwner(RefreshOwner)
 }
 
+// Journal records changes of the storage (see wal.Log). Calls must not block for long,
+// they are made by writers and by the refresher, never by Get.
+type Journal interface {
+	Set(*model.Entry)
+	Refresh(*model.Entry)
+	Remove(*model.Entry)
+	Clear()
+}
+
+// journalBox lets the journal be stored in an atomic.Pointer.
+type journalBox struct{ Journal }
+
+// RefreshOwner decides which entries the refresher of the instance revalidates, so replicas of an entry
+// on several instances do not revalidate it each (see cluster.Node), and learns about successful refreshes.
+type RefreshOwner interface {
+	OwnsRefresh(*model.Entry) bool
+	Refreshed(*model.Entry)
+}
+
+// refreshOwnerBox lets the refresh owner be stored in an atomic.Pointer.
+type refreshOwnerBox struct{ RefreshOwner }
+
 // InMemoryStorage is a Weight-aware, sharded InMemoryStorage cache with background eviction and refreshItem support.
 type InMemoryStorage struct {
-	ctx             context.Context            // Main context for lifecycle control
-	cfg             *config.Cache              // CacheBox configuration
-	shardedMap      *sharded.Map[*model.Entry] // Sharded storage for cache entries
-	tinyLFU         *lfu.TinyLFU               // Helps hold more frequency used items in cache while eviction
-	backend         upstream.Gateway           // Remote backend server.
-	balancer        Balancer                   // Helps pick shards to evict from
-	mem             int64                      // Current Weight usage (bytes)
-	memoryThreshold int64                      // Threshold for triggering eviction (bytes)
+	ctx             context.Context                 // Main context for lifecycle control
+	cfg             *config.Cache                   // CacheBox configuration
+	shardedMap      *sharded.Map[*model.Entry]      // Sharded storage for cache entries
+	tinyLFU         *lfu.TinyLFU                    // Helps hold more frequency used items in cache while eviction
+	backend         upstream.Gateway                // Remote backend server.
+	balancer        Balancer                        // Helps pick shards to evict from
+	refresher       *Refresh                        // Background refresher (set up by Run)
+	evictor         *Evict                          // Background evictor (set up by Run)
+	mem             int64                           // Current Weight usage (bytes)
+	memoryLimit     int64                           // atomic: configured storage size (bytes)
+	memoryThreshold int64                           // atomic: threshold for triggering eviction (bytes)
+	changes         int64                           // atomic: number of changes (see Changes)
+	clock           *atomic.Int64                   // Unix nano of entry accesses (see newClock)
+	journal         atomic.Pointer[journalBox]      // Journal of changes (see SetJournal)
+	refreshOwner    atomic.Pointer[refreshOwnerBox] // Owner of refreshes (see SetRefreshOwner)
 }
 
 // NewStorage constructs a new InMemoryStorage cache instance and launches eviction and refreshItem routines.
@@ -79,7 +130,9 @@ func NewStorage(ctx context.Context, cfg *config.Cache, backend upstream.Gateway
 		balancer:        balancer,
 		backend:         backend,
 		tinyLFU:         lfu.NewTinyLFU(ctx),
-		memoryThreshold: int64(float64(cfg.Cache.Storage.Size) * cfg.Cache.Eviction.Threshold),
+		clock:           newClock(ctx),
+		memoryLimit:     int64(cfg.Cache.Storage.Size),
+		memoryThreshold: cfg.MemoryThreshold(),
 	}).init()
 
 	return db
@@ -87,8 +140,20 @@ func NewStorage(ctx context.Context, cfg *config.Cache, backend upstream.Gateway
 
 func (s *InMemoryStorage) Run() {
 	s.runLogger()
-	NewRefresher(s.ctx, s.cfg, s).Run()
-	NewEvictor(s.ctx, s.cfg, s, s.balancer).Run()
+	s.refresher = NewRefresher(s.ctx, s.cfg, s).Run()
+	s.evictor = NewEvictor(s.ctx, s.cfg, s, s.balancer).Run()
+}
+
+// Reload applies the live-changeable settings: memory limits and refresh rates.
+func (s *InMemoryStorage) Reload(cfg *config.Cache) {
+	atomic.StoreInt64(&s.memoryLimit, int64(cfg.Cache.Storage.Size))
+	atomic.StoreInt64(&s.memoryThreshold, cfg.MemoryThreshold())
+	if s.evictor != nil {
+		s.evictor.Reload(cfg)
+	}
+	if s.refresher != nil {
+		s.refresher.Reload(cfg)
+	}
 }
 
 func (s *InMemoryStorage) init() *InMemoryStorage {
@@ -100,10 +165,92 @@ func (s *InMemoryStorage) init() *InMemoryStorage {
 	return s
 }
 
+// clockResolution is the precision of access times: time.Now on each hit would cost more than the Get itself.
+const clockResolution = 100 * time.Millisecond
+
+// newClock returns the time of entry accesses (unix nano) updated every clockResolution until the context is done.
+// The clock is allocated apart from the storage: its goroutine must not keep a dropped storage reachable.
+func newClock(ctx context.Context) *atomic.Int64 {
+	clock := new(atomic.Int64)
+	clock.Store(time.Now().UnixNano())
+	go func() {
+		ticker := time.NewTicker(clockResolution)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				clock.Store(now.UnixNano())
+			}
+		}
+	}()
+	return clock
+}
+
 func (s *InMemoryStorage) Clear() {
+	atomic.AddInt64(&s.changes, s.shardedMap.RealLen())
 	s.shardedMap.WalkShards(s.ctx, func(key uint64, shard *sharded.Shard[*model.Entry]) {
 		shard.Clear()
 	})
+	if j := s.journal.Load(); j != nil {
+		j.Clear()
+	}
+}
+
+func (s *InMemoryStorage) Changes() int64 {
+	return atomic.LoadInt64(&s.changes)
+}
+
+func (s *InMemoryStorage) SetJournal(journal Journal) {
+	if journal == nil {
+		s.journal.Store(nil)
+		return
+	}
+	s.journal.Store(&journalBox{journal})
+}
+
+func (s *InMemoryStorage) SetRefreshOwner(owner RefreshOwner) {
+	if owner == nil {
+		s.refreshOwner.Store(nil)
+		return
+	}
+	s.refreshOwner.Store(&refreshOwnerBox{owner})
+}
+
+// ownsRefresh reports whether the refresher of the instance revalidates the entry (all of them without an owner).
+func (s *InMemoryStorage) ownsRefresh(entry *model.Entry) bool {
+	if o := s.refreshOwner.Load(); o != nil {
+		return o.OwnsRefresh(entry)
+	}
+	return true
+}
+
+// Refreshed counts a successful refresh of the entry.
+func (s *InMemoryStorage) Refreshed(entry *model.Entry) {
+	atomic.AddInt64(&s.changes, 1)
+	if j := s.journal.Load(); j != nil {
+		j.Refresh(entry)
+	}
+	if o := s.refreshOwner.Load(); o != nil {
+		o.Refreshed(entry)
+	}
+}
+
+// Replicate applies a refresh made by another instance: a stored entry takes the payload through the update path
+// of Set and keeps the update time of the refresh (its stale mark is dropped as by a local refresh), an entry
+// which is not stored yet is set as is. A stored entry updated later than the refresh is kept.
+// The refresh owner is not notified. Replays of recorded entries (the wal) go this way too, so they keep their update times.
+func (s *InMemoryStorage) Replicate(refreshed *model.Entry) (persisted bool) {
+	if old, found := s.shardedMap.Get(refreshed.MapKey()); found && old.IsSameFingerprint(refreshed.Fingerprint()) {
+		if old.UpdateAt() >= refreshed.UpdateAt() {
+			return true
+		}
+		s.update(old, refreshed, refreshed.UpdateAt())
+		old.ClaimStale()
+		return true
+	}
+	return s.Set(refreshed)
 }
 
 // Rand returns a random item from storage.
@@ -140,7 +287,7 @@ func (s *InMemoryStorage) Set(new *model.Entry) (persisted bool) {
 				s.touch(old)
 			} else {
 				// payload has changes, updated it and up the element in LRU list of course
-				s.update(old, new)
+				s.update(old, new, time.Now().UnixNano())
 			}
 			return true
 		}
@@ -159,13 +306,23 @@ func (s *InMemoryStorage) Set(new *model.Entry) (persisted bool) {
 	s.shardedMap.Set(key, new)
 	// insert a new one Entry LRU element into LRU list
 	s.balancer.Push(new)
+	atomic.AddInt64(&s.changes, 1)
+	if j := s.journal.Load(); j != nil {
+		j.Set(new)
+	}
 
 	return true
 }
 
 func (s *InMemoryStorage) Remove(entry *model.Entry) (freedBytes int64, hit bool) {
 	s.balancer.Remove(entry.ShardKey(), entry.LruListElement())
-	return s.shardedMap.Remove(entry.MapKey())
+	if freedBytes, hit = s.shardedMap.Remove(entry.MapKey()); hit {
+		atomic.AddInt64(&s.changes, 1)
+		if j := s.journal.Load(); j != nil {
+			j.Remove(entry)
+		}
+	}
+	return freedBytes, hit
 }
 
 func (s *InMemoryStorage) Len() int64 {
@@ -190,7 +347,7 @@ func (s *InMemoryStorage) Stat() (bytes int64, length int64) {
 
 // ShouldEvict [HOT PATH METHOD] (max stale value = 25ms) checks if current Weight usage has reached or exceeded the threshold.
 func (s *InMemoryStorage) ShouldEvict() bool {
-	return s.Mem() >= s.memoryThreshold
+	return s.Mem() >= atomic.LoadInt64(&s.memoryThreshold)
 }
 
 func (s *InMemoryStorage) WalkShards(ctx context.Context, fn func(key uint64, shard *sharded.Shard[*model.Entry])) {
@@ -199,14 +356,19 @@ func (s *InMemoryStorage) WalkShards(ctx context.Context, fn func(key uint64, sh
 
 // touch bumps the InMemoryStorage position of an existing entry (MoveToFront) and increases its refCount.
 func (s *InMemoryStorage) touch(existing *model.Entry) {
+	existing.Touch(s.clock.Load())
 	s.balancer.Update(existing)
 }
 
-// update refreshes Weight accounting and InMemoryStorage position for an updated entry.
-func (s *InMemoryStorage) update(existing, new *model.Entry) {
+// update refreshes Weight accounting and InMemoryStorage position for an updated entry, updatedAt is unix nano.
+func (s *InMemoryStorage) update(existing, new *model.Entry, updatedAt int64) {
 	existing.SwapPayloads(new)
-	existing.TouchUpdatedAt()
+	existing.SetUpdatedAt(updatedAt)
 	s.balancer.Update(existing)
+	atomic.AddInt64(&s.changes, 1)
+	if j := s.journal.Load(); j != nil {
+		j.Set(existing)
+	}
 }
 
 // runLogger emits detailed stats about evictions, Weight, and GC activity every 5 seconds if debugging is enabled.
@@ -227,7 +389,8 @@ func (s *InMemoryStorage) runLogger() *InMemoryStorage {
 					mem        = utils.FmtMem(realMem)
 					length     = strconv.Itoa(int(s.shardedMap.Len()))
 					gc         = strconv.Itoa(int(m.NumGC))
-					limit      = utils.FmtMem(int64(s.cfg.Cache.Storage.Size))
+					memLimit   = atomic.LoadInt64(&s.memoryLimit)
+					limit      = utils.FmtMem(memLimit)
 					goroutines = strconv.Itoa(runtime.NumGoroutine())
 					alloc      = utils.FmtMem(int64(m.Alloc))
 				)
@@ -241,7 +404,7 @@ func (s *InMemoryStorage) runLogger() *InMemoryStorage {
 						Str("memStr", mem).
 						Str("len", length).
 						Str("gc", gc).
-						Str("memLimit", strconv.Itoa(int(s.cfg.Cache.Storage.Size))).
+						Str("memLimit", strconv.Itoa(int(memLimit))).
 						Str("memLimitStr", limit).
 						Str("goroutines", goroutines).
 						Str("alloc", strconv.Itoa(int(m.Alloc))).
@@ -0,0 +1,206 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// ManifestName is the file name of manifests of version dirs.
+const ManifestName = "manifest.json"
+
+// Statuses of dump versions.
+const (
+	DumpStatusInProgress = "in_progress" // written when a dump starts: the version is incomplete until it's replaced.
+	DumpStatusComplete   = "complete"
+	DumpStatusFailed     = "failed"  // the dump finished with errors.
+	DumpStatusUnknown    = "unknown" // a version without a manifest (written before manifests were introduced).
+)
+
+// IncompleteDumpError means a version dir is not a complete dump or its files don't match the manifest.
+var IncompleteDumpError = errors.New("incomplete dump version")
+
+// Manifest describes a version dir. It's written (atomically) when the dump starts and replaced when it's finished,
+// so a version without a complete manifest is a dump interrupted by a crash.
+type Manifest struct {
+	Status    string         `json:"status"`
+	Format    string         `json:"format"`          // snapshot or legacy
+	Codec     string         `json:"codec,omitempty"` // of snapshot blocks
+	RulesHash string         `json:"rulesHash"`       // hash of the rule set of the instance (see config.Cache.RulesHash)
+	Started   time.Time      `json:"started"`
+	Finished  time.Time      `json:"finished"`
+	Entries   int            `json:"entries"`
+	Size      int64          `json:"size"` // total size of the files (bytes)
+	Files     []ManifestFile `json:"files"`
+	Error     string         `json:"error,omitempty"`
+}
+
+// ManifestFile describes a written file of a version dir.
+type ManifestFile struct {
+	Name    string `json:"name"` // relative to the version dir
+	Entries int    `json:"entries"`
+	Size    int64  `json:"size"`
+	CRC32   string `json:"crc32"` // hex CRC32 (IEEE) of the file
+}
+
+// newManifest returns an in-progress manifest of a dump taken with the rule set of the hash.
+func newManifest(format, codec string, rulesHash uint64) *Manifest {
+	return &Manifest{
+		Status:    DumpStatusInProgress,
+		Format:    format,
+		Codec:     codec,
+		RulesHash: strconv.FormatUint(rulesHash, 16),
+		Started:   time.Now(),
+		Files:     []ManifestFile{},
+	}
+}
+
+// finish sets up the outcome of the dump: its files and the status of err.
+func (m *Manifest) finish(files []ManifestFile, err error) {
+	m.Files, m.Entries, m.Size = files, 0, 0
+	for _, f := range files {
+		m.Entries += f.Entries
+		m.Size += f.Size
+	}
+	m.Finished = time.Now()
+	m.Status = DumpStatusComplete
+	if err != nil {
+		m.Status, m.Error = DumpStatusFailed, err.Error()
+	}
+}
+
+// ReadManifest reads the manifest of a version dir, an error matching os.ErrNotExist means there is none.
+func ReadManifest(dir string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ManifestName))
+	if err != nil {
+		return nil, err
+	}
+	m := new(Manifest)
+	if err = json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("%w: manifest: %v", IncompleteDumpError, err)
+	}
+	return m, nil
+}
+
+// WriteManifest replaces the manifest of a version dir atomically: a temp file is synced and renamed.
+// A complete manifest is written only after the dir is synced, so the renames of the files are persisted first.
+func WriteManifest(dir string, m *Manifest) error {
+	if m.Status == DumpStatusComplete {
+		if err := syncDir(dir); err != nil {
+			return fmt.Errorf("sync version dir: %w", err)
+		}
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	name := filepath.Join(dir, ManifestName)
+	f, err := os.Create(name + ".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if syncErr := f.Sync(); err == nil {
+		err = syncErr
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), name)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+	_ = syncDir(dir) // persists the rename
+	return nil
+}
+
+// syncDir persists the entries of the dir: created, renamed and removed files.
+// Directories can't be synced on Windows, renames are journaled by NTFS there.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if closeErr := d.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Check returns IncompleteDumpError if the version is not complete or its files are missing or have other sizes,
+// checksums of files are verified too if requested (files are read entirely).
+func (m *Manifest) Check(dir string, checksums bool) error {
+	if m.Status != DumpStatusComplete {
+		return fmt.Errorf("%w: status %s", IncompleteDumpError, m.Status)
+	}
+	if len(m.Files) == 0 {
+		return fmt.Errorf("%w: no files", IncompleteDumpError)
+	}
+	for _, file := range m.Files {
+		name := filepath.Join(dir, file.Name)
+		info, err := os.Stat(name)
+		if err != nil {
+			return fmt.Errorf("%w: %v", IncompleteDumpError, err)
+		}
+		if info.Size() != file.Size {
+			return fmt.Errorf("%w: %s has %d bytes, %d expected", IncompleteDumpError, file.Name, info.Size(), file.Size)
+		}
+		if !checksums {
+			continue
+		}
+		sum, err := fileChecksum(name)
+		if err != nil {
+			return err
+		}
+		if sum != file.CRC32 {
+			return fmt.Errorf("%w: %s checksum mismatch", IncompleteDumpError, file.Name)
+		}
+	}
+	return nil
+}
+
+func fileChecksum(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := crc32.NewIEEE()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%08x", h.Sum32()), nil
+}
+
+// summingWriter counts bytes and entries of a written file and computes its checksum on the fly.
+type summingWriter struct {
+	w       io.Writer
+	crc     uint32
+	size    int64
+	entries int
+}
+
+func (s *summingWriter) Write(p []byte) (int, error) {
+	n, err := s.w.Write(p)
+	s.crc = crc32.Update(s.crc, crc32.IEEETable, p[:n])
+	s.size += int64(n)
+	return n, err
+}
+
+func (s *summingWriter) file(name string) ManifestFile {
+	return ManifestFile{Name: filepath.Base(name), Entries: s.entries, Size: s.size, CRC32: fmt.Sprintf("%08x", s.crc)}
+}
@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Borislavv/advanced-cache/pkg/config"
+	"github.com/Borislavv/advanced-cache/pkg/mock"
+	"github.com/Borislavv/advanced-cache/pkg/storage/lru"
+)
+
+func TestLoadSkipsIncompleteVersions(t *testing.T) {
+	ctx := t.Context()
+	dump := &config.Dump{IsEnabled: true, Dir: t.TempDir(), Name: "cache.dump", Format: config.DumpFormatSnapshot, Segments: 2}
+	cfg := newTestConfig(&config.Persistence{Dump: dump})
+	backend, src, _ := newTestDB(t, cfg, 50)
+	dumper := NewDumper(cfg, src, backend)
+
+	// v1 has 50 entries, v2 and v3 have 100.
+	if err := dumper.Dump(ctx); err != nil {
+		t.Fatalf("Dump: %v", err)
+	}
+	for _, entry := range mock.GenerateEntryPointersConsecutive(cfg, backend, path, 100)[50:] {
+		src.Set(entry)
+	}
+	for range 2 {
+		if err := dumper.Dump(ctx); err != nil {
+			t.Fatalf("Dump: %v", err)
+		}
+	}
+
+	versions, err := ListDumpVersions(dump.Dir, dump.Name)
+	if err != nil || len(versions) != 3 {
+		t.Fatalf("expected 3 versions, got %d (%v)", len(versions), err)
+	}
+	for _, v := range versions {
+		if v.Status != DumpStatusComplete || len(v.Files) != 2 || v.Manifest.Check(v.Dir, true) != nil {
+			t.Fatalf("expected a complete version of 2 files, got %+v", v)
+		}
+	}
+	if versions[0].Name != "v3" || versions[0].Entries != 100 || versions[2].Entries != 50 {
+		t.Fatalf("unexpected versions %+v", versions)
+	}
+
+	// v3 was interrupted by a crash, a file of v2 was truncated.
+	m := versions[0].Manifest
+	m.Status = DumpStatusInProgress
+	if err = WriteManifest(versions[0].Dir, m); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Truncate(filepath.Join(versions[1].Dir, versions[1].Manifest.Files[1].Name), 100); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := lru.NewStorage(ctx, cfg, backend)
+	if err = NewDumper(cfg, dst, backend).Load(ctx); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if dst.RealLen() != 50 {
+		t.Fatalf("expected v1 of 50 entries to be loaded, got %d entries", dst.RealLen())
+	}
+}
+
+func TestDumpRetention(t *testing.T) {
+	ctx := t.Context()
+	dump := &config.Dump{IsEnabled: true, Dir: t.TempDir(), Name: "cache.dump", Format: config.DumpFormatSnapshot, Segments: 1}
+	cfg := newTestConfig(&config.Persistence{Dump: dump})
+	backend, src, _ := newTestDB(t, cfg, 10)
+	dumper := NewDumper(cfg, src, backend)
+	names := func() (names []string) {
+		versions, _ := ListDumpVersions(dump.Dir, dump.Name)
+		for _, v := range versions {
+			names = append(names, v.Name)
+		}
+		return names
+	}
+
+	for range 4 {
+		if err := dumper.Dump(ctx); err != nil {
+			t.Fatalf("Dump: %v", err)
+		}
+	}
+	// An incomplete version older than the newest complete one is removed.
+	if err := WriteManifest(filepath.Join(dump.Dir, "v3"), &Manifest{Status: DumpStatusInProgress}); err != nil {
+		t.Fatal(err)
+	}
+
+	dump.MaxVersions = 2 // of complete versions
+	applyRetention(dump, time.Now())
+	if got := names(); len(got) != 2 || got[0] != "v4" || got[1] != "v2" {
+		t.Fatalf("expected v4 and v2 to be kept by count, got %v", got)
+	}
+
+	dump.MaxVersions = 0
+	dump.MaxSize = 1 // less than a version: the newest one is kept anyway
+	applyRetention(dump, time.Now())
+	if got := names(); len(got) != 1 || got[0] != "v4" {
+		t.Fatalf("expected v4 to be kept by size, got %v", got)
+	}
+
+	dump.MaxSize = 0
+	dump.MaxAge = time.Minute
+	if err := dumper.Dump(ctx); err != nil {
+		t.Fatalf("Dump: %v", err)
+	}
+	applyRetention(dump, time.Now().Add(time.Hour))
+	if got := names(); len(got) != 1 || got[0] != "v5" {
+		t.Fatalf("expected v5 to be kept by age, got %v", got)
+	}
+}
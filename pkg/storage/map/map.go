@@ -99,18 +99,16 @@ func (smap *Map[V]) Shard(key uint64) *Shard[V] {
 // The callback runs in a separate goroutine for each shard; fn should be goroutine-safe.
 func (smap *Map[V]) WalkShards(ctx context.Context, fn func(key uint64, shard *Shard[V])) {
 	var wg sync.WaitGroup
-	wg.Add(int(NumOfShards))
 	defer wg.Wait()
 	for k, s := range smap.shards {
-		select {
-		case <-ctx.Done():
+		if ctx.Err() != nil {
 			return
-		default:
-			go func(key uint64, shard *Shard[V]) {
-				defer wg.Done()
-				fn(key, shard)
-			}(uint64(k), s)
 		}
+		wg.Add(1)
+		go func(key uint64, shard *Shard[V]) {
+			defer wg.Done()
+			fn(key, shard)
+		}(uint64(k), s)
 	}
 }
 
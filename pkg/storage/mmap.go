@@ -0,0 +1,37 @@
+package storage
+
+import (
+	"runtime"
+
+	"github.com/Borislavv/advanced-cache/pkg/model"
+	"github.com/Borislavv/advanced-cache/pkg/storage/snapshot"
+)
+
+// mappedChunkSize is the number of payload slots of a chunk, the mapping is referenced once per chunk.
+const mappedChunkSize = 1024
+
+// mappedPayloads points payloads of entries restored from a mapped snapshot into the mapping.
+// Payload pointers of entries are slots of chunks: each chunk holds a reference to the mapping which
+// is released by the GC once no entry points into the chunk any longer (payloads of refreshed and updated
+// entries are replaced by heap copies, removed entries are gone) and no reader holds a slot of it
+// (see model.Entry.Payload), so the mapping is released when the last restored payload is.
+// It's not safe for concurrent use.
+type mappedPayloads struct {
+	mapping *snapshot.Mapping
+	slots   [][]byte // the current chunk
+}
+
+func newMappedPayloads(m *snapshot.Mapping) *mappedPayloads {
+	return &mappedPayloads{mapping: m}
+}
+
+// alias stores the payload of the entry (a slice of the mapping) in a slot and points the entry to it.
+func (p *mappedPayloads) alias(e *model.Entry) {
+	if len(p.slots) == cap(p.slots) {
+		p.slots = make([][]byte, 0, mappedChunkSize)
+		p.mapping.Acquire()
+		runtime.AddCleanup(&p.slots[:1][0], func(m *snapshot.Mapping) { m.Release() }, p.mapping)
+	}
+	p.slots = append(p.slots, e.PayloadBytes())
+	e.SetPayloadRef(&p.slots[len(p.slots)-1])
+}
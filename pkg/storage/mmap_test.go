@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"bytes"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/Borislavv/advanced-cache/pkg/config"
+	"github.com/Borislavv/advanced-cache/pkg/storage/lru"
+	"github.com/Borislavv/advanced-cache/pkg/storage/snapshot"
+)
+
+func TestLoadMappedSnapshot(t *testing.T) {
+	ctx := t.Context()
+	dump := &config.Dump{
+		IsEnabled: true, Dir: t.TempDir(), Name: "cache.dump", Format: config.DumpFormatSnapshot, Segments: 2,
+		Codec: config.DumpCodecNone, Mmap: true, Restore: config.DumpRestore{Expired: config.RestoreExpiredStale, RefreshRate: -1},
+	}
+	cfg := newTestConfig(&config.Persistence{Dump: dump})
+	backend, src, _ := newTestDB(t, cfg, 3000)
+	if err := NewDumper(cfg, src, backend).Dump(ctx); err != nil {
+		t.Fatalf("Dump: %v", err)
+	}
+
+	before := snapshot.MappedBytes()
+	dst := lru.NewStorage(ctx, cfg, backend)
+	if err := NewDumper(cfg, dst, backend).Load(ctx); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if dst.RealLen() != src.RealLen() || snapshot.MappedBytes() <= before {
+		t.Fatalf("expected %d entries of mapped files, got %d (%d mapped bytes)", src.RealLen(), dst.RealLen(), snapshot.MappedBytes()-before)
+	}
+
+	func() {
+		hottest := dst.Hottest(ctx)
+		for _, hot := range hottest {
+			orig, found := src.Get(hot.Entry)
+			if !found || !bytes.Equal(orig.PayloadBytes(), hot.Entry.PayloadBytes()) {
+				t.Fatalf("expected the payload of %d to be restored", hot.Entry.MapKey())
+			}
+		}
+		// A rewritten payload is a heap copy, the mapped one is not touched.
+		e := hottest[0].Entry
+		path, query, queryHeaders, headers, _, status, release, err := e.Payload()
+		if err != nil {
+			t.Fatal(err)
+		}
+		e.SetPayload(path, query, queryHeaders, headers, []byte("refreshed"), status)
+		release(queryHeaders, headers)
+		if _, _, queryHeaders, headers, body, _, release, _ := e.Payload(); string(body) != "refreshed" {
+			t.Fatalf("expected the refreshed body, got %q", body)
+		} else {
+			release(queryHeaders, headers)
+		}
+	}()
+
+	// The mapping is released once no entry points into it.
+	dst.Clear()
+	deadline := time.Now().Add(10 * time.Second)
+	for snapshot.MappedBytes() != before && time.Now().Before(deadline) {
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+	}
+	if mapped := snapshot.MappedBytes() - before; mapped != 0 {
+		t.Fatalf("expected the mappings to be released, %d bytes are still mapped", mapped)
+	}
+}
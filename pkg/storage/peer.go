@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Borislavv/advanced-cache/pkg/config"
+	"github.com/Borislavv/advanced-cache/pkg/model"
+	"github.com/Borislavv/advanced-cache/pkg/server/auth"
+	sharded "github.com/Borislavv/advanced-cache/pkg/storage/map"
+	"github.com/Borislavv/advanced-cache/pkg/storage/snapshot"
+	"github.com/Borislavv/advanced-cache/pkg/upstream"
+	"github.com/rs/zerolog/log"
+)
+
+// WriteSnapshot streams a snapshot of the storage into w, it's read by LoadFromPeer (see snapshot.Stream).
+// Entries of all shards are collected first (each shard under its lock), so a slow reader
+// gets a point-in-time copy and doesn't block the traffic. Returns the number of written entries.
+func WriteSnapshot(ctx context.Context, cfg *config.Cache, storage Storage, w io.Writer) (int, error) {
+	var mu sync.Mutex
+	entries := make([]*model.Entry, 0, storage.RealLen())
+	storage.WalkShards(ctx, func(_ uint64, shard *sharded.Shard[*model.Entry]) {
+		batch := make([]*model.Entry, 0, shard.Len())
+		shard.Walk(ctx, func(_ uint64, e *model.Entry) bool {
+			batch = append(batch, e)
+			return true
+		}, false)
+		mu.Lock()
+		entries = append(entries, batch...)
+		mu.Unlock()
+	})
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	opts := NewSnapshotOptions(cfg)
+	sw, err := snapshot.NewWriter(w, snapshot.Header{
+		EntryVersion: model.EntryFormatVersion,
+		Segments:     1,
+		Created:      time.Now(),
+		HashSeed:     model.HashSeed,
+		RulesHash:    opts.RulesHash,
+		Meta:         map[string]string{"source": "peer", "entries": fmt.Sprint(len(entries)), metaRuleKeys: opts.RuleKeys},
+	}, opts.Codec, opts.BlockSize)
+	if err != nil {
+		return 0, err
+	}
+	for i, e := range entries {
+		data, release := e.ToBytes()
+		err = sw.Write(data)
+		release()
+		if err == nil {
+			err = ctx.Err()
+		}
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(entries), sw.Close()
+}
+
+// LoadFromPeer loads the storage from a snapshot streamed by a running instance (see WriteSnapshot).
+// The request is authorized with a bearer token of persistence.peer.token_env and signed if admin.auth.hmac
+// is configured. On error the storage may be filled partially. Returns the number of loaded entries.
+func LoadFromPeer(ctx context.Context, cfg *config.Cache, storage Storage, backend upstream.Gateway, url string) (int, error) {
+	start := time.Now()
+	peer := cfg.Cache.Persistence.Peer
+	if peer.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, peer.Timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	if peer.TokenEnv != "" {
+		if token := os.Getenv(peer.TokenEnv); token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+	if hmacCfg := cfg.Cache.Admin.Auth.HMAC; hmacCfg != nil {
+		h, err := auth.LoadHMAC(*hmacCfg)
+		if err != nil {
+			return 0, fmt.Errorf("hmac: %w", err)
+		}
+		h.SignNetHttpRequest(req, nil)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("request peer snapshot: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("request peer snapshot: status %d", resp.StatusCode)
+	}
+
+	s, err := snapshot.NewStream(resp.Body)
+	if err != nil {
+		return 0, fmt.Errorf("peer snapshot: %w", err)
+	}
+	header := s.Header()
+	switch {
+	case header.EntryVersion != model.EntryFormatVersion:
+		return 0, fmt.Errorf("%w: %d (supported: %d)", EntryVersionError, header.EntryVersion, model.EntryFormatVersion)
+	case header.HashSeed != model.HashSeed:
+		return 0, fmt.Errorf("peer snapshot: hash seed %d differs from %d", header.HashSeed, model.HashSeed)
+	case header.RulesHash != cfg.RulesHash():
+		log.Info().Str("peer", url).Msg("[peer] peer has another rule set, entries of rules with changed keys are re-keyed")
+	}
+
+	rk := newRekeyer(cfg, header)
+	var loaded, failures int
+	for {
+		err = s.Next(func(data []byte) error {
+			e, err := model.EntryFromBytes(data, cfg, backend)
+			if err == nil {
+				_, err = rk.rekey(e)
+			}
+			if err != nil {
+				failures++ // including entries of rules the instance doesn't have
+				return nil
+			}
+			storage.Set(e)
+			loaded++
+			return nil
+		})
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if errors.Is(err, snapshot.ChecksumError) {
+			log.Error().Err(err).Str("peer", url).Msg("[peer] corrupted block skipped")
+			failures++
+			continue
+		}
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				err = ctxErr
+			}
+			return loaded, fmt.Errorf("peer snapshot: %w", err)
+		}
+	}
+
+	log.Info().Msgf("[peer] restored from %s: %d entries, errors: %d, elapsed: %s", url, loaded, failures, time.Since(start))
+	return loaded, nil
+}
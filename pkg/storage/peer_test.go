@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"testing"
+
+	"github.com/Borislavv/advanced-cache/pkg/config"
+	"github.com/Borislavv/advanced-cache/pkg/storage/lru"
+	"github.com/valyala/fasthttp"
+)
+
+// servePeer serves the snapshot stream of the storage like the admin API does, requests must carry the token.
+func servePeer(t *testing.T, ctx context.Context, cfg *config.Cache, db Storage, token string) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &fasthttp.Server{Handler: func(r *fasthttp.RequestCtx) {
+		if string(r.Request.Header.Peek(fasthttp.HeaderAuthorization)) != "Bearer "+token {
+			r.SetStatusCode(fasthttp.StatusUnauthorized)
+			return
+		}
+		r.SetBodyStreamWriter(func(w *bufio.Writer) {
+			if _, err := WriteSnapshot(ctx, cfg, db, w); err != nil {
+				t.Error(err)
+			}
+		})
+	}}
+	go func() { _ = srv.Serve(ln) }()
+	t.Cleanup(func() { _ = srv.Shutdown() })
+	return "http://" + ln.Addr().String() + "/cache/snapshot"
+}
+
+func TestLoadFromPeer(t *testing.T) {
+	t.Setenv("ADV_CACHE_TEST_PEER_TOKEN", "secret")
+	ctx := t.Context()
+	cfg := newTestConfig(&config.Persistence{
+		Dump: &config.Dump{Format: config.DumpFormatSnapshot, Gzip: true, BlockSize: 4096},
+		Peer: &config.Peer{TokenEnv: "ADV_CACHE_TEST_PEER_TOKEN", Timeout: config.DefaultPeerTimeout},
+	})
+	backend, running, _ := newTestDB(t, cfg, 1000)
+	url := servePeer(t, ctx, cfg, running, "secret")
+
+	fresh := lru.NewStorage(ctx, cfg, backend)
+	n, err := LoadFromPeer(ctx, cfg, fresh, backend, url)
+	if err != nil {
+		t.Fatalf("LoadFromPeer: %v", err)
+	}
+	if int64(n) != running.RealLen() || fresh.RealLen() != running.RealLen() {
+		t.Fatalf("expected %d entries, loaded %d, stored %d", running.RealLen(), n, fresh.RealLen())
+	}
+
+	// The caller falls back to the local dump on errors.
+	t.Setenv("ADV_CACHE_TEST_PEER_TOKEN", "wrong")
+	if _, err = LoadFromPeer(ctx, cfg, lru.NewStorage(ctx, cfg, backend), backend, url); err == nil {
+		t.Fatal("expected an error of an unauthorized request")
+	}
+	if _, err = LoadFromPeer(ctx, cfg, lru.NewStorage(ctx, cfg, backend), backend, "http://127.0.0.1:1/cache/snapshot"); err == nil {
+		t.Fatal("expected an error of an unavailable peer")
+	}
+}
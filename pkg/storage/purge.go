@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/Borislavv/advanced-cache/pkg/model"
+	sharded "github.com/Borislavv/advanced-cache/pkg/storage/map"
+)
+
+var PurgeFilterIsEmptyError = errors.New("purge requires a rule or a path prefix (use clear to remove all entries)")
+
+// PurgeFilter selects purged entries, an entry matches if it matches all of the set fields.
+type PurgeFilter struct {
+	Rule   string `json:"rule,omitempty"`   // Rule path, exact match.
+	Prefix string `json:"prefix,omitempty"` // Request path prefix.
+}
+
+// Purge removes the entries matching the filter, soft marks them stale instead: a stale entry is revalidated
+// on its next hit and served as is only if the upstream fails (see model.Entry.MarkStale).
+// Matching entries are collected under shard locks and purged without them. Returns the number of purged entries.
+func Purge(ctx context.Context, db Storage, filter PurgeFilter, soft bool) (int, error) {
+	if filter.Rule == "" && filter.Prefix == "" {
+		return 0, PurgeFilterIsEmptyError
+	}
+	prefix := []byte(filter.Prefix)
+
+	var (
+		mu      sync.Mutex
+		matched []*model.Entry
+	)
+	db.WalkShards(ctx, func(_ uint64, shard *sharded.Shard[*model.Entry]) {
+		var batch []*model.Entry
+		shard.Walk(ctx, func(_ uint64, e *model.Entry) bool {
+			if filter.Rule == "" || string(e.Rule().PathBytes) == filter.Rule {
+				batch = append(batch, e)
+			}
+			return true
+		}, false)
+		mu.Lock()
+		matched = append(matched, batch...)
+		mu.Unlock()
+	})
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	var n int
+	for _, e := range matched {
+		if len(prefix) > 0 {
+			path, _, queryHeaders, headers, _, _, release, err := e.Payload()
+			ok := err == nil && bytes.HasPrefix(path, prefix)
+			release(queryHeaders, headers)
+			if !ok {
+				continue
+			}
+		}
+		if soft {
+			e.MarkStale()
+			n++
+		} else if _, hit := db.Remove(e); hit {
+			n++
+		}
+	}
+	return n, nil
+}
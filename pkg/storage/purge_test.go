@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPurge(t *testing.T) {
+	ctx := t.Context()
+	cfg := newTestConfig(nil)
+	_, db, _ := newTestDB(t, cfg, 100)
+	total := int(db.RealLen())
+
+	if _, err := Purge(ctx, db, PurgeFilter{}, false); !errors.Is(err, PurgeFilterIsEmptyError) {
+		t.Fatalf("expected an error of an empty filter, got %v", err)
+	}
+	if n, err := Purge(ctx, db, PurgeFilter{Prefix: "/api/v1"}, false); err != nil || n != 0 {
+		t.Fatalf("expected no entries of another prefix, got %d (%v)", n, err)
+	}
+
+	n, err := Purge(ctx, db, PurgeFilter{Rule: string(path), Prefix: "/api/v2/"}, true)
+	if err != nil || n != total || int(db.RealLen()) != total {
+		t.Fatalf("expected %d entries marked stale and kept, got %d of %d (%v)", total, n, db.RealLen(), err)
+	}
+	stale := 0
+	for _, hot := range db.Hottest(ctx) {
+		if hot.Entry.IsStale() {
+			stale++
+		}
+	}
+	if stale != total {
+		t.Fatalf("expected %d stale entries, got %d", total, stale)
+	}
+
+	if n, err = Purge(ctx, db, PurgeFilter{Rule: string(path)}, false); err != nil || n != total || db.RealLen() != 0 {
+		t.Fatalf("expected %d removed entries, got %d, %d left (%v)", total, n, db.RealLen(), err)
+	}
+}
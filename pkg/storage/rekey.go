@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/Borislavv/advanced-cache/pkg/config"
+	"github.com/Borislavv/advanced-cache/pkg/model"
+	"github.com/Borislavv/advanced-cache/pkg/storage/snapshot"
+)
+
+// metaRuleKeys is the snapshot meta key with hashes of cache key definitions of rules (see config.Rule.KeyHash).
+const metaRuleKeys = "rule_keys"
+
+// ruleKeysMeta returns the meta value of the live rules: a JSON object of rule paths and hex hashes.
+func ruleKeysMeta(cfg *config.Cache) string {
+	hashes := make(map[string]string, len(cfg.Rules()))
+	for path, rule := range cfg.Rules() {
+		hashes[path] = strconv.FormatUint(rule.KeyHash(), 16)
+	}
+	data, _ := json.Marshal(hashes)
+	return string(data)
+}
+
+// rekeyer fixes keys of restored entries whose rule got another cache key definition since the snapshot,
+// such entries would sit under keys no request can hit.
+type rekeyer struct {
+	cfg     *config.Cache
+	changed map[*config.Rule]bool // live rules by whether their key definition differs from the snapshot one
+	hashes  map[string]string     // rule key hashes of the snapshot, nil if it has none
+}
+
+// newRekeyer returns a rekeyer of the snapshot header, snapshots without rule key hashes
+// (legacy dumps and older snapshots) are trusted.
+func newRekeyer(cfg *config.Cache, h snapshot.Header) *rekeyer {
+	r := &rekeyer{cfg: cfg, changed: make(map[*config.Rule]bool)}
+	if meta, ok := h.Meta[metaRuleKeys]; ok {
+		_ = json.Unmarshal([]byte(meta), &r.hashes)
+	}
+	return r
+}
+
+// rekey recomputes keys of the entry if its rule was changed (see model.Entry.Rekey), returns whether it did.
+// An entry whose path doesn't map to any rule anymore is an error matching model.IsRouteWasNotFound.
+func (r *rekeyer) rekey(e *model.Entry) (bool, error) {
+	if r == nil || r.hashes == nil {
+		return false, nil
+	}
+	rule := e.Rule()
+	changed, ok := r.changed[rule]
+	if !ok {
+		changed = r.hashes[string(rule.PathBytes)] != strconv.FormatUint(rule.KeyHash(), 16)
+		r.changed[rule] = changed
+	}
+	if !changed {
+		return false, nil
+	}
+	return true, e.Rekey(r.cfg)
+}
@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Borislavv/advanced-cache/pkg/config"
+	"github.com/Borislavv/advanced-cache/pkg/model"
+	"github.com/Borislavv/advanced-cache/pkg/storage/lru"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/time/rate"
+)
+
+// restoreRefreshWorkers is the max number of concurrent refreshes of restored entries.
+const restoreRefreshWorkers = 16
+
+// RestoreStats is the outcome of the classification of restored entries (see config.DumpRestore).
+type RestoreStats struct {
+	Fresh         int `json:"fresh"`         // Loaded as is.
+	RefreshQueued int `json:"refreshQueued"` // Past the refresh coefficient (stale ones included), queued for refresh.
+	Stale         int `json:"stale"`         // Expired, loaded as stale.
+	Dropped       int `json:"dropped"`       // Expired, dropped.
+}
+
+// restorer classifies restored entries against the refresh TTL of their rules.
+type restorer struct {
+	cfg     *config.Cache
+	now     time.Time
+	drop    bool
+	dropped atomic.Int64
+}
+
+func newRestorer(cfg *config.Cache) *restorer {
+	return &restorer{
+		cfg:  cfg,
+		now:  time.Now(),
+		drop: cfg.Cache.Persistence.Dump.Restore.Expired == config.RestoreExpiredDrop,
+	}
+}
+
+// admit is called before the entry is stored: an expired entry is dropped (false is returned) or marked stale.
+func (r *restorer) admit(e *model.Entry) bool {
+	age, _, ok := e.Staleness(r.cfg, r.now)
+	if !ok || age < 1 {
+		return true
+	}
+	if r.drop {
+		r.dropped.Add(1)
+		return false
+	}
+	e.MarkStale()
+	return true
+}
+
+// classify counts the stored entries and returns the ones to refresh, the most stale first.
+func (r *restorer) classify(stored []lru.HotEntry) (RestoreStats, []*model.Entry) {
+	type due struct {
+		entry *model.Entry
+		age   float64
+	}
+	var queue []due
+	stats := RestoreStats{Dropped: int(r.dropped.Load())}
+	for _, hot := range stored {
+		age, coefficient, ok := hot.Entry.Staleness(r.cfg, r.now)
+		if !ok || age < coefficient {
+			stats.Fresh++
+			continue
+		}
+		if hot.Entry.IsStale() {
+			stats.Stale++
+		}
+		queue = append(queue, due{entry: hot.Entry, age: age})
+	}
+	sort.SliceStable(queue, func(i, j int) bool { return queue[i].age > queue[j].age })
+
+	entries := make([]*model.Entry, len(queue))
+	for i, d := range queue {
+		entries[i] = d.entry
+	}
+	stats.RefreshQueued = len(entries)
+	return stats, entries
+}
+
+// refreshRestored refreshes the queued restored entries in order at persistence.dump.restore.refresh_rate,
+// it's interrupted by cancellation of the context.
+func (d *Dump) refreshRestored(ctx context.Context, queue []*model.Entry) {
+	start := time.Now()
+	limit := d.cfg.Cache.Persistence.Dump.Restore.RefreshRate
+	limiter := rate.NewLimiter(rate.Limit(limit), max(limit/10, 1))
+
+	var (
+		wg           sync.WaitGroup
+		done, failed atomic.Int64
+		pending      = int64(len(queue))
+		sem          = make(chan struct{}, restoreRefreshWorkers)
+	)
+	report := func() {
+		if d.meter != nil {
+			d.meter.SetRestoreRefreshes(int(done.Load()), int(failed.Load()), int(atomic.LoadInt64(&pending)))
+		}
+	}
+	report()
+
+	for _, e := range queue {
+		if err := limiter.Wait(ctx); err != nil {
+			break
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(e *model.Entry) {
+			defer func() {
+				atomic.AddInt64(&pending, -1)
+				report()
+				<-sem
+				wg.Done()
+			}()
+			if err := e.Revalidate(); err != nil {
+				failed.Add(1)
+				return
+			}
+			done.Add(1)
+			d.storage.Refreshed(e)
+		}(e)
+	}
+	wg.Wait()
+
+	log.Info().Msgf("[dump] restored entries refreshed: %d, failed: %d, not refreshed: %d, elapsed: %s",
+		done.Load(), failed.Load(), atomic.LoadInt64(&pending), time.Since(start))
+}
@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Borislavv/advanced-cache/pkg/config"
+	"github.com/Borislavv/advanced-cache/pkg/mock"
+	"github.com/Borislavv/advanced-cache/pkg/model"
+	"github.com/Borislavv/advanced-cache/pkg/prometheus/metrics"
+	"github.com/Borislavv/advanced-cache/pkg/storage/lru"
+)
+
+type restoreMeter struct {
+	metrics.Meter
+	stats RestoreStats
+}
+
+func (m *restoreMeter) SetRestore(fresh, refreshQueued, stale, dropped int) {
+	m.stats = RestoreStats{Fresh: fresh, RefreshQueued: refreshQueued, Stale: stale, Dropped: dropped}
+}
+
+func TestLoadClassifiesEntriesByStaleness(t *testing.T) {
+	ctx := t.Context()
+	dump := &config.Dump{IsEnabled: true, Dir: t.TempDir(), Name: "cache.dump", Format: config.DumpFormatSnapshot, Segments: 1}
+	cfg := newTestConfig(&config.Persistence{Dump: dump})
+	backend, src, _ := newTestDB(t, cfg, 0)
+
+	// The rule refreshes entries older than a half of the TTL (an hour): 10 fresh, 20 due, 30 expired.
+	now := time.Now()
+	expired := make(map[uint64]bool)
+	for i, e := range mock.GenerateEntryPointersConsecutive(cfg, backend, path, 60) {
+		age := time.Minute
+		switch {
+		case i >= 30:
+			age = 2 * time.Hour
+		case i >= 10:
+			age = 40 * time.Minute
+		}
+		var compressed int64
+		if e.IsCompressed() {
+			compressed = 1
+		}
+		aged := model.NewEntryFromField(e.MapKey(), e.ShardKey(), e.Fingerprint(), e.PayloadBytes(), e.Rule(), nil, compressed, now.Add(-age).UnixNano())
+		if !src.Set(aged) {
+			t.Fatal("expected the entry to be stored")
+		}
+		expired[aged.MapKey()] = age > time.Hour
+	}
+	if err := NewDumper(cfg, src, backend).Dump(ctx); err != nil {
+		t.Fatalf("Dump: %v", err)
+	}
+
+	for _, tc := range []struct {
+		policy string
+		want   RestoreStats
+	}{
+		{policy: config.RestoreExpiredStale, want: RestoreStats{Fresh: 10, RefreshQueued: 50, Stale: 30}},
+		{policy: config.RestoreExpiredDrop, want: RestoreStats{Fresh: 10, RefreshQueued: 20, Dropped: 30}},
+	} {
+		t.Run(tc.policy, func(t *testing.T) {
+			// Refreshes are disabled: the backend isn't reachable in tests.
+			dump.Restore = config.DumpRestore{Expired: tc.policy, RefreshRate: -1}
+			meter := &restoreMeter{}
+			dst := lru.NewStorage(ctx, cfg, backend)
+			dumper := NewDumper(cfg, dst, backend)
+			dumper.SetMeter(meter)
+			if err := dumper.Load(ctx); err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			if meter.stats != tc.want {
+				t.Fatalf("expected %+v, got %+v", tc.want, meter.stats)
+			}
+			for _, hot := range dst.Hottest(ctx) {
+				if hot.Entry.IsStale() != expired[hot.Entry.MapKey()] {
+					t.Fatalf("expected only expired entries to be stale, entry %d stale: %v", hot.Entry.MapKey(), hot.Entry.IsStale())
+				}
+			}
+		})
+	}
+}
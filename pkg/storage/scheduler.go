@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Borislavv/advanced-cache/pkg/config"
+	"github.com/Borislavv/advanced-cache/pkg/prometheus/metrics"
+	"github.com/rs/zerolog/log"
+)
+
+// schedulerCheckInterval is how often the scheduler checks the number of changed entries.
+const schedulerCheckInterval = time.Second
+
+// DumpScheduler takes snapshots in background while serving (see persistence.dump.schedule).
+// Snapshots are throttled by the schedule limits and are interrupted by Stop.
+type DumpScheduler struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	cfg     *config.Cache
+	dumper  *Dump
+	storage Storage
+	meter   metrics.Meter
+}
+
+// NewDumpScheduler creates a scheduler of the dumper.
+func NewDumpScheduler(ctx context.Context, cfg *config.Cache, dumper *Dump, storage Storage, meter metrics.Meter) *DumpScheduler {
+	ctx, cancel := context.WithCancel(ctx)
+	return &DumpScheduler{ctx: ctx, cancel: cancel, cfg: cfg, dumper: dumper, storage: storage, meter: meter}
+}
+
+// Run starts the scheduler in background.
+func (s *DumpScheduler) Run() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.run()
+	}()
+}
+
+// Stop interrupts a snapshot in progress (its version dir is removed) and waits for the scheduler to exit.
+func (s *DumpScheduler) Stop() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+func (s *DumpScheduler) run() {
+	schedule := s.cfg.Cache.Persistence.Dump.Schedule
+	log.Info().Msgf("[dump] scheduled snapshots: every %s or %d changes (min interval %s)",
+		schedule.Interval, schedule.Changes, schedule.MinInterval)
+
+	check := schedulerCheckInterval
+	if schedule.MinInterval > 0 {
+		check = min(check, schedule.MinInterval)
+	}
+	ticker := time.NewTicker(check)
+	defer ticker.Stop()
+
+	last, lastChanges := time.Now(), s.storage.Changes()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		elapsed, changes := time.Since(last), s.storage.Changes()-lastChanges
+		byInterval := schedule.Interval > 0 && elapsed >= schedule.Interval
+		byChanges := schedule.Changes > 0 && changes >= schedule.Changes
+		if elapsed < schedule.MinInterval || (!byInterval && !byChanges) {
+			continue
+		}
+
+		// Changes made while the snapshot is taken are counted for the next one.
+		last, lastChanges = time.Now(), s.storage.Changes()
+		s.snapshot(schedule, changes)
+	}
+}
+
+func (s *DumpScheduler) snapshot(schedule config.DumpSchedule, changes int64) {
+	start := time.Now()
+	log.Info().Int64("changes", changes).Msg("[dump] taking a scheduled snapshot")
+
+	entries, err := s.dumper.dump(s.ctx, newDumpLimits(schedule))
+	if err != nil {
+		if !errors.Is(err, context.Canceled) {
+			log.Error().Err(err).Msg("[dump] scheduled snapshot failed")
+			s.meter.IncSnapshotFailures()
+		}
+		return
+	}
+	s.meter.SetSnapshot(time.Now(), time.Since(start), entries)
+}
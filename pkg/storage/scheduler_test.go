@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Borislavv/advanced-cache/pkg/config"
+	"github.com/Borislavv/advanced-cache/pkg/mock"
+	"github.com/Borislavv/advanced-cache/pkg/prometheus/metrics"
+)
+
+type snapshotMeter struct {
+	metrics.Meter
+	entries  atomic.Int64
+	failures atomic.Int64
+}
+
+func (m *snapshotMeter) SetSnapshot(_ time.Time, _ time.Duration, entries int) {
+	m.entries.Store(int64(entries))
+}
+func (m *snapshotMeter) IncSnapshotFailures() { m.failures.Add(1) }
+
+func TestDumpSchedulerByChanges(t *testing.T) {
+	dir := t.TempDir()
+	ctx := t.Context()
+	cfg := newTestConfig(&config.Persistence{Dump: &config.Dump{
+		IsEnabled: true, Dir: dir, Name: "cache.dump", Format: config.DumpFormatSnapshot, Segments: 2,
+		Schedule: config.DumpSchedule{Enabled: true, Changes: 50, MinInterval: 10 * time.Millisecond, Workers: 1},
+	}})
+	backend, db, _ := newTestDB(t, cfg, 0)
+	meter := &snapshotMeter{}
+
+	scheduler := NewDumpScheduler(ctx, cfg, NewDumper(cfg, db, backend), db, meter)
+	scheduler.Run()
+	defer scheduler.Stop()
+
+	time.Sleep(50 * time.Millisecond)
+	if versions, _ := ListDumpVersions(dir, "cache.dump"); len(versions) != 0 {
+		t.Fatalf("expected no snapshots without changes, got %d", len(versions))
+	}
+
+	for _, entry := range mock.GenerateEntryPointersConsecutive(cfg, backend, path, 100) {
+		db.Set(entry)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for meter.entries.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if meter.entries.Load() != db.RealLen() || meter.failures.Load() != 0 {
+		t.Fatalf("expected a snapshot of %d entries, got %d (failures: %d)", db.RealLen(), meter.entries.Load(), meter.failures.Load())
+	}
+	if versions, _ := ListDumpVersions(dir, "cache.dump"); len(versions) != 1 {
+		t.Fatalf("expected a single snapshot, got %d", len(versions))
+	}
+}
+
+func TestDumpSchedulerStopRemovesIncompleteSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	ctx := t.Context()
+	cfg := newTestConfig(&config.Persistence{Dump: &config.Dump{
+		IsEnabled: true, Dir: dir, Name: "cache.dump", Format: config.DumpFormatSnapshot, Segments: 1,
+		Schedule: config.DumpSchedule{Enabled: true, Changes: 1, MinInterval: 10 * time.Millisecond, Rate: 10, Workers: 1},
+	}})
+	backend, db, _ := newTestDB(t, cfg, 100)
+
+	meter := &snapshotMeter{}
+	scheduler := NewDumpScheduler(ctx, cfg, NewDumper(cfg, db, backend), db, meter)
+	scheduler.Run()
+	time.Sleep(200 * time.Millisecond) // the throttled snapshot takes ~10s
+	scheduler.Stop()
+
+	if versions, _ := ListDumpVersions(dir, "cache.dump"); len(versions) != 0 {
+		t.Fatalf("expected the interrupted snapshot to be removed, got %+v", versions)
+	}
+	if meter.entries.Load() != 0 || meter.failures.Load() != 0 {
+		t.Fatalf("an interrupted snapshot is neither a success nor a failure")
+	}
+}
@@ -0,0 +1,120 @@
+package snapshot
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	NoneCodecID byte = iota
+	GzipCodecID
+	ZstdCodecID
+)
+
+func init() {
+	RegisterCodec(noneCodec{})
+	RegisterCodec(gzipCodec{})
+	RegisterCodec(zstdCodec{})
+}
+
+// noneCodec stores blocks as is.
+type noneCodec struct{}
+
+func (noneCodec) ID() byte     { return NoneCodecID }
+func (noneCodec) Name() string { return "none" }
+
+func (noneCodec) Compress(dst, src []byte) ([]byte, error)   { return append(dst, src...), nil }
+func (noneCodec) Decompress(dst, src []byte) ([]byte, error) { return append(dst, src...), nil }
+
+// gzipCodec compresses blocks with the default gzip level.
+type gzipCodec struct{}
+
+func (gzipCodec) ID() byte     { return GzipCodecID }
+func (gzipCodec) Name() string { return "gzip" }
+
+func (gzipCodec) Compress(dst, src []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+	w := gzip.NewWriter(buf)
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCodec) Decompress(dst, src []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	buf := bytes.NewBuffer(dst)
+	if _, err = io.Copy(buf, r); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// zstdCodec compresses blocks with zstd, the level matters for writers only (0 is the default level).
+// Each block is an independent zstd frame, so blocks of a file are decompressed in parallel.
+type zstdCodec struct {
+	level int
+}
+
+var (
+	zstdEncoders sync.Map // level -> *zstd.Encoder, EncodeAll is safe for concurrent use
+	zstdDecoder  = sync.OnceValues(func() (*zstd.Decoder, error) {
+		return zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+	})
+)
+
+// NewZstdCodec returns the zstd codec with the compression level, 1 (fastest) to 22 (best), 0 is the default one.
+func NewZstdCodec(level int) (Codec, error) {
+	if level < 0 || level > 22 {
+		return nil, fmt.Errorf("zstd level %d is out of range 1..22", level)
+	}
+	return zstdCodec{level: level}, nil
+}
+
+func (zstdCodec) ID() byte     { return ZstdCodecID }
+func (zstdCodec) Name() string { return "zstd" }
+
+func (c zstdCodec) Compress(dst, src []byte) ([]byte, error) {
+	enc, err := c.encoder()
+	if err != nil {
+		return nil, err
+	}
+	return enc.EncodeAll(src, dst), nil
+}
+
+func (zstdCodec) Decompress(dst, src []byte) ([]byte, error) {
+	dec, err := zstdDecoder()
+	if err != nil {
+		return nil, err
+	}
+	return dec.DecodeAll(src, dst)
+}
+
+func (c zstdCodec) encoder() (*zstd.Encoder, error) {
+	if enc, ok := zstdEncoders.Load(c.level); ok {
+		return enc.(*zstd.Encoder), nil
+	}
+	level := zstd.SpeedDefault
+	if c.level > 0 {
+		level = zstd.EncoderLevelFromZstd(c.level)
+	}
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(level))
+	if err != nil {
+		return nil, err
+	}
+	actual, _ := zstdEncoders.LoadOrStore(c.level, enc)
+	return actual.(*zstd.Encoder), nil
+}
@@ -0,0 +1,99 @@
+package snapshot
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// KeyError means a snapshot is encrypted with a key which is not available (or doesn't match its id).
+var KeyError = errors.New("snapshot key mismatch")
+
+// reserved meta keys of encrypted snapshots
+const (
+	metaKeyID    = "key_id"
+	metaKeyCheck = "key_check"
+)
+
+// Key encrypts blocks with AES-GCM. Each block is sealed after compression with a random nonce
+// (stored bytes are the nonce and the sealed data), the codec, records and raw length of the block are authenticated.
+type Key struct {
+	id    string
+	aead  cipher.AEAD
+	check string
+}
+
+// NewKey creates a key of the id, the secret is an AES key of 16, 24 or 32 bytes.
+func NewKey(id string, secret []byte) (*Key, error) {
+	if id == "" {
+		return nil, errors.New("key id is required")
+	}
+	block, err := aes.NewCipher(secret)
+	if err != nil {
+		return nil, fmt.Errorf("key %q: %w", id, err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("key %q: %w", id, err)
+	}
+	// The check tells a wrong secret from a corrupted file without decrypting blocks, it doesn't reveal the key.
+	sum := sha256.Sum256(append([]byte("advanced-cache snapshot key "+id+"\x00"), secret...))
+	return &Key{id: id, aead: aead, check: hex.EncodeToString(sum[:8])}, nil
+}
+
+// ID returns the id of the key stored in headers of encrypted snapshots.
+func (k *Key) ID() string { return k.id }
+
+func (k *Key) seal(dst, raw []byte, hdr []byte) ([]byte, error) {
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	dst = append(dst, nonce...)
+	return k.aead.Seal(dst, nonce, raw, blockAAD(hdr)), nil
+}
+
+func (k *Key) open(stored []byte, hdr []byte) ([]byte, error) {
+	n := k.aead.NonceSize()
+	if len(stored) < n+k.aead.Overhead() {
+		return nil, TruncatedError
+	}
+	return k.aead.Open(nil, stored[:n], stored[n:], blockAAD(hdr))
+}
+
+// blockAAD returns the authenticated part of a block header: codec, records, raw length.
+func blockAAD(hdr []byte) []byte {
+	return hdr[:1+4+4]
+}
+
+// Keyring holds the keys of snapshots, the first one encrypts written snapshots.
+type Keyring []*Key
+
+// Primary returns the key new snapshots are encrypted with, nil if there are no keys.
+func (kr Keyring) Primary() *Key {
+	if len(kr) == 0 {
+		return nil
+	}
+	return kr[0]
+}
+
+// resolve returns the key of the header: nil for a plain snapshot, KeyError if the key is missing or doesn't match.
+func (kr Keyring) resolve(h Header) (*Key, error) {
+	if h.KeyID == "" {
+		return nil, nil
+	}
+	for _, k := range kr {
+		if k.id != h.KeyID {
+			continue
+		}
+		if k.check != h.KeyCheck {
+			return nil, fmt.Errorf("%w: snapshot is encrypted with another key of id %q", KeyError, h.KeyID)
+		}
+		return k, nil
+	}
+	return nil, fmt.Errorf("%w: snapshot is encrypted with key %q, which is not configured", KeyError, h.KeyID)
+}
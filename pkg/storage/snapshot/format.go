@@ -0,0 +1,105 @@
+// Package snapshot implements the self-describing container of dumps.
+//
+// Layout of a snapshot file (all integers are little endian):
+//
+//	header   magic "ADVCSNAP", format version u16, entry version u16, segment u16, segments u16,
+//	         created unix nano i64, hash seed u64, rules hash u64, meta length u32, crc32 u32 (of the header and meta)
+//	meta     JSON object of strings (optional), "key_id" and "key_check" are reserved for encrypted snapshots
+//	blocks   codec u8, records u32, raw length u32, stored length u32, crc32 u32 (of the stored bytes), stored bytes;
+//	         raw bytes are records, each prefixed with its length u32; stored bytes of encrypted snapshots
+//	         are a nonce and AES-GCM sealed compressed bytes (see Key)
+//	index    blocks u32, per block: offset u64, records u32, raw length u32, stored length u32, codec u8
+//	trailer  index offset u64, index length u32, index crc32 u32, magic "ADVCSEND"
+//
+// Readers dispatch by the format version, so the container may evolve while older snapshots stay readable.
+// The entry version describes the layout of records (see model.EntryFormatVersion), it's checked by loaders.
+package snapshot
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Version is the format version written by the Writer.
+const Version uint16 = 1
+
+const (
+	headerSize      = 8 + 2 + 2 + 2 + 2 + 8 + 8 + 8 + 4 + 4
+	blockHeaderSize = 1 + 4 + 4 + 4 + 4
+	indexEntrySize  = 8 + 4 + 4 + 4 + 1
+	trailerSize     = 8 + 4 + 4 + 8
+
+	// DefaultBlockSize is the raw size of a block after which it's flushed.
+	DefaultBlockSize = 1 << 20
+)
+
+var (
+	magic    = [8]byte{'A', 'D', 'V', 'C', 'S', 'N', 'A', 'P'}
+	endMagic = [8]byte{'A', 'D', 'V', 'C', 'S', 'E', 'N', 'D'}
+)
+
+var (
+	NotSnapshotError        = errors.New("not a snapshot (bad magic)")
+	UnsupportedVersionError = errors.New("unsupported snapshot format version")
+	ChecksumError           = errors.New("checksum mismatch")
+	TruncatedError          = errors.New("snapshot is truncated")
+)
+
+// Header describes a snapshot file.
+type Header struct {
+	Version      uint16            // Format version of the container.
+	EntryVersion uint16            // Layout version of records.
+	Segment      uint16            // Index of the file in a segmented snapshot (0 for a single file).
+	Segments     uint16            // Number of files of the snapshot (1 for a single file).
+	Created      time.Time         // Time the snapshot was started at.
+	HashSeed     uint64            // Seed of entry keys hashing.
+	RulesHash    uint64            // Hash of the rule set entries were created with.
+	Meta         map[string]string // Free-form metadata.
+	KeyID        string            // Id of the key blocks are encrypted with, empty for a plain snapshot.
+	KeyCheck     string            // Fingerprint of the key, set by the Writer.
+}
+
+// BlockInfo is an entry of the trailing index.
+type BlockInfo struct {
+	Offset    int64 // Offset of the block header in the file.
+	Records   int
+	RawLen    int
+	StoredLen int
+	Codec     byte
+}
+
+// Codec compresses blocks, the id is stored in each block.
+type Codec interface {
+	ID() byte
+	Name() string
+	Compress(dst, src []byte) ([]byte, error)
+	Decompress(dst, src []byte) ([]byte, error)
+}
+
+var codecs = map[byte]Codec{}
+
+// RegisterCodec makes the codec available to writers and readers, ids must be unique.
+func RegisterCodec(c Codec) {
+	if _, ok := codecs[c.ID()]; ok {
+		panic(fmt.Sprintf("snapshot: codec %d is already registered", c.ID()))
+	}
+	codecs[c.ID()] = c
+}
+
+// CodecByName returns a registered codec by its name.
+func CodecByName(name string) (Codec, error) {
+	for _, c := range codecs {
+		if c.Name() == name {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown snapshot codec %q", name)
+}
+
+func codecByID(id byte) (Codec, error) {
+	if c, ok := codecs[id]; ok {
+		return c, nil
+	}
+	return nil, fmt.Errorf("unknown snapshot codec id %d", id)
+}
@@ -0,0 +1,101 @@
+package snapshot
+
+import (
+	"bytes"
+	"os"
+	"sync/atomic"
+)
+
+// mappedBytes is the total size of mappings which are not released yet.
+var mappedBytes atomic.Int64
+
+// MappedBytes returns the total size of snapshot files mapped into memory and not released yet.
+func MappedBytes() int64 {
+	return mappedBytes.Load()
+}
+
+// Mapping is a Reader of a snapshot file mapped into memory read-only. Records of plain blocks
+// (the none codec, not encrypted) alias the mapping instead of being copied, records of other blocks
+// are decompressed into the heap as usual (see Zerocopy).
+//
+// The mapping is reference counted: it's acquired once by MapFile, holders of records call Acquire
+// and Release, the file is unmapped once the last reference is released. Records must not be modified.
+// Files are mapped on unix only, elsewhere they are read into the heap (see mmap_other.go).
+type Mapping struct {
+	*Reader
+	name     string
+	refs     atomic.Int64
+	released atomic.Bool
+}
+
+// MapFile maps a snapshot file and reads its header and index. The caller holds a reference (see Release).
+func MapFile(name string) (*Mapping, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() // the mapping outlives the descriptor
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() < headerSize {
+		return nil, TruncatedError
+	}
+	data, err := mmap(f, int(fi.Size()))
+	if err != nil {
+		return nil, &os.PathError{Op: "mmap", Path: name, Err: err}
+	}
+
+	r, err := Open(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		_ = unmap(data)
+		return nil, err
+	}
+	r.data = data
+	m := &Mapping{Reader: r, name: name}
+	m.refs.Store(1)
+	mappedBytes.Add(int64(len(data)))
+	return m, nil
+}
+
+// Zerocopy reports whether all records alias the mapping: the snapshot is not encrypted
+// and all of its blocks are stored by the none codec.
+func (m *Mapping) Zerocopy() bool {
+	if m.header.KeyID != "" {
+		return false
+	}
+	for _, b := range m.blocks {
+		if b.Codec != NoneCodecID {
+			return false
+		}
+	}
+	return true
+}
+
+// Size returns the size of the mapping.
+func (m *Mapping) Size() int64 { return int64(len(m.data)) }
+
+// Acquire adds a reference to the mapping, it must not be released yet.
+func (m *Mapping) Acquire() {
+	if m.refs.Add(1) <= 1 {
+		panic("snapshot: acquire of a released mapping " + m.name)
+	}
+}
+
+// Release drops a reference, the file is unmapped once there are none.
+func (m *Mapping) Release() {
+	switch refs := m.refs.Add(-1); {
+	case refs > 0:
+		return
+	case refs < 0:
+		panic("snapshot: mapping released more times than acquired " + m.name)
+	}
+	m.released.Store(true)
+	mappedBytes.Add(-int64(len(m.data)))
+	_ = unmap(m.data)
+}
+
+// Released reports whether the last reference is released.
+func (m *Mapping) Released() bool { return m.released.Load() }
@@ -0,0 +1,22 @@
+//go:build !unix
+
+package snapshot
+
+import (
+	"io"
+	"os"
+)
+
+// mmap reads size bytes of the file into the heap: records alias the buffer as they would alias a mapping.
+func mmap(f *os.File, size int) ([]byte, error) {
+	data := make([]byte, size)
+	if _, err := io.ReadFull(f, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// unmap does nothing, the buffer is collected by the GC once no record refers to it.
+func unmap([]byte) error {
+	return nil
+}
@@ -0,0 +1,398 @@
+package snapshot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+)
+
+// Reader gives random access to blocks of a snapshot file. It's safe for concurrent use.
+type Reader struct {
+	r          io.ReaderAt
+	size       int64
+	header     Header
+	dataOffset int64 // offset of the first block
+	blocks     []BlockInfo
+	incomplete bool
+	key        *Key
+	data       []byte // the whole file if it's mapped (see Mapping), blocks are sliced instead of read
+}
+
+// Open reads the header and the index of a snapshot, the reader is chosen by the format version.
+func Open(r io.ReaderAt, size int64) (*Reader, error) {
+	var prefix [10]byte
+	if _, err := r.ReadAt(prefix[:], 0); err != nil {
+		if err == io.EOF {
+			return nil, TruncatedError
+		}
+		return nil, err
+	}
+	if !bytes.Equal(prefix[:8], magic[:]) {
+		return nil, NotSnapshotError
+	}
+
+	switch version := binary.LittleEndian.Uint16(prefix[8:]); version {
+	case 1:
+		return openV1(r, size)
+	default:
+		return nil, fmt.Errorf("%w: %d (supported: %d)", UnsupportedVersionError, version, Version)
+	}
+}
+
+func openV1(r io.ReaderAt, size int64) (*Reader, error) {
+	if size < headerSize {
+		return nil, TruncatedError
+	}
+	buf := make([]byte, headerSize)
+	if _, err := r.ReadAt(buf, 0); err != nil {
+		return nil, err
+	}
+
+	metaLen := int64(binary.LittleEndian.Uint32(buf[40:]))
+	if headerSize+metaLen > size {
+		return nil, TruncatedError
+	}
+	meta := make([]byte, metaLen)
+	if _, err := r.ReadAt(meta, headerSize); err != nil {
+		return nil, err
+	}
+	header, err := parseHeaderV1(buf, meta)
+	if err != nil {
+		return nil, err
+	}
+	sr := &Reader{r: r, size: size, header: header, dataOffset: headerSize + metaLen}
+
+	if err = sr.readIndex(); err != nil {
+		// The file was not finished (e.g. the process was killed): recover complete blocks by scanning.
+		sr.incomplete = true
+		sr.scan()
+	}
+	return sr, nil
+}
+
+// parseHeaderV1 verifies and decodes the fixed part of a v1 header and its meta.
+func parseHeaderV1(buf, meta []byte) (Header, error) {
+	crc := crc32.NewIEEE()
+	crc.Write(buf[:headerSize-4])
+	crc.Write(meta)
+	if crc.Sum32() != binary.LittleEndian.Uint32(buf[headerSize-4:]) {
+		return Header{}, fmt.Errorf("header: %w", ChecksumError)
+	}
+
+	h := Header{
+		Version:      binary.LittleEndian.Uint16(buf[8:]),
+		EntryVersion: binary.LittleEndian.Uint16(buf[10:]),
+		Segment:      binary.LittleEndian.Uint16(buf[12:]),
+		Segments:     binary.LittleEndian.Uint16(buf[14:]),
+		Created:      time.Unix(0, int64(binary.LittleEndian.Uint64(buf[16:]))),
+		HashSeed:     binary.LittleEndian.Uint64(buf[24:]),
+		RulesHash:    binary.LittleEndian.Uint64(buf[32:]),
+	}
+	if len(meta) > 0 {
+		if err := json.Unmarshal(meta, &h.Meta); err != nil {
+			return Header{}, fmt.Errorf("header meta: %w", err)
+		}
+		h.KeyID, h.KeyCheck = h.Meta[metaKeyID], h.Meta[metaKeyCheck]
+		delete(h.Meta, metaKeyID)
+		delete(h.Meta, metaKeyCheck)
+	}
+	return h, nil
+}
+
+func (r *Reader) readIndex() error {
+	if r.size < r.dataOffset+trailerSize {
+		return TruncatedError
+	}
+	trailer := make([]byte, trailerSize)
+	if _, err := r.r.ReadAt(trailer, r.size-trailerSize); err != nil {
+		return err
+	}
+	if !bytes.Equal(trailer[16:], endMagic[:]) {
+		return TruncatedError
+	}
+
+	offset := int64(binary.LittleEndian.Uint64(trailer[0:]))
+	length := int64(binary.LittleEndian.Uint32(trailer[8:]))
+	if offset < r.dataOffset || offset+length != r.size-trailerSize || length < 4 {
+		return TruncatedError
+	}
+	index := make([]byte, length)
+	if _, err := r.r.ReadAt(index, offset); err != nil {
+		return err
+	}
+	if crc32.ChecksumIEEE(index) != binary.LittleEndian.Uint32(trailer[12:]) {
+		return fmt.Errorf("index: %w", ChecksumError)
+	}
+
+	n := int(binary.LittleEndian.Uint32(index))
+	if int64(4+n*indexEntrySize) != length {
+		return TruncatedError
+	}
+	r.blocks = make([]BlockInfo, 0, n)
+	for i, p := 0, 4; i < n; i, p = i+1, p+indexEntrySize {
+		r.blocks = append(r.blocks, BlockInfo{
+			Offset:    int64(binary.LittleEndian.Uint64(index[p:])),
+			Records:   int(binary.LittleEndian.Uint32(index[p+8:])),
+			RawLen:    int(binary.LittleEndian.Uint32(index[p+12:])),
+			StoredLen: int(binary.LittleEndian.Uint32(index[p+16:])),
+			Codec:     index[p+20],
+		})
+	}
+	return nil
+}
+
+// scan builds the index from block headers, it stops at the first incomplete block.
+func (r *Reader) scan() {
+	r.blocks = nil
+	var hdr [blockHeaderSize]byte
+	for offset := r.dataOffset; offset+blockHeaderSize <= r.size; {
+		if _, err := r.r.ReadAt(hdr[:], offset); err != nil {
+			return
+		}
+		info := BlockInfo{
+			Offset:    offset,
+			Codec:     hdr[0],
+			Records:   int(binary.LittleEndian.Uint32(hdr[1:])),
+			RawLen:    int(binary.LittleEndian.Uint32(hdr[5:])),
+			StoredLen: int(binary.LittleEndian.Uint32(hdr[9:])),
+		}
+		next := offset + blockHeaderSize + int64(info.StoredLen)
+		if info.Records == 0 || next > r.size {
+			return
+		}
+		r.blocks = append(r.blocks, info)
+		offset = next
+	}
+}
+
+// Header returns the header of the snapshot.
+func (r *Reader) Header() Header { return r.header }
+
+// SetKeys selects the key of an encrypted snapshot, it must be called before blocks are read.
+// Returns KeyError if the key of the snapshot is not in the keyring or doesn't match.
+func (r *Reader) SetKeys(keys Keyring) (err error) {
+	r.key, err = keys.resolve(r.header)
+	return err
+}
+
+// Blocks returns the index of blocks.
+func (r *Reader) Blocks() []BlockInfo { return r.blocks }
+
+// Incomplete reports whether the snapshot has no valid index (it was not finished), blocks were recovered by scanning.
+func (r *Reader) Incomplete() bool { return r.incomplete }
+
+// Records returns the number of records of all blocks.
+func (r *Reader) Records() int {
+	var n int
+	for _, b := range r.blocks {
+		n += b.Records
+	}
+	return n
+}
+
+// ReadBlock verifies and decompresses the i-th block and calls fn for each record.
+// Records alias a buffer allocated for the block, it's never reused (or the mapping of plain blocks, see Mapping).
+func (r *Reader) ReadBlock(i int, fn func(record []byte) error) error {
+	raw, err := r.readBlock(i)
+	if err != nil {
+		return err
+	}
+	return splitRecords(i, r.blocks[i], raw, fn)
+}
+
+// ReadBlocks reads all blocks in order, up to workers blocks are read and decompressed in parallel
+// while records of the previous ones are consumed. fn is called by the calling goroutine only,
+// records alias a buffer allocated for the block. A damaged block is passed to skip and the rest is read further.
+// Returns the first error of fn.
+func (r *Reader) ReadBlocks(workers int, fn func(record []byte) error, skip func(err error)) error {
+	var fnErr error
+	consume := func(record []byte) error {
+		fnErr = fn(record)
+		return fnErr
+	}
+	read := func(i int, raw []byte, err error) error {
+		if err == nil {
+			err = splitRecords(i, r.blocks[i], raw, consume)
+		}
+		if fnErr != nil {
+			return fnErr
+		}
+		if err != nil {
+			skip(err)
+		}
+		return nil
+	}
+
+	if workers <= 1 {
+		for i := range r.blocks {
+			raw, err := r.readBlock(i)
+			if err = read(i, raw, err); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	type decoded struct {
+		raw []byte
+		err error
+	}
+	results := make([]chan decoded, len(r.blocks))
+	for i := range results {
+		results[i] = make(chan decoded, 1)
+	}
+	inFlight := make(chan struct{}, workers) // decoded blocks which are not consumed yet
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		for i := range r.blocks {
+			select {
+			case inFlight <- struct{}{}:
+			case <-done:
+				return
+			}
+			go func(i int) {
+				raw, err := r.readBlock(i)
+				results[i] <- decoded{raw: raw, err: err}
+			}(i)
+		}
+	}()
+
+	for i := range r.blocks {
+		res := <-results[i]
+		<-inFlight
+		if err := read(i, res.raw, res.err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readBlock reads, verifies and decompresses the i-th block.
+func (r *Reader) readBlock(i int) ([]byte, error) {
+	info := r.blocks[i]
+	if r.data != nil {
+		end := info.Offset + blockHeaderSize + int64(info.StoredLen)
+		if end > int64(len(r.data)) {
+			return nil, TruncatedError
+		}
+		hdr, stored := r.data[info.Offset:info.Offset+blockHeaderSize], r.data[info.Offset+blockHeaderSize:end:end]
+		if info.Codec == NoneCodecID && r.header.KeyID == "" {
+			// Plain blocks are not copied: records alias the mapping.
+			if err := verifyBlock(i, info, hdr, stored); err != nil {
+				return nil, err
+			}
+			return stored, nil
+		}
+		return decompressBlock(i, info, hdr, stored, r.header, r.key)
+	}
+	buf := make([]byte, blockHeaderSize+info.StoredLen)
+	if _, err := r.r.ReadAt(buf, info.Offset); err != nil {
+		if err == io.EOF {
+			return nil, TruncatedError
+		}
+		return nil, err
+	}
+	return decompressBlock(i, info, buf[:blockHeaderSize], buf[blockHeaderSize:], r.header, r.key)
+}
+
+// decodeBlock verifies, decrypts and decompresses the i-th block (its header and stored bytes) and calls fn for each record.
+func decodeBlock(i int, info BlockInfo, hdr, stored []byte, h Header, key *Key, fn func(record []byte) error) error {
+	raw, err := decompressBlock(i, info, hdr, stored, h, key)
+	if err != nil {
+		return err
+	}
+	return splitRecords(i, info, raw, fn)
+}
+
+// decompressBlock verifies the i-th block (its header and stored bytes) of a snapshot of the header,
+// decrypts it with the key (see Reader.SetKeys) and returns its raw records.
+func decompressBlock(i int, info BlockInfo, hdr, stored []byte, h Header, key *Key) ([]byte, error) {
+	if err := verifyBlock(i, info, hdr, stored); err != nil {
+		return nil, err
+	}
+	if h.KeyID != "" {
+		if key == nil {
+			return nil, fmt.Errorf("block %d: %w: snapshot is encrypted with key %q", i, KeyError, h.KeyID)
+		}
+		var err error
+		if stored, err = key.open(stored, hdr); err != nil {
+			// The key matches the header, so the block was damaged or tampered with.
+			return nil, fmt.Errorf("block %d: %w: %v", i, ChecksumError, err)
+		}
+	}
+
+	codec, err := codecByID(info.Codec)
+	if err != nil {
+		return nil, fmt.Errorf("block %d: %w", i, err)
+	}
+	raw, err := codec.Decompress(make([]byte, 0, info.RawLen), stored)
+	if err != nil {
+		return nil, fmt.Errorf("block %d: %w", i, err)
+	}
+	return raw, nil
+}
+
+// verifyBlock checks the header of the i-th block against the index and the checksum of its stored bytes.
+func verifyBlock(i int, info BlockInfo, hdr, stored []byte) error {
+	if hdr[0] != info.Codec || int(binary.LittleEndian.Uint32(hdr[9:])) != info.StoredLen {
+		return fmt.Errorf("block %d: header does not match the index", i)
+	}
+	if crc32.ChecksumIEEE(stored) != binary.LittleEndian.Uint32(hdr[13:]) {
+		return fmt.Errorf("block %d: %w", i, ChecksumError)
+	}
+	return nil
+}
+
+// splitRecords calls fn for each record of the raw block.
+func splitRecords(i int, info BlockInfo, raw []byte, fn func(record []byte) error) error {
+	for p, n := 0, 0; n < info.Records; n++ {
+		if p+4 > len(raw) {
+			return fmt.Errorf("block %d: %w", i, TruncatedError)
+		}
+		l := int(binary.LittleEndian.Uint32(raw[p:]))
+		p += 4
+		if p+l > len(raw) {
+			return fmt.Errorf("block %d: %w", i, TruncatedError)
+		}
+		if err := fn(raw[p : p+l : p+l]); err != nil {
+			return err
+		}
+		p += l
+	}
+	return nil
+}
+
+// File is a Reader of an opened file.
+type File struct {
+	*Reader
+	f *os.File
+}
+
+// OpenFile opens a snapshot file.
+func OpenFile(name string) (*File, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	r, err := Open(f, fi.Size())
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return &File{Reader: r, f: f}, nil
+}
+
+// Close closes the file.
+func (f *File) Close() error { return f.f.Close() }
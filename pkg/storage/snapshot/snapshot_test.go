@@ -0,0 +1,375 @@
+package snapshot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+func writeSnapshot(t *testing.T, codec string, records int, blockSize int) ([]byte, Header) {
+	c, err := CodecByName(codec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := Header{
+		EntryVersion: 1,
+		Segment:      1,
+		Segments:     2,
+		Created:      time.Unix(0, time.Now().UnixNano()),
+		HashSeed:     7,
+		RulesHash:    42,
+		Meta:         map[string]string{"node": "test"},
+	}
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, h, c, blockSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < records; i++ {
+		if err = w.Write([]byte(fmt.Sprintf("record-%04d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if w.Records() != records {
+		t.Fatalf("expected %d written records, got %d", records, w.Records())
+	}
+	return buf.Bytes(), h
+}
+
+func readAll(t *testing.T, r *Reader) (records []string, errs []error) {
+	for i := range r.Blocks() {
+		if err := r.ReadBlock(i, func(record []byte) error {
+			records = append(records, string(record))
+			return nil
+		}); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return records, errs
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, codec := range []string{"none", "gzip", "zstd"} {
+		t.Run(codec, func(t *testing.T) {
+			data, h := writeSnapshot(t, codec, 1000, 1024)
+
+			r, err := Open(bytes.NewReader(data), int64(len(data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := r.Header()
+			if got.Version != Version || got.EntryVersion != h.EntryVersion || got.Segment != 1 || got.Segments != 2 ||
+				!got.Created.Equal(h.Created) || got.HashSeed != 7 || got.RulesHash != 42 || got.Meta["node"] != "test" {
+				t.Fatalf("unexpected header: %+v", got)
+			}
+			if r.Incomplete() || len(r.Blocks()) < 2 || r.Records() != 1000 {
+				t.Fatalf("expected several indexed blocks with 1000 records, got %d blocks, %d records", len(r.Blocks()), r.Records())
+			}
+
+			records, errs := readAll(t, r)
+			if len(errs) > 0 || len(records) != 1000 || records[0] != "record-0000" || records[999] != "record-0999" {
+				t.Fatalf("unexpected records (%d), errors: %v", len(records), errs)
+			}
+
+			// Random access.
+			last := len(r.Blocks()) - 1
+			var lastRecord string
+			if err = r.ReadBlock(last, func(record []byte) error { lastRecord = string(record); return nil }); err != nil {
+				t.Fatal(err)
+			}
+			if lastRecord != "record-0999" {
+				t.Fatalf("unexpected last record of the last block: %q", lastRecord)
+			}
+		})
+	}
+}
+
+func TestCorruptedBlock(t *testing.T) {
+	data, _ := writeSnapshot(t, "none", 1000, 1024)
+	r, err := Open(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := r.Blocks()[1]
+	data[second.Offset+blockHeaderSize+10] ^= 0xff
+
+	records, errs := readAll(t, r)
+	if len(errs) != 1 || !errors.Is(errs[0], ChecksumError) {
+		t.Fatalf("expected a checksum error of a single block, got %v", errs)
+	}
+	if len(records) != 1000-second.Records {
+		t.Fatalf("expected records of other blocks to be read, got %d", len(records))
+	}
+}
+
+func TestReadBlocksInParallel(t *testing.T) {
+	data, _ := writeSnapshot(t, "zstd", 1000, 1024)
+	r, err := Open(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := r.Blocks()[1]
+	data[second.Offset+blockHeaderSize+1] ^= 0xff
+
+	var records []string
+	var skipped []error
+	err = r.ReadBlocks(4, func(record []byte) error {
+		records = append(records, string(record))
+		return nil
+	}, func(err error) { skipped = append(skipped, err) })
+	if err != nil || len(skipped) != 1 || !errors.Is(skipped[0], ChecksumError) {
+		t.Fatalf("expected a single skipped block, got %v (%v)", skipped, err)
+	}
+	if len(records) != 1000-second.Records || records[0] != "record-0000" || records[len(records)-1] != "record-0999" {
+		t.Fatalf("unexpected records (%d)", len(records))
+	}
+	for i := 1; i < len(records); i++ {
+		if records[i-1] >= records[i] {
+			t.Fatalf("records are out of order: %q, %q", records[i-1], records[i])
+		}
+	}
+
+	stop := errors.New("stop")
+	var n int
+	err = r.ReadBlocks(4, func([]byte) error {
+		if n++; n == 10 {
+			return stop
+		}
+		return nil
+	}, func(error) {})
+	if !errors.Is(err, stop) || n != 10 {
+		t.Fatalf("expected reading to stop on the error of fn, got %v after %d records", err, n)
+	}
+}
+
+func TestEncryption(t *testing.T) {
+	newKey := func(id string, b byte) *Key {
+		k, err := NewKey(id, bytes.Repeat([]byte{b}, 32))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+	oldKey, newKeyOfOldID, rotated := newKey("k1", 1), newKey("k1", 2), newKey("k2", 3)
+
+	var buf bytes.Buffer
+	w, err := NewEncryptedWriter(&buf, Header{Meta: map[string]string{"node": "test"}}, nil, 1024, oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		if err = w.Write([]byte(fmt.Sprintf("record-%04d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	if bytes.Contains(data, []byte("record-0001")) {
+		t.Fatal("expected records to be encrypted")
+	}
+
+	r, err := Open(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := r.Header(); h.KeyID != "k1" || len(h.Meta) != 1 {
+		t.Fatalf("unexpected header: %+v", h)
+	}
+	if err = r.ReadBlock(0, func([]byte) error { return nil }); !errors.Is(err, KeyError) {
+		t.Fatalf("expected KeyError without a key, got %v", err)
+	}
+	for _, keys := range []Keyring{nil, {rotated}, {newKeyOfOldID}} {
+		if err = r.SetKeys(keys); !errors.Is(err, KeyError) {
+			t.Fatalf("expected KeyError, got %v", err)
+		}
+	}
+
+	// A rotated keyring still reads snapshots of the old key.
+	if err = r.SetKeys(Keyring{rotated, oldKey}); err != nil {
+		t.Fatal(err)
+	}
+	records, errs := readAll(t, r)
+	if len(errs) > 0 || len(records) != 1000 || records[999] != "record-0999" {
+		t.Fatalf("unexpected records (%d), errors: %v", len(records), errs)
+	}
+
+	// Tampering is detected even if the checksum is fixed up.
+	second := r.Blocks()[1]
+	stored := data[second.Offset+blockHeaderSize : second.Offset+blockHeaderSize+int64(second.StoredLen)]
+	stored[len(stored)-1] ^= 0xff
+	binary.LittleEndian.PutUint32(data[second.Offset+13:], crc32.ChecksumIEEE(stored))
+	if err = r.ReadBlock(1, func([]byte) error { return nil }); !errors.Is(err, ChecksumError) {
+		t.Fatalf("expected ChecksumError of a tampered block, got %v", err)
+	}
+}
+
+func TestIncompleteSnapshot(t *testing.T) {
+	data, _ := writeSnapshot(t, "gzip", 1000, 1024)
+	r, err := Open(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := r.Blocks()
+
+	// Cut the file in the middle of the third block: the index and the rest are lost.
+	cut := data[:blocks[2].Offset+5]
+	r, err = Open(bytes.NewReader(cut), int64(len(cut)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Incomplete() || len(r.Blocks()) != 2 {
+		t.Fatalf("expected 2 recovered blocks, got %d (incomplete: %v)", len(r.Blocks()), r.Incomplete())
+	}
+	records, errs := readAll(t, r)
+	if len(errs) > 0 || len(records) != blocks[0].Records+blocks[1].Records {
+		t.Fatalf("unexpected recovered records (%d), errors: %v", len(records), errs)
+	}
+}
+
+func TestOpenDispatchesByVersion(t *testing.T) {
+	if _, err := Open(bytes.NewReader([]byte("not a snapshot at all")), 21); !errors.Is(err, NotSnapshotError) {
+		t.Fatalf("expected NotSnapshotError, got %v", err)
+	}
+
+	data, _ := writeSnapshot(t, "none", 10, 0)
+	binary.LittleEndian.PutUint16(data[8:], Version+1)
+	if _, err := Open(bytes.NewReader(data), int64(len(data))); !errors.Is(err, UnsupportedVersionError) {
+		t.Fatalf("expected UnsupportedVersionError, got %v", err)
+	}
+
+	data, _ = writeSnapshot(t, "none", 10, 0)
+	data[20] ^= 0xff // created time
+	if _, err := Open(bytes.NewReader(data), int64(len(data))); !errors.Is(err, ChecksumError) {
+		t.Fatalf("expected a header checksum error, got %v", err)
+	}
+}
+
+func TestStream(t *testing.T) {
+	for _, records := range []int{0, 1000} {
+		data, h := writeSnapshot(t, "gzip", records, 1024)
+
+		s, err := NewStream(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := s.Header(); got.RulesHash != h.RulesHash || got.Meta["node"] != "test" {
+			t.Fatalf("unexpected header: %+v", got)
+		}
+		var got []string
+		for err = nil; err == nil; {
+			err = s.Next(func(record []byte) error {
+				got = append(got, string(record))
+				return nil
+			})
+		}
+		if !errors.Is(err, io.EOF) || len(got) != records || s.Records() != records {
+			t.Fatalf("expected %d records and EOF, got %d (%v)", records, len(got), err)
+		}
+		if records > 0 && got[records-1] != "record-0999" {
+			t.Fatalf("unexpected last record: %q", got[records-1])
+		}
+
+		// A stream cut before the index is truncated.
+		s, err = NewStream(bytes.NewReader(data[:len(data)-trailerSize]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for err = nil; err == nil; {
+			err = s.Next(func([]byte) error { return nil })
+		}
+		if !errors.Is(err, TruncatedError) {
+			t.Fatalf("expected TruncatedError, got %v", err)
+		}
+	}
+}
+
+func BenchmarkCodecs(b *testing.B) {
+	var raw []byte
+	for i := 0; len(raw) < 1<<20; i++ {
+		raw = fmt.Appendf(raw, `{"id":%d,"title":"item %d","tags":["a","b","c"],"price":%d.99}`, i, i%97, i%1000)
+	}
+	for _, name := range []string{"none", "gzip", "zstd"} {
+		codec, _ := CodecByName(name)
+		stored, err := codec.Compress(nil, raw)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(name+"/compress", func(b *testing.B) {
+			b.SetBytes(int64(len(raw)))
+			for i := 0; i < b.N; i++ {
+				_, _ = codec.Compress(nil, raw)
+			}
+		})
+		b.Run(name+"/decompress", func(b *testing.B) {
+			b.SetBytes(int64(len(raw)))
+			b.ReportMetric(float64(len(stored))/float64(len(raw)), "ratio")
+			for i := 0; i < b.N; i++ {
+				_, _ = codec.Decompress(make([]byte, 0, len(raw)), stored)
+			}
+		})
+	}
+}
+
+func TestMapFile(t *testing.T) {
+	for _, tc := range []struct {
+		codec    string
+		zerocopy bool
+	}{{"none", true}, {"gzip", false}} {
+		data, _ := writeSnapshot(t, tc.codec, 100, 256)
+		name := filepath.Join(t.TempDir(), "cache.snap")
+		if err := os.WriteFile(name, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		before := MappedBytes()
+		m, err := MapFile(name)
+		if err != nil {
+			t.Fatalf("%s: MapFile: %v", tc.codec, err)
+		}
+		if m.Zerocopy() != tc.zerocopy || MappedBytes()-before != m.Size() {
+			t.Fatalf("%s: expected zerocopy %v and %d mapped bytes, got %v and %d", tc.codec, tc.zerocopy, m.Size(), m.Zerocopy(), MappedBytes()-before)
+		}
+
+		start := uintptr(unsafe.Pointer(unsafe.SliceData(m.data)))
+		var records, aliased int
+		err = m.ReadBlocks(2, func(record []byte) error {
+			if string(record) != fmt.Sprintf("record-%04d", records) {
+				return fmt.Errorf("unexpected record %d: %q", records, record)
+			}
+			if p := uintptr(unsafe.Pointer(unsafe.SliceData(record))); p >= start && p < start+uintptr(m.Size()) {
+				aliased++
+			}
+			records++
+			return nil
+		}, func(err error) { t.Errorf("%s: %v", tc.codec, err) })
+		if err != nil || records != 100 {
+			t.Fatalf("%s: expected 100 records, got %d (%v)", tc.codec, records, err)
+		}
+		if want := map[bool]int{true: 100, false: 0}[tc.zerocopy]; aliased != want {
+			t.Fatalf("%s: expected %d records aliasing the mapping, got %d", tc.codec, want, aliased)
+		}
+
+		m.Acquire()
+		m.Release()
+		if m.Released() {
+			t.Fatalf("%s: released while referenced", tc.codec)
+		}
+		m.Release()
+		if !m.Released() || MappedBytes() != before {
+			t.Fatalf("%s: expected the mapping to be released", tc.codec)
+		}
+	}
+}
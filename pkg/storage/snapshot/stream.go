@@ -0,0 +1,169 @@
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// streamBufferSize is the read buffer of a Stream, it must hold a block header and the first index entry.
+const streamBufferSize = 64 * 1024
+
+// Stream reads a snapshot sequentially, e.g. from a network stream where the index can't be read first.
+// Blocks are verified as they come, the index at the end must match the read blocks.
+type Stream struct {
+	r      *bufio.Reader
+	header Header
+	offset int64 // offset of the next block (or the index)
+	blocks []BlockInfo
+	done   bool
+	key    *Key
+}
+
+// NewStream reads and verifies the header.
+func NewStream(r io.Reader) (*Stream, error) {
+	br := bufio.NewReaderSize(r, streamBufferSize)
+
+	buf := make([]byte, headerSize)
+	if err := readFull(br, buf[:10]); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(buf[:8], magic[:]) {
+		return nil, NotSnapshotError
+	}
+	if version := binary.LittleEndian.Uint16(buf[8:]); version != 1 {
+		return nil, fmt.Errorf("%w: %d (supported by streams: 1)", UnsupportedVersionError, version)
+	}
+	if err := readFull(br, buf[10:]); err != nil {
+		return nil, err
+	}
+	meta := make([]byte, binary.LittleEndian.Uint32(buf[40:]))
+	if err := readFull(br, meta); err != nil {
+		return nil, err
+	}
+	header, err := parseHeaderV1(buf, meta)
+	if err != nil {
+		return nil, err
+	}
+	return &Stream{r: br, header: header, offset: int64(headerSize + len(meta))}, nil
+}
+
+// Header returns the header of the snapshot.
+func (s *Stream) Header() Header { return s.header }
+
+// SetKeys selects the key of an encrypted snapshot, see Reader.SetKeys.
+func (s *Stream) SetKeys(keys Keyring) (err error) {
+	s.key, err = keys.resolve(s.header)
+	return err
+}
+
+// Records returns the number of records of the read blocks.
+func (s *Stream) Records() int {
+	var n int
+	for _, b := range s.blocks {
+		n += b.Records
+	}
+	return n
+}
+
+// Next reads the next block and calls fn for each record. Records alias a buffer allocated for the block.
+// Returns io.EOF when the index is reached and verified, TruncatedError if the stream ends before it.
+// A block with a checksum error is skipped (ChecksumError is returned), the stream may be read further.
+func (s *Stream) Next(fn func(record []byte) error) error {
+	if s.done {
+		return io.EOF
+	}
+	if s.atIndex() {
+		if err := s.readIndex(); err != nil {
+			return err
+		}
+		s.done = true
+		return io.EOF
+	}
+
+	hdr := make([]byte, blockHeaderSize)
+	if err := readFull(s.r, hdr); err != nil {
+		return err
+	}
+	info := BlockInfo{
+		Offset:    s.offset,
+		Codec:     hdr[0],
+		Records:   int(binary.LittleEndian.Uint32(hdr[1:])),
+		RawLen:    int(binary.LittleEndian.Uint32(hdr[5:])),
+		StoredLen: int(binary.LittleEndian.Uint32(hdr[9:])),
+	}
+	if info.Records == 0 {
+		return fmt.Errorf("block %d: %w", len(s.blocks), TruncatedError)
+	}
+	stored := make([]byte, info.StoredLen)
+	if err := readFull(s.r, stored); err != nil {
+		return err
+	}
+	s.offset += int64(blockHeaderSize + info.StoredLen)
+	s.blocks = append(s.blocks, info)
+
+	return decodeBlock(len(s.blocks)-1, info, hdr, stored, s.header, s.key, fn)
+}
+
+// atIndex reports whether the index follows: it starts with the number of read blocks
+// and the first entry describes the first read block, which is never a valid block header.
+func (s *Stream) atIndex() bool {
+	if len(s.blocks) == 0 {
+		// A block header starts with a codec and a non-zero number of records.
+		head, err := s.r.Peek(4)
+		return err == nil && binary.LittleEndian.Uint32(head) == 0
+	}
+	head, err := s.r.Peek(4 + indexEntrySize)
+	if err != nil || int(binary.LittleEndian.Uint32(head)) != len(s.blocks) {
+		return false
+	}
+	entry, first := head[4:], s.blocks[0]
+	return int64(binary.LittleEndian.Uint64(entry)) == first.Offset &&
+		int(binary.LittleEndian.Uint32(entry[8:])) == first.Records &&
+		int(binary.LittleEndian.Uint32(entry[12:])) == first.RawLen &&
+		int(binary.LittleEndian.Uint32(entry[16:])) == first.StoredLen &&
+		entry[20] == first.Codec
+}
+
+// readIndex reads the index and the trailer and checks them against the read blocks.
+func (s *Stream) readIndex() error {
+	index := make([]byte, 4+len(s.blocks)*indexEntrySize)
+	if err := readFull(s.r, index); err != nil {
+		return err
+	}
+	for i, b := range s.blocks {
+		p := 4 + i*indexEntrySize
+		if int64(binary.LittleEndian.Uint64(index[p:])) != b.Offset || index[p+20] != b.Codec ||
+			int(binary.LittleEndian.Uint32(index[p+8:])) != b.Records {
+			return fmt.Errorf("index: entry %d does not match the block", i)
+		}
+	}
+
+	trailer := make([]byte, trailerSize)
+	if err := readFull(s.r, trailer); err != nil {
+		return err
+	}
+	if !bytes.Equal(trailer[16:], endMagic[:]) || int64(binary.LittleEndian.Uint64(trailer)) != s.offset ||
+		int(binary.LittleEndian.Uint32(trailer[8:])) != len(index) {
+		return fmt.Errorf("index: %w", TruncatedError)
+	}
+	if crc32.ChecksumIEEE(index) != binary.LittleEndian.Uint32(trailer[12:]) {
+		return fmt.Errorf("index: %w", ChecksumError)
+	}
+	return nil
+}
+
+// readFull reads len(buf) bytes, a premature end of the stream is TruncatedError.
+func readFull(r io.Reader, buf []byte) error {
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return TruncatedError
+		}
+		return err
+	}
+	return nil
+}
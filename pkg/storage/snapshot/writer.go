@@ -0,0 +1,166 @@
+package snapshot
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+)
+
+// Writer writes records into blocks of a snapshot file. It's not safe for concurrent use.
+type Writer struct {
+	w         io.Writer
+	codec     Codec
+	key       *Key
+	blockSize int
+	offset    int64
+	block     []byte // raw records of the current block
+	records   int    // records of the current block
+	stored    []byte // reusable buffer of compressed blocks
+	sealed    []byte // reusable buffer of encrypted blocks
+	index     []BlockInfo
+}
+
+// NewWriter writes the header and returns a writer of blocks compressed with the codec (none if nil).
+// blockSize <= 0 means DefaultBlockSize.
+func NewWriter(w io.Writer, h Header, codec Codec, blockSize int) (*Writer, error) {
+	return NewEncryptedWriter(w, h, codec, blockSize, nil)
+}
+
+// NewEncryptedWriter is NewWriter which encrypts blocks with the key (a plain snapshot is written if it's nil),
+// the id of the key is stored in the header.
+func NewEncryptedWriter(w io.Writer, h Header, codec Codec, blockSize int, key *Key) (*Writer, error) {
+	if codec == nil {
+		codec = noneCodec{}
+	}
+	if blockSize <= 0 {
+		blockSize = DefaultBlockSize
+	}
+	sw := &Writer{w: w, codec: codec, key: key, blockSize: blockSize, block: make([]byte, 0, blockSize)}
+
+	m := h.Meta
+	if key != nil {
+		m = make(map[string]string, len(h.Meta)+2)
+		for k, v := range h.Meta {
+			m[k] = v
+		}
+		m[metaKeyID], m[metaKeyCheck] = key.id, key.check
+	}
+	var meta []byte
+	if len(m) > 0 {
+		var err error
+		if meta, err = json.Marshal(m); err != nil {
+			return nil, err
+		}
+	}
+
+	buf := make([]byte, headerSize, headerSize+len(meta))
+	copy(buf[0:8], magic[:])
+	binary.LittleEndian.PutUint16(buf[8:], Version)
+	binary.LittleEndian.PutUint16(buf[10:], h.EntryVersion)
+	binary.LittleEndian.PutUint16(buf[12:], h.Segment)
+	binary.LittleEndian.PutUint16(buf[14:], max(h.Segments, 1))
+	binary.LittleEndian.PutUint64(buf[16:], uint64(h.Created.UnixNano()))
+	binary.LittleEndian.PutUint64(buf[24:], h.HashSeed)
+	binary.LittleEndian.PutUint64(buf[32:], h.RulesHash)
+	binary.LittleEndian.PutUint32(buf[40:], uint32(len(meta)))
+	buf = append(buf, meta...)
+	crc := crc32.NewIEEE()
+	crc.Write(buf[:headerSize-4])
+	crc.Write(meta)
+	binary.LittleEndian.PutUint32(buf[headerSize-4:], crc.Sum32())
+
+	if err := sw.write(buf); err != nil {
+		return nil, err
+	}
+	return sw, nil
+}
+
+// Write appends a record, the block is flushed when it reaches the block size.
+func (w *Writer) Write(record []byte) error {
+	w.block = binary.LittleEndian.AppendUint32(w.block, uint32(len(record)))
+	w.block = append(w.block, record...)
+	w.records++
+	if len(w.block) >= w.blockSize {
+		return w.flush()
+	}
+	return nil
+}
+
+// Records returns the number of written records.
+func (w *Writer) Records() int {
+	n := w.records
+	for _, b := range w.index {
+		n += b.Records
+	}
+	return n
+}
+
+// Close flushes the last block and writes the index and the trailer, the underlying writer is not closed.
+func (w *Writer) Close() error {
+	if err := w.flush(); err != nil {
+		return err
+	}
+
+	index := make([]byte, 4, 4+len(w.index)*indexEntrySize+trailerSize)
+	binary.LittleEndian.PutUint32(index, uint32(len(w.index)))
+	for _, b := range w.index {
+		index = binary.LittleEndian.AppendUint64(index, uint64(b.Offset))
+		index = binary.LittleEndian.AppendUint32(index, uint32(b.Records))
+		index = binary.LittleEndian.AppendUint32(index, uint32(b.RawLen))
+		index = binary.LittleEndian.AppendUint32(index, uint32(b.StoredLen))
+		index = append(index, b.Codec)
+	}
+	indexLen := len(index)
+
+	index = binary.LittleEndian.AppendUint64(index, uint64(w.offset))
+	index = binary.LittleEndian.AppendUint32(index, uint32(indexLen))
+	index = binary.LittleEndian.AppendUint32(index, crc32.ChecksumIEEE(index[:indexLen]))
+	index = append(index, endMagic[:]...)
+	return w.write(index)
+}
+
+func (w *Writer) flush() error {
+	if w.records == 0 {
+		return nil
+	}
+
+	var hdr [blockHeaderSize]byte
+	hdr[0] = w.codec.ID()
+	binary.LittleEndian.PutUint32(hdr[1:], uint32(w.records))
+	binary.LittleEndian.PutUint32(hdr[5:], uint32(len(w.block)))
+
+	stored, err := w.codec.Compress(w.stored[:0], w.block)
+	if err != nil {
+		return err
+	}
+	if w.key != nil {
+		if w.sealed, err = w.key.seal(w.sealed[:0], stored, hdr[:]); err != nil {
+			return err
+		}
+		w.stored, stored = stored, w.sealed
+	} else {
+		w.stored = stored
+	}
+	binary.LittleEndian.PutUint32(hdr[9:], uint32(len(stored)))
+	binary.LittleEndian.PutUint32(hdr[13:], crc32.ChecksumIEEE(stored))
+
+	info := BlockInfo{Offset: w.offset, Records: w.records, RawLen: len(w.block), StoredLen: len(stored), Codec: w.codec.ID()}
+	if err = w.write(hdr[:]); err != nil {
+		return err
+	}
+	if err = w.write(stored); err != nil {
+		return err
+	}
+
+	w.index = append(w.index, info)
+	w.block = w.block[:0]
+	w.records = 0
+	return nil
+}
+
+func (w *Writer) write(p []byte) error {
+	n, err := w.w.Write(p)
+	w.offset += int64(n)
+	return err
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"github.com/Borislavv/advanced-cache/pkg/mock"
+	"github.com/Borislavv/advanced-cache/pkg/model"
 	"github.com/Borislavv/advanced-cache/pkg/storage/lru"
 	"github.com/Borislavv/advanced-cache/pkg/upstream"
 	"sync/atomic"
@@ -23,10 +24,16 @@ var (
 	path = []byte("/api/v2/pagedata")
 )
 
-var cfg *config.Cache
+// cfg is the test config of the benchmarks, tests build their own ones (see newTestConfig).
+var cfg = newTestConfig(nil)
 
 func init() {
-	cfg = &config.Cache{
+	zerolog.SetGlobalLevel(zerolog.ErrorLevel)
+}
+
+// newTestConfig returns a new test config with the persistence, so a test may change it.
+func newTestConfig(persistence *config.Persistence) *config.Cache {
+	return &config.Cache{
 		Cache: &config.CacheBox{
 			Enabled: true,
 			LifeTime: config.Lifetime{
@@ -53,6 +60,7 @@ func init() {
 				Type: "malloc",
 				Size: 1024 * 500000, // 5 MB
 			},
+			Persistence: persistence,
 			Rules: map[string]*config.Rule{
 				"/api/v2/pagedata": {
 					PathBytes: []byte("/api/v2/pagedata"),
@@ -82,8 +90,18 @@ func init() {
 			},
 		},
 	}
+}
 
-	zerolog.SetGlobalLevel(zerolog.ErrorLevel)
+// newTestDB returns a backend and a storage of the test context with n generated entries of path stored in it.
+func newTestDB(tb testing.TB, cfg *config.Cache, n int) (*upstream.Backend, *lru.InMemoryStorage, []*model.Entry) {
+	tb.Helper()
+	backend := upstream.NewBackend(tb.Context(), cfg)
+	db := lru.NewStorage(tb.Context(), cfg, backend)
+	entries := mock.GenerateEntryPointersConsecutive(cfg, backend, path, n)
+	for _, entry := range entries {
+		db.Set(entry)
+	}
+	return backend, db, entries
 }
 
 func BenchmarkReadFromStorage1000TimesPerIter(b *testing.B) {
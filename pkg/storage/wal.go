@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Borislavv/advanced-cache/pkg/config"
+	"github.com/Borislavv/advanced-cache/pkg/model"
+	"github.com/Borislavv/advanced-cache/pkg/storage/wal"
+	"github.com/Borislavv/advanced-cache/pkg/upstream"
+	"github.com/rs/zerolog/log"
+)
+
+// ReplayWAL applies the write-ahead log (persistence.wal.dir) to the storage, it's done on top of the loaded dump.
+// Entries keep their recorded update times, entries of not configured rules and malformed records are skipped.
+func ReplayWAL(ctx context.Context, cfg *config.Cache, storage Storage, backend upstream.Gateway) error {
+	start := time.Now()
+	var skipped int
+
+	stats, err := wal.Replay(cfg.Cache.Persistence.WAL.Dir, func(r wal.Record) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		switch r.Type {
+		case wal.SetRecord, wal.RefreshRecord:
+			e, err := model.EntryFromBytes(r.Data, cfg, backend)
+			if err != nil {
+				skipped++
+				return nil
+			}
+			storage.Replicate(e) // not Set: an entry loaded from the dump would be updated now
+		case wal.RemoveRecord:
+			key, shard, fingerprint, err := r.RemoveKeys()
+			if err != nil {
+				skipped++
+				return nil
+			}
+			req := model.NewEntryFromField(key, shard, fingerprint, nil, nil, nil, 0, 0)
+			if e, found := storage.Get(req); found {
+				storage.Remove(e)
+			}
+		case wal.ClearRecord:
+			storage.Clear()
+		default:
+			skipped++
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("replay wal: %w", err)
+	}
+
+	log.Info().Msgf("[wal] replayed: %d records of %d segments, skipped: %d, corrupted segments: %d, elapsed: %s",
+		stats.Records, stats.Segments, skipped, stats.Corrupted, time.Since(start))
+	return nil
+}
@@ -0,0 +1,110 @@
+package wal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// maxRecordSize limits the length of a record, a bigger one is treated as corruption.
+const maxRecordSize = 1 << 30
+
+var (
+	NotSegmentError = errors.New("not a wal segment")
+	CorruptedError  = errors.New("wal record is corrupted")
+)
+
+// Record is a replayed change. Data aliases the segment read into memory, it is never reused.
+type Record struct {
+	Type RecordType
+	Time time.Time
+	Data []byte // model.Entry.ToBytes data of Set and Refresh records
+}
+
+// RemoveKeys returns the map key, the shard key and the fingerprint of a Remove record.
+func (r Record) RemoveKeys() (key, shard uint64, fingerprint [16]byte, err error) {
+	if r.Type != RemoveRecord || len(r.Data) != removeRecordSize {
+		return 0, 0, fingerprint, fmt.Errorf("%w: malformed %s record", CorruptedError, r.Type)
+	}
+	copy(fingerprint[:], r.Data[16:])
+	return binary.LittleEndian.Uint64(r.Data), binary.LittleEndian.Uint64(r.Data[8:]), fingerprint, nil
+}
+
+// ReplayStats are the results of Replay.
+type ReplayStats struct {
+	Segments  int
+	Records   int
+	Corrupted int // Segments with a corrupted or truncated tail (records after it are skipped).
+	Bytes     int64
+}
+
+// Replay reads the segments of the dir in order and calls fn for each record.
+// A truncated or corrupted tail of a segment (e.g. the process was killed while writing) stops
+// reading of that segment only. An error of fn stops the replay.
+func Replay(dir string, fn func(Record) error) (ReplayStats, error) {
+	var stats ReplayStats
+	segments, err := Segments(dir)
+	if err != nil {
+		return stats, err
+	}
+	for _, segment := range segments {
+		data, err := os.ReadFile(segment.File)
+		if err != nil {
+			return stats, err
+		}
+		stats.Segments++
+		stats.Bytes += int64(len(data))
+
+		n, err := replaySegment(data, fn)
+		stats.Records += n
+		if err != nil {
+			if !errors.Is(err, CorruptedError) && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, NotSegmentError) {
+				return stats, err
+			}
+			stats.Corrupted++
+			log.Warn().Err(err).Msgf("[wal] segment %s: %d records replayed, the rest is skipped", segment.File, n)
+		}
+	}
+	return stats, nil
+}
+
+func replaySegment(data []byte, fn func(Record) error) (n int, err error) {
+	if len(data) < len(segmentMagic) || !bytes.Equal(data[:len(segmentMagic)], segmentMagic[:]) {
+		return 0, NotSegmentError
+	}
+	for p := len(segmentMagic); p < len(data); n++ {
+		if p+recordHeaderSize > len(data) {
+			return n, io.ErrUnexpectedEOF
+		}
+		l := int(binary.LittleEndian.Uint32(data[p:]))
+		crc := binary.LittleEndian.Uint32(data[p+4:])
+		p += recordHeaderSize
+		if l < recordPrefixSize || l > maxRecordSize {
+			return n, fmt.Errorf("%w: length %d", CorruptedError, l)
+		}
+		if p+l > len(data) {
+			return n, io.ErrUnexpectedEOF
+		}
+		raw := data[p : p+l : p+l]
+		if crc32.ChecksumIEEE(raw) != crc {
+			return n, fmt.Errorf("%w: checksum mismatch", CorruptedError)
+		}
+		p += l
+
+		if err = fn(Record{
+			Type: RecordType(raw[0]),
+			Time: time.Unix(0, int64(binary.LittleEndian.Uint64(raw[1:]))),
+			Data: raw[recordPrefixSize:],
+		}); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,392 @@
+// Package wal implements the append-only write-ahead log of storage changes.
+//
+// The log is a sequence of segment files "<seq>.wal" (seq is zero padded), each one starts with the magic
+// "ADVCWAL1" followed by records: length u32 (of the rest of the record without the CRC), crc32 u32,
+// type u8, time unix nano i64, data. Set and Refresh records hold model.Entry.ToBytes data,
+// Remove records hold the map key u64, the shard key u64 and the fingerprint [16]byte, Clear records are empty.
+//
+// Events are queued by the storage (Set blocks only if the queue is full) and written by a single goroutine
+// in batches, so the Get path is never touched.
+package wal
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/Borislavv/advanced-cache/pkg/config"
+	"github.com/Borislavv/advanced-cache/pkg/model"
+	"github.com/rs/zerolog/log"
+)
+
+// RecordType is the kind of a storage change.
+type RecordType byte
+
+const (
+	SetRecord RecordType = iota + 1
+	RefreshRecord
+	RemoveRecord
+	ClearRecord
+)
+
+func (t RecordType) String() string {
+	switch t {
+	case SetRecord:
+		return "set"
+	case RefreshRecord:
+		return "refresh"
+	case RemoveRecord:
+		return "remove"
+	case ClearRecord:
+		return "clear"
+	default:
+		return "unknown(" + strconv.Itoa(int(t)) + ")"
+	}
+}
+
+// control events of the writer goroutine, they are never written
+const (
+	rotateEvent RecordType = iota + 100
+	closeEvent
+)
+
+const (
+	segmentExt       = ".wal"
+	recordHeaderSize = 4 + 4 // length, crc32
+	recordPrefixSize = 1 + 8 // type, time
+	removeRecordSize = 8 + 8 + 16
+	writeBufferSize  = 256 * 1024
+)
+
+var (
+	segmentMagic = [8]byte{'A', 'D', 'V', 'C', 'W', 'A', 'L', '1'}
+
+	ClosedError = errors.New("wal is closed")
+)
+
+type event struct {
+	typ   RecordType
+	entry *model.Entry
+	reply chan<- result // control events only
+}
+
+type result struct {
+	seq uint64
+	err error
+}
+
+// Log writes storage changes into segment files. It implements lru.Journal.
+type Log struct {
+	dir    string
+	cfg    config.WAL
+	events chan event
+	done   chan struct{}
+	closed atomic.Bool
+
+	// owned by the writer goroutine
+	seq     uint64
+	f       *os.File
+	bw      *bufio.Writer
+	size    int64
+	dirty   bool // written but not fsynced
+	scratch []byte
+	failed  error
+}
+
+// Open starts a new segment after the existing ones (they are kept for Replay) and the writer goroutine.
+func Open(ctx context.Context, cfg config.WAL) (*Log, error) {
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create wal dir: %w", err)
+	}
+	segments, err := Segments(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Log{
+		dir:    cfg.Dir,
+		cfg:    cfg,
+		events: make(chan event, max(cfg.Buffer, 1)),
+		done:   make(chan struct{}),
+	}
+	if len(segments) > 0 {
+		l.seq = segments[len(segments)-1].Seq
+	}
+	if err = l.next(); err != nil {
+		return nil, err
+	}
+
+	go l.run(ctx)
+	return l, nil
+}
+
+// Set records a new or updated entry.
+func (l *Log) Set(e *model.Entry) { l.push(event{typ: SetRecord, entry: e}) }
+
+// Refresh records a refreshed entry.
+func (l *Log) Refresh(e *model.Entry) { l.push(event{typ: RefreshRecord, entry: e}) }
+
+// Remove records a removed (or evicted) entry.
+func (l *Log) Remove(e *model.Entry) { l.push(event{typ: RemoveRecord, entry: e}) }
+
+// Clear records removal of all entries.
+func (l *Log) Clear() { l.push(event{typ: ClearRecord}) }
+
+func (l *Log) push(ev event) {
+	select {
+	case l.events <- ev:
+	case <-l.done:
+	}
+}
+
+// Rotate starts a new segment, all events queued before are written into the previous ones.
+// Returns the sequence number of the last finished segment (see Compact).
+func (l *Log) Rotate() (uint64, error) {
+	return l.control(rotateEvent)
+}
+
+// Close writes the queued events, syncs and closes the current segment.
+// The log is closed by cancellation of its context too, then Close does nothing.
+func (l *Log) Close() error {
+	if l.closed.Swap(true) {
+		return ClosedError
+	}
+	if _, err := l.control(closeEvent); err != nil && !errors.Is(err, ClosedError) {
+		return err
+	}
+	return nil
+}
+
+func (l *Log) control(typ RecordType) (uint64, error) {
+	reply := make(chan result, 1)
+	select {
+	case l.events <- event{typ: typ, reply: reply}:
+	case <-l.done:
+		return 0, ClosedError
+	}
+	select {
+	case res := <-reply:
+		return res.seq, res.err
+	case <-l.done:
+		return 0, ClosedError
+	}
+}
+
+// Compact removes segments up to the sequence number (inclusive), e.g. covered by a finished snapshot.
+func (l *Log) Compact(upTo uint64) error {
+	segments, err := Segments(l.dir)
+	if err != nil {
+		return err
+	}
+	var removed int
+	for _, segment := range segments {
+		if segment.Seq > upTo {
+			break
+		}
+		if err = os.Remove(segment.File); err != nil {
+			return err
+		}
+		removed++
+	}
+	log.Info().Msgf("[wal] compacted: %d segments removed", removed)
+	return nil
+}
+
+func (l *Log) run(ctx context.Context) {
+	defer close(l.done)
+
+	var syncCh <-chan time.Time
+	if l.cfg.Fsync == config.WALFsyncInterval {
+		ticker := time.NewTicker(l.cfg.SyncInterval)
+		defer ticker.Stop()
+		syncCh = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			// the app closes the log on shutdown, this is the last resort
+			_ = l.flush(true)
+			_ = l.f.Close()
+			return
+		case <-syncCh:
+			if l.dirty {
+				l.fail(l.f.Sync())
+				l.dirty = false
+			}
+		case ev := <-l.events:
+			if l.batch(ev) {
+				return
+			}
+		}
+	}
+}
+
+// batch writes the event and the queued ones (up to the batch size), then flushes the batch.
+// Returns true if the log was closed.
+func (l *Log) batch(ev event) bool {
+	for n := 1; ; n++ {
+		if ev.reply != nil {
+			if l.handle(ev) {
+				return true
+			}
+		} else {
+			l.fail(l.write(ev))
+		}
+		if n >= l.cfg.BatchSize {
+			break
+		}
+		var ok bool
+		select {
+		case ev, ok = <-l.events:
+		default:
+		}
+		if !ok {
+			break
+		}
+	}
+	l.fail(l.flush(l.cfg.Fsync == config.WALFsyncBatch))
+	return false
+}
+
+// handle processes a control event (the pending records are synced first), returns true on close.
+func (l *Log) handle(ev event) bool {
+	if err := l.flush(true); err != nil {
+		ev.reply <- result{err: err}
+		return false
+	}
+	if ev.typ == closeEvent {
+		ev.reply <- result{seq: l.seq, err: l.f.Close()}
+		return true
+	}
+	prev := l.seq
+	err := l.f.Close()
+	if err == nil {
+		err = l.next()
+	}
+	ev.reply <- result{seq: prev, err: err}
+	return false
+}
+
+func (l *Log) fail(err error) {
+	if err != nil && l.failed == nil {
+		l.failed = err
+		log.Error().Err(err).Msg("[wal] write failed, changes are not persisted until the next snapshot")
+	}
+}
+
+// write appends a record of the event, the segment is rotated when it exceeds the segment size.
+func (l *Log) write(ev event) error {
+	buf := l.scratch[:0]
+	buf = append(buf, make([]byte, recordHeaderSize)...)
+	buf = append(buf, byte(ev.typ))
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(time.Now().UnixNano()))
+
+	switch ev.typ {
+	case SetRecord, RefreshRecord:
+		data, release := ev.entry.ToBytes()
+		buf = append(buf, data...)
+		release()
+	case RemoveRecord:
+		fp := ev.entry.Fingerprint()
+		buf = binary.LittleEndian.AppendUint64(buf, ev.entry.MapKey())
+		buf = binary.LittleEndian.AppendUint64(buf, ev.entry.ShardKey())
+		buf = append(buf, fp[:]...)
+	}
+	binary.LittleEndian.PutUint32(buf[0:], uint32(len(buf)-recordHeaderSize))
+	binary.LittleEndian.PutUint32(buf[4:], crc32.ChecksumIEEE(buf[recordHeaderSize:]))
+	l.scratch = buf
+
+	if _, err := l.bw.Write(buf); err != nil {
+		return err
+	}
+	l.size += int64(len(buf))
+	l.dirty = true
+
+	if l.cfg.SegmentSize > 0 && l.size >= l.cfg.SegmentSize {
+		if err := l.flush(true); err != nil {
+			return err
+		}
+		if err := l.f.Close(); err != nil {
+			return err
+		}
+		return l.next()
+	}
+	return nil
+}
+
+func (l *Log) flush(sync bool) error {
+	if err := l.bw.Flush(); err != nil {
+		return err
+	}
+	if sync && l.dirty {
+		l.dirty = false
+		return l.f.Sync()
+	}
+	return nil
+}
+
+// next creates the next segment.
+func (l *Log) next() error {
+	l.seq++
+	f, err := os.OpenFile(segmentFileName(l.dir, l.seq), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("create wal segment: %w", err)
+	}
+	if _, err = f.Write(segmentMagic[:]); err != nil {
+		_ = f.Close()
+		return err
+	}
+	l.f, l.size = f, int64(len(segmentMagic))
+	if l.bw == nil {
+		l.bw = bufio.NewWriterSize(f, writeBufferSize)
+	} else {
+		l.bw.Reset(f)
+	}
+	return nil
+}
+
+// Segment is a file of the log.
+type Segment struct {
+	Seq  uint64
+	File string
+}
+
+// Segments returns the segments of the dir in order.
+func Segments(dir string) ([]Segment, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var segments []Segment
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, Segment{Seq: seq, File: filepath.Join(dir, name)})
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].Seq < segments[j].Seq })
+	return segments, nil
+}
+
+func segmentFileName(dir string, seq uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%016d%s", seq, segmentExt))
+}
@@ -0,0 +1,153 @@
+package wal
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/Borislavv/advanced-cache/pkg/config"
+	"github.com/Borislavv/advanced-cache/pkg/model"
+)
+
+var rule = &config.Rule{PathBytes: []byte("/api/v2/pagedata")}
+
+func newLog(t *testing.T, ctx context.Context, dir string) *Log {
+	l, err := Open(ctx, config.WAL{
+		Enabled: true, Dir: dir, Fsync: config.WALFsyncBatch, BatchSize: 16, Buffer: 64, SegmentSize: 1 << 20,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func newEntry(key uint64) *model.Entry {
+	return model.NewEntryFromField(key, key%7, [16]byte{byte(key)}, []byte("payload"), rule, nil, 0, int64(key))
+}
+
+func replayAll(t *testing.T, dir string) ([]Record, ReplayStats) {
+	var records []Record
+	stats, err := Replay(dir, func(r Record) error {
+		records = append(records, r)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records, stats
+}
+
+func TestLogRoundTrip(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dir := t.TempDir()
+
+	l := newLog(t, ctx, dir)
+	for key := uint64(1); key <= 3; key++ {
+		l.Set(newEntry(key))
+	}
+	l.Remove(newEntry(2))
+	l.Clear()
+	if seq, err := l.Rotate(); err != nil || seq != 1 {
+		t.Fatalf("expected the first segment to be finished, got %d (%v)", seq, err)
+	}
+	l.Refresh(newEntry(4))
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	l.Set(newEntry(5)) // ignored, must not block
+
+	records, stats := replayAll(t, dir)
+	if stats.Segments != 2 || stats.Records != 6 || stats.Corrupted != 0 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	want := []RecordType{SetRecord, SetRecord, SetRecord, RemoveRecord, ClearRecord, RefreshRecord}
+	for i, r := range records {
+		if r.Type != want[i] {
+			t.Fatalf("record %d: expected %s, got %s", i, want[i], r.Type)
+		}
+	}
+
+	e, err := model.EntryFromBytesWithRule(records[0].Data, func([]byte) *config.Rule { return rule }, nil)
+	if err != nil || e.MapKey() != 1 || string(e.PayloadBytes()) != "payload" || e.UpdateAt() != 1 {
+		t.Fatalf("unexpected set record: %v", err)
+	}
+	key, shard, fingerprint, err := records[3].RemoveKeys()
+	if err != nil || key != 2 || shard != 2 || fingerprint[0] != 2 {
+		t.Fatalf("unexpected remove record: %d %d %v (%v)", key, shard, fingerprint, err)
+	}
+}
+
+func TestReplayCorruptedTail(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dir := t.TempDir()
+
+	l := newLog(t, ctx, dir)
+	for key := uint64(1); key <= 10; key++ {
+		l.Set(newEntry(key))
+	}
+	if _, err := l.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	for key := uint64(11); key <= 20; key++ {
+		l.Set(newEntry(key))
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	segments, err := Segments(dir)
+	if err != nil || len(segments) != 2 {
+		t.Fatalf("expected 2 segments, got %v (%v)", segments, err)
+	}
+	// The process was killed while writing the last record of the first segment
+	// and a bit of the second segment was corrupted.
+	first, _ := os.ReadFile(segments[0].File)
+	if err = os.WriteFile(segments[0].File, first[:len(first)-3], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	second, _ := os.ReadFile(segments[1].File)
+	second[len(second)-5] ^= 0xff
+	if err = os.WriteFile(segments[1].File, second, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, stats := replayAll(t, dir)
+	if stats.Records != 18 || stats.Corrupted != 2 {
+		t.Fatalf("expected 18 records and 2 corrupted segments, got %+v", stats)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dir := t.TempDir()
+
+	l := newLog(t, ctx, dir)
+	l.Set(newEntry(1))
+	seq, err := l.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Set(newEntry(2))
+	if err = l.Compact(seq); err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	records, _ := replayAll(t, dir)
+	if len(records) != 1 {
+		t.Fatalf("expected a single record after compaction, got %d", len(records))
+	}
+
+	// A reopened log continues the sequence.
+	l = newLog(t, ctx, dir)
+	defer l.Close()
+	segments, _ := Segments(dir)
+	if len(segments) != 2 || segments[1].Seq != seq+2 {
+		t.Fatalf("unexpected segments: %+v", segments)
+	}
+}
@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Borislavv/advanced-cache/pkg/config"
+	"github.com/Borislavv/advanced-cache/pkg/mock"
+	"github.com/Borislavv/advanced-cache/pkg/storage/lru"
+	"github.com/Borislavv/advanced-cache/pkg/storage/wal"
+	"github.com/Borislavv/advanced-cache/pkg/upstream"
+)
+
+func TestReplayWALOverDump(t *testing.T) {
+	dir := t.TempDir()
+	ctx := t.Context()
+	walCfg := &config.WAL{
+		Enabled: true, Dir: filepath.Join(dir, "wal"), Fsync: config.WALFsyncBatch,
+		BatchSize: 128, Buffer: 1024, SegmentSize: 1 << 20,
+	}
+	cfg := newTestConfig(&config.Persistence{
+		Dump: &config.Dump{IsEnabled: true, Dir: dir, Name: "cache.dump", Format: config.DumpFormatSnapshot, Segments: 1},
+		WAL:  walCfg,
+	})
+	backend, src, _ := newTestDB(t, cfg, 0)
+
+	log, err := wal.Open(ctx, *walCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src.SetJournal(log)
+	dumper := NewDumper(cfg, src, backend)
+	dumper.SetWAL(log)
+
+	entries := mock.GenerateEntryPointersConsecutive(cfg, backend, path, 300)
+	for _, entry := range entries[:200] {
+		src.Set(entry)
+	}
+	if err = dumper.Dump(ctx); err != nil {
+		t.Fatalf("Dump: %v", err)
+	}
+	// Changes after the dump are recovered from the wal only.
+	for _, entry := range entries[200:] {
+		src.Set(entry)
+	}
+	for _, entry := range entries[:50] {
+		src.Remove(entry)
+	}
+	if err = log.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var records int
+	if _, err = wal.Replay(walCfg.Dir, func(wal.Record) error { records++; return nil }); err != nil || records != 150 {
+		t.Fatalf("expected the wal to be compacted to 150 records, got %d (%v)", records, err)
+	}
+
+	dst := lru.NewStorage(ctx, cfg, backend)
+	if err = NewDumper(cfg, dst, backend).Load(ctx); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if err = ReplayWAL(ctx, cfg, dst, backend); err != nil {
+		t.Fatalf("ReplayWAL: %v", err)
+	}
+	if dst.RealLen() != src.RealLen() || dst.RealLen() != 250 {
+		t.Fatalf("expected %d entries, got %d", src.RealLen(), dst.RealLen())
+	}
+	for _, entry := range entries[:50] {
+		if _, found := dst.Get(entry); found {
+			t.Fatal("a removed entry was restored")
+		}
+	}
+}
+
+func TestDumpCompactsWALAfterShutdown(t *testing.T) {
+	dir := t.TempDir()
+	ctx, cancel := context.WithCancel(t.Context())
+	walCfg := &config.WAL{
+		Enabled: true, Dir: filepath.Join(dir, "wal"), Fsync: config.WALFsyncBatch,
+		BatchSize: 128, Buffer: 1024, SegmentSize: 1 << 20,
+	}
+	cfg := newTestConfig(&config.Persistence{
+		Dump: &config.Dump{IsEnabled: true, Dir: dir, Name: "cache.dump", Format: config.DumpFormatSnapshot, Segments: 1},
+		WAL:  walCfg,
+	})
+	backend := upstream.NewBackend(ctx, cfg)
+	db := lru.NewStorage(ctx, cfg, backend)
+
+	// Like the app: the wal outlives the cancelled context and is closed after the final dump.
+	log, err := wal.Open(context.WithoutCancel(ctx), *walCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetJournal(log)
+	dumper := NewDumper(cfg, db, backend)
+	dumper.SetWAL(log)
+	for _, entry := range mock.GenerateEntryPointersConsecutive(cfg, backend, path, 100) {
+		db.Set(entry)
+	}
+
+	cancel()
+	time.Sleep(50 * time.Millisecond) // a writer of the cancelled context would have stopped by now
+	if err = dumper.Dump(t.Context()); err != nil {
+		t.Fatalf("Dump: %v", err)
+	}
+	db.SetJournal(nil)
+	if err = log.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var records int
+	if _, err = wal.Replay(walCfg.Dir, func(wal.Record) error { records++; return nil }); err != nil || records != 0 {
+		t.Fatalf("expected the wal to be compacted by the final dump, got %d records (%v)", records, err)
+	}
+}
+
+func TestReplayWALKeepsUpdateTimes(t *testing.T) {
+	dir := t.TempDir()
+	ctx := t.Context()
+	walCfg := &config.WAL{
+		Enabled: true, Dir: filepath.Join(dir, "wal"), Fsync: config.WALFsyncBatch,
+		BatchSize: 128, Buffer: 1024, SegmentSize: 1 << 20,
+	}
+	cfg := newTestConfig(&config.Persistence{
+		Dump: &config.Dump{IsEnabled: true, Dir: dir, Name: "cache.dump", Format: config.DumpFormatSnapshot, Segments: 1},
+		WAL:  walCfg,
+	})
+	backend, src, entries := newTestDB(t, cfg, 10)
+	dumpedAt := time.Now().Add(-3 * time.Hour).UnixNano()
+	for _, entry := range entries {
+		entry.SetUpdatedAt(dumpedAt)
+	}
+	if err := NewDumper(cfg, src, backend).Dump(ctx); err != nil {
+		t.Fatalf("Dump: %v", err)
+	}
+
+	// The entry was refreshed with another body two hours ago.
+	log, err := wal.Open(ctx, *walCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refreshed := mock.GenerateEntryPointersConsecutive(cfg, backend, path, 1)[0]
+	reqPath, query, queryHeaders, respHeaders, _, status, release, err := refreshed.Payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	refreshed.SetPayload(reqPath, query, queryHeaders, respHeaders, []byte("refreshed"), status)
+	release(queryHeaders, respHeaders)
+	refreshedAt := time.Now().Add(-2 * time.Hour).UnixNano()
+	refreshed.SetUpdatedAt(refreshedAt)
+	log.Refresh(refreshed)
+	if err = log.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := lru.NewStorage(ctx, cfg, backend)
+	if err = NewDumper(cfg, dst, backend).Load(ctx); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if err = ReplayWAL(ctx, cfg, dst, backend); err != nil {
+		t.Fatalf("ReplayWAL: %v", err)
+	}
+	for i, entry := range entries {
+		stored, found := dst.Get(entry)
+		if !found {
+			t.Fatal("expected the entry to be restored")
+		}
+		expected := dumpedAt
+		if i == 0 {
+			expected = refreshedAt
+		}
+		if stored.UpdateAt() != expected {
+			t.Fatalf("expected the entry %d to be updated at %d, got %d", i, expected, stored.UpdateAt())
+		}
+	}
+}
@@ -88,11 +88,17 @@ func NewBackend(ctx context.Context, cfg *config.Cache) *Backend {
 		},
 		rateLimiter: rate.NewLimiter(
 			rate.Limit(cfg.Cache.Proxy.Rate),
-			cfg.Cache.Proxy.Rate/10,
+			max(cfg.Cache.Proxy.Rate/10, 1),
 		),
 	}
 }
 
+// Reload applies the live-changeable upstream settings (rate limit).
+func (s *Backend) Reload(cfg *config.Cache) {
+	s.rateLimiter.SetLimit(rate.Limit(cfg.Cache.Proxy.Rate))
+	s.rateLimiter.SetBurst(max(cfg.Cache.Proxy.Rate/10, 1))
+}
+
 func (s *Backend) Fetch(
 	rule *config.Rule, path []byte, query []byte, queryHeaders *[][2][]byte,
 ) (
@@ -0,0 +1,189 @@
+package warmup
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Borislavv/advanced-cache/pkg/config"
+)
+
+// maxLineSize is the max length of a line of text and JSONL lists.
+const maxLineSize = 1 << 20
+
+var InvalidURLError = errors.New("invalid warm-up url")
+
+// Request is a request of a URL list.
+type Request struct {
+	Path    []byte
+	Query   []byte // without the leading '?'
+	Headers [][2][]byte
+}
+
+// URL returns the path with the query.
+func (r Request) URL() string {
+	if len(r.Query) == 0 {
+		return string(r.Path)
+	}
+	return string(r.Path) + "?" + string(r.Query)
+}
+
+// jsonlRequest is a line of a JSONL list, either url or path (with an optional query) is required.
+type jsonlRequest struct {
+	URL     string            `json:"url"`
+	Path    string            `json:"path"`
+	Query   string            `json:"query"`
+	Headers map[string]string `json:"headers"`
+}
+
+// FormatOf returns the format of the source: the configured one or the one of the file extension.
+func FormatOf(src config.WarmupSource) string {
+	if src.Format != "" {
+		return src.Format
+	}
+	switch strings.ToLower(filepath.Ext(src.File)) {
+	case ".jsonl", ".ndjson":
+		return config.WarmupFormatJSONL
+	case ".xml":
+		return config.WarmupFormatSitemap
+	default:
+		return config.WarmupFormatText
+	}
+}
+
+// ReadSource calls fn for each request of the source file (see Parse).
+func ReadSource(src config.WarmupSource, fn func(Request, error) error) error {
+	f, err := os.Open(src.File)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err = Parse(f, FormatOf(src), src.Headers, fn); err != nil {
+		return fmt.Errorf("%s: %w", src.File, err)
+	}
+	return nil
+}
+
+// Parse calls fn for each request of the list of the format, the headers are added to each request.
+// A malformed URL is passed as an error matching InvalidURLError, the parsing goes on unless fn returns an error.
+// A broken list (e.g. invalid JSON or XML) stops the parsing with an error.
+func Parse(r io.Reader, format string, headers map[string]string, fn func(Request, error) error) error {
+	switch format {
+	case config.WarmupFormatText, "":
+		return parseText(r, headers, fn)
+	case config.WarmupFormatJSONL:
+		return parseJSONL(r, headers, fn)
+	case config.WarmupFormatSitemap:
+		return parseSitemap(r, headers, fn)
+	default:
+		return fmt.Errorf("unknown warm-up list format %q", format)
+	}
+}
+
+func parseText(r io.Reader, headers map[string]string, fn func(Request, error) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		req, err := newRequest(line, "", headers, nil)
+		if err = fn(req, err); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func parseJSONL(r io.Reader, headers map[string]string, fn func(Request, error) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	for line := 1; scanner.Scan(); line++ {
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		var item jsonlRequest
+		if err := json.Unmarshal(data, &item); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		raw := item.URL
+		if raw == "" {
+			raw = item.Path
+		}
+		req, err := newRequest(raw, item.Query, headers, item.Headers)
+		if err = fn(req, err); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func parseSitemap(r io.Reader, headers map[string]string, fn func(Request, error) error) error {
+	dec := xml.NewDecoder(r)
+	for {
+		token, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "loc" {
+			continue
+		}
+		var loc string
+		if err = dec.DecodeElement(&loc, &start); err != nil {
+			return err
+		}
+		req, err := newRequest(strings.TrimSpace(loc), "", headers, nil)
+		if err = fn(req, err); err != nil {
+			return err
+		}
+	}
+}
+
+// newRequest parses a URL (absolute or a path with a query), the query is appended to its own one if any.
+// The host of absolute URLs is dropped: requests go to the configured upstream.
+func newRequest(raw, query string, headers, own map[string]string) (Request, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Path == "" || !strings.HasPrefix(u.Path, "/") {
+		return Request{}, fmt.Errorf("%w: %q", InvalidURLError, raw)
+	}
+	rawQuery := u.RawQuery
+	if query = strings.TrimPrefix(query, "?"); query != "" {
+		if rawQuery != "" {
+			rawQuery += "&"
+		}
+		rawQuery += query
+	}
+
+	req := Request{Path: []byte(u.EscapedPath()), Query: []byte(rawQuery)}
+	merged := make(map[string]string, len(headers)+len(own))
+	for k, v := range headers {
+		merged[k] = v
+	}
+	for k, v := range own {
+		merged[k] = v
+	}
+	names := make([]string, 0, len(merged))
+	for k := range merged {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		req.Headers = append(req.Headers, [2][]byte{[]byte(k), []byte(merged[k])})
+	}
+	return req, nil
+}
@@ -0,0 +1,174 @@
+package warmup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Borislavv/advanced-cache/pkg/config"
+	"github.com/Borislavv/advanced-cache/pkg/model"
+	"github.com/Borislavv/advanced-cache/pkg/storage"
+	"github.com/Borislavv/advanced-cache/pkg/upstream"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/time/rate"
+)
+
+// maxLoggedFailures is the number of failed URLs logged by a warm-up, the rest are only counted.
+const maxLoggedFailures = 20
+
+// JobWarmup is the job kind of warm-ups.
+const JobWarmup = "warmup"
+
+var (
+	unexpectedStatusError = errors.New("unexpected upstream status")
+	notAdmittedError      = errors.New("not admitted by the storage")
+)
+
+// Stats is the outcome of a warm-up.
+type Stats struct {
+	Total   int    `json:"total"`  // Requests of the lists.
+	Stored  int    `json:"stored"` // Fetched and stored.
+	Cached  int    `json:"cached"` // Already cached, not fetched.
+	NoRule  int    `json:"noRule"` // Paths of no rule, not fetched.
+	Failed  int    `json:"failed"` // Malformed URLs, upstream errors and non-200 responses.
+	Elapsed string `json:"elapsed"`
+}
+
+// Warmer fills the storage from URL lists (see config.Warmup).
+type Warmer struct {
+	ctx     context.Context
+	cfg     *config.Cache
+	backend upstream.Gateway
+	storage storage.Storage
+	jobs    *storage.Jobs
+}
+
+func NewWarmer(ctx context.Context, cfg *config.Cache, backend upstream.Gateway, db storage.Storage) *Warmer {
+	return &Warmer{ctx: ctx, cfg: cfg, backend: backend, storage: db, jobs: storage.NewJobs()}
+}
+
+// Jobs returns the jobs of the warmer.
+func (w *Warmer) Jobs() *storage.Jobs {
+	return w.jobs
+}
+
+// Start starts a job warming up from the requests (see ReadSources and Parse), one at a time.
+func (w *Warmer) Start(target string, requests []Request, invalid int) (storage.JobInfo, error) {
+	return w.jobs.Start(w.ctx, JobWarmup, target, func(ctx context.Context, p *storage.Progress) error {
+		_, err := w.Run(ctx, requests, invalid, p)
+		return err
+	})
+}
+
+// ReadSources reads the requests of the configured sources, malformed URLs are counted as invalid.
+func (w *Warmer) ReadSources() (requests []Request, invalid int, err error) {
+	for _, src := range w.cfg.Cache.Persistence.Warmup.Sources {
+		err = ReadSource(src, func(req Request, err error) error {
+			if err != nil {
+				invalid++
+				return nil
+			}
+			requests = append(requests, req)
+			return nil
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	return requests, invalid, nil
+}
+
+// Run fetches the requests which are not cached yet at persistence.warmup.rate and stores them,
+// invalid is the number of malformed URLs of the lists (counted as failed). progress may be nil.
+func (w *Warmer) Run(ctx context.Context, requests []Request, invalid int, progress *storage.Progress) (Stats, error) {
+	start := time.Now()
+	cfg := w.cfg.Cache.Persistence.Warmup
+	progress.SetTotal(int64(len(requests) + invalid))
+	progress.Fail(int64(invalid))
+
+	var (
+		wg                            sync.WaitGroup
+		stored, cached, noRule, fails atomic.Int64
+		limiter                       = rate.NewLimiter(rate.Limit(cfg.Rate), max(cfg.Rate/10, 1))
+		sem                           = make(chan struct{}, max(cfg.Workers, 1))
+	)
+	fails.Add(int64(invalid))
+
+	for _, req := range requests {
+		if err := limiter.Wait(ctx); err != nil {
+			break
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(req Request) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			switch found, err := w.warm(req); {
+			case model.IsRouteWasNotFound(err):
+				noRule.Add(1)
+				progress.Add(1)
+			case err != nil:
+				if n := fails.Add(1); n <= maxLoggedFailures {
+					log.Warn().Err(err).Str("url", req.URL()).Msg("[warmup] request failed")
+				}
+				progress.Fail(1)
+			case found:
+				cached.Add(1)
+				progress.Add(1)
+			default:
+				stored.Add(1)
+				progress.Add(1)
+			}
+		}(req)
+	}
+	wg.Wait()
+
+	stats := Stats{
+		Total:   len(requests) + invalid,
+		Stored:  int(stored.Load()),
+		Cached:  int(cached.Load()),
+		NoRule:  int(noRule.Load()),
+		Failed:  int(fails.Load()),
+		Elapsed: time.Since(start).String(),
+	}
+	log.Info().Msgf("[warmup] finished: %d urls, stored: %d, already cached: %d, no rule: %d, failed: %d, elapsed: %s",
+		stats.Total, stats.Stored, stats.Cached, stats.NoRule, stats.Failed, stats.Elapsed)
+	if err := ctx.Err(); err != nil {
+		return stats, fmt.Errorf("warm-up interrupted: %w", err)
+	}
+	return stats, nil
+}
+
+// warm fetches and stores the request unless it's cached already, returns whether it was.
+func (w *Warmer) warm(req Request) (found bool, err error) {
+	headers := append([][2][]byte(nil), req.Headers...)
+	entry, err := model.NewEntryManual(w.cfg, req.Path, req.Query, &headers, w.backend.RevalidatorMaker())
+	if err != nil {
+		return false, err
+	}
+	if _, found = w.storage.Get(entry); found {
+		return true, nil
+	}
+
+	// Key headers are filtered in place, the request goes upstream with all of them.
+	headers = append(headers[:0], req.Headers...)
+	status, respHeaders, body, release, err := w.backend.Fetch(entry.Rule(), req.Path, req.Query, &headers)
+	defer release()
+	if err != nil {
+		return false, err
+	}
+	if status != http.StatusOK {
+		return false, fmt.Errorf("%w: %d", unexpectedStatusError, status)
+	}
+	entry.SetPayload(req.Path, req.Query, &headers, respHeaders, body, status)
+	if !w.storage.Set(entry) {
+		return false, notAdmittedError
+	}
+	return false, nil
+}
@@ -0,0 +1,168 @@
+package warmup
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Borislavv/advanced-cache/pkg/config"
+	"github.com/Borislavv/advanced-cache/pkg/storage"
+	"github.com/Borislavv/advanced-cache/pkg/storage/lru"
+	"github.com/rs/zerolog"
+)
+
+func newConfig() *config.Cache {
+	zerolog.SetGlobalLevel(zerolog.ErrorLevel)
+	return &config.Cache{
+		Cache: &config.CacheBox{
+			Enabled: true,
+			Proxy: &config.Proxy{
+				FromUrl: []byte("http://localhost"),
+				Rate:    1000,
+				Timeout: time.Second,
+			},
+			Preallocate: config.Preallocation{PerShard: 8},
+			Eviction:    &config.Eviction{Enabled: true, Threshold: 0.9},
+			Refresh:     &config.Refresh{TTL: time.Hour, Beta: 0.4},
+			Storage:     &config.Storage{Type: "malloc", Size: 1024 * 1024 * 64},
+			Persistence: &config.Persistence{Warmup: &config.Warmup{Enabled: true, Rate: 1000, Workers: 4}},
+			Rules: map[string]*config.Rule{
+				"/api/v2/pagedata": {
+					PathBytes: []byte("/api/v2/pagedata"),
+					CacheKey: config.RuleKey{
+						Query:      []string{"id"},
+						QueryBytes: [][]byte{[]byte("id")},
+						Headers:    []string{"Accept-Language"},
+						HeadersMap: map[string]struct{}{"Accept-Language": {}},
+					},
+					CacheValue: config.RuleValue{
+						Headers:    []string{"Content-Type"},
+						HeadersMap: map[string]struct{}{"Content-Type": {}},
+					},
+				},
+			},
+		},
+	}
+}
+
+// gateway answers 200 to each request except the ones with id=500 (a 500) and id=err (an error).
+type gateway struct {
+	fetched atomic.Int64
+}
+
+func (g *gateway) Fetch(
+	_ *config.Rule, _ []byte, query []byte, _ *[][2][]byte,
+) (int, *[][2][]byte, []byte, func(), error) {
+	g.fetched.Add(1)
+	release := func() {}
+	switch {
+	case strings.Contains(string(query), "id=err"):
+		return 0, nil, nil, release, errors.New("connection refused")
+	case strings.Contains(string(query), "id=500"):
+		return http.StatusInternalServerError, &[][2][]byte{}, nil, release, nil
+	}
+	headers := [][2][]byte{{[]byte("Content-Type"), []byte("application/json")}}
+	return http.StatusOK, &headers, []byte(`{"ok":true}`), release, nil
+}
+
+func (g *gateway) RevalidatorMaker() func(
+	rule *config.Rule, path []byte, query []byte, queryHeaders *[][2][]byte,
+) (int, *[][2][]byte, []byte, func(), error) {
+	return g.Fetch
+}
+
+func parseAll(t *testing.T, list, format string, headers map[string]string) (requests []Request, invalid int) {
+	t.Helper()
+	err := Parse(strings.NewReader(list), format, headers, func(req Request, err error) error {
+		if errors.Is(err, InvalidURLError) {
+			invalid++
+			return nil
+		} else if err != nil {
+			return err
+		}
+		requests = append(requests, req)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Parse %s: %v", format, err)
+	}
+	return requests, invalid
+}
+
+func TestParse(t *testing.T) {
+	common := map[string]string{"Accept-Language": "en", "X-Warmup": "1"}
+
+	requests, invalid := parseAll(t, "# comment\n/api/v2/pagedata?id=1\n\nhttps://example.com/api/v2/pagedata?id=2\nnot a path\n", config.WarmupFormatText, common)
+	if invalid != 1 || len(requests) != 2 || requests[1].URL() != "/api/v2/pagedata?id=2" {
+		t.Fatalf("text: expected 2 requests without the host and 1 invalid, got %+v, %d", requests, invalid)
+	}
+	if h := requests[0].Headers; len(h) != 2 || string(h[0][0]) != "Accept-Language" || string(h[1][0]) != "X-Warmup" {
+		t.Fatalf("text: expected the sorted common headers, got %q", h)
+	}
+
+	requests, invalid = parseAll(t, `{"path":"/api/v2/pagedata","query":"id=1","headers":{"Accept-Language":"de"}}
+{"url":"/api/v2/pagedata?id=2","query":"page=3"}
+{"url":"::"}
+`, config.WarmupFormatJSONL, common)
+	if invalid != 1 || len(requests) != 2 || requests[1].URL() != "/api/v2/pagedata?id=2&page=3" {
+		t.Fatalf("jsonl: expected 2 requests and 1 invalid, got %+v, %d", requests, invalid)
+	}
+	if lang := string(requests[0].Headers[0][1]); lang != "de" {
+		t.Fatalf("jsonl: expected the own header to win, got %q", lang)
+	}
+
+	requests, invalid = parseAll(t, `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>https://example.com/api/v2/pagedata?id=1</loc></url>
+  <url><loc> https://example.com/api/v2/pagedata?id=2&amp;page=1 </loc><lastmod>2025-01-01</lastmod></url>
+</urlset>`, config.WarmupFormatSitemap, nil)
+	if invalid != 0 || len(requests) != 2 || requests[1].URL() != "/api/v2/pagedata?id=2&page=1" {
+		t.Fatalf("sitemap: expected 2 requests, got %+v, %d", requests, invalid)
+	}
+
+	if err := Parse(strings.NewReader("{broken\n"), config.WarmupFormatJSONL, nil, func(Request, error) error { return nil }); err == nil {
+		t.Fatal("expected an error of a broken JSONL list")
+	}
+}
+
+func TestRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := newConfig()
+	backend := &gateway{}
+	db := lru.NewStorage(ctx, cfg, backend)
+	warmer := NewWarmer(ctx, cfg, backend, db)
+
+	list := "/api/v2/pagedata?id=1\n/api/v2/pagedata?id=2\n/api/v2/pagedata?id=500\n/api/v2/pagedata?id=err\n/unknown?id=1\n::\n"
+	requests, invalid := parseAll(t, list, config.WarmupFormatText, map[string]string{"Accept-Language": "en"})
+	stats, err := warmer.Run(ctx, requests, invalid, nil)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if stats.Total != 6 || stats.Stored != 2 || stats.NoRule != 1 || stats.Failed != 3 || stats.Cached != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if db.RealLen() != 2 {
+		t.Fatalf("expected 2 stored entries, got %d", db.RealLen())
+	}
+
+	// Cached entries are not fetched again.
+	fetched := backend.fetched.Load()
+	job, err := warmer.Start("test", requests[:2], 0)
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for job.Status == storage.JobRunning && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+		job, _ = warmer.Jobs().Get(job.ID)
+	}
+	if job.Status != storage.JobDone || job.Done != 2 || job.Failed != 0 || backend.fetched.Load() != fetched {
+		t.Fatalf("expected a job hitting the cache only, got %+v (%d fetches)", job, backend.fetched.Load()-fetched)
+	}
+}